@@ -17,6 +17,7 @@ make build
 make bin/example
 make examples
 make test
+make test.adapters
 make integration
 
 cd ./xdsmatcher
@@ -11,8 +11,12 @@ set -o pipefail
 # Envoy start-up command
 ENVOY=${ENVOY:-/usr/local/bin/envoy}
 
+# Generate the Envoy bootstrap for the example server
+BOOTSTRAP="envoy.example.yaml"
+bin/example -bootstrap ${BOOTSTRAP}
+
 # Start envoy: important to keep drain time short
-(${ENVOY} -c sample/bootstrap-xds.yaml --drain-time-s 1 -l debug)&
+(${ENVOY} -c ${BOOTSTRAP} --drain-time-s 1 -l debug)&
 ENVOY_PID=$!
 
 function cleanup() {
@@ -32,8 +32,12 @@ ENVOY=${ENVOY:-/usr/local/bin/envoy}
 ENVOY_LOG="envoy.${XDS}$@.log"
 echo Envoy log: ${ENVOY_LOG}
 
+# Generate the Envoy bootstrap for the management server type
+BOOTSTRAP="envoy.${XDS}.yaml"
+bin/test --xds=${XDS} --runtimes=${RUNTIMES} --bootstrap=${BOOTSTRAP}
+
 # Start envoy: important to keep drain time short
-(${ENVOY} -c sample/bootstrap-${XDS}.yaml --drain-time-s 1 -l debug 2> ${ENVOY_LOG})&
+(${ENVOY} -c ${BOOTSTRAP} --drain-time-s 1 -l debug 2> ${ENVOY_LOG})&
 ENVOY_PID=$!
 
 function cleanup() {
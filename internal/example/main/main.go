@@ -26,9 +26,10 @@ import (
 )
 
 var (
-	l      example.Logger
-	port   uint
-	nodeID string
+	l             example.Logger
+	port          uint
+	nodeID        string
+	bootstrapPath string
 )
 
 func init() {
@@ -41,11 +42,22 @@ func init() {
 
 	// Tell Envoy to use this Node ID
 	flag.StringVar(&nodeID, "nodeID", "test-id", "Node ID")
+
+	// Write the bootstrap of an Envoy connecting to this server instead of serving
+	flag.StringVar(&bootstrapPath, "bootstrap", "", "Write the Envoy bootstrap to this file and exit")
 }
 
 func main() {
 	flag.Parse()
 
+	if bootstrapPath != "" {
+		if err := example.WriteBootstrap(bootstrapPath, nodeID, port); err != nil {
+			l.Errorf("bootstrap error %q", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Create a cache
 	cache := cache.NewSnapshotCache(false, cache.IDHash{}, l)
 
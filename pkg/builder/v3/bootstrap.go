@@ -0,0 +1,187 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package builder
+
+import (
+	"fmt"
+	"time"
+
+	bootstrap "github.com/envoyproxy/go-control-plane/envoy/config/bootstrap/v3"
+	cluster "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+)
+
+// Mode is the transport Envoy uses to fetch its configuration from the
+// management server. The values match the modes of the integration tests.
+type Mode string
+
+const (
+	// ModeADS fetches all resources over one aggregated gRPC stream.
+	ModeADS Mode = "ads"
+	// ModeXDS fetches each resource type over its own gRPC stream.
+	ModeXDS Mode = "xds"
+	// ModeREST polls each resource type with the REST-JSON API.
+	ModeREST Mode = "rest"
+	// ModeDelta fetches each resource type over its own incremental gRPC stream.
+	ModeDelta Mode = "delta"
+	// ModeDeltaADS fetches all resources over one aggregated incremental gRPC stream.
+	ModeDeltaADS Mode = "delta-ads"
+)
+
+const (
+	// DefaultXDSClusterName is the name of the static cluster pointing to the
+	// management server.
+	DefaultXDSClusterName = "xds_cluster"
+
+	// DefaultRefreshDelay is the polling interval of the REST mode.
+	DefaultRefreshDelay = 500 * time.Millisecond
+
+	bootstrapConnectTimeout = time.Second
+)
+
+type bootstrapConfig struct {
+	clusterName    string
+	refreshDelay   time.Duration
+	admin          *Address
+	tls            *core.TransportSocket
+	staticClusters []*cluster.Cluster
+	runtimeLayers  []string
+}
+
+// BootstrapOption configures the bootstrap built by NewBootstrap.
+type BootstrapOption func(*bootstrapConfig)
+
+// WithAdmin exposes the admin interface on the address.
+func WithAdmin(addr Address) BootstrapOption {
+	return func(c *bootstrapConfig) {
+		c.admin = &addr
+	}
+}
+
+// WithManagementServerTLS connects to the management server over TLS.
+// Secrets cannot be fetched over SDS before the management server is
+// reachable, so the options should reference files, e.g. WithCertificateFiles
+// and WithTrustedCAFile.
+func WithManagementServerTLS(sni string, opts ...TLSOption) BootstrapOption {
+	return func(c *bootstrapConfig) {
+		c.tls = NewUpstreamTLSTransportSocket(sni, opts...)
+	}
+}
+
+// WithXDSClusterName overrides DefaultXDSClusterName.
+func WithXDSClusterName(name string) BootstrapOption {
+	return func(c *bootstrapConfig) {
+		c.clusterName = name
+	}
+}
+
+// WithRefreshDelay overrides DefaultRefreshDelay in the REST mode.
+func WithRefreshDelay(delay time.Duration) BootstrapOption {
+	return func(c *bootstrapConfig) {
+		c.refreshDelay = delay
+	}
+}
+
+// WithStaticClusters adds clusters known before contacting the management
+// server, such as the one of an access log service.
+func WithStaticClusters(clusters ...*cluster.Cluster) BootstrapOption {
+	return func(c *bootstrapConfig) {
+		c.staticClusters = append(c.staticClusters, clusters...)
+	}
+}
+
+// WithRuntimeLayers adds RTDS layers, fetched from the management server
+// with the mode of the bootstrap.
+func WithRuntimeLayers(names ...string) BootstrapOption {
+	return func(c *bootstrapConfig) {
+		c.runtimeLayers = append(c.runtimeLayers, names...)
+	}
+}
+
+// NewBootstrap returns the bootstrap of an Envoy identified by the node ID and
+// cluster, fetching its listeners, clusters and runtime layers from the
+// management server at the address with the given mode.
+func NewBootstrap(nodeID, nodeCluster string, mode Mode, server Address, opts ...BootstrapOption) (*bootstrap.Bootstrap, error) {
+	c := &bootstrapConfig{
+		clusterName:  DefaultXDSClusterName,
+		refreshDelay: DefaultRefreshDelay,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	xdsClusterOpts := []ClusterOption{
+		WithStatic(server),
+		WithConnectTimeout(bootstrapConnectTimeout),
+	}
+	dynamic := &bootstrap.Bootstrap_DynamicResources{}
+	var source *core.ConfigSource
+	switch mode {
+	case ModeADS, ModeDeltaADS:
+		apiType := core.ApiConfigSource_GRPC
+		if mode == ModeDeltaADS {
+			apiType = core.ApiConfigSource_DELTA_GRPC
+		}
+		dynamic.AdsConfig = apiConfigSource(apiType, c.clusterName).GetApiConfigSource()
+		source = ADSConfigSource()
+		xdsClusterOpts = append(xdsClusterOpts, WithHTTP2())
+	case ModeXDS:
+		source = GRPCConfigSource(c.clusterName)
+		xdsClusterOpts = append(xdsClusterOpts, WithHTTP2())
+	case ModeDelta:
+		source = DeltaGRPCConfigSource(c.clusterName)
+		xdsClusterOpts = append(xdsClusterOpts, WithHTTP2())
+	case ModeREST:
+		source = RESTConfigSource(c.clusterName, c.refreshDelay)
+	default:
+		return nil, fmt.Errorf("unknown xDS mode %q", mode)
+	}
+	dynamic.CdsConfig = source
+	dynamic.LdsConfig = source
+
+	xdsCluster := NewCluster(c.clusterName, xdsClusterOpts...)
+	xdsCluster.TransportSocket = c.tls
+
+	out := &bootstrap.Bootstrap{
+		Node: &core.Node{
+			Id:      nodeID,
+			Cluster: nodeCluster,
+		},
+		StaticResources: &bootstrap.Bootstrap_StaticResources{
+			Clusters: append([]*cluster.Cluster{xdsCluster}, c.staticClusters...),
+		},
+		DynamicResources: dynamic,
+	}
+	if c.admin != nil {
+		out.Admin = &bootstrap.Admin{
+			Address: socketAddress(*c.admin),
+		}
+	}
+	if len(c.runtimeLayers) > 0 {
+		out.LayeredRuntime = &bootstrap.LayeredRuntime{}
+		for _, name := range c.runtimeLayers {
+			out.LayeredRuntime.Layers = append(out.LayeredRuntime.Layers, &bootstrap.RuntimeLayer{
+				Name: name,
+				LayerSpecifier: &bootstrap.RuntimeLayer_RtdsLayer_{
+					RtdsLayer: &bootstrap.RuntimeLayer_RtdsLayer{
+						Name:       name,
+						RtdsConfig: source,
+					},
+				},
+			})
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,137 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package builder_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"gopkg.in/yaml.v3"
+
+	bootstrap "github.com/envoyproxy/go-control-plane/envoy/config/bootstrap/v3"
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/builder/v3"
+)
+
+var managementServer = builder.Address{Host: "127.0.0.1", Port: 18000}
+
+func TestNewBootstrap(t *testing.T) {
+	tests := []struct {
+		mode    builder.Mode
+		ads     bool
+		apiType core.ApiConfigSource_ApiType
+	}{
+		{mode: builder.ModeADS, ads: true, apiType: core.ApiConfigSource_GRPC},
+		{mode: builder.ModeDeltaADS, ads: true, apiType: core.ApiConfigSource_DELTA_GRPC},
+		{mode: builder.ModeXDS, apiType: core.ApiConfigSource_GRPC},
+		{mode: builder.ModeDelta, apiType: core.ApiConfigSource_DELTA_GRPC},
+		{mode: builder.ModeREST, apiType: core.ApiConfigSource_REST},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			b, err := builder.NewBootstrap("test-id", "test-cluster", tt.mode, managementServer,
+				builder.WithAdmin(builder.Address{Host: "127.0.0.1", Port: 19000}),
+				builder.WithRuntimeLayers("runtime-0"),
+			)
+			require.NoError(t, err)
+			require.NoError(t, b.ValidateAll())
+
+			assert.Equal(t, "test-id", b.GetNode().GetId())
+			assert.Equal(t, "test-cluster", b.GetNode().GetCluster())
+			assert.Equal(t, uint32(19000), b.GetAdmin().GetAddress().GetSocketAddress().GetPortValue())
+
+			dynamic := b.GetDynamicResources()
+			if tt.ads {
+				assert.Equal(t, tt.apiType, dynamic.GetAdsConfig().GetApiType())
+				assert.NotNil(t, dynamic.GetCdsConfig().GetAds())
+				assert.NotNil(t, dynamic.GetLdsConfig().GetAds())
+			} else {
+				assert.Nil(t, dynamic.GetAdsConfig())
+				assert.Equal(t, tt.apiType, dynamic.GetCdsConfig().GetApiConfigSource().GetApiType())
+				assert.Equal(t, tt.apiType, dynamic.GetLdsConfig().GetApiConfigSource().GetApiType())
+			}
+			rtds := b.GetLayeredRuntime().GetLayers()[0].GetRtdsLayer()
+			assert.Equal(t, "runtime-0", rtds.GetName())
+			assert.True(t, proto.Equal(dynamic.GetCdsConfig(), rtds.GetRtdsConfig()))
+
+			clusters := b.GetStaticResources().GetClusters()
+			require.Len(t, clusters, 1)
+			assert.Equal(t, builder.DefaultXDSClusterName, clusters[0].GetName())
+			// REST requests are plain HTTP/1.1.
+			assert.Equal(t, tt.mode != builder.ModeREST, len(clusters[0].GetTypedExtensionProtocolOptions()) > 0)
+		})
+	}
+}
+
+func TestNewBootstrapOptions(t *testing.T) {
+	b, err := builder.NewBootstrap("test-id", "test-cluster", builder.ModeREST, managementServer,
+		builder.WithXDSClusterName("control_plane"),
+		builder.WithRefreshDelay(time.Second),
+		builder.WithManagementServerTLS("xds.local",
+			builder.WithCertificateFiles("cert.pem", "key.pem"),
+			builder.WithTrustedCAFile("ca.pem"),
+		),
+		builder.WithStaticClusters(builder.NewCluster("als_cluster",
+			builder.WithStatic(builder.Address{Host: "127.0.0.1", Port: 18090}),
+			builder.WithHTTP2(),
+		)),
+	)
+	require.NoError(t, err)
+	require.NoError(t, b.ValidateAll())
+
+	assert.Nil(t, b.GetAdmin())
+	assert.Nil(t, b.GetLayeredRuntime())
+	source := b.GetDynamicResources().GetCdsConfig().GetApiConfigSource()
+	assert.Equal(t, []string{"control_plane"}, source.GetClusterNames())
+	assert.Equal(t, time.Second, source.GetRefreshDelay().AsDuration())
+
+	clusters := b.GetStaticResources().GetClusters()
+	require.Len(t, clusters, 2)
+	assert.Equal(t, "control_plane", clusters[0].GetName())
+	assert.NotNil(t, clusters[0].GetTransportSocket())
+	assert.Equal(t, "als_cluster", clusters[1].GetName())
+}
+
+func TestNewBootstrapUnknownMode(t *testing.T) {
+	_, err := builder.NewBootstrap("test-id", "test-cluster", "sotw", managementServer)
+	assert.Error(t, err)
+}
+
+func TestMarshalYAML(t *testing.T) {
+	b, err := builder.NewBootstrap("test-id", "test-cluster", builder.ModeADS, managementServer,
+		builder.WithAdmin(builder.Address{Host: "127.0.0.1", Port: 19000}),
+		builder.WithRuntimeLayers("runtime-0", "runtime-1"),
+	)
+	require.NoError(t, err)
+
+	out, err := builder.MarshalYAML(b)
+	require.NoError(t, err)
+	assert.Contains(t, string(out), "node:\n  id: test-id\n")
+	assert.NotContains(t, string(out), `"`)
+
+	// Envoy parses YAML by converting it to JSON first.
+	var tree interface{}
+	require.NoError(t, yaml.Unmarshal(out, &tree))
+	js, err := json.Marshal(tree)
+	require.NoError(t, err)
+	parsed := &bootstrap.Bootstrap{}
+	require.NoError(t, protojson.Unmarshal(js, parsed))
+	assert.True(t, proto.Equal(b, parsed))
+}
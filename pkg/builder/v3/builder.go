@@ -0,0 +1,121 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+// Package builder provides constructors for the most common xDS v3 resources.
+//
+// Each constructor takes the fields that Envoy requires as arguments and the
+// optional ones as functional options, and fills in sensible defaults, so that
+// the returned resources pass the protoc-gen-validate rules without further
+// changes. The resources are plain protos and can be modified freely afterwards.
+//
+// Constructors that pack a message into a typed config panic if the message
+// cannot be marshaled, which only happens for strings holding invalid UTF-8.
+package builder
+
+import (
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+)
+
+// ADSConfigSource returns a config source fetching resources over the
+// aggregated discovery service configured in the bootstrap.
+func ADSConfigSource() *core.ConfigSource {
+	return &core.ConfigSource{
+		ResourceApiVersion: resource.DefaultAPIVersion,
+		ConfigSourceSpecifier: &core.ConfigSource_Ads{
+			Ads: &core.AggregatedConfigSource{},
+		},
+	}
+}
+
+// GRPCConfigSource returns a config source fetching resources over a
+// dedicated gRPC stream to the given cluster.
+func GRPCConfigSource(clusterName string) *core.ConfigSource {
+	return apiConfigSource(core.ApiConfigSource_GRPC, clusterName)
+}
+
+// DeltaGRPCConfigSource returns a config source fetching resources over a
+// dedicated incremental gRPC stream to the given cluster.
+func DeltaGRPCConfigSource(clusterName string) *core.ConfigSource {
+	return apiConfigSource(core.ApiConfigSource_DELTA_GRPC, clusterName)
+}
+
+// RESTConfigSource returns a config source polling resources from the given
+// cluster with the REST-JSON API.
+func RESTConfigSource(clusterName string, refreshDelay time.Duration) *core.ConfigSource {
+	return &core.ConfigSource{
+		ResourceApiVersion: resource.DefaultAPIVersion,
+		ConfigSourceSpecifier: &core.ConfigSource_ApiConfigSource{
+			ApiConfigSource: &core.ApiConfigSource{
+				ApiType:             core.ApiConfigSource_REST,
+				TransportApiVersion: resource.DefaultAPIVersion,
+				ClusterNames:        []string{clusterName},
+				RefreshDelay:        durationpb.New(refreshDelay),
+			},
+		},
+	}
+}
+
+func apiConfigSource(apiType core.ApiConfigSource_ApiType, clusterName string) *core.ConfigSource {
+	return &core.ConfigSource{
+		ResourceApiVersion: resource.DefaultAPIVersion,
+		ConfigSourceSpecifier: &core.ConfigSource_ApiConfigSource{
+			ApiConfigSource: &core.ApiConfigSource{
+				ApiType:                   apiType,
+				TransportApiVersion:       resource.DefaultAPIVersion,
+				SetNodeOnFirstMessageOnly: true,
+				GrpcServices: []*core.GrpcService{{
+					TargetSpecifier: &core.GrpcService_EnvoyGrpc_{
+						EnvoyGrpc: &core.GrpcService_EnvoyGrpc{ClusterName: clusterName},
+					},
+				}},
+			},
+		},
+	}
+}
+
+// Address is the socket address of a host.
+type Address struct {
+	Host string
+	Port uint32
+}
+
+func socketAddress(addr Address) *core.Address {
+	return &core.Address{
+		Address: &core.Address_SocketAddress{
+			SocketAddress: &core.SocketAddress{
+				Protocol: core.SocketAddress_TCP,
+				Address:  addr.Host,
+				PortSpecifier: &core.SocketAddress_PortValue{
+					PortValue: addr.Port,
+				},
+			},
+		},
+	}
+}
+
+// mustMarshalAny packs a message in an Any.
+func mustMarshalAny(msg proto.Message) *anypb.Any {
+	out, err := anypb.New(msg)
+	if err != nil {
+		panic(err)
+	}
+	return out
+}
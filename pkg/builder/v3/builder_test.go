@@ -0,0 +1,177 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package builder_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	cluster "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	cors "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/cors/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/builder/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/wellknown"
+)
+
+func makeSnapshot(t *testing.T) *cache.Snapshot {
+	sds := builder.ADSConfigSource()
+	snap, err := cache.NewSnapshot("1", map[resource.Type][]types.Resource{
+		resource.EndpointType: {
+			builder.NewEndpoints("backend",
+				builder.WithLocality(builder.Locality{
+					Region:   "us-east-1",
+					Zone:     "a",
+					Weight:   10,
+					Hosts:    []builder.Address{{Host: "10.0.0.1", Port: 8080}},
+					Priority: 0,
+				}),
+				builder.WithLocality(builder.Locality{
+					Region:   "us-east-1",
+					Zone:     "b",
+					Weight:   10,
+					Priority: 1,
+					Hosts:    []builder.Address{{Host: "10.0.1.1", Port: 8080}},
+				}),
+			),
+		},
+		resource.ClusterType: {
+			builder.NewCluster("backend",
+				builder.WithHTTP2(),
+				builder.WithHealthCheck(builder.NewHTTPHealthCheck("/healthz", 5*time.Second, time.Second)),
+				builder.WithUpstreamTLS("backend.local", builder.WithSDSValidationContext("ca", sds)),
+			),
+			builder.NewCluster("canary",
+				builder.WithStrictDNS(builder.Address{Host: "canary.local", Port: 443}),
+				builder.WithLbPolicy(cluster.Cluster_LEAST_REQUEST),
+				builder.WithHealthCheck(builder.NewTCPHealthCheck(5*time.Second, time.Second)),
+			),
+		},
+		resource.RouteType: {
+			builder.NewRouteConfig("routes",
+				builder.NewVirtualHost("backend", nil,
+					builder.NewRoute("/", "backend",
+						builder.WithWeightedClusters(map[string]uint32{"backend": 90, "canary": 10}),
+						builder.WithTimeout(15*time.Second),
+					),
+				),
+			),
+		},
+		resource.ListenerType: {
+			builder.NewListener("https", "0.0.0.0", 8443,
+				builder.WithFilterChain(builder.NewHTTPFilterChain(
+					builder.NewHTTPConnectionManager("https", "routes"),
+					builder.WithServerNames("backend.example.com"),
+					builder.WithDownstreamTLS(
+						builder.WithSDSCertificate("cert", sds),
+						builder.WithALPN("h2", "http/1.1"),
+					),
+				)),
+			),
+			builder.NewListener("tcp", "0.0.0.0", 9000,
+				builder.WithFilterChain(builder.NewTCPProxyFilterChain("tcp", "canary")),
+			),
+		},
+		resource.SecretType: {
+			builder.NewTLSCertificateSecret("cert", []byte("chain"), []byte("key")),
+			builder.NewValidationContextSecret("ca", []byte("ca")),
+		},
+	})
+	require.NoError(t, err)
+	return snap
+}
+
+func TestBuiltSnapshot(t *testing.T) {
+	snap := makeSnapshot(t)
+	assert.NoError(t, snap.Consistent())
+	assert.NoError(t, snap.Validate())
+	assert.Empty(t, snap.CheckSemantics().Issues)
+}
+
+func TestNewCluster(t *testing.T) {
+	c := builder.NewCluster("eds", builder.WithServiceName("service"))
+	assert.Equal(t, cluster.Cluster_EDS, c.GetType())
+	assert.Equal(t, "service", c.GetEdsClusterConfig().GetServiceName())
+	assert.Equal(t, builder.DefaultConnectTimeout, c.GetConnectTimeout().AsDuration())
+	assert.Nil(t, c.GetLoadAssignment())
+
+	c = builder.NewCluster("static",
+		builder.WithStatic(builder.Address{Host: "127.0.0.1", Port: 8080}, builder.Address{Host: "127.0.0.2", Port: 8080}),
+		builder.WithConnectTimeout(time.Second),
+	)
+	assert.Equal(t, cluster.Cluster_STATIC, c.GetType())
+	assert.Nil(t, c.GetEdsClusterConfig())
+	assert.Equal(t, "static", c.GetLoadAssignment().GetClusterName())
+	assert.Len(t, c.GetLoadAssignment().GetEndpoints()[0].GetLbEndpoints(), 2)
+	assert.Equal(t, time.Second, c.GetConnectTimeout().AsDuration())
+	assert.NoError(t, cache.ValidateResource(resource.ClusterType, c))
+
+	c = builder.NewCluster("dns", builder.WithLogicalDNS(builder.Address{Host: "example.com", Port: 443}))
+	assert.Equal(t, cluster.Cluster_LOGICAL_DNS, c.GetType())
+	assert.NoError(t, cache.ValidateResource(resource.ClusterType, c))
+}
+
+func TestNewHTTPConnectionManager(t *testing.T) {
+	manager := builder.NewHTTPConnectionManager("http", "routes",
+		builder.WithRDSConfigSource(builder.GRPCConfigSource("xds_cluster")),
+		builder.WithHTTPFilter(wellknown.CORS, &cors.Cors{}),
+	)
+	require.Len(t, manager.GetHttpFilters(), 2)
+	assert.Equal(t, wellknown.CORS, manager.GetHttpFilters()[0].GetName())
+	assert.Equal(t, wellknown.Router, manager.GetHttpFilters()[1].GetName())
+	assert.Equal(t, "routes", manager.GetRds().GetRouteConfigName())
+	grpc := manager.GetRds().GetConfigSource().GetApiConfigSource().GetGrpcServices()
+	require.Len(t, grpc, 1)
+	assert.Equal(t, "xds_cluster", grpc[0].GetEnvoyGrpc().GetClusterName())
+
+	manager = builder.NewHTTPConnectionManager("http", "",
+		builder.WithInlineRoutes(builder.NewRouteConfig("inline",
+			builder.NewVirtualHost("all", nil, builder.NewRoute("/", "backend")))),
+	)
+	assert.Nil(t, manager.GetRds())
+	assert.Equal(t, "inline", manager.GetRouteConfig().GetName())
+}
+
+func TestNewRoute(t *testing.T) {
+	r := builder.NewRoute("/api", "backend",
+		builder.WithExactPath("/api/v1"),
+		builder.WithPrefixRewrite("/"),
+		builder.WithHostRewrite("backend.local"),
+		builder.WithWeightedClusters(map[string]uint32{"b": 20, "a": 80}),
+	)
+	assert.Equal(t, "/api/v1", r.GetMatch().GetPath())
+	assert.Equal(t, "/", r.GetRoute().GetPrefixRewrite())
+	assert.Equal(t, "backend.local", r.GetRoute().GetHostRewriteLiteral())
+	assert.Empty(t, r.GetRoute().GetCluster())
+
+	weighted := r.GetRoute().GetWeightedClusters().GetClusters()
+	require.Len(t, weighted, 2)
+	assert.Equal(t, "a", weighted[0].GetName())
+	assert.Equal(t, uint32(80), weighted[0].GetWeight().GetValue())
+	assert.Equal(t, "b", weighted[1].GetName())
+}
+
+func TestConfigSources(t *testing.T) {
+	assert.NotNil(t, builder.ADSConfigSource().GetAds())
+	assert.Equal(t, resource.DefaultAPIVersion, builder.GRPCConfigSource("xds").GetResourceApiVersion())
+	assert.Equal(t, "DELTA_GRPC", builder.DeltaGRPCConfigSource("xds").GetApiConfigSource().GetApiType().String())
+	rest := builder.RESTConfigSource("xds", time.Second).GetApiConfigSource()
+	assert.Equal(t, []string{"xds"}, rest.GetClusterNames())
+	assert.Equal(t, time.Second, rest.GetRefreshDelay().AsDuration())
+}
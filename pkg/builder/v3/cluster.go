@@ -0,0 +1,188 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package builder
+
+import (
+	"time"
+
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	cluster "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	upstreamhttp "github.com/envoyproxy/go-control-plane/envoy/extensions/upstreams/http/v3"
+)
+
+// DefaultConnectTimeout is the connect timeout of the clusters built by
+// NewCluster.
+const DefaultConnectTimeout = 5 * time.Second
+
+// httpProtocolOptionsKey is the extension name of the upstream HTTP protocol
+// options in a cluster.
+const httpProtocolOptionsKey = "envoy.extensions.upstreams.http.v3.HttpProtocolOptions"
+
+// ClusterOption configures a cluster.
+type ClusterOption func(*cluster.Cluster)
+
+// NewCluster returns a round robin cluster fetching its endpoints over EDS
+// through ADS, unless configured otherwise.
+func NewCluster(name string, opts ...ClusterOption) *cluster.Cluster {
+	c := &cluster.Cluster{
+		Name:           name,
+		ConnectTimeout: durationpb.New(DefaultConnectTimeout),
+		LbPolicy:       cluster.Cluster_ROUND_ROBIN,
+	}
+	WithEDS(ADSConfigSource())(c)
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// WithEDS fetches the endpoints of the cluster over EDS from the given source.
+func WithEDS(source *core.ConfigSource) ClusterOption {
+	return func(c *cluster.Cluster) {
+		c.ClusterDiscoveryType = &cluster.Cluster_Type{Type: cluster.Cluster_EDS}
+		c.LoadAssignment = nil
+		c.EdsClusterConfig = &cluster.Cluster_EdsClusterConfig{
+			EdsConfig: source,
+		}
+	}
+}
+
+// WithServiceName sets the name of the load assignment requested over EDS,
+// which defaults to the cluster name.
+func WithServiceName(serviceName string) ClusterOption {
+	return func(c *cluster.Cluster) {
+		if c.EdsClusterConfig != nil {
+			c.EdsClusterConfig.ServiceName = serviceName
+		}
+	}
+}
+
+// WithStatic embeds the hosts of the cluster in its definition.
+func WithStatic(hosts ...Address) ClusterOption {
+	return withLoadAssignment(cluster.Cluster_STATIC, hosts)
+}
+
+// WithStrictDNS resolves the hosts of the cluster with DNS, using every
+// returned address.
+func WithStrictDNS(hosts ...Address) ClusterOption {
+	return withLoadAssignment(cluster.Cluster_STRICT_DNS, hosts)
+}
+
+// WithLogicalDNS resolves the host of the cluster with DNS, using only the
+// first returned address for new connections.
+func WithLogicalDNS(host Address) ClusterOption {
+	return withLoadAssignment(cluster.Cluster_LOGICAL_DNS, []Address{host})
+}
+
+func withLoadAssignment(discoveryType cluster.Cluster_DiscoveryType, hosts []Address) ClusterOption {
+	return func(c *cluster.Cluster) {
+		c.ClusterDiscoveryType = &cluster.Cluster_Type{Type: discoveryType}
+		c.EdsClusterConfig = nil
+		c.LoadAssignment = NewEndpoints(c.Name, WithHosts(hosts...))
+	}
+}
+
+// WithConnectTimeout overrides DefaultConnectTimeout.
+func WithConnectTimeout(timeout time.Duration) ClusterOption {
+	return func(c *cluster.Cluster) {
+		c.ConnectTimeout = durationpb.New(timeout)
+	}
+}
+
+// WithLbPolicy sets the load balancing policy of the cluster.
+func WithLbPolicy(policy cluster.Cluster_LbPolicy) ClusterOption {
+	return func(c *cluster.Cluster) {
+		c.LbPolicy = policy
+	}
+}
+
+// WithDNSLookupFamily sets the address family used to resolve DNS hosts.
+func WithDNSLookupFamily(family cluster.Cluster_DnsLookupFamily) ClusterOption {
+	return func(c *cluster.Cluster) {
+		c.DnsLookupFamily = family
+	}
+}
+
+// WithUpstreamTLS originates TLS to the hosts of the cluster.
+func WithUpstreamTLS(sni string, opts ...TLSOption) ClusterOption {
+	return func(c *cluster.Cluster) {
+		c.TransportSocket = NewUpstreamTLSTransportSocket(sni, opts...)
+	}
+}
+
+// WithHealthCheck adds an active health check to the cluster.
+func WithHealthCheck(hc *core.HealthCheck) ClusterOption {
+	return func(c *cluster.Cluster) {
+		c.HealthChecks = append(c.HealthChecks, hc)
+	}
+}
+
+// WithHTTP2 makes the cluster use HTTP/2 for upstream requests, as required
+// by gRPC services.
+func WithHTTP2() ClusterOption {
+	return func(c *cluster.Cluster) {
+		options := &upstreamhttp.HttpProtocolOptions{
+			UpstreamProtocolOptions: &upstreamhttp.HttpProtocolOptions_ExplicitHttpConfig_{
+				ExplicitHttpConfig: &upstreamhttp.HttpProtocolOptions_ExplicitHttpConfig{
+					ProtocolConfig: &upstreamhttp.HttpProtocolOptions_ExplicitHttpConfig_Http2ProtocolOptions{
+						Http2ProtocolOptions: &core.Http2ProtocolOptions{},
+					},
+				},
+			},
+		}
+		if c.TypedExtensionProtocolOptions == nil {
+			c.TypedExtensionProtocolOptions = map[string]*anypb.Any{}
+		}
+		c.TypedExtensionProtocolOptions[httpProtocolOptionsKey] = mustMarshalAny(options)
+	}
+}
+
+const (
+	defaultUnhealthyThreshold = 3
+	defaultHealthyThreshold   = 2
+)
+
+// NewHTTPHealthCheck returns a health check issuing GET requests on the path.
+func NewHTTPHealthCheck(path string, interval, timeout time.Duration) *core.HealthCheck {
+	hc := newHealthCheck(interval, timeout)
+	hc.HealthChecker = &core.HealthCheck_HttpHealthCheck_{
+		HttpHealthCheck: &core.HealthCheck_HttpHealthCheck{
+			Path: path,
+		},
+	}
+	return hc
+}
+
+// NewTCPHealthCheck returns a health check that only opens connections.
+func NewTCPHealthCheck(interval, timeout time.Duration) *core.HealthCheck {
+	hc := newHealthCheck(interval, timeout)
+	hc.HealthChecker = &core.HealthCheck_TcpHealthCheck_{
+		TcpHealthCheck: &core.HealthCheck_TcpHealthCheck{},
+	}
+	return hc
+}
+
+func newHealthCheck(interval, timeout time.Duration) *core.HealthCheck {
+	return &core.HealthCheck{
+		Interval:           durationpb.New(interval),
+		Timeout:            durationpb.New(timeout),
+		UnhealthyThreshold: wrapperspb.UInt32(defaultUnhealthyThreshold),
+		HealthyThreshold:   wrapperspb.UInt32(defaultHealthyThreshold),
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package builder
+
+import (
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	endpoint "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+)
+
+// EndpointsOption configures a cluster load assignment.
+type EndpointsOption func(*endpoint.ClusterLoadAssignment)
+
+// NewEndpoints returns the load assignment of a cluster, served over EDS or
+// embedded in a static cluster.
+func NewEndpoints(clusterName string, opts ...EndpointsOption) *endpoint.ClusterLoadAssignment {
+	cla := &endpoint.ClusterLoadAssignment{
+		ClusterName: clusterName,
+	}
+	for _, opt := range opts {
+		opt(cla)
+	}
+	return cla
+}
+
+// WithHosts adds the hosts to a locality without any region or zone.
+func WithHosts(hosts ...Address) EndpointsOption {
+	return WithLocality(Locality{Hosts: hosts})
+}
+
+// Locality is a group of hosts sharing a region, zone and priority.
+type Locality struct {
+	Region  string
+	Zone    string
+	SubZone string
+
+	// Priority is the failover priority of the locality, 0 being the highest.
+	Priority uint32
+
+	// Weight enables locality weighted load balancing when set.
+	Weight uint32
+
+	Hosts []Address
+}
+
+// WithLocality adds a locality to the load assignment.
+func WithLocality(locality Locality) EndpointsOption {
+	return func(cla *endpoint.ClusterLoadAssignment) {
+		lb := &endpoint.LocalityLbEndpoints{
+			Priority: locality.Priority,
+		}
+		if locality.Region != "" || locality.Zone != "" || locality.SubZone != "" {
+			lb.Locality = &core.Locality{
+				Region:  locality.Region,
+				Zone:    locality.Zone,
+				SubZone: locality.SubZone,
+			}
+		}
+		if locality.Weight > 0 {
+			lb.LoadBalancingWeight = wrapperspb.UInt32(locality.Weight)
+		}
+		for _, host := range locality.Hosts {
+			lb.LbEndpoints = append(lb.LbEndpoints, &endpoint.LbEndpoint{
+				HostIdentifier: &endpoint.LbEndpoint_Endpoint{
+					Endpoint: &endpoint.Endpoint{
+						Address: socketAddress(host),
+					},
+				},
+			})
+		}
+		cla.Endpoints = append(cla.Endpoints, lb)
+	}
+}
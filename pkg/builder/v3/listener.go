@@ -0,0 +1,183 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package builder
+
+import (
+	"google.golang.org/protobuf/proto"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	listener "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	route "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	router "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/router/v3"
+	hcm "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	tcp "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/tcp_proxy/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/wellknown"
+)
+
+// ListenerOption configures a listener.
+type ListenerOption func(*listener.Listener)
+
+// NewListener returns a listener bound to the address and port. A listener
+// needs at least one filter chain, added with WithFilterChain or
+// WithDefaultFilterChain.
+func NewListener(name, address string, port uint32, opts ...ListenerOption) *listener.Listener {
+	l := &listener.Listener{
+		Name:    name,
+		Address: socketAddress(Address{Host: address, Port: port}),
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+// WithFilterChain adds a filter chain to the listener.
+func WithFilterChain(chain *listener.FilterChain) ListenerOption {
+	return func(l *listener.Listener) {
+		l.FilterChains = append(l.FilterChains, chain)
+	}
+}
+
+// WithDefaultFilterChain sets the filter chain used when no other chain
+// matches the connection.
+func WithDefaultFilterChain(chain *listener.FilterChain) ListenerOption {
+	return func(l *listener.Listener) {
+		l.DefaultFilterChain = chain
+	}
+}
+
+// WithListenerFilter adds a listener filter, such as the TLS inspector.
+func WithListenerFilter(name string, config proto.Message) ListenerOption {
+	return func(l *listener.Listener) {
+		l.ListenerFilters = append(l.ListenerFilters, &listener.ListenerFilter{
+			Name: name,
+			ConfigType: &listener.ListenerFilter_TypedConfig{
+				TypedConfig: mustMarshalAny(config),
+			},
+		})
+	}
+}
+
+// FilterChainOption configures a filter chain.
+type FilterChainOption func(*listener.FilterChain)
+
+// NewHTTPFilterChain returns a filter chain handing connections to the HTTP
+// connection manager.
+func NewHTTPFilterChain(manager *hcm.HttpConnectionManager, opts ...FilterChainOption) *listener.FilterChain {
+	return newFilterChain(wellknown.HTTPConnectionManager, manager, opts)
+}
+
+// NewTCPProxyFilterChain returns a filter chain proxying connections to the
+// cluster.
+func NewTCPProxyFilterChain(statPrefix, clusterName string, opts ...FilterChainOption) *listener.FilterChain {
+	return newFilterChain(wellknown.TCPProxy, &tcp.TcpProxy{
+		StatPrefix: statPrefix,
+		ClusterSpecifier: &tcp.TcpProxy_Cluster{
+			Cluster: clusterName,
+		},
+	}, opts)
+}
+
+func newFilterChain(name string, config proto.Message, opts []FilterChainOption) *listener.FilterChain {
+	chain := &listener.FilterChain{
+		Filters: []*listener.Filter{{
+			Name: name,
+			ConfigType: &listener.Filter_TypedConfig{
+				TypedConfig: mustMarshalAny(config),
+			},
+		}},
+	}
+	for _, opt := range opts {
+		opt(chain)
+	}
+	return chain
+}
+
+// WithServerNames matches the chain on the SNI of the connection.
+func WithServerNames(serverNames ...string) FilterChainOption {
+	return func(chain *listener.FilterChain) {
+		if chain.FilterChainMatch == nil {
+			chain.FilterChainMatch = &listener.FilterChainMatch{}
+		}
+		chain.FilterChainMatch.ServerNames = serverNames
+	}
+}
+
+// WithDownstreamTLS terminates TLS on the chain.
+func WithDownstreamTLS(opts ...TLSOption) FilterChainOption {
+	return func(chain *listener.FilterChain) {
+		chain.TransportSocket = NewDownstreamTLSTransportSocket(opts...)
+	}
+}
+
+// HTTPConnectionManagerOption configures an HTTP connection manager.
+type HTTPConnectionManagerOption func(*hcm.HttpConnectionManager)
+
+// NewHTTPConnectionManager returns an HTTP connection manager fetching the
+// route configuration over RDS through ADS, unless configured otherwise. The
+// router filter is always installed last.
+func NewHTTPConnectionManager(statPrefix, routeName string, opts ...HTTPConnectionManagerOption) *hcm.HttpConnectionManager {
+	manager := &hcm.HttpConnectionManager{
+		CodecType:  hcm.HttpConnectionManager_AUTO,
+		StatPrefix: statPrefix,
+		RouteSpecifier: &hcm.HttpConnectionManager_Rds{
+			Rds: &hcm.Rds{
+				RouteConfigName: routeName,
+				ConfigSource:    ADSConfigSource(),
+			},
+		},
+	}
+	for _, opt := range opts {
+		opt(manager)
+	}
+	manager.HttpFilters = append(manager.HttpFilters, &hcm.HttpFilter{
+		Name: wellknown.Router,
+		ConfigType: &hcm.HttpFilter_TypedConfig{
+			TypedConfig: mustMarshalAny(&router.Router{}),
+		},
+	})
+	return manager
+}
+
+// WithRDSConfigSource fetches the route configuration from the given source.
+func WithRDSConfigSource(source *core.ConfigSource) HTTPConnectionManagerOption {
+	return func(manager *hcm.HttpConnectionManager) {
+		if rds := manager.GetRds(); rds != nil {
+			rds.ConfigSource = source
+		}
+	}
+}
+
+// WithInlineRoutes embeds the route configuration instead of fetching it over
+// RDS.
+func WithInlineRoutes(routes *route.RouteConfiguration) HTTPConnectionManagerOption {
+	return func(manager *hcm.HttpConnectionManager) {
+		manager.RouteSpecifier = &hcm.HttpConnectionManager_RouteConfig{
+			RouteConfig: routes,
+		}
+	}
+}
+
+// WithHTTPFilter adds an HTTP filter, run in order before the router filter.
+func WithHTTPFilter(name string, config proto.Message) HTTPConnectionManagerOption {
+	return func(manager *hcm.HttpConnectionManager) {
+		manager.HttpFilters = append(manager.HttpFilters, &hcm.HttpFilter{
+			Name: name,
+			ConfigType: &hcm.HttpFilter_TypedConfig{
+				TypedConfig: mustMarshalAny(config),
+			},
+		})
+	}
+}
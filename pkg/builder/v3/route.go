@@ -0,0 +1,128 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package builder
+
+import (
+	"sort"
+	"time"
+
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	route "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+)
+
+// NewRouteConfig returns a route configuration served over RDS or inlined in
+// an HTTP connection manager.
+func NewRouteConfig(name string, virtualHosts ...*route.VirtualHost) *route.RouteConfiguration {
+	return &route.RouteConfiguration{
+		Name:         name,
+		VirtualHosts: virtualHosts,
+	}
+}
+
+// NewVirtualHost returns a virtual host matching the domains, or any domain
+// when none is given.
+func NewVirtualHost(name string, domains []string, routes ...*route.Route) *route.VirtualHost {
+	if len(domains) == 0 {
+		domains = []string{"*"}
+	}
+	return &route.VirtualHost{
+		Name:    name,
+		Domains: domains,
+		Routes:  routes,
+	}
+}
+
+// RouteOption configures a route.
+type RouteOption func(*route.Route)
+
+// NewRoute returns a route forwarding the requests whose path starts with the
+// prefix to the cluster.
+func NewRoute(prefix, clusterName string, opts ...RouteOption) *route.Route {
+	r := &route.Route{
+		Match: &route.RouteMatch{
+			PathSpecifier: &route.RouteMatch_Prefix{
+				Prefix: prefix,
+			},
+		},
+		Action: &route.Route_Route{
+			Route: &route.RouteAction{
+				ClusterSpecifier: &route.RouteAction_Cluster{
+					Cluster: clusterName,
+				},
+			},
+		},
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// WithExactPath matches the path exactly instead of by prefix.
+func WithExactPath(path string) RouteOption {
+	return func(r *route.Route) {
+		r.Match.PathSpecifier = &route.RouteMatch_Path{
+			Path: path,
+		}
+	}
+}
+
+// WithWeightedClusters splits the traffic between the clusters according to
+// their weights, replacing the cluster given to NewRoute.
+func WithWeightedClusters(weights map[string]uint32) RouteOption {
+	return func(r *route.Route) {
+		names := make([]string, 0, len(weights))
+		for name := range weights {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		weighted := &route.WeightedCluster{}
+		for _, name := range names {
+			weighted.Clusters = append(weighted.Clusters, &route.WeightedCluster_ClusterWeight{
+				Name:   name,
+				Weight: wrapperspb.UInt32(weights[name]),
+			})
+		}
+		r.GetRoute().ClusterSpecifier = &route.RouteAction_WeightedClusters{
+			WeightedClusters: weighted,
+		}
+	}
+}
+
+// WithTimeout sets the timeout of the upstream requests.
+func WithTimeout(timeout time.Duration) RouteOption {
+	return func(r *route.Route) {
+		r.GetRoute().Timeout = durationpb.New(timeout)
+	}
+}
+
+// WithPrefixRewrite replaces the matched prefix before forwarding the request.
+func WithPrefixRewrite(prefix string) RouteOption {
+	return func(r *route.Route) {
+		r.GetRoute().PrefixRewrite = prefix
+	}
+}
+
+// WithHostRewrite replaces the host header before forwarding the request.
+func WithHostRewrite(host string) RouteOption {
+	return func(r *route.Route) {
+		r.GetRoute().HostRewriteSpecifier = &route.RouteAction_HostRewriteLiteral{
+			HostRewriteLiteral: host,
+		}
+	}
+}
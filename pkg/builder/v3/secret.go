@@ -0,0 +1,55 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package builder
+
+import (
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	auth "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+)
+
+// NewTLSCertificateSecret returns a secret holding a PEM encoded certificate
+// chain and its private key.
+func NewTLSCertificateSecret(name string, certificateChain, privateKey []byte) *auth.Secret {
+	return &auth.Secret{
+		Name: name,
+		Type: &auth.Secret_TlsCertificate{
+			TlsCertificate: &auth.TlsCertificate{
+				CertificateChain: inlineBytes(certificateChain),
+				PrivateKey:       inlineBytes(privateKey),
+			},
+		},
+	}
+}
+
+// NewValidationContextSecret returns a secret holding the PEM encoded
+// certificate authorities trusted to verify peers.
+func NewValidationContextSecret(name string, trustedCA []byte) *auth.Secret {
+	return &auth.Secret{
+		Name: name,
+		Type: &auth.Secret_ValidationContext{
+			ValidationContext: &auth.CertificateValidationContext{
+				TrustedCa: inlineBytes(trustedCA),
+			},
+		},
+	}
+}
+
+func inlineBytes(data []byte) *core.DataSource {
+	return &core.DataSource{
+		Specifier: &core.DataSource_InlineBytes{
+			InlineBytes: data,
+		},
+	}
+}
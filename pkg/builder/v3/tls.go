@@ -0,0 +1,123 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package builder
+
+import (
+	"google.golang.org/protobuf/proto"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	auth "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/wellknown"
+)
+
+// TLSOption configures the TLS context shared by upstream and downstream
+// transport sockets.
+type TLSOption func(*auth.CommonTlsContext)
+
+// WithSDSCertificate fetches the certificate presented to the peer from the
+// secret discovery service.
+func WithSDSCertificate(secretName string, source *core.ConfigSource) TLSOption {
+	return func(c *auth.CommonTlsContext) {
+		c.TlsCertificateSdsSecretConfigs = append(c.TlsCertificateSdsSecretConfigs, &auth.SdsSecretConfig{
+			Name:      secretName,
+			SdsConfig: source,
+		})
+	}
+}
+
+// WithSDSValidationContext fetches the context used to verify the peer
+// certificate from the secret discovery service.
+func WithSDSValidationContext(secretName string, source *core.ConfigSource) TLSOption {
+	return func(c *auth.CommonTlsContext) {
+		c.ValidationContextType = &auth.CommonTlsContext_ValidationContextSdsSecretConfig{
+			ValidationContextSdsSecretConfig: &auth.SdsSecretConfig{
+				Name:      secretName,
+				SdsConfig: source,
+			},
+		}
+	}
+}
+
+// WithALPN sets the protocols advertised during ALPN negotiation.
+func WithALPN(protocols ...string) TLSOption {
+	return func(c *auth.CommonTlsContext) {
+		c.AlpnProtocols = protocols
+	}
+}
+
+func commonTLSContext(opts []TLSOption) *auth.CommonTlsContext {
+	c := &auth.CommonTlsContext{}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// NewUpstreamTLSTransportSocket returns a TLS transport socket for a cluster.
+// The server name is sent as SNI when not empty.
+func NewUpstreamTLSTransportSocket(sni string, opts ...TLSOption) *core.TransportSocket {
+	return tlsTransportSocket(&auth.UpstreamTlsContext{
+		CommonTlsContext: commonTLSContext(opts),
+		Sni:              sni,
+	})
+}
+
+// NewDownstreamTLSTransportSocket returns a TLS transport socket for a
+// listener filter chain.
+func NewDownstreamTLSTransportSocket(opts ...TLSOption) *core.TransportSocket {
+	return tlsTransportSocket(&auth.DownstreamTlsContext{
+		CommonTlsContext: commonTLSContext(opts),
+	})
+}
+
+func tlsTransportSocket(context proto.Message) *core.TransportSocket {
+	return &core.TransportSocket{
+		Name: wellknown.TransportSocketTls,
+		ConfigType: &core.TransportSocket_TypedConfig{
+			TypedConfig: mustMarshalAny(context),
+		},
+	}
+}
+
+// WithCertificateFiles reads the certificate presented to the peer and its
+// private key from PEM files.
+func WithCertificateFiles(certificateChainPath, privateKeyPath string) TLSOption {
+	return func(c *auth.CommonTlsContext) {
+		c.TlsCertificates = append(c.TlsCertificates, &auth.TlsCertificate{
+			CertificateChain: fileDataSource(certificateChainPath),
+			PrivateKey:       fileDataSource(privateKeyPath),
+		})
+	}
+}
+
+// WithTrustedCAFile verifies the peer certificate against the certificate
+// authorities of a PEM file.
+func WithTrustedCAFile(path string) TLSOption {
+	return func(c *auth.CommonTlsContext) {
+		c.ValidationContextType = &auth.CommonTlsContext_ValidationContext{
+			ValidationContext: &auth.CertificateValidationContext{
+				TrustedCa: fileDataSource(path),
+			},
+		}
+	}
+}
+
+func fileDataSource(path string) *core.DataSource {
+	return &core.DataSource{
+		Specifier: &core.DataSource_Filename{
+			Filename: path,
+		},
+	}
+}
@@ -1,9 +1,11 @@
 package types
 
 import (
+	"sync"
 	"time"
 
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoregistry"
 )
 
 // Resource is the base interface for the xDS payload.
@@ -15,6 +17,10 @@ type Resource interface {
 type ResourceWithTTL struct {
 	Resource Resource
 	TTL      *time.Duration
+
+	// Serialized is the resource already marshaled, served in place of
+	// Resource, which is then left nil.
+	Serialized *SerializedResource
 }
 
 // ResourceWithName provides a name for out-of-tree resources.
@@ -26,6 +32,55 @@ type ResourceWithName interface {
 // MarshaledResource is an alias for the serialized binary array.
 type MarshaledResource = []byte
 
+// SerializedResource is a resource already marshaled in the protobuf wire
+// format, e.g. as read from storage. The caches serve its bytes as they are,
+// without reflection, and hash them only when no version is given.
+//
+// The message is decoded, with the type registered for TypeURL, only when it
+// must be inspected: consistency checks, validation and semantic checks.
+// The bytes must not be modified once the resource is added to a cache.
+//
+// It is not a Resource: it is added to snapshots in the Serialized field of a
+// ResourceWithTTL, and to the linear cache with UpdateSerializedResources.
+// Decode returns the message it holds.
+type SerializedResource struct {
+	// Name of the resource.
+	Name string
+
+	// TypeURL of the serialized message.
+	TypeURL string
+
+	// Bytes of the serialized message.
+	Bytes MarshaledResource
+
+	// Version of the resource, used in place of the hash of its bytes
+	// for delta xDS and content versions. Optional.
+	Version string
+
+	once    sync.Once
+	decoded proto.Message
+	err     error
+}
+
+// Decode returns the message of the resource, unmarshaled on first use.
+// The error wraps protoregistry.NotFound if the type is not linked into the binary.
+func (r *SerializedResource) Decode() (proto.Message, error) {
+	r.once.Do(func() {
+		mt, err := protoregistry.GlobalTypes.FindMessageByURL(r.TypeURL)
+		if err != nil {
+			r.err = err
+			return
+		}
+		msg := mt.New().Interface()
+		if err := proto.Unmarshal(r.Bytes, msg); err != nil {
+			r.err = err
+			return
+		}
+		r.decoded = msg
+	})
+	return r.decoded, r.err
+}
+
 // SkipFetchError is the error returned when the cache fetch is short
 // circuited due to the client's version already being up-to-date.
 type SkipFetchError struct{}
@@ -35,7 +90,9 @@ func (e SkipFetchError) Error() string {
 	return "skip fetch: version up to date"
 }
 
-// ResponseType enumeration of supported response types
+// ResponseType enumeration of supported response types. Types registered at
+// run time with cache.RegisterResourceType are assigned values above
+// UnknownType.
 type ResponseType int
 
 const (
@@ -49,5 +106,6 @@ const (
 	Runtime
 	ExtensionConfig
 	FilterChain
-	UnknownType // token to count the total number of supported types
+	ThriftRoute
+	UnknownType // token to count the total number of built-in types
 )
@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"sync/atomic"
 
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/anypb"
 	"google.golang.org/protobuf/types/known/durationpb"
@@ -133,6 +134,9 @@ type RawResponse struct {
 
 	// marshaledResponse holds an atomic reference to the serialized discovery response.
 	marshaledResponse atomic.Value
+
+	// marshalCache of the cache the response comes from, if any.
+	marshalCache *MarshalCache
 }
 
 // RawDeltaResponse is a pre-serialized xDS response that utilizes the delta discovery request/response objects.
@@ -146,6 +150,9 @@ type RawDeltaResponse struct {
 	// Resources to be included in the response.
 	Resources []types.Resource
 
+	// SerializedResources to be included in the response, already marshaled.
+	SerializedResources []*types.SerializedResource
+
 	// RemovedResources is a list of resource aliases which should be dropped by the consuming client.
 	RemovedResources []string
 
@@ -158,6 +165,9 @@ type RawDeltaResponse struct {
 
 	// Marshaled Resources to be included in the response.
 	marshaledResponse atomic.Value
+
+	// marshalCache of the cache the response comes from, if any.
+	marshalCache *MarshalCache
 }
 
 var _ Response = &RawResponse{}
@@ -191,6 +201,25 @@ type DeltaPassthroughResponse struct {
 var _ Response = &PassthroughResponse{}
 var _ DeltaResponse = &DeltaPassthroughResponse{}
 
+// TerminateResponse ends the stream it is sent on with a gRPC status instead
+// of responding to the request. It can be sent on both SOTW and delta watches.
+type TerminateResponse struct {
+	// Request is the original request, for SOTW watches.
+	Request *discovery.DiscoveryRequest
+
+	// DeltaRequest is the latest delta request, for delta watches.
+	DeltaRequest *discovery.DeltaDiscoveryRequest
+
+	// Status is returned to the client.
+	Status *status.Status
+
+	// Context provided at the time of response creation.
+	Ctx context.Context
+}
+
+var _ Response = &TerminateResponse{}
+var _ DeltaResponse = &TerminateResponse{}
+
 // GetDiscoveryResponse performs the marshaling the first time its called and uses the cached response subsequently.
 // This is necessary because the marshaled response does not change across the calls.
 // This caching behavior is important in high throughput scenarios because grpc marshaling has a cost and it drives the cpu utilization under load.
@@ -205,7 +234,14 @@ func (r *RawResponse) GetDiscoveryResponse() (*discovery.DiscoveryResponse, erro
 			if err != nil {
 				return nil, err
 			}
-			marshaledResource, err := MarshalResource(maybeTtldResource)
+			// The TTL wrappers are created for each response, only the
+			// resources they hold are memoized.
+			var marshaledResource types.MarshaledResource
+			if resource.TTL != nil {
+				marshaledResource, err = MarshalResource(maybeTtldResource)
+			} else {
+				marshaledResource, err = r.marshalCache.marshalEntry(resource)
+			}
 			if err != nil {
 				return nil, err
 			}
@@ -234,15 +270,14 @@ func (r *RawDeltaResponse) GetDeltaDiscoveryResponse() (*discovery.DeltaDiscover
 	marshaledResponse := r.marshaledResponse.Load()
 
 	if marshaledResponse == nil {
-		marshaledResources := make([]*discovery.Resource, len(r.Resources))
+		marshaledResources := make([]*discovery.Resource, len(r.Resources), len(r.Resources)+len(r.SerializedResources))
 
 		for i, resource := range r.Resources {
 			name := GetResourceName(resource)
-			marshaledResource, err := MarshalResource(resource)
+			marshaledResource, version, err := r.marshalCache.marshalAndHash(resource)
 			if err != nil {
 				return nil, err
 			}
-			version := HashResource(marshaledResource)
 			if version == "" {
 				return nil, errors.New("failed to create a resource hash")
 			}
@@ -255,6 +290,20 @@ func (r *RawDeltaResponse) GetDeltaDiscoveryResponse() (*discovery.DeltaDiscover
 				Version: version,
 			}
 		}
+		for _, s := range r.SerializedResources {
+			marshaledResource, version, err := serializedAndHash(s)
+			if err != nil {
+				return nil, err
+			}
+			marshaledResources = append(marshaledResources, &discovery.Resource{
+				Name: s.Name,
+				Resource: &anypb.Any{
+					TypeUrl: r.DeltaRequest.TypeUrl,
+					Value:   marshaledResource,
+				},
+				Version: version,
+			})
+		}
 
 		marshaledResponse = &discovery.DeltaDiscoveryResponse{
 			Resources:         marshaledResources,
@@ -301,22 +350,38 @@ func (r *RawDeltaResponse) GetContext() context.Context {
 	return r.Ctx
 }
 
+// numResources returns the number of resources of the response, serialized or not.
+func (r *RawDeltaResponse) numResources() int {
+	return len(r.Resources) + len(r.SerializedResources)
+}
+
+// resourceNames returns the names of the resources of the response, serialized or not.
+func (r *RawDeltaResponse) resourceNames() []string {
+	names := GetResourceNames(r.Resources)
+	for _, s := range r.SerializedResources {
+		names = append(names, s.Name)
+	}
+	return names
+}
+
 var deltaResourceTypeURL = "type.googleapis.com/" + string(proto.MessageName(&discovery.Resource{}))
 
 func (r *RawResponse) maybeCreateTTLResource(resource types.ResourceWithTTL) (types.Resource, string, error) {
 	if resource.TTL != nil {
 		wrappedResource := &discovery.Resource{
-			Name: GetResourceName(resource.Resource),
+			Name: entryName(resource),
 			Ttl:  durationpb.New(*resource.TTL),
 		}
 
 		if !r.Heartbeat {
-			any, err := anypb.New(resource.Resource)
+			marshaledResource, err := r.marshalCache.marshalEntry(resource)
 			if err != nil {
 				return nil, "", err
 			}
-			any.TypeUrl = r.Request.TypeUrl
-			wrappedResource.Resource = any
+			wrappedResource.Resource = &anypb.Any{
+				TypeUrl: r.Request.TypeUrl,
+				Value:   marshaledResource,
+			}
 		}
 
 		return wrappedResource, deltaResourceTypeURL, nil
@@ -325,6 +390,45 @@ func (r *RawResponse) maybeCreateTTLResource(resource types.ResourceWithTTL) (ty
 	return resource.Resource, r.Request.TypeUrl, nil
 }
 
+// GetDiscoveryResponse returns the status error terminating the stream.
+func (r *TerminateResponse) GetDiscoveryResponse() (*discovery.DiscoveryResponse, error) {
+	return nil, r.Status.Err()
+}
+
+// GetDeltaDiscoveryResponse returns the status error terminating the stream.
+func (r *TerminateResponse) GetDeltaDiscoveryResponse() (*discovery.DeltaDiscoveryResponse, error) {
+	return nil, r.Status.Err()
+}
+
+// GetRequest returns the original Discovery Request, if any.
+func (r *TerminateResponse) GetRequest() *discovery.DiscoveryRequest {
+	return r.Request
+}
+
+// GetDeltaRequest returns the Delta Discovery Request, if any.
+func (r *TerminateResponse) GetDeltaRequest() *discovery.DeltaDiscoveryRequest {
+	return r.DeltaRequest
+}
+
+// GetVersion returns the status error, the response has no version.
+func (r *TerminateResponse) GetVersion() (string, error) {
+	return "", r.Status.Err()
+}
+
+// GetSystemVersion returns the status error, the response has no version.
+func (r *TerminateResponse) GetSystemVersion() (string, error) {
+	return "", r.Status.Err()
+}
+
+// GetNextVersionMap returns nil, the stream ends with the response.
+func (r *TerminateResponse) GetNextVersionMap() map[string]string {
+	return nil
+}
+
+func (r *TerminateResponse) GetContext() context.Context {
+	return r.Ctx
+}
+
 // GetDiscoveryResponse returns the final passthrough Discovery Response.
 func (r *PassthroughResponse) GetDiscoveryResponse() (*discovery.DiscoveryResponse, error) {
 	return r.DiscoveryResponse, nil
@@ -0,0 +1,121 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/envoyproxy/go-control-plane/pkg/log"
+)
+
+// EvictionCallback is called with the status of the nodes evicted by the
+// stale node collection, see WithStaleNodeCollection.
+type EvictionCallback func(node string, info StatusInfo)
+
+// NodeCollectionStats reports the activity of the stale node collection.
+type NodeCollectionStats struct {
+	// Collections is the number of collections run.
+	Collections int64
+
+	// EvictedNodes is the total number of nodes evicted.
+	EvictedNodes int64
+
+	// LastCollection is the time of the last collection.
+	LastCollection time.Time
+}
+
+// NodeCollector is implemented by the snapshot cache to report the activity
+// of its stale node collection:
+//
+//	c.(cache.NodeCollector).GetNodeCollectionStats()
+type NodeCollector interface {
+	// GetNodeCollectionStats returns the statistics of the stale node
+	// collection, see WithStaleNodeCollection.
+	GetNodeCollectionStats() NodeCollectionStats
+}
+
+var _ NodeCollector = &snapshotCache{}
+
+// WithStaleNodeCollection evicts the nodes that have no open watch and have
+// neither requested resources nor had their snapshot changed for longer than
+// the TTL, so that the status of the nodes that went away is not kept forever. The collection runs every interval
+// until the context is canceled.
+//
+// Eviction removes the status, the snapshot and the overlay of the node, as
+// ClearSnapshot does: a node coming back must be served a group, generated or
+// fallback snapshot, or have its snapshot set again. The optional callback is
+// called with the cache locked for each evicted node and must not call the
+// cache.
+func WithStaleNodeCollection(ctx context.Context, ttl, interval time.Duration, callback EvictionCallback) SnapshotCacheOption {
+	return func(cache *snapshotCache) {
+		cache.onEvict = callback
+		go func() {
+			t := time.NewTicker(interval)
+			defer t.Stop()
+
+			for {
+				select {
+				case now := <-t.C:
+					cache.collectStaleNodes(now.Add(-ttl))
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+}
+
+// collectStaleNodes evicts the nodes without open watches whose last watch
+// request and last snapshot change are older than the deadline.
+//
+// Watches are created and snapshots set with the cache locked, and refresh
+// these times, so a node is never evicted while a watch is being created nor
+// with a snapshot set after the deadline.
+func (cache *snapshotCache) collectStaleNodes(deadline time.Time) []string {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	var evicted []string
+	for node, info := range cache.status {
+		if !info.stale(deadline) {
+			continue
+		}
+
+		cache.log.Info("evict stale node", log.Node(node))
+		delete(cache.snapshots, node)
+		delete(cache.overlays, node)
+		delete(cache.status, node)
+		evicted = append(evicted, node)
+
+		if cache.onEvict != nil {
+			cache.onEvict(node, info)
+		}
+	}
+
+	cache.collectionStats.Collections++
+	cache.collectionStats.EvictedNodes += int64(len(evicted))
+	cache.metrics.NodeCollections.Add(1)
+	cache.metrics.EvictedNodes.Add(float64(len(evicted)))
+	cache.collectionStats.LastCollection = time.Now()
+	return evicted
+}
+
+// GetNodeCollectionStats returns the statistics of the stale node collection.
+func (cache *snapshotCache) GetNodeCollectionStats() NodeCollectionStats {
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+	return cache.collectionStats
+}
@@ -0,0 +1,121 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	cluster "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/server/stream/v3"
+)
+
+func TestCollectStaleNodes(t *testing.T) {
+	var evicted []string
+	cache := newSnapshotCache(false, IDHash{}, nil)
+	cache.onEvict = func(node string, info StatusInfo) {
+		evicted = append(evicted, node)
+	}
+	for _, id := range []string{"idle", "watching", "delta"} {
+		snapshot, err := NewSnapshot("1", nil)
+		require.NoError(t, err)
+		require.NoError(t, cache.SetSnapshot(context.Background(), id, snapshot))
+	}
+
+	state := stream.NewStreamState(false, nil)
+	cancel := cache.CreateWatch(&discovery.DiscoveryRequest{
+		Node: &core.Node{Id: "idle"}, TypeUrl: resource.ClusterType,
+	}, state, make(chan Response, 1))
+	cancel()
+	cache.CreateWatch(&discovery.DiscoveryRequest{
+		Node: &core.Node{Id: "watching"}, TypeUrl: resource.ClusterType,
+	}, state, make(chan Response, 1))
+	cache.CreateDeltaWatch(&discovery.DeltaDiscoveryRequest{
+		Node: &core.Node{Id: "delta"}, TypeUrl: resource.ClusterType,
+	}, state, make(chan DeltaResponse, 1))
+
+	// Recent requests are not collected.
+	assert.Empty(t, cache.collectStaleNodes(time.Now().Add(-time.Minute)))
+
+	assert.Equal(t, []string{"idle"}, cache.collectStaleNodes(time.Now().Add(time.Minute)))
+	assert.Equal(t, []string{"idle"}, evicted)
+	assert.ElementsMatch(t, []string{"watching", "delta"}, cache.GetStatusKeys())
+	_, err := cache.GetSnapshot("idle")
+	assert.Error(t, err)
+
+	stats := cache.GetNodeCollectionStats()
+	assert.Equal(t, int64(2), stats.Collections)
+	assert.Equal(t, int64(1), stats.EvictedNodes)
+	assert.False(t, stats.LastCollection.IsZero())
+}
+
+func TestWithStaleNodeCollection(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	evicted := make(chan string, 1)
+	cache := newSnapshotCache(false, IDHash{}, nil, WithStaleNodeCollection(ctx, time.Millisecond, 10*time.Millisecond,
+		func(node string, info StatusInfo) { evicted <- node }))
+
+	cache.CreateWatch(&discovery.DiscoveryRequest{
+		Node: &core.Node{Id: "node"}, TypeUrl: resource.ClusterType,
+	}, stream.NewStreamState(false, nil), make(chan Response, 1))()
+
+	select {
+	case node := <-evicted:
+		assert.Equal(t, "node", node)
+	case <-time.After(time.Second):
+		t.Fatal("node was not evicted")
+	}
+	assert.Empty(t, cache.GetStatusKeys())
+}
+
+func TestCollectStaleNodesAfterSetSnapshot(t *testing.T) {
+	cache := newSnapshotCache(false, IDHash{}, nil)
+	clusters := map[resource.Type][]types.Resource{resource.ClusterType: {&cluster.Cluster{Name: "backend"}}}
+	snapshot, err := NewSnapshot("1", clusters)
+	require.NoError(t, err)
+	require.NoError(t, cache.SetSnapshot(context.Background(), "node", snapshot))
+
+	state := stream.NewStreamState(false, nil)
+	request := &discovery.DiscoveryRequest{Node: &core.Node{Id: "node"}, TypeUrl: resource.ClusterType, VersionInfo: "1"}
+	cache.CreateWatch(request, state, make(chan Response, 1))
+	cache.status["node"].lastWatchRequestTime = time.Now().Add(-time.Hour)
+
+	// The new snapshot responds to the only watch of the node, which has none
+	// open until its client ACKs.
+	snapshot2, err := NewSnapshot("2", clusters)
+	require.NoError(t, err)
+	require.NoError(t, cache.SetSnapshot(context.Background(), "node", snapshot2))
+	assert.Equal(t, 0, cache.GetStatusInfo("node").GetNumWatches())
+
+	assert.Empty(t, cache.collectStaleNodes(time.Now().Add(-time.Minute)))
+
+	ack := &discovery.DiscoveryRequest{Node: &core.Node{Id: "node"}, TypeUrl: resource.ClusterType, VersionInfo: "2"}
+	value := make(chan Response, 1)
+	cache.CreateWatch(ack, state, value)
+	assert.Empty(t, value)
+	got, err := cache.GetSnapshot("node")
+	require.NoError(t, err)
+	assert.Equal(t, "2", got.GetVersion(resource.ClusterType))
+}
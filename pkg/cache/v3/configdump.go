@@ -0,0 +1,355 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package cache
+
+import (
+	"fmt"
+	"sort"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+
+	admin "github.com/envoyproxy/go-control-plane/envoy/admin/v3"
+	bootstrap "github.com/envoyproxy/go-control-plane/envoy/config/bootstrap/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+)
+
+// snapshotImporter collects resources by type, keeping the first resource
+// seen for a name since Envoy lists static resources in several sections of a
+// config dump.
+type snapshotImporter struct {
+	resources map[resource.Type][]types.Resource
+	names     map[resource.Type]map[string]struct{}
+}
+
+func newSnapshotImporter() *snapshotImporter {
+	return &snapshotImporter{
+		resources: make(map[resource.Type][]types.Resource),
+		names:     make(map[resource.Type]map[string]struct{}),
+	}
+}
+
+func (i *snapshotImporter) add(typeURL resource.Type, res types.Resource) {
+	name := GetResourceName(res)
+	if _, ok := i.names[typeURL]; !ok {
+		i.names[typeURL] = make(map[string]struct{})
+	}
+	if _, ok := i.names[typeURL][name]; ok {
+		return
+	}
+	i.names[typeURL][name] = struct{}{}
+	i.resources[typeURL] = append(i.resources[typeURL], res)
+}
+
+// addAny unpacks a resource from a config dump. Missing entries, e.g. the
+// active state of a listener that is still warming, are skipped.
+func (i *snapshotImporter) addAny(typeURL resource.Type, a *anypb.Any) error {
+	if a == nil {
+		return nil
+	}
+	if a.GetTypeUrl() != typeURL {
+		return fmt.Errorf("unexpected resource type %q, want %q", a.GetTypeUrl(), typeURL)
+	}
+	msg, err := a.UnmarshalNew()
+	if err != nil {
+		return err
+	}
+	i.add(typeURL, msg)
+	return nil
+}
+
+func (i *snapshotImporter) addBootstrap(b *bootstrap.Bootstrap) {
+	static := b.GetStaticResources()
+	for _, l := range static.GetListeners() {
+		i.add(resource.ListenerType, l)
+	}
+	for _, c := range static.GetClusters() {
+		i.add(resource.ClusterType, c)
+	}
+	for _, s := range static.GetSecrets() {
+		i.add(resource.SecretType, s)
+	}
+}
+
+// NewSnapshotFromBootstrap creates a snapshot from the listeners, clusters and
+// secrets of the static_resources section of a bootstrap.
+//
+// Note that the cluster pointing to the management server usually belongs to
+// the bootstrap only, as Envoy refuses to replace a static cluster over CDS.
+func NewSnapshotFromBootstrap(version string, b *bootstrap.Bootstrap) (*Snapshot, error) {
+	importer := newSnapshotImporter()
+	importer.addBootstrap(b)
+	return NewSnapshot(version, importer.resources)
+}
+
+// NewSnapshotFromConfigDump creates a snapshot from the output of the
+// /config_dump admin endpoint. Both the static and the dynamic sections are
+// imported: for dynamic resources, the active state is preferred over the
+// warming one and draining or failed updates are ignored.
+func NewSnapshotFromConfigDump(version string, dump *admin.ConfigDump) (*Snapshot, error) {
+	importer := newSnapshotImporter()
+	for _, config := range dump.GetConfigs() {
+		msg, err := config.UnmarshalNew()
+		if err != nil {
+			return nil, err
+		}
+		if err := importer.addConfigDump(msg); err != nil {
+			return nil, fmt.Errorf("%s: %w", config.GetTypeUrl(), err)
+		}
+	}
+	return NewSnapshot(version, importer.resources)
+}
+
+func (i *snapshotImporter) addConfigDump(msg proto.Message) error {
+	var err error
+	add := func(typeURL resource.Type, a *anypb.Any) {
+		if err == nil {
+			err = i.addAny(typeURL, a)
+		}
+	}
+
+	switch dump := msg.(type) {
+	case *admin.BootstrapConfigDump:
+		i.addBootstrap(dump.GetBootstrap())
+	case *admin.ClustersConfigDump:
+		for _, c := range dump.GetStaticClusters() {
+			add(resource.ClusterType, c.GetCluster())
+		}
+		for _, c := range dump.GetDynamicActiveClusters() {
+			add(resource.ClusterType, c.GetCluster())
+		}
+		for _, c := range dump.GetDynamicWarmingClusters() {
+			add(resource.ClusterType, c.GetCluster())
+		}
+	case *admin.ListenersConfigDump:
+		for _, l := range dump.GetStaticListeners() {
+			add(resource.ListenerType, l.GetListener())
+		}
+		for _, l := range dump.GetDynamicListeners() {
+			if state := l.GetActiveState(); state != nil {
+				add(resource.ListenerType, state.GetListener())
+			} else {
+				add(resource.ListenerType, l.GetWarmingState().GetListener())
+			}
+		}
+	case *admin.RoutesConfigDump:
+		for _, r := range dump.GetStaticRouteConfigs() {
+			add(resource.RouteType, r.GetRouteConfig())
+		}
+		for _, r := range dump.GetDynamicRouteConfigs() {
+			add(resource.RouteType, r.GetRouteConfig())
+		}
+	case *admin.ScopedRoutesConfigDump:
+		for _, r := range dump.GetInlineScopedRouteConfigs() {
+			for _, scope := range r.GetScopedRouteConfigs() {
+				add(resource.ScopedRouteType, scope)
+			}
+		}
+		for _, r := range dump.GetDynamicScopedRouteConfigs() {
+			for _, scope := range r.GetScopedRouteConfigs() {
+				add(resource.ScopedRouteType, scope)
+			}
+		}
+	case *admin.EndpointsConfigDump:
+		for _, e := range dump.GetStaticEndpointConfigs() {
+			add(resource.EndpointType, e.GetEndpointConfig())
+		}
+		for _, e := range dump.GetDynamicEndpointConfigs() {
+			add(resource.EndpointType, e.GetEndpointConfig())
+		}
+	case *admin.SecretsConfigDump:
+		for _, s := range dump.GetStaticSecrets() {
+			add(resource.SecretType, s.GetSecret())
+		}
+		for _, s := range dump.GetDynamicActiveSecrets() {
+			add(resource.SecretType, s.GetSecret())
+		}
+		for _, s := range dump.GetDynamicWarmingSecrets() {
+			add(resource.SecretType, s.GetSecret())
+		}
+	case *admin.EcdsConfigDump:
+		for _, f := range dump.GetEcdsFilters() {
+			add(resource.ExtensionConfigType, f.GetEcdsFilter())
+		}
+	}
+
+	return err
+}
+
+// ConfigDump exports the snapshot in the format of the /config_dump admin
+// endpoint, as the dynamic resources of an Envoy that accepted it. Runtime
+// layers and virtual hosts have no config dump section and are left out.
+func (s *Snapshot) ConfigDump() (*admin.ConfigDump, error) {
+	if s == nil {
+		return nil, fmt.Errorf("missing snapshot")
+	}
+
+	// Sections follow the order of Envoy.
+	var dumps []proto.Message
+	clusters, err := s.packedResources(resource.ClusterType)
+	if err != nil {
+		return nil, err
+	}
+	if len(clusters) > 0 {
+		version := s.GetVersion(resource.ClusterType)
+		dump := &admin.ClustersConfigDump{VersionInfo: version}
+		for _, r := range clusters {
+			dump.DynamicActiveClusters = append(dump.DynamicActiveClusters, &admin.ClustersConfigDump_DynamicCluster{
+				VersionInfo: version,
+				Cluster:     r.resource,
+			})
+		}
+		dumps = append(dumps, dump)
+	}
+
+	extensions, err := s.packedResources(resource.ExtensionConfigType)
+	if err != nil {
+		return nil, err
+	}
+	if len(extensions) > 0 {
+		version := s.GetVersion(resource.ExtensionConfigType)
+		dump := &admin.EcdsConfigDump{}
+		for _, r := range extensions {
+			dump.EcdsFilters = append(dump.EcdsFilters, &admin.EcdsConfigDump_EcdsFilterConfig{
+				VersionInfo: version,
+				EcdsFilter:  r.resource,
+			})
+		}
+		dumps = append(dumps, dump)
+	}
+
+	listeners, err := s.packedResources(resource.ListenerType)
+	if err != nil {
+		return nil, err
+	}
+	if len(listeners) > 0 {
+		version := s.GetVersion(resource.ListenerType)
+		dump := &admin.ListenersConfigDump{VersionInfo: version}
+		for _, r := range listeners {
+			dump.DynamicListeners = append(dump.DynamicListeners, &admin.ListenersConfigDump_DynamicListener{
+				Name: r.name,
+				ActiveState: &admin.ListenersConfigDump_DynamicListenerState{
+					VersionInfo: version,
+					Listener:    r.resource,
+				},
+			})
+		}
+		dumps = append(dumps, dump)
+	}
+
+	scopedRoutes, err := s.packedResources(resource.ScopedRouteType)
+	if err != nil {
+		return nil, err
+	}
+	if len(scopedRoutes) > 0 {
+		version := s.GetVersion(resource.ScopedRouteType)
+		dump := &admin.ScopedRoutesConfigDump{}
+		for _, r := range scopedRoutes {
+			dump.DynamicScopedRouteConfigs = append(dump.DynamicScopedRouteConfigs, &admin.ScopedRoutesConfigDump_DynamicScopedRouteConfigs{
+				Name:               r.name,
+				VersionInfo:        version,
+				ScopedRouteConfigs: []*anypb.Any{r.resource},
+			})
+		}
+		dumps = append(dumps, dump)
+	}
+
+	routes, err := s.packedResources(resource.RouteType)
+	if err != nil {
+		return nil, err
+	}
+	if len(routes) > 0 {
+		version := s.GetVersion(resource.RouteType)
+		dump := &admin.RoutesConfigDump{}
+		for _, r := range routes {
+			dump.DynamicRouteConfigs = append(dump.DynamicRouteConfigs, &admin.RoutesConfigDump_DynamicRouteConfig{
+				VersionInfo: version,
+				RouteConfig: r.resource,
+			})
+		}
+		dumps = append(dumps, dump)
+	}
+
+	endpoints, err := s.packedResources(resource.EndpointType)
+	if err != nil {
+		return nil, err
+	}
+	if len(endpoints) > 0 {
+		version := s.GetVersion(resource.EndpointType)
+		dump := &admin.EndpointsConfigDump{}
+		for _, r := range endpoints {
+			dump.DynamicEndpointConfigs = append(dump.DynamicEndpointConfigs, &admin.EndpointsConfigDump_DynamicEndpointConfig{
+				VersionInfo:    version,
+				EndpointConfig: r.resource,
+			})
+		}
+		dumps = append(dumps, dump)
+	}
+
+	secrets, err := s.packedResources(resource.SecretType)
+	if err != nil {
+		return nil, err
+	}
+	if len(secrets) > 0 {
+		version := s.GetVersion(resource.SecretType)
+		dump := &admin.SecretsConfigDump{}
+		for _, r := range secrets {
+			dump.DynamicActiveSecrets = append(dump.DynamicActiveSecrets, &admin.SecretsConfigDump_DynamicSecret{
+				Name:        r.name,
+				VersionInfo: version,
+				Secret:      r.resource,
+			})
+		}
+		dumps = append(dumps, dump)
+	}
+
+	out := &admin.ConfigDump{}
+	for _, dump := range dumps {
+		config, err := anypb.New(dump)
+		if err != nil {
+			return nil, err
+		}
+		out.Configs = append(out.Configs, config)
+	}
+	return out, nil
+}
+
+type packedResource struct {
+	name     string
+	resource *anypb.Any
+}
+
+// packedResources returns the resources of a type packed in Any, sorted by
+// name so that the output is stable.
+func (s *Snapshot) packedResources(typeURL resource.Type) ([]packedResource, error) {
+	items := s.GetResourcesAndTTL(typeURL)
+	out := make([]packedResource, 0, len(items))
+	for name, item := range items {
+		if s := item.Serialized; s != nil {
+			out = append(out, packedResource{name: name, resource: &anypb.Any{TypeUrl: s.TypeURL, Value: s.Bytes}})
+			continue
+		}
+		packed, err := anypb.New(item.Resource)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, packedResource{name: name, resource: packed})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].name < out[j].name
+	})
+	return out, nil
+}
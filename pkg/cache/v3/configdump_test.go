@@ -0,0 +1,152 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package cache_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+
+	admin "github.com/envoyproxy/go-control-plane/envoy/admin/v3"
+	bootstrap "github.com/envoyproxy/go-control-plane/envoy/config/bootstrap/v3"
+	cluster "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	listener "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	auth "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	rsrc "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/test/resource/v3"
+)
+
+func mustAny(t *testing.T, msg proto.Message) *anypb.Any {
+	out, err := anypb.New(msg)
+	require.NoError(t, err)
+	return out
+}
+
+func assertSameResources(t *testing.T, expected, actual *cache.Snapshot, typeURL rsrc.Type) {
+	want := expected.GetResources(typeURL)
+	got := actual.GetResources(typeURL)
+	require.Len(t, got, len(want), typeURL)
+	for name, res := range want {
+		assert.True(t, proto.Equal(res, got[name]), "%s %q", typeURL, name)
+	}
+}
+
+func TestNewSnapshotFromBootstrap(t *testing.T) {
+	b := &bootstrap.Bootstrap{
+		StaticResources: &bootstrap.Bootstrap_StaticResources{
+			Listeners: []*listener.Listener{testListener},
+			Clusters:  []*cluster.Cluster{testCluster},
+			Secrets:   []*auth.Secret{testSecret[0]},
+		},
+	}
+	snap, err := cache.NewSnapshotFromBootstrap(fixture.version, b)
+	require.NoError(t, err)
+
+	assert.Equal(t, fixture.version, snap.GetVersion(rsrc.ListenerType))
+	assert.Contains(t, snap.GetResources(rsrc.ListenerType), listenerName)
+	assert.Contains(t, snap.GetResources(rsrc.ClusterType), clusterName)
+	assert.Contains(t, snap.GetResources(rsrc.SecretType), testSecret[0].Name)
+	assert.Empty(t, snap.GetResources(rsrc.RouteType))
+}
+
+func TestConfigDumpRoundTrip(t *testing.T) {
+	snap := fixture.snapshot()
+	dump, err := snap.ConfigDump()
+	require.NoError(t, err)
+
+	imported, err := cache.NewSnapshotFromConfigDump(fixture.version, dump)
+	require.NoError(t, err)
+	for _, typeURL := range []rsrc.Type{
+		rsrc.EndpointType,
+		rsrc.ClusterType,
+		rsrc.RouteType,
+		rsrc.ScopedRouteType,
+		rsrc.ListenerType,
+		rsrc.SecretType,
+		rsrc.ExtensionConfigType,
+	} {
+		assertSameResources(t, snap, imported, typeURL)
+	}
+	// Runtimes have no config dump section.
+	assert.Empty(t, imported.GetResources(rsrc.RuntimeType))
+}
+
+func TestNewSnapshotFromConfigDump(t *testing.T) {
+	warming := resource.MakeTCPListener("warming", 9001, clusterName)
+	dump := &admin.ConfigDump{
+		Configs: []*anypb.Any{
+			mustAny(t, &admin.BootstrapConfigDump{
+				Bootstrap: &bootstrap.Bootstrap{
+					StaticResources: &bootstrap.Bootstrap_StaticResources{
+						Clusters: []*cluster.Cluster{testCluster},
+					},
+				},
+			}),
+			mustAny(t, &admin.ClustersConfigDump{
+				// The static clusters of the bootstrap are repeated.
+				StaticClusters: []*admin.ClustersConfigDump_StaticCluster{{
+					Cluster: mustAny(t, testCluster),
+				}},
+			}),
+			mustAny(t, &admin.ListenersConfigDump{
+				DynamicListeners: []*admin.ListenersConfigDump_DynamicListener{
+					{
+						Name: listenerName,
+						ActiveState: &admin.ListenersConfigDump_DynamicListenerState{
+							Listener: mustAny(t, testListener),
+						},
+						DrainingState: &admin.ListenersConfigDump_DynamicListenerState{
+							Listener: mustAny(t, resource.MakeTCPListener(listenerName, 9000, clusterName)),
+						},
+					},
+					{
+						Name: "warming",
+						WarmingState: &admin.ListenersConfigDump_DynamicListenerState{
+							Listener: mustAny(t, warming),
+						},
+					},
+				},
+			}),
+			// Sections unrelated to resources are ignored.
+			mustAny(t, &admin.ServerInfo{}),
+		},
+	}
+
+	snap, err := cache.NewSnapshotFromConfigDump(fixture.version, dump)
+	require.NoError(t, err)
+	assert.Len(t, snap.GetResources(rsrc.ClusterType), 1)
+	listeners := snap.GetResources(rsrc.ListenerType)
+	require.Len(t, listeners, 2)
+	assert.True(t, proto.Equal(testListener, listeners[listenerName]))
+	assert.True(t, proto.Equal(warming, listeners["warming"]))
+}
+
+func TestNewSnapshotFromConfigDumpMismatchedType(t *testing.T) {
+	dump := &admin.ConfigDump{
+		Configs: []*anypb.Any{
+			mustAny(t, &admin.ClustersConfigDump{
+				StaticClusters: []*admin.ClustersConfigDump_StaticCluster{{
+					Cluster: mustAny(t, testListener),
+				}},
+			}),
+		},
+	}
+	_, err := cache.NewSnapshotFromConfigDump(fixture.version, dump)
+	assert.Error(t, err)
+}
@@ -0,0 +1,450 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	statuspb "google.golang.org/genproto/googleapis/rpc/status"
+
+	"github.com/envoyproxy/go-control-plane/pkg/server/events/v3"
+)
+
+// The snapshot cache is told by the servers it is given to of the versions
+// the streams ACKed and NACKed, which they match with the nonces of their
+// responses. Each server observes its streams with its own observer, as the
+// IDs of the streams are only unique within a server.
+var _ events.ObserverFactory = &snapshotCache{}
+
+// ConvergenceTracker is implemented by the snapshot cache to tell when the
+// snapshots it serves are applied:
+//
+//	c.(cache.ConvergenceTracker).Convergence(node).Wait(ctx)
+type ConvergenceTracker interface {
+	// Convergence returns a handle on the application of the snapshot served
+	// to a node by its streams currently connected, e.g. right after
+	// SetSnapshot. The cache learns the versions the streams applied from
+	// the servers it is given to.
+	Convergence(node string) *Convergence
+
+	// GroupConvergence returns a handle on the application of the snapshot
+	// of a group by the streams of its members currently connected, see
+	// GroupSnapshotCache.
+	GroupConvergence(group string) *Convergence
+}
+
+var _ ConvergenceTracker = &snapshotCache{}
+
+// StreamConvergence describes the application of a snapshot by a stream.
+type StreamConvergence struct {
+	// Node is the ID of the node of the stream, as computed by the NodeHash
+	// of the cache.
+	Node string
+
+	// StreamID is the ID of the stream, as given to the server callbacks,
+	// on the SOTW or delta server.
+	StreamID int64
+	Delta    bool
+
+	// Pending are the types requested by the stream which it has yet to ACK
+	// the version of.
+	Pending []string
+
+	// Nacks are the errors reported by the stream for the types it NACKed
+	// the version of.
+	Nacks map[string]*statuspb.Status
+}
+
+// ConvergenceReport classifies the streams tracked by a Convergence.
+type ConvergenceReport struct {
+	// Converged streams ACKed the version of all the types they requested.
+	Converged []StreamConvergence
+
+	// Laggards have yet to ACK the version of some types.
+	Laggards []StreamConvergence
+
+	// Nackers NACKed the version of some types.
+	Nackers []StreamConvergence
+
+	// Disconnected streams were closed before converging.
+	Disconnected []StreamConvergence
+}
+
+// ConvergenceError is returned by Convergence.Wait when some streams NACKed
+// the snapshot, were closed before applying it, or had not applied it yet
+// once the context was done.
+type ConvergenceError struct {
+	Report ConvergenceReport
+
+	// err is the error of the context, if done.
+	err error
+}
+
+func (e *ConvergenceError) Error() string {
+	msg := fmt.Sprintf("%d streams NACKed the snapshot, %d disconnected and %d have not applied it",
+		len(e.Report.Nackers), len(e.Report.Disconnected), len(e.Report.Laggards))
+	if e.err != nil {
+		msg += ": " + e.err.Error()
+	}
+	return msg
+}
+
+// Unwrap returns the error of the context, if done.
+func (e *ConvergenceError) Unwrap() error {
+	return e.err
+}
+
+// Convergence tracks the application of the snapshots served to the streams
+// connected when it was created. The versions of the snapshots are reported
+// ACKed by the servers the cache is given to; streams are unknown to a cache
+// that serves none, and there is then nothing to wait for.
+type Convergence struct {
+	tracker *ackTracker
+	streams []trackedStream
+}
+
+// trackedStream is a stream and the snapshot it is expected to apply.
+type trackedStream struct {
+	acks     *streamAcks
+	snapshot ResourceSnapshot
+}
+
+// Report returns the current state of the streams.
+func (c *Convergence) Report() ConvergenceReport {
+	c.tracker.mu.Lock()
+	defer c.tracker.mu.Unlock()
+
+	var report ConvergenceReport
+	for _, tracked := range c.streams {
+		s := tracked.acks
+		out := StreamConvergence{
+			Node:     s.nodeID,
+			StreamID: s.stream.ID,
+			Delta:    s.stream.Delta,
+		}
+		if s.closed {
+			report.Disconnected = append(report.Disconnected, out)
+			continue
+		}
+		for typeURL, acks := range s.types {
+			version := tracked.snapshot.GetVersion(typeURL)
+			switch {
+			case version == "":
+			case acks.nacked == version:
+				if out.Nacks == nil {
+					out.Nacks = make(map[string]*statuspb.Status)
+				}
+				out.Nacks[typeURL] = acks.errorDetail
+			case acks.acked != version:
+				out.Pending = append(out.Pending, typeURL)
+			}
+		}
+		sort.Strings(out.Pending)
+		switch {
+		case len(out.Nacks) > 0:
+			report.Nackers = append(report.Nackers, out)
+		case len(out.Pending) > 0:
+			report.Laggards = append(report.Laggards, out)
+		default:
+			report.Converged = append(report.Converged, out)
+		}
+	}
+	return report
+}
+
+// Wait waits until every stream has ACKed the version of the snapshot of all
+// the types it requested, or was closed. It returns a *ConvergenceError
+// reporting the NACKers and the closed streams as soon as the other streams
+// have all converged, or the laggards once the context is done.
+func (c *Convergence) Wait(ctx context.Context) error {
+	changes := c.tracker.subscribe()
+	defer c.tracker.unsubscribe(changes)
+
+	for {
+		report := c.Report()
+		if len(report.Laggards) == 0 {
+			if len(report.Nackers) > 0 || len(report.Disconnected) > 0 {
+				return &ConvergenceError{Report: report}
+			}
+			return nil
+		}
+		select {
+		case <-changes:
+		case <-ctx.Done():
+			return &ConvergenceError{Report: report, err: ctx.Err()}
+		}
+	}
+}
+
+// Convergence returns a handle on the application of the snapshot served to
+// a node by its streams currently connected.
+func (cache *snapshotCache) Convergence(node string) *Convergence {
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+
+	return cache.acks.track(func(s *streamAcks) ResourceSnapshot {
+		if s.nodeID != node {
+			return nil
+		}
+		return cache.servedSnapshot(s.nodeID, s.stream.Node)
+	})
+}
+
+// GroupConvergence returns a handle on the application of the snapshot of a
+// group by the streams of its members currently connected, except those with
+// their own snapshot.
+func (cache *snapshotCache) GroupConvergence(group string) *Convergence {
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+
+	return cache.acks.track(func(s *streamAcks) ResourceSnapshot {
+		if cache.groups == nil || cache.groups.ID(s.stream.Node) != group {
+			return nil
+		}
+		if _, ok := cache.snapshots[s.nodeID]; ok {
+			return nil
+		}
+		return cache.servedSnapshot(s.nodeID, s.stream.Node)
+	})
+}
+
+// NewObserver returns an observer of the streams of a server, which reports
+// the versions they ACK and NACK to the convergences of the cache. The
+// servers call it once each.
+func (cache *snapshotCache) NewObserver() events.Observer {
+	return &ackObserver{cache: cache, server: cache.acks.newServer()}
+}
+
+// ackObserver reports the versions applied by the streams of a server.
+type ackObserver struct {
+	cache  *snapshotCache
+	server uint64
+}
+
+func (o *ackObserver) key(stream events.Stream) streamKey {
+	return streamKey{server: o.server, id: stream.ID, delta: stream.Delta}
+}
+
+func (o *ackObserver) StreamOpened(_ context.Context, stream events.Stream) {
+	o.cache.acks.open(o.key(stream), stream, o.cache.hash.ID(stream.Node))
+}
+
+func (o *ackObserver) StreamClosed(_ context.Context, stream events.Stream) {
+	o.cache.acks.close(o.key(stream))
+}
+
+func (o *ackObserver) Requested(_ context.Context, stream events.Stream, typeURL string, names []string) {
+	o.cache.acks.update(o.key(stream), typeURL, func(acks *typeAcks) {
+		acks.names = names
+	})
+}
+
+func (o *ackObserver) ResourcesPushed(context.Context, events.Stream, events.Push) {}
+
+func (o *ackObserver) Acked(_ context.Context, stream events.Stream, typeURL, version string) {
+	o.cache.acks.update(o.key(stream), typeURL, func(acks *typeAcks) {
+		acks.acked = version
+		acks.nacked = ""
+		acks.errorDetail = nil
+	})
+}
+
+func (o *ackObserver) Nacked(_ context.Context, stream events.Stream, typeURL, version string, errorDetail *statuspb.Status) {
+	o.cache.acks.update(o.key(stream), typeURL, func(acks *typeAcks) {
+		acks.nacked = version
+		acks.errorDetail = errorDetail
+	})
+}
+
+func (o *ackObserver) Unsubscribed(context.Context, events.Stream, string, []string) {}
+
+// ackTracker records the versions applied by the streams of the servers, and
+// wakes up the convergences waiting for them.
+type ackTracker struct {
+	mu         sync.Mutex
+	lastServer uint64
+	streams    map[streamKey]*streamAcks
+	waiters    map[chan struct{}]struct{}
+
+	// nodes are the streams by node ID.
+	nodes map[string]map[streamKey]*streamAcks
+}
+
+// streamKey identifies a stream among those of all the servers.
+type streamKey struct {
+	server uint64
+	id     int64
+	delta  bool
+}
+
+// streamAcks are the versions last ACKed and NACKed by a stream, by type.
+type streamAcks struct {
+	stream events.Stream
+	nodeID string
+	closed bool
+	types  map[string]*typeAcks
+}
+
+type typeAcks struct {
+	acked       string
+	nacked      string
+	errorDetail *statuspb.Status
+
+	// names are the resource names last requested, see events.Observer.
+	names []string
+}
+
+// newServer returns the identifier of a new server.
+func (t *ackTracker) newServer() uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lastServer++
+	return t.lastServer
+}
+
+func (t *ackTracker) open(key streamKey, stream events.Stream, nodeID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.streams == nil {
+		t.streams = make(map[streamKey]*streamAcks)
+		t.nodes = make(map[string]map[streamKey]*streamAcks)
+	}
+	s := &streamAcks{
+		stream: stream,
+		nodeID: nodeID,
+		types:  make(map[string]*typeAcks),
+	}
+	t.streams[key] = s
+	if t.nodes[nodeID] == nil {
+		t.nodes[nodeID] = make(map[streamKey]*streamAcks)
+	}
+	t.nodes[nodeID][key] = s
+}
+
+func (t *ackTracker) close(key streamKey) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if s, ok := t.streams[key]; ok {
+		s.closed = true
+		delete(t.streams, key)
+		delete(t.nodes[s.nodeID], key)
+		if len(t.nodes[s.nodeID]) == 0 {
+			delete(t.nodes, s.nodeID)
+		}
+		t.notify()
+	}
+}
+
+// skipped records that the streams of a node which applied the version of a
+// type of the previous snapshot are not pushed the new one, as unchanged
+// reports for the resources they requested: they hold its resources, and
+// are considered to have applied its version.
+func (t *ackTracker) skipped(nodeID string, previous, snapshot ResourceSnapshot, unchanged func(s *streamAcks, typeURL string, names []string) bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	changed := false
+	for _, s := range t.nodes[nodeID] {
+		for typeURL, acks := range s.types {
+			version := snapshot.GetVersion(typeURL)
+			if acks.acked == "" || acks.acked == version || acks.acked != previous.GetVersion(typeURL) {
+				continue
+			}
+			if unchanged(s, typeURL, acks.names) {
+				acks.acked = version
+				changed = true
+			}
+		}
+	}
+	if changed {
+		t.notify()
+	}
+}
+
+// update applies f to the versions of a type of a stream.
+func (t *ackTracker) update(key streamKey, typeURL string, f func(*typeAcks)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s, ok := t.streams[key]
+	if !ok {
+		return
+	}
+	acks, ok := s.types[typeURL]
+	if !ok {
+		acks = &typeAcks{}
+		s.types[typeURL] = acks
+	}
+	f(acks)
+	t.notify()
+}
+
+// track returns a convergence of the open streams for which target returns a
+// snapshot, sorted by node and stream.
+func (t *ackTracker) track(target func(*streamAcks) ResourceSnapshot) *Convergence {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	c := &Convergence{tracker: t}
+	for _, s := range t.streams {
+		if snapshot := target(s); snapshot != nil {
+			c.streams = append(c.streams, trackedStream{acks: s, snapshot: snapshot})
+		}
+	}
+	sort.Slice(c.streams, func(i, j int) bool {
+		a, b := c.streams[i].acks, c.streams[j].acks
+		if a.nodeID != b.nodeID {
+			return a.nodeID < b.nodeID
+		}
+		if a.stream.Delta != b.stream.Delta {
+			return !a.stream.Delta
+		}
+		return a.stream.ID < b.stream.ID
+	})
+	return c
+}
+
+func (t *ackTracker) subscribe() chan struct{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	changes := make(chan struct{}, 1)
+	if t.waiters == nil {
+		t.waiters = make(map[chan struct{}]struct{})
+	}
+	t.waiters[changes] = struct{}{}
+	return changes
+}
+
+func (t *ackTracker) unsubscribe(changes chan struct{}) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.waiters, changes)
+}
+
+// notify wakes up the waiters. It must be called with the lock held.
+func (t *ackTracker) notify() {
+	for changes := range t.waiters {
+		select {
+		case changes <- struct{}{}:
+		default:
+		}
+	}
+}
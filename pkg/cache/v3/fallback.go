@@ -0,0 +1,70 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package cache
+
+import (
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/log"
+)
+
+// FallbackCallback is called when a node starts being served the fallback
+// snapshot, with fallback set to true, and when it is served a snapshot of its
+// own again, with fallback set to false.
+type FallbackCallback func(node *core.Node, fallback bool)
+
+// WithFallbackSnapshot serves a default snapshot, e.g. a minimal listener
+// denying all traffic, to the nodes the cache has no snapshot for instead of
+// leaving their watches open. The fallback is replaced as soon as a snapshot
+// is set for the node or its group, the open watches of the node being
+// responded with it as for any other update.
+//
+// The optional callback reports the streaming nodes moving onto and off the
+// fallback. It is called with the cache locked and must not call the cache.
+func WithFallbackSnapshot(snapshot ResourceSnapshot, callback FallbackCallback) SnapshotCacheOption {
+	return func(cache *snapshotCache) {
+		cache.fallback = snapshot
+		cache.onFallback = callback
+	}
+}
+
+// servedSnapshot returns the snapshot served to a node, its own or the
+// fallback, or nil. The cache mutex must be held.
+func (cache *snapshotCache) servedSnapshot(nodeID string, node *core.Node) ResourceSnapshot {
+	if snapshot := cache.nodeSnapshot(nodeID, node); snapshot != nil {
+		return snapshot
+	}
+	return cache.fallback
+}
+
+// markFallback records whether a node is served the fallback snapshot and
+// reports the changes to the callback. The cache mutex must be held.
+func (cache *snapshotCache) markFallback(info *statusInfo, snapshot ResourceSnapshot) {
+	fallback := cache.fallback != nil && snapshot == cache.fallback
+
+	info.mu.Lock()
+	changed := info.fallback != fallback
+	info.fallback = fallback
+	info.mu.Unlock()
+
+	if !changed {
+		return
+	}
+	if fallback {
+		cache.log.Info("serve fallback snapshot", log.Node(info.node.GetId()))
+	}
+	if cache.onFallback != nil {
+		cache.onFallback(info.node, fallback)
+	}
+}
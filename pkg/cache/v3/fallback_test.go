@@ -0,0 +1,88 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package cache_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	rsrc "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+)
+
+func TestSnapshotCacheFallback(t *testing.T) {
+	type event struct {
+		node     string
+		fallback bool
+	}
+	var events []event
+	callback := func(node *core.Node, fallback bool) {
+		events = append(events, event{node: node.GetId(), fallback: fallback})
+	}
+	c := cache.NewSnapshotCache(false, group{}, logger{t: t},
+		cache.WithFallbackSnapshot(clusterSnapshot(t, "fallback", "deny"), callback))
+	node := &core.Node{Id: "a"}
+
+	version, names := receiveClusters(t, watchClusters(c, node, ""))
+	assert.Equal(t, "fallback", version)
+	assert.Equal(t, []string{"deny"}, names)
+
+	value := watchClusters(c, node, version)
+	assert.Equal(t, []event{{node: "a", fallback: true}}, events)
+	_, err := c.GetSnapshot("a")
+	assert.Error(t, err)
+
+	// The fallback is replaced by the snapshot of the node.
+	require.NoError(t, c.SetSnapshot(context.Background(), "a", clusterSnapshot(t, "1", "backend")))
+	version, names = receiveClusters(t, value)
+	assert.Equal(t, "1", version)
+	assert.Equal(t, []string{"backend"}, names)
+	assert.Equal(t, []event{{node: "a", fallback: true}, {node: "a", fallback: false}}, events)
+
+	out, err := c.Fetch(context.Background(), &discovery.DiscoveryRequest{Node: &core.Node{Id: "b"}, TypeUrl: rsrc.ClusterType})
+	require.NoError(t, err)
+	version, err = out.GetVersion()
+	require.NoError(t, err)
+	assert.Equal(t, "fallback", version)
+}
+
+func TestSnapshotCacheFallbackGroup(t *testing.T) {
+	var fallbacks int
+	callback := func(node *core.Node, fallback bool) {
+		if fallback {
+			fallbacks++
+		} else {
+			fallbacks--
+		}
+	}
+	c := cache.NewSnapshotCache(false, group{}, logger{t: t}, cache.WithNodeGroups(clusterGroup{}),
+		cache.WithFallbackSnapshot(clusterSnapshot(t, "fallback", "deny"), callback))
+
+	a := watchClusters(c, &core.Node{Id: "a", Cluster: "edge"}, "fallback")
+	b := watchClusters(c, &core.Node{Id: "b", Cluster: "edge"}, "fallback")
+	assert.Equal(t, 2, fallbacks)
+
+	require.NoError(t, c.(cache.GroupSnapshotCache).SetGroupSnapshot(context.Background(), "edge", clusterSnapshot(t, "1", "backend")))
+	for _, value := range []chan cache.Response{a, b} {
+		version, _ := receiveClusters(t, value)
+		assert.Equal(t, "1", version)
+	}
+	assert.Equal(t, 0, fallbacks)
+}
@@ -0,0 +1,158 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/log"
+)
+
+// DefaultGenerationTimeout is the time the generator is given to compute a
+// snapshot, see WithGenerationTimeout.
+const DefaultGenerationTimeout = 30 * time.Second
+
+// SnapshotGenerator computes the snapshot of a node the cache has no
+// snapshot for. It is called from a separate goroutine, once per group of
+// nodes, see WithSnapshotGenerator.
+type SnapshotGenerator interface {
+	GenerateSnapshot(ctx context.Context, node *core.Node) (ResourceSnapshot, error)
+}
+
+// SnapshotGeneratorFunc is a function implementing SnapshotGenerator.
+type SnapshotGeneratorFunc func(ctx context.Context, node *core.Node) (ResourceSnapshot, error)
+
+// GenerateSnapshot calls the function.
+func (f SnapshotGeneratorFunc) GenerateSnapshot(ctx context.Context, node *core.Node) (ResourceSnapshot, error) {
+	return f(ctx, node)
+}
+
+// WithSnapshotGenerator makes the cache generate the snapshots of the nodes it
+// has none for when they first request resources, rather than requiring them
+// to be set beforehand. The watches of the node are left open in the meantime
+// and responded once the snapshot is generated, while fetch requests wait for
+// it.
+//
+// Generated snapshots are stored as group snapshots when WithNodeGroups is
+// used, so that the generator runs once for all the nodes of a group, and as
+// node snapshots otherwise. They are not generated again until cleared, and a
+// snapshot set while the generator runs takes precedence over its result.
+// Failures, including a nil snapshot, are logged and retried on the next
+// request of a node.
+func WithSnapshotGenerator(generator SnapshotGenerator) SnapshotCacheOption {
+	return func(cache *snapshotCache) {
+		cache.generator = generator
+	}
+}
+
+// WithGenerationTimeout sets the deadline of the context given to the
+// generator, DefaultGenerationTimeout by default. The cache stops waiting for
+// a generator still running past it, which fails the generation and releases
+// the fetch requests waiting for it.
+func WithGenerationTimeout(timeout time.Duration) SnapshotCacheOption {
+	return func(cache *snapshotCache) {
+		cache.generationTimeout = timeout
+	}
+}
+
+// generation is a snapshot being generated. done is closed when it completes.
+type generation struct {
+	done chan struct{}
+}
+
+// generate starts the generation of the snapshot of a node without one, unless
+// it is already underway for its group, and returns a channel closed once it
+// completes. The cache mutex must be held for writing.
+func (cache *snapshotCache) generate(nodeID string, node *core.Node) <-chan struct{} {
+	if cache.nodeSnapshot(nodeID, node) != nil {
+		done := make(chan struct{})
+		close(done)
+		return done
+	}
+
+	key := nodeID
+	if cache.groups != nil {
+		key = cache.groups.ID(node)
+	}
+	if gen, ok := cache.generations[key]; ok {
+		return gen.done
+	}
+
+	gen := &generation{done: make(chan struct{})}
+	cache.generations[key] = gen
+	cache.log.Debug("generate snapshot", log.Node(nodeID), log.Any("key", key))
+
+	go func() {
+		snapshot, err := cache.callGenerator(node)
+		if err == nil && snapshot == nil {
+			err = errors.New("generator returned no snapshot")
+		}
+		if err == nil && cache.validate {
+			err = validateSnapshot(snapshot)
+		}
+
+		cache.mu.Lock()
+		defer cache.mu.Unlock()
+		defer close(gen.done)
+		delete(cache.generations, key)
+
+		if err != nil {
+			cache.log.Error("failed to generate snapshot", log.Node(nodeID), log.Any("key", key), log.Err(err))
+			return
+		}
+		// The generation timeout does not apply to the responses to the open
+		// watches.
+		ctx := context.Background()
+		if cache.groups != nil {
+			if _, ok := cache.groupSnapshots[key]; !ok {
+				err = cache.setGroupSnapshot(ctx, key, snapshot)
+			}
+		} else if _, ok := cache.snapshots[key]; !ok {
+			err = cache.setSnapshot(ctx, key, snapshot)
+		}
+		if err != nil {
+			cache.log.Error("failed to set generated snapshot", log.Node(nodeID), log.Any("key", key), log.Err(err))
+		}
+	}()
+
+	return gen.done
+}
+
+// callGenerator calls the generator, and returns the error of its context if
+// the generation timeout expires first.
+func (cache *snapshotCache) callGenerator(node *core.Node) (ResourceSnapshot, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), cache.generationTimeout)
+	defer cancel()
+
+	type result struct {
+		snapshot ResourceSnapshot
+		err      error
+	}
+	out := make(chan result, 1)
+	go func() {
+		snapshot, err := cache.generator.GenerateSnapshot(ctx, node)
+		out <- result{snapshot: snapshot, err: err}
+	}()
+
+	select {
+	case res := <-out:
+		return res.snapshot, res.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
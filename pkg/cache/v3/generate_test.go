@@ -0,0 +1,151 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package cache_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	rsrc "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+)
+
+func TestSnapshotCacheGeneratorByGroup(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	generator := cache.SnapshotGeneratorFunc(func(ctx context.Context, node *core.Node) (cache.ResourceSnapshot, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return clusterSnapshot(t, "1", node.GetCluster()), nil
+	})
+	c := cache.NewSnapshotCache(false, group{}, logger{t: t},
+		cache.WithNodeGroups(clusterGroup{}), cache.WithSnapshotGenerator(generator))
+
+	watchA := watchClusters(c, &core.Node{Id: "a", Cluster: "edge"}, "")
+	watchB := watchClusters(c, &core.Node{Id: "b", Cluster: "edge"}, "")
+	close(release)
+
+	for _, value := range []chan cache.Response{watchA, watchB} {
+		version, names := receiveClusters(t, value)
+		assert.Equal(t, "1", version)
+		assert.Equal(t, []string{"edge"}, names)
+	}
+
+	// Later nodes of the group are served the memoized snapshot.
+	version, _ := receiveClusters(t, watchClusters(c, &core.Node{Id: "c", Cluster: "edge"}, ""))
+	assert.Equal(t, "1", version)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestSnapshotCacheGeneratorFetch(t *testing.T) {
+	var calls int32
+	generator := cache.SnapshotGeneratorFunc(func(ctx context.Context, node *core.Node) (cache.ResourceSnapshot, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			return nil, errors.New("not ready")
+		}
+		return clusterSnapshot(t, "1", node.GetId()), nil
+	})
+	c := cache.NewSnapshotCache(false, group{}, logger{t: t}, cache.WithSnapshotGenerator(generator))
+	request := &discovery.DiscoveryRequest{Node: &core.Node{Id: "a"}, TypeUrl: rsrc.ClusterType}
+
+	// Failures are retried on the next request.
+	_, err := c.Fetch(context.Background(), request)
+	assert.Error(t, err)
+
+	out, err := c.Fetch(context.Background(), request)
+	require.NoError(t, err)
+	version, err := out.GetVersion()
+	require.NoError(t, err)
+	assert.Equal(t, "1", version)
+
+	snap, err := c.GetSnapshot("a")
+	require.NoError(t, err)
+	assert.Equal(t, "1", snap.GetVersion(rsrc.ClusterType))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestSnapshotCacheGeneratorPrecedence(t *testing.T) {
+	release := make(chan struct{})
+	generated := make(chan struct{})
+	generator := cache.SnapshotGeneratorFunc(func(ctx context.Context, node *core.Node) (cache.ResourceSnapshot, error) {
+		<-release
+		defer close(generated)
+		return clusterSnapshot(t, "generated", "backend"), nil
+	})
+	c := cache.NewSnapshotCache(false, group{}, logger{t: t}, cache.WithSnapshotGenerator(generator))
+
+	value := watchClusters(c, &core.Node{Id: "a"}, "")
+	require.NoError(t, c.SetSnapshot(context.Background(), "a", clusterSnapshot(t, "set", "backend")))
+	close(release)
+	<-generated
+
+	version, _ := receiveClusters(t, value)
+	assert.Equal(t, "set", version)
+	snap, err := c.GetSnapshot("a")
+	require.NoError(t, err)
+	assert.Equal(t, "set", snap.GetVersion(rsrc.ClusterType))
+}
+
+func TestSnapshotCacheGeneratorNilSnapshot(t *testing.T) {
+	generator := cache.SnapshotGeneratorFunc(func(ctx context.Context, node *core.Node) (cache.ResourceSnapshot, error) {
+		return nil, nil
+	})
+	c := cache.NewSnapshotCache(false, group{}, logger{t: t}, cache.WithSnapshotGenerator(generator))
+
+	_, err := c.Fetch(context.Background(), &discovery.DiscoveryRequest{Node: &core.Node{Id: "a"}, TypeUrl: rsrc.ClusterType})
+	assert.Error(t, err)
+	_, err = c.GetSnapshot("a")
+	assert.Error(t, err)
+}
+
+func TestSnapshotCacheGeneratorTimeout(t *testing.T) {
+	hung := make(chan struct{})
+	defer close(hung)
+	generator := cache.SnapshotGeneratorFunc(func(ctx context.Context, node *core.Node) (cache.ResourceSnapshot, error) {
+		<-hung
+		return nil, errors.New("released")
+	})
+	c := cache.NewSnapshotCache(false, group{}, logger{t: t},
+		cache.WithSnapshotGenerator(generator), cache.WithGenerationTimeout(10*time.Millisecond))
+
+	// The fetch is released at the deadline although the generator still runs.
+	_, err := c.Fetch(context.Background(), &discovery.DiscoveryRequest{Node: &core.Node{Id: "a"}, TypeUrl: rsrc.ClusterType})
+	assert.Error(t, err)
+}
+
+func TestSnapshotCacheGeneratorTimeoutResponses(t *testing.T) {
+	generator := cache.SnapshotGeneratorFunc(func(ctx context.Context, node *core.Node) (cache.ResourceSnapshot, error) {
+		return clusterSnapshot(t, "1", "backend"), nil
+	})
+	c := cache.NewSnapshotCache(false, group{}, logger{t: t},
+		cache.WithSnapshotGenerator(generator), cache.WithGenerationTimeout(10*time.Millisecond))
+
+	// The responses outlive the generation timeout.
+	value := watchClusters(c, &core.Node{Id: "a"}, "")
+	resp := <-value
+	select {
+	case <-resp.GetContext().Done():
+		t.Fatal("the response context ended with the generation")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,252 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"go.opentelemetry.io/otel/trace"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	"github.com/envoyproxy/go-control-plane/pkg/tracing"
+)
+
+// WithNodeGroups lets nodes share the snapshot of their group, as computed by
+// the groups hash, instead of each requiring its own. The NodeHash given to
+// the cache still identifies the individual nodes: it keys their status, the
+// overlays of SetNodeOverlay and the snapshots of SetSnapshot, which take
+// precedence over the group snapshot.
+func WithNodeGroups(groups NodeHash) SnapshotCacheOption {
+	return func(cache *snapshotCache) {
+		cache.groups = groups
+	}
+}
+
+// GroupSnapshotCache is implemented by the snapshot cache to set the
+// snapshots of the groups of WithNodeGroups:
+//
+//	c.(cache.GroupSnapshotCache).SetGroupSnapshot(ctx, group, snapshot)
+type GroupSnapshotCache interface {
+	// SetGroupSnapshot sets the snapshot of all the nodes of a group, see
+	// WithNodeGroups. It responds to the open watches of the member nodes
+	// for which the version differs from the snapshot version.
+	SetGroupSnapshot(ctx context.Context, group string, snapshot ResourceSnapshot) error
+
+	// SetNodeOverlay sets resources added to the group snapshot for a single
+	// node, or removes them if the overlay is nil.
+	SetNodeOverlay(ctx context.Context, node string, overlay ResourceSnapshot) error
+}
+
+var _ GroupSnapshotCache = &snapshotCache{}
+
+// nodeOverlay holds the overlay of a node and its merge with the last group
+// snapshot it was applied to.
+type nodeOverlay struct {
+	overlay ResourceSnapshot
+
+	// mu protects the merge, which is updated under the read lock of the cache.
+	mu     sync.Mutex
+	base   ResourceSnapshot
+	merged *overlaySnapshot
+}
+
+// SetGroupSnapshot sets the snapshot shared by all the nodes of a group and
+// responds to the open watches of the nodes currently connected, as
+// SetSnapshot does for a single node. Nodes with their own snapshot are left
+// untouched.
+func (cache *snapshotCache) SetGroupSnapshot(ctx context.Context, group string, snapshot ResourceSnapshot) (err error) {
+	ctx, span := cache.tracer.Start(ctx, "SetGroupSnapshot", trace.WithAttributes(tracing.GroupKey.String(group)))
+	defer func() {
+		tracing.End(span, err)
+	}()
+
+	if cache.groups == nil {
+		return fmt.Errorf("node groups are not enabled")
+	}
+	if cache.validate {
+		if err := validateSnapshot(snapshot); err != nil {
+			return err
+		}
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	return cache.setGroupSnapshot(ctx, group, snapshot)
+}
+
+// setGroupSnapshot updates the snapshot of a group. The cache mutex must be held.
+func (cache *snapshotCache) setGroupSnapshot(ctx context.Context, group string, snapshot ResourceSnapshot) error {
+	previous := make(map[string]ResourceSnapshot)
+	for node, info := range cache.status {
+		if _, ok := cache.snapshots[node]; ok {
+			continue
+		}
+		if cache.groups.ID(info.node) == group {
+			previous[node] = cache.servedSnapshot(node, info.node)
+		}
+	}
+
+	cache.groupSnapshots[group] = snapshot
+
+	for node, prev := range previous {
+		info := cache.status[node]
+		if err := cache.respondOpenWatches(ctx, info, prev, cache.servedSnapshot(node, info.node), ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetNodeOverlay sets resources served to a node on top of the snapshot of
+// its group. For each type present in the overlay, its resources replace the
+// group resources of the same name and are added to the others, under a
+// version combining both. A nil overlay removes the overlay of the node.
+//
+// Overlays apply only to the nodes served a group snapshot.
+func (cache *snapshotCache) SetNodeOverlay(ctx context.Context, node string, overlay ResourceSnapshot) error {
+	if cache.groups == nil {
+		return fmt.Errorf("node groups are not enabled")
+	}
+	if cache.validate && overlay != nil {
+		if err := validateSnapshot(overlay); err != nil {
+			return err
+		}
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	info, connected := cache.status[node]
+	var previous ResourceSnapshot
+	if connected {
+		previous = cache.servedSnapshot(node, info.node)
+	}
+
+	if overlay == nil {
+		delete(cache.overlays, node)
+	} else {
+		cache.overlays[node] = &nodeOverlay{overlay: overlay}
+	}
+
+	if !connected {
+		return nil
+	}
+	snapshot := cache.servedSnapshot(node, info.node)
+	if snapshot == nil || snapshot == previous {
+		return nil
+	}
+	return cache.respondOpenWatches(ctx, info, previous, snapshot, "")
+}
+
+// nodeSnapshot returns the snapshot served to a node: its own snapshot if it
+// has one, otherwise the snapshot of its group with its overlay applied, or
+// nil. The cache mutex must be held.
+func (cache *snapshotCache) nodeSnapshot(nodeID string, node *core.Node) ResourceSnapshot {
+	if snapshot, ok := cache.snapshots[nodeID]; ok {
+		return snapshot
+	}
+	if cache.groups == nil {
+		return nil
+	}
+
+	base, ok := cache.groupSnapshots[cache.groups.ID(node)]
+	if !ok {
+		return nil
+	}
+	overlay, ok := cache.overlays[nodeID]
+	if !ok {
+		return base
+	}
+	overlay.mu.Lock()
+	defer overlay.mu.Unlock()
+	if overlay.base != base {
+		overlay.base = base
+		overlay.merged = &overlaySnapshot{base: base, overlay: overlay.overlay}
+	}
+	return overlay.merged
+}
+
+// overlaySnapshot is a group snapshot with the overlay of a node applied.
+type overlaySnapshot struct {
+	base    ResourceSnapshot
+	overlay ResourceSnapshot
+}
+
+var _ ResourceSnapshot = &overlaySnapshot{}
+
+func (s *overlaySnapshot) GetVersion(typeURL string) string {
+	overlay := s.overlay.GetVersion(typeURL)
+	if overlay == "" {
+		return s.base.GetVersion(typeURL)
+	}
+	return s.base.GetVersion(typeURL) + "+" + overlay
+}
+
+func (s *overlaySnapshot) GetResourcesAndTTL(typeURL string) map[string]types.ResourceWithTTL {
+	if s.overlay.GetVersion(typeURL) == "" {
+		return s.base.GetResourcesAndTTL(typeURL)
+	}
+	out := make(map[string]types.ResourceWithTTL)
+	for name, res := range s.base.GetResourcesAndTTL(typeURL) {
+		out[name] = res
+	}
+	for name, res := range s.overlay.GetResourcesAndTTL(typeURL) {
+		out[name] = res
+	}
+	return out
+}
+
+func (s *overlaySnapshot) GetResources(typeURL string) map[string]types.Resource {
+	if s.overlay.GetVersion(typeURL) == "" {
+		return s.base.GetResources(typeURL)
+	}
+	out := make(map[string]types.Resource)
+	for name, res := range s.base.GetResources(typeURL) {
+		out[name] = res
+	}
+	for name, res := range s.overlay.GetResources(typeURL) {
+		out[name] = res
+	}
+	return out
+}
+
+func (s *overlaySnapshot) ConstructVersionMap() error {
+	return s.constructVersionMap(nil)
+}
+
+func (s *overlaySnapshot) constructVersionMap(m *MarshalCache) error {
+	if err := constructVersionMap(s.base, m); err != nil {
+		return err
+	}
+	return constructVersionMap(s.overlay, m)
+}
+
+func (s *overlaySnapshot) GetVersionMap(typeURL string) map[string]string {
+	if s.overlay.GetVersion(typeURL) == "" {
+		return s.base.GetVersionMap(typeURL)
+	}
+	out := make(map[string]string)
+	for name, version := range s.base.GetVersionMap(typeURL) {
+		out[name] = version
+	}
+	for name, version := range s.overlay.GetVersionMap(typeURL) {
+		out[name] = version
+	}
+	return out
+}
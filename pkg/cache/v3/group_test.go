@@ -0,0 +1,195 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package cache_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	cluster "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	rsrc "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/server/stream/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/test/resource/v3"
+)
+
+// clusterGroup groups the nodes by their cluster.
+type clusterGroup struct{}
+
+func (clusterGroup) ID(node *core.Node) string {
+	return node.GetCluster()
+}
+
+func clusterSnapshot(t *testing.T, version string, names ...string) *cache.Snapshot {
+	clusters := make([]types.Resource, 0, len(names))
+	for _, name := range names {
+		clusters = append(clusters, resource.MakeCluster(resource.Ads, name))
+	}
+	snap, err := cache.NewSnapshot(version, map[rsrc.Type][]types.Resource{rsrc.ClusterType: clusters})
+	require.NoError(t, err)
+	return snap
+}
+
+func watchClusters(c cache.Cache, node *core.Node, version string) chan cache.Response {
+	value := make(chan cache.Response, 1)
+	c.CreateWatch(&discovery.DiscoveryRequest{Node: node, TypeUrl: rsrc.ClusterType, VersionInfo: version},
+		stream.NewStreamState(false, map[string]string{}), value)
+	return value
+}
+
+func receiveClusters(t *testing.T, value chan cache.Response) (string, []string) {
+	t.Helper()
+	select {
+	case out := <-value:
+		version, err := out.GetVersion()
+		require.NoError(t, err)
+		var names []string
+		for _, res := range out.(*cache.RawResponse).Resources {
+			names = append(names, res.Resource.(*cluster.Cluster).GetName())
+		}
+		return version, names
+	case <-time.After(time.Second):
+		t.Fatal("failed to receive snapshot response")
+		return "", nil
+	}
+}
+
+func TestSnapshotCacheGroupSnapshot(t *testing.T) {
+	c := cache.NewSnapshotCache(false, group{}, logger{t: t}, cache.WithNodeGroups(clusterGroup{}))
+	nodes := []*core.Node{
+		{Id: "a", Cluster: "edge"},
+		{Id: "b", Cluster: "edge"},
+		{Id: "c", Cluster: "internal"},
+	}
+
+	watches := make([]chan cache.Response, len(nodes))
+	for i, node := range nodes {
+		watches[i] = watchClusters(c, node, "")
+	}
+
+	require.NoError(t, c.(cache.GroupSnapshotCache).SetGroupSnapshot(context.Background(), "edge", clusterSnapshot(t, "1", "backend")))
+	for _, value := range watches[:2] {
+		version, names := receiveClusters(t, value)
+		assert.Equal(t, "1", version)
+		assert.Equal(t, []string{"backend"}, names)
+	}
+	assert.Equal(t, 1, c.GetStatusInfo("c").GetNumWatches())
+
+	snap, err := c.GetSnapshot("a")
+	require.NoError(t, err)
+	assert.Equal(t, "1", snap.GetVersion(rsrc.ClusterType))
+
+	// Nodes connecting later are served the group snapshot too.
+	version, _ := receiveClusters(t, watchClusters(c, &core.Node{Id: "d", Cluster: "edge"}, ""))
+	assert.Equal(t, "1", version)
+
+	// The snapshot of a node takes precedence over the one of its group.
+	require.NoError(t, c.SetSnapshot(context.Background(), "b", clusterSnapshot(t, "own", "other")))
+	watches[0] = watchClusters(c, nodes[0], "1")
+	watches[1] = watchClusters(c, nodes[1], "own")
+	require.NoError(t, c.(cache.GroupSnapshotCache).SetGroupSnapshot(context.Background(), "edge", clusterSnapshot(t, "2", "backend")))
+	version, _ = receiveClusters(t, watches[0])
+	assert.Equal(t, "2", version)
+	assert.Equal(t, 1, c.GetStatusInfo("b").GetNumWatches())
+}
+
+func TestSnapshotCacheDeleteSnapshot(t *testing.T) {
+	c := cache.NewSnapshotCache(false, group{}, logger{t: t}, cache.WithNodeGroups(clusterGroup{}))
+	node := &core.Node{Id: "a", Cluster: "edge"}
+	require.NoError(t, c.(cache.GroupSnapshotCache).SetGroupSnapshot(context.Background(), "edge", clusterSnapshot(t, "1", "backend")))
+	require.NoError(t, c.SetSnapshot(context.Background(), "a", clusterSnapshot(t, "own", "other")))
+
+	own, err := c.(cache.NodeSnapshotCache).GetNodeSnapshot("a")
+	require.NoError(t, err)
+	assert.Equal(t, "own", own.GetVersion(rsrc.ClusterType))
+
+	// The node is served its group snapshot again, on the same stream.
+	value := watchClusters(c, node, "own")
+	require.NoError(t, c.(cache.NodeSnapshotCache).DeleteSnapshot(context.Background(), "a"))
+	version, names := receiveClusters(t, value)
+	assert.Equal(t, "1", version)
+	assert.Equal(t, []string{"backend"}, names)
+
+	_, err = c.(cache.NodeSnapshotCache).GetNodeSnapshot("a")
+	assert.Error(t, err)
+	snap, err := c.GetSnapshot("a")
+	require.NoError(t, err)
+	assert.Equal(t, "1", snap.GetVersion(rsrc.ClusterType))
+	assert.NotNil(t, c.GetStatusInfo("a"))
+	assert.NoError(t, c.(cache.NodeSnapshotCache).DeleteSnapshot(context.Background(), "a"))
+}
+
+func TestSnapshotCacheNodeOverlay(t *testing.T) {
+	c := cache.NewSnapshotCache(false, group{}, logger{t: t}, cache.WithNodeGroups(clusterGroup{}))
+	a := &core.Node{Id: "a", Cluster: "edge"}
+	b := &core.Node{Id: "b", Cluster: "edge"}
+	require.NoError(t, c.(cache.GroupSnapshotCache).SetGroupSnapshot(context.Background(), "edge", clusterSnapshot(t, "1", "backend")))
+
+	watchA := watchClusters(c, a, "1")
+	watchB := watchClusters(c, b, "1")
+	require.NoError(t, c.(cache.GroupSnapshotCache).SetNodeOverlay(context.Background(), "a", clusterSnapshot(t, "x", "canary")))
+
+	version, names := receiveClusters(t, watchA)
+	assert.Equal(t, "1+x", version)
+	assert.ElementsMatch(t, []string{"backend", "canary"}, names)
+	assert.Equal(t, 1, c.GetStatusInfo("b").GetNumWatches())
+
+	// Group updates keep the overlay.
+	watchA = watchClusters(c, a, version)
+	require.NoError(t, c.(cache.GroupSnapshotCache).SetGroupSnapshot(context.Background(), "edge", clusterSnapshot(t, "2", "backend", "frontend")))
+	version, names = receiveClusters(t, watchA)
+	assert.Equal(t, "2+x", version)
+	assert.ElementsMatch(t, []string{"backend", "frontend", "canary"}, names)
+	version, names = receiveClusters(t, watchB)
+	assert.Equal(t, "2", version)
+	assert.ElementsMatch(t, []string{"backend", "frontend"}, names)
+
+	// Removing the overlay reverts to the group snapshot.
+	watchA = watchClusters(c, a, version+"+x")
+	require.NoError(t, c.(cache.GroupSnapshotCache).SetNodeOverlay(context.Background(), "a", nil))
+	version, names = receiveClusters(t, watchA)
+	assert.Equal(t, "2", version)
+	assert.ElementsMatch(t, []string{"backend", "frontend"}, names)
+}
+
+func TestSnapshotCacheGroupSnapshotDelta(t *testing.T) {
+	c := cache.NewSnapshotCache(false, group{}, logger{t: t}, cache.WithNodeGroups(clusterGroup{}))
+	value := make(chan cache.DeltaResponse, 1)
+	c.CreateDeltaWatch(&discovery.DeltaDiscoveryRequest{
+		Node:    &core.Node{Id: "a", Cluster: "edge"},
+		TypeUrl: rsrc.ClusterType,
+	}, stream.NewStreamState(true, nil), value)
+	require.NoError(t, c.(cache.GroupSnapshotCache).SetGroupSnapshot(context.Background(), "edge", clusterSnapshot(t, "1", "backend")))
+
+	select {
+	case out := <-value:
+		assert.Len(t, out.(*cache.RawDeltaResponse).Resources, 1)
+	case <-time.After(time.Second):
+		t.Fatal("failed to receive delta response")
+	}
+}
+
+func TestSnapshotCacheGroupsDisabled(t *testing.T) {
+	c := cache.NewSnapshotCache(false, group{}, logger{t: t})
+	assert.Error(t, c.(cache.GroupSnapshotCache).SetGroupSnapshot(context.Background(), "edge", clusterSnapshot(t, "1", "backend")))
+	assert.Error(t, c.(cache.GroupSnapshotCache).SetNodeOverlay(context.Background(), "a", nil))
+}
@@ -22,9 +22,13 @@ import (
 	"sync"
 	"sync/atomic"
 
+	"go.opentelemetry.io/otel/trace"
+
 	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
 	"github.com/envoyproxy/go-control-plane/pkg/log"
+	"github.com/envoyproxy/go-control-plane/pkg/metrics"
 	"github.com/envoyproxy/go-control-plane/pkg/server/stream/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/tracing"
 )
 
 type watches = map[chan Response]struct{}
@@ -38,7 +42,7 @@ type LinearCache struct {
 	// Type URL specific to the cache.
 	typeURL string
 	// Collection of resources indexed by name.
-	resources map[string]types.Resource
+	resources map[string]types.ResourceWithTTL
 	// Watches open by clients, indexed by resource name. Whenever resources
 	// are changed, the watch is triggered.
 	watches map[string]watches
@@ -59,8 +63,23 @@ type LinearCache struct {
 	versionPrefix string
 	// Versions for each resource by name.
 	versionVector map[string]uint64
+	// Run protoc-gen-validate rules on updated resources.
+	validate bool
+	// Memoizes the marshaled resources, if set.
+	marshalCache *MarshalCache
+
+	// Metrics of the cache, and the number of watches and resources last
+	// reported. Watches open for several names are counted once, using the
+	// number of names they are open for.
+	metrics           *metrics.CacheMetrics
+	watchNames        map[chan Response]int
+	reportedWatches   int
+	reportedResources int
+
+	// tracer records the spans of updates and watch responses.
+	tracer trace.Tracer
 
-	log log.Logger
+	log log.StructuredLogger
 
 	mu sync.RWMutex
 }
@@ -82,16 +101,62 @@ func WithVersionPrefix(prefix string) LinearCacheOption {
 // WithInitialResources initializes the initial set of resources.
 func WithInitialResources(resources map[string]types.Resource) LinearCacheOption {
 	return func(cache *LinearCache) {
-		cache.resources = resources
-		for name := range resources {
+		for name, resource := range resources {
+			cache.resources[name] = types.ResourceWithTTL{Resource: resource}
 			cache.versionVector[name] = 0
 		}
 	}
 }
 
-func WithLogger(log log.Logger) LinearCacheOption {
+// WithResourceValidation makes UpdateResource, UpdateResources and
+// UpdateSerializedResources run the protoc-gen-validate rules on the provided
+// resources, including the messages
+// packed in typed configs. Invalid resources are rejected with a ValidationErrors
+// and the cache is left unchanged.
+func WithResourceValidation() LinearCacheOption {
+	return func(cache *LinearCache) {
+		cache.validate = true
+	}
+}
+
+// WithLinearMarshalCache memoizes the serialized form and hash of the
+// resources with a marshal cache, see NewMarshalCache.
+func WithLinearMarshalCache(m *MarshalCache) LinearCacheOption {
+	return func(cache *LinearCache) {
+		cache.marshalCache = m
+	}
+}
+
+// WithLinearCacheMetrics makes the cache report its number of resources and
+// open watches, see metrics.CacheMetrics.
+func WithLinearCacheMetrics(m metrics.Metrics) LinearCacheOption {
+	return func(cache *LinearCache) {
+		cache.metrics = metrics.NewCacheMetrics(m)
+	}
+}
+
+// WithLinearCacheTracing makes the cache record OpenTelemetry spans for the
+// updates of its resources and for each watch they respond to. The responses
+// carry the context of their span, so that the servers record the marshaling
+// and sending of the response in the same trace.
+func WithLinearCacheTracing(provider trace.TracerProvider) LinearCacheOption {
+	return func(cache *LinearCache) {
+		cache.tracer = tracing.Tracer(provider)
+	}
+}
+
+// WithLogger makes the cache report its activity to a printf-style logger.
+func WithLogger(logger log.Logger) LinearCacheOption {
+	return func(cache *LinearCache) {
+		cache.log = log.FromLogger(logger)
+	}
+}
+
+// WithStructuredLogger makes the cache report its activity to a structured
+// logger, with the type URL, node ID and version attached to the messages.
+func WithStructuredLogger(logger log.StructuredLogger) LinearCacheOption {
 	return func(cache *LinearCache) {
-		cache.log = log
+		cache.log = logger
 	}
 }
 
@@ -99,59 +164,107 @@ func WithLogger(log log.Logger) LinearCacheOption {
 func NewLinearCache(typeURL string, opts ...LinearCacheOption) *LinearCache {
 	out := &LinearCache{
 		typeURL:       typeURL,
-		resources:     make(map[string]types.Resource),
+		resources:     make(map[string]types.ResourceWithTTL),
 		watches:       make(map[string]watches),
 		watchAll:      make(watches),
 		deltaWatches:  make(map[int64]DeltaResponseWatch),
 		versionMap:    nil,
 		version:       0,
 		versionVector: make(map[string]uint64),
+		metrics:       metrics.NewCacheMetrics(nil),
+		watchNames:    make(map[chan Response]int),
+		tracer:        tracing.Tracer(nil),
+		log:           log.Discard,
 	}
 	for _, opt := range opts {
 		opt(out)
 	}
+	out.log = out.log.With(log.TypeURL(typeURL))
+	out.report()
 	return out
 }
 
-func (cache *LinearCache) respond(value chan Response, staleResources []string) {
+// report updates the gauges of the cache. The cache mutex must be held.
+func (cache *LinearCache) report() {
+	numWatches := len(cache.watchAll) + len(cache.watchNames) + len(cache.deltaWatches)
+	if numWatches != cache.reportedWatches {
+		cache.metrics.Watches.Add(float64(numWatches-cache.reportedWatches), cache.typeURL)
+		cache.reportedWatches = numWatches
+	}
+	if len(cache.resources) != cache.reportedResources {
+		cache.metrics.Resources.Add(float64(len(cache.resources)-cache.reportedResources), cache.typeURL)
+		cache.reportedResources = len(cache.resources)
+	}
+}
+
+// unregisterWatch removes a watch from the set of a name.
+func (cache *LinearCache) unregisterWatch(name string, value chan Response) {
+	set, exists := cache.watches[name]
+	if !exists {
+		return
+	}
+	if _, ok := set[value]; ok {
+		delete(set, value)
+		if cache.watchNames[value]--; cache.watchNames[value] == 0 {
+			delete(cache.watchNames, value)
+		}
+	}
+	if len(set) == 0 {
+		delete(cache.watches, name)
+	}
+}
+
+func (cache *LinearCache) respond(ctx context.Context, value chan Response, staleResources []string) {
 	var resources []types.ResourceWithTTL
 	// TODO: optimize the resources slice creations across different clients
 	if len(staleResources) == 0 {
 		resources = make([]types.ResourceWithTTL, 0, len(cache.resources))
 		for _, resource := range cache.resources {
-			resources = append(resources, types.ResourceWithTTL{Resource: resource})
+			resources = append(resources, resource)
 		}
 	} else {
 		resources = make([]types.ResourceWithTTL, 0, len(staleResources))
 		for _, name := range staleResources {
-			resource := cache.resources[name]
-			if resource != nil {
-				resources = append(resources, types.ResourceWithTTL{Resource: resource})
+			if resource, ok := cache.resources[name]; ok {
+				resources = append(resources, resource)
 			}
 		}
 	}
+
+	ctx, span := cache.tracer.Start(ctx, "RespondWatch", trace.WithAttributes(
+		tracing.TypeURLKey.String(cache.typeURL),
+		tracing.VersionKey.String(cache.getVersion()),
+	))
+	defer span.End()
+
 	value <- &RawResponse{
-		Request:   &Request{TypeUrl: cache.typeURL},
-		Resources: resources,
-		Version:   cache.getVersion(),
-		Ctx:       context.Background(),
+		Request:      &Request{TypeUrl: cache.typeURL},
+		Resources:    resources,
+		Version:      cache.getVersion(),
+		Ctx:          ctx,
+		marshalCache: cache.marshalCache,
 	}
 }
 
-func (cache *LinearCache) notifyAll(modified map[string]struct{}) {
+// startUpdate starts the span of an update of the resources.
+func (cache *LinearCache) startUpdate(name string) (context.Context, trace.Span) {
+	return cache.tracer.Start(context.Background(), name, trace.WithAttributes(tracing.TypeURLKey.String(cache.typeURL)))
+}
+
+func (cache *LinearCache) notifyAll(ctx context.Context, modified map[string]struct{}) {
 	// de-duplicate watches that need to be responded
 	notifyList := make(map[chan Response][]string)
 	for name := range modified {
 		for watch := range cache.watches[name] {
 			notifyList[watch] = append(notifyList[watch], name)
+			cache.unregisterWatch(name, watch)
 		}
-		delete(cache.watches, name)
 	}
 	for value, stale := range notifyList {
-		cache.respond(value, stale)
+		cache.respond(ctx, value, stale)
 	}
 	for value := range cache.watchAll {
-		cache.respond(value, nil)
+		cache.respond(ctx, value, nil)
 	}
 	cache.watchAll = make(watches)
 
@@ -160,7 +273,7 @@ func (cache *LinearCache) notifyAll(modified map[string]struct{}) {
 	if cache.versionMap != nil {
 		err := cache.updateVersionMap(modified)
 		if err != nil {
-			cache.log.Errorf("failed to update version map: %v", err)
+			cache.log.Error("failed to update version map", log.Err(err))
 		}
 
 		for id, watch := range cache.deltaWatches {
@@ -168,27 +281,37 @@ func (cache *LinearCache) notifyAll(modified map[string]struct{}) {
 				continue
 			}
 
-			res := cache.respondDelta(watch.Request, watch.Response, watch.StreamState)
+			res := cache.respondDelta(ctx, watch.Request, watch.Response, watch.StreamState)
 			if res != nil {
 				delete(cache.deltaWatches, id)
 			}
 		}
 	}
+	cache.report()
 }
 
-func (cache *LinearCache) respondDelta(request *DeltaRequest, value chan DeltaResponse, state stream.StreamState) *RawDeltaResponse {
-	resp := createDeltaResponse(context.Background(), request, state, resourceContainer{
+func (cache *LinearCache) respondDelta(ctx context.Context, request *DeltaRequest, value chan DeltaResponse, state stream.StreamState) *RawDeltaResponse {
+	resp := createDeltaResponse(ctx, request, state, resourceContainer{
 		resourceMap:   cache.resources,
 		versionMap:    cache.versionMap,
 		systemVersion: cache.getVersion(),
 	})
+	resp.marshalCache = cache.marshalCache
 
 	// Only send a response if there were changes
-	if len(resp.Resources) > 0 || len(resp.RemovedResources) > 0 {
-		if cache.log != nil {
-			cache.log.Debugf("[linear cache] node: %s, sending delta response for typeURL %s with resources: %v removed resources: %v with wildcard: %t",
-				request.GetNode().GetId(), request.TypeUrl, GetResourceNames(resp.Resources), resp.RemovedResources, state.IsWildcard())
-		}
+	if resp.numResources() > 0 || len(resp.RemovedResources) > 0 {
+		cache.log.Debug("send delta response", log.Node(request.GetNode().GetId()), log.Version(resp.SystemVersionInfo),
+			log.Resources(resp.resourceNames()), log.Any("removed", resp.RemovedResources),
+			log.Any("wildcard", state.IsWildcard()))
+
+		var span trace.Span
+		resp.Ctx, span = cache.tracer.Start(ctx, "RespondDeltaWatch", trace.WithAttributes(
+			tracing.NodeKey.String(request.GetNode().GetId()),
+			tracing.TypeURLKey.String(request.TypeUrl),
+			tracing.VersionKey.String(resp.SystemVersionInfo),
+		))
+		defer span.End()
+
 		value <- resp
 		return resp
 	}
@@ -200,21 +323,32 @@ func (cache *LinearCache) UpdateResource(name string, res types.Resource) error
 	if res == nil {
 		return errors.New("nil resource")
 	}
+	if cache.validate {
+		if err := ValidateResource(cache.typeURL, res); err != nil {
+			return err
+		}
+	}
+	ctx, span := cache.startUpdate("UpdateResource")
+	defer span.End()
+
 	cache.mu.Lock()
 	defer cache.mu.Unlock()
 
 	cache.version++
 	cache.versionVector[name] = cache.version
-	cache.resources[name] = res
+	cache.resources[name] = types.ResourceWithTTL{Resource: res}
 
 	// TODO: batch watch closures to prevent rapid updates
-	cache.notifyAll(map[string]struct{}{name: {}})
+	cache.notifyAll(ctx, map[string]struct{}{name: {}})
 
 	return nil
 }
 
 // DeleteResource removes a resource in the collection.
 func (cache *LinearCache) DeleteResource(name string) error {
+	ctx, span := cache.startUpdate("DeleteResource")
+	defer span.End()
+
 	cache.mu.Lock()
 	defer cache.mu.Unlock()
 
@@ -223,7 +357,7 @@ func (cache *LinearCache) DeleteResource(name string) error {
 	delete(cache.resources, name)
 
 	// TODO: batch watch closures to prevent rapid updates
-	cache.notifyAll(map[string]struct{}{name: {}})
+	cache.notifyAll(ctx, map[string]struct{}{name: {}})
 	return nil
 }
 
@@ -231,6 +365,41 @@ func (cache *LinearCache) DeleteResource(name string) error {
 // Calling UpdateResources instead of iterating on UpdateResource and DeleteResource
 // is significantly more efficient when using delta or wildcard watches.
 func (cache *LinearCache) UpdateResources(toUpdate map[string]types.Resource, toDelete []string) error {
+	if cache.validate {
+		if err := ValidateResources(cache.typeURL, toUpdate); err != nil {
+			return err
+		}
+	}
+	resources := make(map[string]types.ResourceWithTTL, len(toUpdate))
+	for name, resource := range toUpdate {
+		resources[name] = types.ResourceWithTTL{Resource: resource}
+	}
+	cache.update("UpdateResources", resources, toDelete)
+	return nil
+}
+
+// UpdateSerializedResources is UpdateResources for resources already
+// marshaled, which are indexed by their name and served as they are.
+func (cache *LinearCache) UpdateSerializedResources(toUpdate []*types.SerializedResource, toDelete []string) error {
+	resources := make(map[string]types.ResourceWithTTL, len(toUpdate))
+	var errs ValidationErrors
+	for _, resource := range toUpdate {
+		if cache.validate {
+			errs = append(errs, validateSerialized(cache.typeURL, resource)...)
+		}
+		resources[resource.Name] = types.ResourceWithTTL{Serialized: resource}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	cache.update("UpdateSerializedResources", resources, toDelete)
+	return nil
+}
+
+func (cache *LinearCache) update(operation string, toUpdate map[string]types.ResourceWithTTL, toDelete []string) {
+	ctx, span := cache.startUpdate(operation)
+	defer span.End()
+
 	cache.mu.Lock()
 	defer cache.mu.Unlock()
 
@@ -248,15 +417,16 @@ func (cache *LinearCache) UpdateResources(toUpdate map[string]types.Resource, to
 		modified[name] = struct{}{}
 	}
 
-	cache.notifyAll(modified)
-
-	return nil
+	cache.notifyAll(ctx, modified)
 }
 
 // SetResources replaces current resources with a new set of resources.
 // This function is useful for wildcard xDS subscriptions.
 // This way watches that are subscribed to all resources are triggered only once regardless of how many resources are changed.
 func (cache *LinearCache) SetResources(resources map[string]types.Resource) {
+	ctx, span := cache.startUpdate("SetResources")
+	defer span.End()
+
 	cache.mu.Lock()
 	defer cache.mu.Unlock()
 
@@ -271,7 +441,10 @@ func (cache *LinearCache) SetResources(resources map[string]types.Resource) {
 		}
 	}
 
-	cache.resources = resources
+	cache.resources = make(map[string]types.ResourceWithTTL, len(resources))
+	for name, resource := range resources {
+		cache.resources[name] = types.ResourceWithTTL{Resource: resource}
+	}
 
 	// Collect changed resource names.
 	// We assume all resources passed to SetResources are changed.
@@ -281,10 +454,11 @@ func (cache *LinearCache) SetResources(resources map[string]types.Resource) {
 		modified[name] = struct{}{}
 	}
 
-	cache.notifyAll(modified)
+	cache.notifyAll(ctx, modified)
 }
 
-// GetResources returns current resources stored in the cache
+// GetResources returns current resources stored in the cache. Serialized
+// resources are decoded, and left out if they cannot be.
 func (cache *LinearCache) GetResources() map[string]types.Resource {
 	cache.mu.RLock()
 	defer cache.mu.RUnlock()
@@ -293,7 +467,9 @@ func (cache *LinearCache) GetResources() map[string]types.Resource {
 	// involving mutations of our backing map
 	resources := make(map[string]types.Resource, len(cache.resources))
 	for k, v := range cache.resources {
-		resources[k] = v
+		if msg := entryMessage(v); msg != nil {
+			resources[k] = msg
+		}
 	}
 	return resources
 }
@@ -336,16 +512,18 @@ func (cache *LinearCache) CreateWatch(request *Request, streamState stream.Strea
 		}
 	}
 	if stale {
-		cache.respond(value, staleResources)
+		cache.respond(context.Background(), value, staleResources)
 		return nil
 	}
 	// Create open watches since versions are up to date.
 	if len(request.ResourceNames) == 0 {
 		cache.watchAll[value] = struct{}{}
+		cache.report()
 		return func() {
 			cache.mu.Lock()
 			defer cache.mu.Unlock()
 			delete(cache.watchAll, value)
+			cache.report()
 		}
 	}
 	for _, name := range request.ResourceNames {
@@ -354,20 +532,19 @@ func (cache *LinearCache) CreateWatch(request *Request, streamState stream.Strea
 			set = make(watches)
 			cache.watches[name] = set
 		}
-		set[value] = struct{}{}
+		if _, ok := set[value]; !ok {
+			set[value] = struct{}{}
+			cache.watchNames[value]++
+		}
 	}
+	cache.report()
 	return func() {
 		cache.mu.Lock()
 		defer cache.mu.Unlock()
 		for _, name := range request.ResourceNames {
-			set, exists := cache.watches[name]
-			if exists {
-				delete(set, value)
-			}
-			if len(set) == 0 {
-				delete(cache.watches, name)
-			}
+			cache.unregisterWatch(name, value)
 		}
+		cache.report()
 	}
 }
 
@@ -384,22 +561,21 @@ func (cache *LinearCache) CreateDeltaWatch(request *DeltaRequest, state stream.S
 			modified[name] = struct{}{}
 		}
 		err := cache.updateVersionMap(modified)
-		if err != nil && cache.log != nil {
-			cache.log.Errorf("failed to update version map: %v", err)
+		if err != nil {
+			cache.log.Error("failed to update version map", log.Err(err))
 		}
 	}
-	response := cache.respondDelta(request, value, state)
+	response := cache.respondDelta(context.Background(), request, value, state)
 
 	// if respondDelta returns nil this means that there is no change in any resource version
 	// create a new watch accordingly
 	if response == nil {
 		watchID := cache.nextDeltaWatchID()
-		if cache.log != nil {
-			cache.log.Infof("[linear cache] open delta watch ID:%d for %s Resources:%v, system version %q", watchID,
-				cache.typeURL, state.GetSubscribedResourceNames(), cache.getVersion())
-		}
+		cache.log.Info("open delta watch", log.Node(request.GetNode().GetId()), log.Version(cache.getVersion()),
+			log.WatchID(watchID), log.Any(log.ResourcesKey, state.GetSubscribedResourceNames()))
 
 		cache.deltaWatches[watchID] = DeltaResponseWatch{Request: request, Response: value, StreamState: state}
+		cache.report()
 
 		return cache.cancelDeltaWatch(watchID)
 	}
@@ -419,11 +595,10 @@ func (cache *LinearCache) updateVersionMap(modified map[string]struct{}) error {
 			continue
 		}
 		// hash our version in here and build the version map
-		marshaledResource, err := MarshalResource(r)
+		_, v, err := cache.marshalCache.marshalAndHashEntry(r)
 		if err != nil {
 			return err
 		}
-		v := HashResource(marshaledResource)
 		if v == "" {
 			return errors.New("failed to build resource version")
 		}
@@ -443,6 +618,7 @@ func (cache *LinearCache) cancelDeltaWatch(watchID int64) func() {
 		cache.mu.Lock()
 		defer cache.mu.Unlock()
 		delete(cache.deltaWatches, watchID)
+		cache.report()
 	}
 }
 
@@ -0,0 +1,70 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package cache_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/log"
+	"github.com/envoyproxy/go-control-plane/pkg/log/logtest"
+	rsrc "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/server/stream/v3"
+)
+
+func TestSnapshotCacheLogging(t *testing.T) {
+	logs := logtest.New()
+	c := cache.NewSnapshotCache(false, group{}, nil, cache.WithSnapshotCacheLogger(logs))
+
+	value := make(chan cache.Response, 1)
+	c.CreateWatch(&discovery.DiscoveryRequest{TypeUrl: rsrc.ClusterType, Node: &core.Node{Id: key}},
+		stream.NewStreamState(false, nil), value)
+	require.NoError(t, c.SetSnapshot(context.Background(), key, fixture.snapshot()))
+	<-value
+
+	opened := logs.FilterMessage("open watch")
+	require.Len(t, opened, 1)
+	assert.Equal(t, key, opened[0].Fields[log.NodeKey])
+	assert.Equal(t, rsrc.ClusterType, opened[0].Fields[log.TypeURLKey])
+
+	responded := logs.FilterMessage("respond open watch with new version")
+	require.Len(t, responded, 1)
+	assert.Equal(t, key, responded[0].Fields[log.NodeKey])
+	assert.Equal(t, rsrc.ClusterType, responded[0].Fields[log.TypeURLKey])
+	assert.Equal(t, fixture.version, responded[0].Fields[log.VersionKey])
+}
+
+func TestLinearCacheLogging(t *testing.T) {
+	logs := logtest.New()
+	c := cache.NewLinearCache(stringType, cache.WithStructuredLogger(logs))
+	require.NoError(t, c.UpdateResource("a", wrapperspb.String("a")))
+
+	value := make(chan cache.DeltaResponse, 1)
+	c.CreateDeltaWatch(&discovery.DeltaDiscoveryRequest{TypeUrl: stringType, Node: &core.Node{Id: key}},
+		stream.NewStreamState(true, nil), value)
+	<-value
+
+	sent := logs.FilterMessage("send delta response")
+	require.Len(t, sent, 1)
+	assert.Equal(t, key, sent[0].Fields[log.NodeKey])
+	assert.Equal(t, stringType, sent[0].Fields[log.TypeURLKey])
+}
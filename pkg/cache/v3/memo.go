@@ -0,0 +1,202 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package cache
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+)
+
+// MarshalCacheStats reports the activity of a marshal cache, see
+// NewMarshalCache.
+type MarshalCacheStats struct {
+	// Hits is the number of resources found in the cache.
+	Hits int64
+
+	// Misses is the number of resources marshaled.
+	Misses int64
+
+	// Evictions is the number of resources evicted to respect the size.
+	Evictions int64
+
+	// Size is the current number of resources in the cache.
+	Size int
+}
+
+// MarshalCache memoizes the serialized form and hash of up to a number of
+// resources, the least recently used being evicted first. Given to the caches
+// with WithMarshalCache or WithLinearMarshalCache, it is used for the version
+// maps of delta xDS, the content versions of UpdateResources and the
+// responses, so that resources shared by many nodes, e.g. the resources of a
+// snapshot set for several nodes or of a group snapshot, are marshaled and
+// hashed once rather than for every version map and response. Several caches
+// may share a marshal cache.
+//
+// Resources are identified by their pointer, not their content: a resource
+// modified in place keeps being served its memoized bytes and hash. Resources
+// must not be modified once added to a snapshot or cache, which is already
+// required since snapshots are shared with the streams; a modified copy is a
+// new resource.
+type MarshalCache struct {
+	mu      sync.Mutex
+	size    int
+	entries map[types.Resource]*list.Element
+	lru     *list.List
+	stats   MarshalCacheStats
+}
+
+type memoEntry struct {
+	resource types.Resource
+	bytes    types.MarshaledResource
+
+	// hash is computed on first use, SOTW responses only need the bytes.
+	hash string
+}
+
+// NewMarshalCache creates a marshal cache holding up to size resources.
+func NewMarshalCache(size int) *MarshalCache {
+	return &MarshalCache{
+		size:    size,
+		entries: make(map[types.Resource]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// Stats returns the statistics of the marshal cache.
+func (m *MarshalCache) Stats() MarshalCacheStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats := m.stats
+	stats.Size = len(m.entries)
+	return stats
+}
+
+// marshal returns the serialized form of a resource, see MarshalResource.
+// A nil marshal cache marshals the resource every time.
+func (m *MarshalCache) marshal(r types.Resource) (types.MarshaledResource, error) {
+	bytes, _, err := m.get(r, false)
+	return bytes, err
+}
+
+// marshalAndHash returns the serialized form of a resource and its hash, see
+// HashResource. A nil marshal cache marshals the resource every time.
+func (m *MarshalCache) marshalAndHash(r types.Resource) (types.MarshaledResource, string, error) {
+	return m.get(r, true)
+}
+
+func (m *MarshalCache) get(r types.Resource, withHash bool) (types.MarshaledResource, string, error) {
+	if m == nil || m.size <= 0 {
+		return marshalAndHash(r, withHash)
+	}
+
+	m.mu.Lock()
+	if e, ok := m.entries[r]; ok {
+		m.lru.MoveToFront(e)
+		m.stats.Hits++
+		entry := e.Value.(*memoEntry)
+		bytes, hash := entry.bytes, entry.hash
+		m.mu.Unlock()
+
+		if withHash && hash == "" {
+			hash = HashResource(bytes)
+			m.mu.Lock()
+			entry.hash = hash
+			m.mu.Unlock()
+		}
+		return bytes, hash, nil
+	}
+	m.stats.Misses++
+	m.mu.Unlock()
+
+	// Marshal outside of the lock, a concurrent miss on the same resource
+	// only costs a redundant marshaling.
+	bytes, hash, err := marshalAndHash(r, withHash)
+	if err != nil {
+		return nil, "", err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry := &memoEntry{resource: r, bytes: bytes, hash: hash}
+	if e, ok := m.entries[r]; ok {
+		// The resource was memoized by a concurrent miss.
+		m.lru.MoveToFront(e)
+		return bytes, hash, nil
+	}
+	m.entries[r] = m.lru.PushFront(entry)
+	for m.lru.Len() > m.size {
+		oldest := m.lru.Back()
+		m.lru.Remove(oldest)
+		delete(m.entries, oldest.Value.(*memoEntry).resource)
+		m.stats.Evictions++
+	}
+	return bytes, hash, nil
+}
+
+// marshalEntry returns the serialized form of a resource, or the bytes of the
+// serialized resource held in its place.
+func (m *MarshalCache) marshalEntry(r types.ResourceWithTTL) (types.MarshaledResource, error) {
+	if r.Serialized != nil {
+		return r.Serialized.Bytes, nil
+	}
+	return m.marshal(r.Resource)
+}
+
+// marshalAndHashEntry returns the serialized form of a resource and its hash,
+// or the bytes of the serialized resource held in its place and its version,
+// its bytes being hashed only when it has none.
+func (m *MarshalCache) marshalAndHashEntry(r types.ResourceWithTTL) (types.MarshaledResource, string, error) {
+	if r.Serialized != nil {
+		return serializedAndHash(r.Serialized)
+	}
+	return m.marshalAndHash(r.Resource)
+}
+
+// hashEntry returns the hash of a resource without a marshal cache, see
+// marshalAndHashEntry.
+func hashEntry(r types.ResourceWithTTL) (string, error) {
+	var m *MarshalCache
+	_, hash, err := m.marshalAndHashEntry(r)
+	return hash, err
+}
+
+func serializedAndHash(s *types.SerializedResource) (types.MarshaledResource, string, error) {
+	if s.Version != "" {
+		return s.Bytes, s.Version, nil
+	}
+	return s.Bytes, HashResource(s.Bytes), nil
+}
+
+func marshalAndHash(r types.Resource, withHash bool) (types.MarshaledResource, string, error) {
+	bytes, err := MarshalResource(r)
+	if err != nil || !withHash {
+		return bytes, "", err
+	}
+	return bytes, HashResource(bytes), nil
+}
+
+// constructVersionMap constructs the version map of a snapshot with a marshal
+// cache, when the snapshot supports it.
+func constructVersionMap(s ResourceSnapshot, m *MarshalCache) error {
+	if memoized, ok := s.(interface {
+		constructVersionMap(m *MarshalCache) error
+	}); ok {
+		return memoized.constructVersionMap(m)
+	}
+	return s.ConstructVersionMap()
+}
@@ -0,0 +1,123 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package cache_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	rsrc "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/server/stream/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/test/resource/v3"
+)
+
+func TestMarshalCache(t *testing.T) {
+	m := cache.NewMarshalCache(2)
+	c := cache.NewSnapshotCache(false, cache.IDHash{}, logger{t: t}, cache.WithMarshalCache(m))
+	setClusters := func(node string, resources ...types.Resource) {
+		snap, err := cache.NewSnapshot(fixture.version, map[rsrc.Type][]types.Resource{rsrc.ClusterType: resources})
+		require.NoError(t, err)
+		require.NoError(t, c.SetSnapshot(context.Background(), node, snap))
+	}
+
+	cluster := resource.MakeCluster(resource.Ads, clusterName)
+	other := resource.MakeCluster(resource.Ads, "other")
+	setClusters("a", cluster, other)
+	setClusters("b", cluster, other)
+	first := deltaClusters(t, c, "a")
+	second := deltaClusters(t, c, "b")
+	assert.Equal(t, first, second)
+	assert.Equal(t, cache.MarshalCacheStats{Hits: 6, Misses: 2, Size: 2}, m.Stats())
+
+	// Resources are identified by their pointer, a modified copy is a new
+	// resource.
+	modifiedCluster := resource.MakeCluster(resource.Ads, clusterName)
+	modifiedCluster.AltStatName = "modified"
+	setClusters("c", modifiedCluster, other)
+	modified := deltaClusters(t, c, "c")
+	assert.NotEqual(t, first[clusterName], modified[clusterName])
+	assert.Equal(t, first["other"], modified["other"])
+	assert.Equal(t, int64(3), m.Stats().Misses)
+
+	setClusters("d", resource.MakeCluster(resource.Ads, "third"))
+	deltaClusters(t, c, "d")
+	stats := m.Stats()
+	assert.Equal(t, int64(2), stats.Evictions)
+	assert.Equal(t, 2, stats.Size)
+}
+
+// deltaClusters returns the versions of the clusters sent to a new delta
+// stream of a node.
+func deltaClusters(t *testing.T, c cache.SnapshotCache, node string) map[string]string {
+	value := make(chan cache.DeltaResponse, 1)
+	c.CreateDeltaWatch(&discovery.DeltaDiscoveryRequest{Node: &core.Node{Id: node}, TypeUrl: rsrc.ClusterType},
+		stream.NewStreamState(true, nil), value)
+	out, err := (<-value).GetDeltaDiscoveryResponse()
+	require.NoError(t, err)
+
+	versions := make(map[string]string, len(out.Resources))
+	for _, r := range out.Resources {
+		versions[r.Name] = r.Version
+	}
+	return versions
+}
+
+// benchmarkResources returns the resources of a snapshot of a mid-sized node.
+func benchmarkResources() [types.UnknownType]cache.Resources {
+	ts := resource.TestSnapshot{
+		Xds:              resource.Ads,
+		Version:          "1",
+		UpstreamPort:     18080,
+		BasePort:         9000,
+		NumClusters:      100,
+		NumHTTPListeners: 10,
+		NumTCPListeners:  10,
+	}
+	return ts.Generate().Resources
+}
+
+func BenchmarkDeltaWatches(b *testing.B) {
+	shared := benchmarkResources()
+	for _, size := range []int{0, 1000} {
+		b.Run(fmt.Sprintf("cache=%d", size), func(b *testing.B) {
+			c := cache.NewSnapshotCache(false, cache.IDHash{}, nil, cache.WithMarshalCache(cache.NewMarshalCache(size)))
+
+			// The snapshots of 100 nodes sharing the same resources, each
+			// building its version map and responding to a delta stream.
+			for i := 0; i < b.N; i++ {
+				for node := 0; node < 100; node++ {
+					id := fmt.Sprintf("node-%d", node)
+					if err := c.SetSnapshot(context.Background(), id, &cache.Snapshot{Resources: shared}); err != nil {
+						b.Fatal(err)
+					}
+					value := make(chan cache.DeltaResponse, 1)
+					c.CreateDeltaWatch(&discovery.DeltaDiscoveryRequest{Node: &core.Node{Id: id}, TypeUrl: rsrc.ClusterType},
+						stream.NewStreamState(true, nil), value)
+					if _, err := (<-value).GetDeltaDiscoveryResponse(); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package cache_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/metrics/metricstest"
+	rsrc "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/server/stream/v3"
+)
+
+func TestSnapshotCacheMetrics(t *testing.T) {
+	reg := metricstest.New()
+	c := cache.NewSnapshotCache(false, cache.IDHash{}, nil,
+		cache.WithSnapshotCacheMetrics(reg), cache.WithUnchangedPushSuppression())
+	node := &core.Node{Id: key}
+	watches := "xds_cache_watches{" + rsrc.ClusterType + "}"
+
+	value := watchClusters(c, node, "")
+	delta := make(chan cache.DeltaResponse, 1)
+	c.CreateDeltaWatch(&discovery.DeltaDiscoveryRequest{Node: node, TypeUrl: rsrc.EndpointType},
+		stream.NewStreamState(true, nil), delta)
+	assert.Equal(t, 1.0, reg.Values()[watches])
+	assert.Equal(t, 1.0, reg.Values()["xds_cache_watches{"+rsrc.EndpointType+"}"])
+
+	require.NoError(t, c.SetSnapshot(context.Background(), key, clusterSnapshot(t, "1", "a")))
+	receiveClusters(t, value)
+	<-delta
+	assert.Equal(t, 0.0, reg.Values()[watches])
+	assert.Equal(t, 0.0, reg.Values()["xds_cache_watches{"+rsrc.EndpointType+"}"])
+
+	// The unchanged clusters are not pushed and their watch stays open until canceled.
+	cancel := c.CreateWatch(&discovery.DiscoveryRequest{Node: node, TypeUrl: rsrc.ClusterType, VersionInfo: "1"},
+		stream.NewStreamState(false, nil), make(chan cache.Response, 1))
+	require.NoError(t, c.SetSnapshot(context.Background(), key, clusterSnapshot(t, "2", "a")))
+	values := reg.Values()
+	assert.Equal(t, 1.0, values[watches])
+	assert.Equal(t, 1.0, values["xds_cache_suppressed_pushes_total{"+rsrc.ClusterType+"}"])
+	assert.Equal(t, 2.0, values["xds_cache_set_snapshot_duration_seconds{}"])
+
+	cancel()
+	assert.Equal(t, 0.0, reg.Values()[watches])
+}
+
+func TestLinearCacheMetrics(t *testing.T) {
+	const typeURL = "type.googleapis.com/google.protobuf.StringValue"
+	reg := metricstest.New()
+	c := cache.NewLinearCache(typeURL, cache.WithLinearCacheMetrics(reg),
+		cache.WithInitialResources(map[string]types.Resource{"a": wrapperspb.String("a")}))
+	watches := "xds_cache_watches{" + typeURL + "}"
+
+	// A watch is counted once whatever the number of names it is open for.
+	cancel := c.CreateWatch(&discovery.DiscoveryRequest{TypeUrl: typeURL, ResourceNames: []string{"a", "b"}, VersionInfo: "0"},
+		stream.NewStreamState(false, nil), make(chan cache.Response, 1))
+	c.CreateWatch(&discovery.DiscoveryRequest{TypeUrl: typeURL, VersionInfo: "0"},
+		stream.NewStreamState(false, nil), make(chan cache.Response, 1))
+	assert.Equal(t, 2.0, reg.Values()[watches])
+
+	cancel()
+	assert.Equal(t, 1.0, reg.Values()[watches])
+
+	require.NoError(t, c.UpdateResource("b", wrapperspb.String("b")))
+	values := reg.Values()
+	assert.Equal(t, 0.0, values[watches])
+	assert.Equal(t, 2.0, values["xds_cache_resources{"+typeURL+"}"])
+}
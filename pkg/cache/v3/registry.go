@@ -0,0 +1,219 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	cluster "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	endpoint "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	listener "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	route "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+)
+
+// ResourceType describes how the cache handles the resources of an xDS type.
+type ResourceType struct {
+	// TypeURL identifies the type, e.g. resource.ClusterType. It must match
+	// the type URL of the resource protos, as resources are matched to their
+	// type by message name.
+	TypeURL resource.Type
+
+	// Name returns the name of a resource. It defaults to GetName() for
+	// resources implementing types.ResourceWithName.
+	Name func(types.Resource) string
+
+	// References, if set, adds the names of the resources referenced by a
+	// resource to out, keyed by type URL.
+	References func(res types.Resource, out map[resource.Type]map[string]bool)
+
+	// RequestedByName is set for types that clients request by the names
+	// referenced in other resources, such as endpoints referenced by
+	// clusters. Consistent requires snapshots to list exactly the referenced
+	// resources of these types.
+	RequestedByName bool
+}
+
+type registeredType struct {
+	ResourceType
+	responseType types.ResponseType
+}
+
+type typeRegistry struct {
+	mu sync.RWMutex
+	// byURL indexes the types by type URL, byMessage by the name of their
+	// resource proto and byResponseType by their response type.
+	byURL          map[resource.Type]*registeredType
+	byMessage      map[protoreflect.FullName]*registeredType
+	byResponseType []*registeredType
+}
+
+var registry = newTypeRegistry()
+
+func newTypeRegistry() *typeRegistry {
+	r := &typeRegistry{
+		byURL:          make(map[resource.Type]*registeredType),
+		byMessage:      make(map[protoreflect.FullName]*registeredType),
+		byResponseType: make([]*registeredType, types.UnknownType+1),
+	}
+
+	builtins := map[types.ResponseType]ResourceType{
+		types.Endpoint: {
+			TypeURL: resource.EndpointType,
+			Name: func(res types.Resource) string {
+				return res.(*endpoint.ClusterLoadAssignment).GetClusterName()
+			},
+			RequestedByName: true,
+		},
+		types.Cluster: {
+			TypeURL: resource.ClusterType,
+			References: func(res types.Resource, out map[resource.Type]map[string]bool) {
+				getClusterReferences(res.(*cluster.Cluster), out)
+			},
+		},
+		types.Route: {
+			TypeURL:         resource.RouteType,
+			RequestedByName: true,
+		},
+		types.ScopedRoute: {
+			TypeURL: resource.ScopedRouteType,
+			References: func(res types.Resource, out map[resource.Type]map[string]bool) {
+				getScopedRouteReferences(res.(*route.ScopedRouteConfiguration), out)
+			},
+		},
+		types.VirtualHost: {TypeURL: resource.VirtualHostType},
+		types.Listener: {
+			TypeURL: resource.ListenerType,
+			References: func(res types.Resource, out map[resource.Type]map[string]bool) {
+				getListenerReferences(res.(*listener.Listener), out)
+			},
+		},
+		types.Secret:          {TypeURL: resource.SecretType},
+		types.Runtime:         {TypeURL: resource.RuntimeType},
+		types.ExtensionConfig: {TypeURL: resource.ExtensionConfigType},
+		types.FilterChain:     {TypeURL: resource.FilterChainType},
+		types.ThriftRoute: {
+			TypeURL:         resource.ThriftRouteType,
+			RequestedByName: true,
+		},
+	}
+	for responseType, t := range builtins {
+		r.add(responseType, t)
+	}
+
+	return r
+}
+
+func (r *typeRegistry) add(responseType types.ResponseType, t ResourceType) {
+	if t.Name == nil {
+		t.Name = defaultResourceName
+	}
+	registered := &registeredType{ResourceType: t, responseType: responseType}
+	r.byURL[t.TypeURL] = registered
+	r.byMessage[protoreflect.FullName(t.TypeURL[strings.LastIndex(t.TypeURL, "/")+1:])] = registered
+	if int(responseType) >= len(r.byResponseType) {
+		r.byResponseType = append(r.byResponseType, make([]*registeredType, int(responseType)-len(r.byResponseType)+1)...)
+	}
+	r.byResponseType[responseType] = registered
+}
+
+func (r *typeRegistry) lookup(typeURL resource.Type) *registeredType {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.byURL[typeURL]
+}
+
+func (r *typeRegistry) lookupResource(res types.Resource) *registeredType {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.byMessage[res.ProtoReflect().Descriptor().FullName()]
+}
+
+// lookupEntry returns the type of a resource, or of the serialized resource
+// held in its place.
+func (r *typeRegistry) lookupEntry(res types.ResourceWithTTL) *registeredType {
+	if res.Serialized != nil {
+		return r.lookup(res.Serialized.TypeURL)
+	}
+	if res.Resource == nil {
+		return nil
+	}
+	return r.lookupResource(res.Resource)
+}
+
+func (r *typeRegistry) lookupResponseType(responseType types.ResponseType) *registeredType {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if responseType < 0 || int(responseType) >= len(r.byResponseType) {
+		return nil
+	}
+	return r.byResponseType[responseType]
+}
+
+// all returns the registered types in the order of their response types.
+func (r *typeRegistry) all() []*registeredType {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make([]*registeredType, 0, len(r.byURL))
+	for _, t := range r.byResponseType {
+		if t != nil {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+func defaultResourceName(res types.Resource) string {
+	if named, ok := res.(types.ResourceWithName); ok {
+		return named.GetName()
+	}
+	return ""
+}
+
+// RegisterResourceType makes snapshots accept resources of a new type, such
+// as a contrib extension or a custom xDS type, and returns the response type
+// assigned to it. Types should be registered during initialization, before
+// any snapshot holding them is created.
+func RegisterResourceType(t ResourceType) (types.ResponseType, error) {
+	if t.TypeURL == "" {
+		return types.UnknownType, errors.New("missing type URL")
+	}
+
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	if _, ok := registry.byURL[t.TypeURL]; ok {
+		return types.UnknownType, fmt.Errorf("resource type %q is already registered", t.TypeURL)
+	}
+	responseType := types.ResponseType(len(registry.byResponseType))
+	registry.add(responseType, t)
+	return responseType, nil
+}
+
+// RegisteredTypeURLs returns the type URLs of the built-in and registered
+// resource types.
+func RegisteredTypeURLs() []resource.Type {
+	all := registry.all()
+	out := make([]resource.Type, 0, len(all))
+	for _, t := range all {
+		out = append(out, t.TypeURL)
+	}
+	return out
+}
@@ -0,0 +1,127 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package cache_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	listener "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	thrift "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/thrift_proxy/v3"
+	auth "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	rsrc "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/wellknown"
+)
+
+// sdsConfigType is a custom resource type referencing secrets by name.
+const sdsConfigType = rsrc.APITypePrefix + "envoy.extensions.transport_sockets.tls.v3.SdsSecretConfig"
+
+var sdsConfigResponseType, sdsConfigErr = cache.RegisterResourceType(cache.ResourceType{
+	TypeURL: sdsConfigType,
+	References: func(res types.Resource, out map[rsrc.Type]map[string]bool) {
+		if out[rsrc.SecretType] == nil {
+			out[rsrc.SecretType] = map[string]bool{}
+		}
+		out[rsrc.SecretType][res.(*auth.SdsSecretConfig).GetName()] = true
+	},
+})
+
+func TestRegisterResourceType(t *testing.T) {
+	require.NoError(t, sdsConfigErr)
+	assert.Greater(t, int(sdsConfigResponseType), int(types.UnknownType))
+	assert.Equal(t, sdsConfigResponseType, cache.GetResponseType(sdsConfigType))
+	typeURL, err := cache.GetResponseTypeURL(sdsConfigResponseType)
+	require.NoError(t, err)
+	assert.Equal(t, sdsConfigType, typeURL)
+	assert.Contains(t, cache.RegisteredTypeURLs(), sdsConfigType)
+
+	_, err = cache.RegisterResourceType(cache.ResourceType{TypeURL: sdsConfigType})
+	assert.Error(t, err)
+	_, err = cache.RegisterResourceType(cache.ResourceType{TypeURL: rsrc.ClusterType})
+	assert.Error(t, err)
+	_, err = cache.RegisterResourceType(cache.ResourceType{})
+	assert.Error(t, err)
+}
+
+func TestSnapshotWithRegisteredType(t *testing.T) {
+	config := &auth.SdsSecretConfig{Name: "config"}
+	snap, err := cache.NewSnapshot(fixture.version, map[rsrc.Type][]types.Resource{
+		sdsConfigType:   {config},
+		rsrc.SecretType: {testSecret[0]},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "config", cache.GetResourceName(config))
+	assert.Equal(t, fixture.version, snap.GetVersion(sdsConfigType))
+	assert.Contains(t, snap.GetResources(sdsConfigType), "config")
+	assert.Equal(t, map[rsrc.Type]map[string]bool{rsrc.SecretType: {"config": true}},
+		cache.GetResourceReferences(snap.GetResourcesAndTTL(sdsConfigType)))
+
+	require.NoError(t, snap.ConstructVersionMap())
+	assert.Contains(t, snap.GetVersionMap(sdsConfigType), "config")
+}
+
+func TestBuiltinResponseTypeURLs(t *testing.T) {
+	for responseType := types.ResponseType(0); responseType < types.UnknownType; responseType++ {
+		typeURL, err := cache.GetResponseTypeURL(responseType)
+		require.NoError(t, err)
+		assert.Equal(t, responseType, cache.GetResponseType(typeURL))
+	}
+	_, err := cache.GetResponseTypeURL(types.UnknownType)
+	assert.Error(t, err)
+}
+
+func makeThriftListener(t *testing.T, name string, routeName string) *listener.Listener {
+	config := &thrift.ThriftProxy{
+		StatPrefix: "thrift",
+		Trds: &thrift.Trds{
+			RouteConfigName: routeName,
+		},
+	}
+	return &listener.Listener{
+		Name: name,
+		FilterChains: []*listener.FilterChain{{
+			Filters: []*listener.Filter{{
+				Name: wellknown.ThriftProxy,
+				ConfigType: &listener.Filter_TypedConfig{
+					TypedConfig: mustAny(t, config),
+				},
+			}},
+		}},
+	}
+}
+
+func TestSnapshotConsistentThriftRoutes(t *testing.T) {
+	thriftRoute := &thrift.RouteConfiguration{Name: "thrift-route"}
+	assert.Equal(t, "thrift-route", cache.GetResourceName(thriftRoute))
+
+	snap, err := cache.NewSnapshot(fixture.version, map[rsrc.Type][]types.Resource{
+		rsrc.ListenerType:    {makeThriftListener(t, "thrift", "thrift-route")},
+		rsrc.ThriftRouteType: {thriftRoute},
+	})
+	require.NoError(t, err)
+	assert.NoError(t, snap.Consistent())
+
+	snap, err = cache.NewSnapshot(fixture.version, map[rsrc.Type][]types.Resource{
+		rsrc.ListenerType:    {makeThriftListener(t, "thrift", "missing")},
+		rsrc.ThriftRouteType: {thriftRoute},
+	})
+	require.NoError(t, err)
+	assert.Error(t, snap.Consistent())
+}
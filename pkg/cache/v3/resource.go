@@ -15,102 +15,48 @@
 package cache
 
 import (
+	"bytes"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"sort"
 
 	"google.golang.org/protobuf/proto"
 
 	cluster "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
-	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
-	endpoint "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
 	listener "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
 	route "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
-	auth "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
-	runtime "github.com/envoyproxy/go-control-plane/envoy/service/runtime/v3"
+	thrift "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/thrift_proxy/v3"
 	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
 	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
 )
 
-// GetResponseType returns the enumeration for a valid xDS type URL.
+// GetResponseType returns the enumeration for a valid xDS type URL, or
+// types.UnknownType if the type is not registered.
 func GetResponseType(typeURL resource.Type) types.ResponseType {
-	switch typeURL {
-	case resource.EndpointType:
-		return types.Endpoint
-	case resource.ClusterType:
-		return types.Cluster
-	case resource.RouteType:
-		return types.Route
-	case resource.ScopedRouteType:
-		return types.ScopedRoute
-	case resource.VirtualHostType:
-		return types.VirtualHost
-	case resource.ListenerType:
-		return types.Listener
-	case resource.SecretType:
-		return types.Secret
-	case resource.RuntimeType:
-		return types.Runtime
-	case resource.ExtensionConfigType:
-		return types.ExtensionConfig
-	case resource.FilterChainType:
-		return types.FilterChain
+	if t := registry.lookup(typeURL); t != nil {
+		return t.responseType
 	}
 	return types.UnknownType
 }
 
 // GetResponseTypeURL returns the type url for a valid enum.
 func GetResponseTypeURL(responseType types.ResponseType) (string, error) {
-	switch responseType {
-	case types.Endpoint:
-		return resource.EndpointType, nil
-	case types.Cluster:
-		return resource.ClusterType, nil
-	case types.Route:
-		return resource.RouteType, nil
-	case types.ScopedRoute:
-		return resource.ScopedRouteType, nil
-	case types.VirtualHost:
-		return resource.VirtualHostType, nil
-	case types.Listener:
-		return resource.ListenerType, nil
-	case types.Secret:
-		return resource.SecretType, nil
-	case types.Runtime:
-		return resource.RuntimeType, nil
-	case types.ExtensionConfig:
-		return resource.ExtensionConfigType, nil
-	default:
-		return "", fmt.Errorf("couldn't map response type %v to known resource type", responseType)
+	if t := registry.lookupResponseType(responseType); t != nil {
+		return t.TypeURL, nil
 	}
+	return "", fmt.Errorf("couldn't map response type %v to known resource type", responseType)
 }
 
 // GetResourceName returns the resource name for a valid xDS response type.
 func GetResourceName(res types.Resource) string {
-	switch v := res.(type) {
-	case *endpoint.ClusterLoadAssignment:
-		return v.GetClusterName()
-	case *cluster.Cluster:
-		return v.GetName()
-	case *route.RouteConfiguration:
-		return v.GetName()
-	case *route.ScopedRouteConfiguration:
-		return v.GetName()
-	case *route.VirtualHost:
-		return v.GetName()
-	case *listener.Listener:
-		return v.GetName()
-	case *auth.Secret:
-		return v.GetName()
-	case *runtime.Runtime:
-		return v.GetName()
-	case *core.TypedExtensionConfig:
-		return v.GetName()
-	case types.ResourceWithName:
-		return v.GetName()
-	default:
+	if res == nil {
 		return ""
 	}
+	if t := registry.lookupResource(res); t != nil {
+		return t.Name(res)
+	}
+	return defaultResourceName(res)
 }
 
 // GetResourceName returns the resource names for a list of valid xDS response types.
@@ -127,6 +73,29 @@ func MarshalResource(resource types.Resource) (types.MarshaledResource, error) {
 	return proto.MarshalOptions{Deterministic: true}.Marshal(resource)
 }
 
+// entryName returns the name of a resource, or of the serialized resource held
+// in its place.
+func entryName(res types.ResourceWithTTL) string {
+	if res.Serialized != nil {
+		return res.Serialized.Name
+	}
+	return GetResourceName(res.Resource)
+}
+
+// entryMessage returns the message of a resource, decoding the serialized
+// resource held in its place. Serialized resources that cannot be decoded are
+// returned as nil.
+func entryMessage(res types.ResourceWithTTL) types.Resource {
+	if res.Serialized == nil {
+		return res.Resource
+	}
+	msg, err := res.Serialized.Decode()
+	if err != nil {
+		return nil
+	}
+	return msg
+}
+
 // GetResourceReferences returns a map of dependent resources keyed by resource type, given a map of resources.
 // (EDS cluster names for CDS, RDS/SRDS routes names for LDS, RDS route names for SRDS).
 func GetResourceReferences(resources map[string]types.ResourceWithTTL) map[resource.Type]map[string]bool {
@@ -140,18 +109,8 @@ func GetResourceReferences(resources map[string]types.ResourceWithTTL) map[resou
 func GetAllResourceReferences(resourceGroups [types.UnknownType]Resources) map[resource.Type]map[string]bool {
 	ret := map[resource.Type]map[string]bool{}
 
-	// We only check resources that we expect to have references to other resources.
-	responseTypesWithReferences := map[types.ResponseType]struct{}{
-		types.Cluster:     {},
-		types.Listener:    {},
-		types.ScopedRoute: {},
-	}
-
-	for responseType, resourceGroup := range resourceGroups {
-		if _, ok := responseTypesWithReferences[types.ResponseType(responseType)]; ok {
-			items := resourceGroup.Items
-			getResourceReferences(items, ret)
-		}
+	for _, resourceGroup := range resourceGroups {
+		getResourceReferences(resourceGroup.Items, ret)
 	}
 
 	return ret
@@ -159,25 +118,13 @@ func GetAllResourceReferences(resourceGroups [types.UnknownType]Resources) map[r
 
 func getResourceReferences(resources map[string]types.ResourceWithTTL, out map[resource.Type]map[string]bool) {
 	for _, res := range resources {
-		if res.Resource == nil {
-			continue
-		}
-
-		switch v := res.Resource.(type) {
-		case *endpoint.ClusterLoadAssignment:
-			// No dependencies.
-		case *cluster.Cluster:
-			getClusterReferences(v, out)
-		case *route.RouteConfiguration:
-			// References to clusters in both routes (and listeners) are not included
-			// in the result, because the clusters are retrieved in bulk currently,
-			// and not by name.
-		case *route.ScopedRouteConfiguration:
-			getScopedRouteReferences(v, out)
-		case *listener.Listener:
-			getListenerReferences(v, out)
-		case *runtime.Runtime:
-			// no dependencies
+		// References to clusters in both routes and listeners are not included
+		// in the result, because the clusters are retrieved in bulk currently,
+		// and not by name.
+		if t := registry.lookupEntry(res); t != nil && t.References != nil {
+			if msg := entryMessage(res); msg != nil {
+				t.References(msg, out)
+			}
 		}
 	}
 }
@@ -212,9 +159,11 @@ func getClusterReferences(src *cluster.Cluster, out map[resource.Type]map[string
 	}
 }
 
-// HTTP listeners will either reference ScopedRoutes or Routes.
+// HTTP listeners will either reference ScopedRoutes or Routes, Thrift listeners
+// reference Thrift routes.
 func getListenerReferences(src *listener.Listener, out map[resource.Type]map[string]bool) {
 	routes := map[string]bool{}
+	thriftRoutes := map[string]bool{}
 
 	// Extract route configuration names from HTTP connection manager.
 	for _, chain := range src.FilterChains {
@@ -234,6 +183,13 @@ func getListenerReferences(src *listener.Listener, out map[resource.Type]map[str
 				routes[s.RouteConfigurationName] = true
 			}
 		}
+
+		for _, filter := range chain.Filters {
+			config := getThriftProxy(filter)
+			if name := config.GetTrds().GetRouteConfigName(); name != "" {
+				thriftRoutes[name] = true
+			}
+		}
 	}
 
 	if len(routes) > 0 {
@@ -243,6 +199,27 @@ func getListenerReferences(src *listener.Listener, out map[resource.Type]map[str
 
 		mapMerge(out[resource.RouteType], routes)
 	}
+
+	if len(thriftRoutes) > 0 {
+		if _, ok := out[resource.ThriftRouteType]; !ok {
+			out[resource.ThriftRouteType] = map[string]bool{}
+		}
+
+		mapMerge(out[resource.ThriftRouteType], thriftRoutes)
+	}
+}
+
+// getThriftProxy returns the Thrift proxy configuration of a filter, or nil.
+func getThriftProxy(filter *listener.Filter) *thrift.ThriftProxy {
+	typedConfig := filter.GetTypedConfig()
+	if typedConfig == nil || !typedConfig.MessageIs(&thrift.ThriftProxy{}) {
+		return nil
+	}
+	config := &thrift.ThriftProxy{}
+	if err := typedConfig.UnmarshalTo(config); err != nil {
+		return nil
+	}
+	return config
 }
 
 func getScopedRouteReferences(src *route.ScopedRouteConfiguration, out map[resource.Type]map[string]bool) {
@@ -260,6 +237,49 @@ func getScopedRouteReferences(src *route.ScopedRouteConfiguration, out map[resou
 	}
 }
 
+// ContentVersion returns a version identifying the content of a group of
+// resources: it only changes when a resource is added, removed or modified,
+// including its TTL, and does not depend on the order of the resources.
+func ContentVersion(resources map[string]types.ResourceWithTTL) (string, error) {
+	version, _, err := contentVersion(resources)
+	return version, err
+}
+
+// contentVersion returns the version of a group of resources together with
+// the hash of each resource, as used in delta xDS version maps.
+func contentVersion(resources map[string]types.ResourceWithTTL) (string, map[string]string, error) {
+	names := make([]string, 0, len(resources))
+	for name := range resources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	hashes := make(map[string]string, len(resources))
+	for _, name := range names {
+		hash, err := hashEntry(resources[name])
+		if err != nil {
+			return "", nil, err
+		}
+		hashes[name] = hash
+	}
+
+	return versionFromHashes(names, resources, hashes), hashes, nil
+}
+
+// versionFromHashes returns the content version of resources already hashed,
+// the names being sorted.
+func versionFromHashes(names []string, resources map[string]types.ResourceWithTTL, hashes map[string]string) string {
+	var content bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&content, "%q:%s", name, hashes[name])
+		if ttl := resources[name].TTL; ttl != nil {
+			fmt.Fprintf(&content, ":%d", *ttl)
+		}
+		content.WriteByte('\n')
+	}
+	return HashResource(content.Bytes())
+}
+
 // HashResource will take a resource and create a SHA256 hash sum out of the marshaled bytes
 func HashResource(resource []byte) string {
 	hasher := sha256.New()
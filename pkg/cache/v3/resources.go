@@ -15,7 +15,7 @@ type Resources struct {
 func IndexResourcesByName(items []types.ResourceWithTTL) map[string]types.ResourceWithTTL {
 	indexed := make(map[string]types.ResourceWithTTL)
 	for _, item := range items {
-		indexed[GetResourceName(item.Resource)] = item
+		indexed[entryName(item)] = item
 	}
 	return indexed
 }
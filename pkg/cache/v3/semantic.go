@@ -0,0 +1,349 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package cache
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+
+	cluster "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	listener "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	route "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	hcm "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	tcp "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/tcp_proxy/v3"
+	auth "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+)
+
+// IssueKind classifies a semantic problem found in a snapshot.
+type IssueKind string
+
+const (
+	// MissingCluster is reported for a route or TCP proxy pointing to a cluster absent from CDS.
+	MissingCluster IssueKind = "MissingCluster"
+	// InvalidClusterWeights is reported for weighted clusters whose weights do not add up.
+	InvalidClusterWeights IssueKind = "InvalidClusterWeights"
+	// DuplicateListenerAddress is reported for listeners bound to the same address and port.
+	DuplicateListenerAddress IssueKind = "DuplicateListenerAddress"
+	// MissingSecret is reported for a TLS context referencing an SDS secret absent from the snapshot.
+	MissingSecret IssueKind = "MissingSecret"
+	// MissingExtensionConfig is reported for a filter discovered through ECDS absent from the snapshot.
+	MissingExtensionConfig IssueKind = "MissingExtensionConfig"
+)
+
+// SemanticIssue is a single cross-type problem found in a snapshot.
+type SemanticIssue struct {
+	// Kind of the problem.
+	Kind IssueKind
+
+	// TypeURL of the resource holding the offending configuration.
+	TypeURL string
+
+	// Name of the resource holding the offending configuration.
+	Name string
+
+	// Reference is the name of the missing or conflicting resource or address, if any.
+	Reference string
+
+	// Detail is a human readable description of the problem.
+	Detail string
+}
+
+func (i SemanticIssue) String() string {
+	return fmt.Sprintf("%s %s %q: %s", i.Kind, i.TypeURL, i.Name, i.Detail)
+}
+
+// SemanticReport lists the cross-type problems found in a snapshot, sorted by
+// resource type, resource name and kind.
+type SemanticReport struct {
+	Issues []SemanticIssue
+}
+
+// Err returns nil if the report has no issues, or an error listing them.
+func (r *SemanticReport) Err() error {
+	if r == nil || len(r.Issues) == 0 {
+		return nil
+	}
+	msgs := make([]string, 0, len(r.Issues))
+	for _, issue := range r.Issues {
+		msgs = append(msgs, issue.String())
+	}
+	return fmt.Errorf("semantic check failed: %s", strings.Join(msgs, "; "))
+}
+
+// IssuesOfKind returns the issues of the given kind.
+func (r *SemanticReport) IssuesOfKind(kind IssueKind) []SemanticIssue {
+	var out []SemanticIssue
+	for _, issue := range r.Issues {
+		if issue.Kind == kind {
+			out = append(out, issue)
+		}
+	}
+	return out
+}
+
+// CheckSemantics runs CheckSemantics on the snapshot.
+func (s *Snapshot) CheckSemantics() *SemanticReport {
+	return CheckSemantics(s)
+}
+
+// CheckSemantics goes beyond Consistent and verifies the references between
+// resource types that Envoy resolves by itself:
+// - routes and TCP proxies point to clusters listed in CDS
+// - weighted clusters have weights adding up to the total weight, or to a non-zero sum
+// - listeners do not share the same address and port
+// - TLS contexts of listeners and clusters reference secrets listed in SDS
+// - filters configured through ECDS are listed in the extension configs
+//
+// Snapshots can be partial, so references to a type are only checked when the
+// snapshot includes that type, even if it holds no resources of it.
+func CheckSemantics(snapshot ResourceSnapshot) *SemanticReport {
+	c := &semanticChecker{
+		clusters:   snapshot.GetResources(resource.ClusterType),
+		secrets:    snapshot.GetResources(resource.SecretType),
+		extensions: snapshot.GetResources(resource.ExtensionConfigType),
+		report:     &SemanticReport{},
+		seen:       map[issueKey]struct{}{},
+	}
+
+	addresses := map[string][]string{}
+	for name, res := range snapshot.GetResources(resource.ListenerType) {
+		if l, ok := res.(*listener.Listener); ok {
+			c.checkListener(name, l)
+			if addr := l.GetAddress().GetSocketAddress(); addr != nil {
+				key := fmt.Sprintf("%s %s:%d", addr.GetProtocol(), addr.GetAddress(), addr.GetPortValue())
+				addresses[key] = append(addresses[key], name)
+			}
+		}
+	}
+	for addr, names := range addresses {
+		// The first listener by name owns the address, the others are reported as duplicates.
+		sort.Strings(names)
+		for _, name := range names[1:] {
+			c.add(DuplicateListenerAddress, resource.ListenerType, name, names[0],
+				"address %s is already used by listener %q", addr, names[0])
+		}
+	}
+	for name, res := range snapshot.GetResources(resource.RouteType) {
+		if rc, ok := res.(*route.RouteConfiguration); ok {
+			c.checkVirtualHosts(resource.RouteType, name, rc.GetVirtualHosts())
+		}
+	}
+	for name, res := range snapshot.GetResources(resource.VirtualHostType) {
+		if vh, ok := res.(*route.VirtualHost); ok {
+			c.checkVirtualHosts(resource.VirtualHostType, name, []*route.VirtualHost{vh})
+		}
+	}
+	for name, res := range c.clusters {
+		if cl, ok := res.(*cluster.Cluster); ok {
+			c.checkCluster(name, cl)
+		}
+	}
+
+	issues := c.report.Issues
+	sort.SliceStable(issues, func(i, j int) bool {
+		if issues[i].TypeURL != issues[j].TypeURL {
+			return issues[i].TypeURL < issues[j].TypeURL
+		}
+		if issues[i].Name != issues[j].Name {
+			return issues[i].Name < issues[j].Name
+		}
+		if issues[i].Kind != issues[j].Kind {
+			return issues[i].Kind < issues[j].Kind
+		}
+		return issues[i].Reference < issues[j].Reference
+	})
+	return c.report
+}
+
+type semanticChecker struct {
+	// Resources that can be referenced. A nil map means the type is not part of the snapshot.
+	clusters   map[string]types.Resource
+	secrets    map[string]types.Resource
+	extensions map[string]types.Resource
+
+	report *SemanticReport
+	seen   map[issueKey]struct{}
+}
+
+// issueKey identifies an issue so that repeated references are reported once.
+type issueKey struct {
+	kind      IssueKind
+	typeURL   string
+	name      string
+	reference string
+}
+
+func (c *semanticChecker) add(kind IssueKind, typeURL, name, reference, format string, args ...interface{}) {
+	key := issueKey{kind: kind, typeURL: typeURL, name: name, reference: reference}
+	if _, ok := c.seen[key]; ok {
+		return
+	}
+	c.seen[key] = struct{}{}
+
+	c.report.Issues = append(c.report.Issues, SemanticIssue{
+		Kind:      kind,
+		TypeURL:   typeURL,
+		Name:      name,
+		Reference: reference,
+		Detail:    fmt.Sprintf(format, args...),
+	})
+}
+
+func (c *semanticChecker) checkClusterReference(typeURL, name, clusterName string) {
+	if c.clusters == nil || clusterName == "" {
+		return
+	}
+	if _, ok := c.clusters[clusterName]; !ok {
+		c.add(MissingCluster, typeURL, name, clusterName, "cluster %q is not listed in CDS", clusterName)
+	}
+}
+
+func (c *semanticChecker) checkSecretReference(typeURL, name string, sds *auth.SdsSecretConfig) {
+	if c.secrets == nil || sds.GetName() == "" {
+		return
+	}
+	if _, ok := c.secrets[sds.GetName()]; !ok {
+		c.add(MissingSecret, typeURL, name, sds.GetName(), "secret %q is not listed in SDS", sds.GetName())
+	}
+}
+
+func (c *semanticChecker) checkExtensionReference(typeURL, name, filterName string, source *core.ExtensionConfigSource) {
+	if c.extensions == nil || source == nil {
+		return
+	}
+	if _, ok := c.extensions[filterName]; !ok {
+		c.add(MissingExtensionConfig, typeURL, name, filterName, "extension config %q is not listed in ECDS", filterName)
+	}
+}
+
+func (c *semanticChecker) checkListener(name string, l *listener.Listener) {
+	for _, filter := range l.GetListenerFilters() {
+		c.checkExtensionReference(resource.ListenerType, name, filter.GetName(), filter.GetConfigDiscovery())
+	}
+
+	chains := l.GetFilterChains()
+	if l.GetDefaultFilterChain() != nil {
+		chains = append(chains[:len(chains):len(chains)], l.GetDefaultFilterChain())
+	}
+	for _, chain := range chains {
+		c.checkDownstreamTLS(name, chain.GetTransportSocket())
+
+		for _, filter := range chain.GetFilters() {
+			c.checkExtensionReference(resource.ListenerType, name, filter.GetName(), filter.GetConfigDiscovery())
+
+			if manager := resource.GetHTTPConnectionManager(filter); manager != nil {
+				c.checkHTTPConnectionManager(name, manager)
+				continue
+			}
+			proxy := &tcp.TcpProxy{}
+			if unmarshalTypedConfig(filter.GetTypedConfig(), proxy) {
+				c.checkClusterReference(resource.ListenerType, name, proxy.GetCluster())
+				for _, weighted := range proxy.GetWeightedClusters().GetClusters() {
+					c.checkClusterReference(resource.ListenerType, name, weighted.GetName())
+				}
+			}
+		}
+	}
+}
+
+func (c *semanticChecker) checkHTTPConnectionManager(name string, manager *hcm.HttpConnectionManager) {
+	for _, filter := range manager.GetHttpFilters() {
+		c.checkExtensionReference(resource.ListenerType, name, filter.GetName(), filter.GetConfigDiscovery())
+	}
+	if rc := manager.GetRouteConfig(); rc != nil {
+		c.checkVirtualHosts(resource.ListenerType, name, rc.GetVirtualHosts())
+	}
+}
+
+func (c *semanticChecker) checkVirtualHosts(typeURL, name string, hosts []*route.VirtualHost) {
+	for _, vh := range hosts {
+		for _, r := range vh.GetRoutes() {
+			action := r.GetRoute()
+			if action == nil {
+				continue
+			}
+
+			c.checkClusterReference(typeURL, name, action.GetCluster())
+			for _, mirror := range action.GetRequestMirrorPolicies() {
+				c.checkClusterReference(typeURL, name, mirror.GetCluster())
+			}
+
+			weighted := action.GetWeightedClusters()
+			if weighted == nil {
+				continue
+			}
+			var sum uint32
+			for _, w := range weighted.GetClusters() {
+				c.checkClusterReference(typeURL, name, w.GetName())
+				sum += w.GetWeight().GetValue()
+			}
+			//nolint:staticcheck // total_weight is deprecated but still honored by Envoy.
+			if total := weighted.GetTotalWeight(); total != nil && total.GetValue() != sum {
+				c.add(InvalidClusterWeights, typeURL, name, vh.GetName(),
+					"weighted clusters of virtual host %q add up to %d instead of %d", vh.GetName(), sum, total.GetValue())
+			} else if total == nil && sum == 0 {
+				c.add(InvalidClusterWeights, typeURL, name, vh.GetName(),
+					"weighted clusters of virtual host %q add up to 0", vh.GetName())
+			}
+		}
+	}
+}
+
+func (c *semanticChecker) checkCluster(name string, cl *cluster.Cluster) {
+	c.checkUpstreamTLS(name, cl.GetTransportSocket())
+	for _, match := range cl.GetTransportSocketMatches() {
+		c.checkUpstreamTLS(name, match.GetTransportSocket())
+	}
+}
+
+func (c *semanticChecker) checkDownstreamTLS(name string, socket *core.TransportSocket) {
+	ctx := &auth.DownstreamTlsContext{}
+	if !unmarshalTypedConfig(socket.GetTypedConfig(), ctx) {
+		return
+	}
+	c.checkCommonTLS(resource.ListenerType, name, ctx.GetCommonTlsContext())
+	c.checkSecretReference(resource.ListenerType, name, ctx.GetSessionTicketKeysSdsSecretConfig())
+}
+
+func (c *semanticChecker) checkUpstreamTLS(name string, socket *core.TransportSocket) {
+	ctx := &auth.UpstreamTlsContext{}
+	if !unmarshalTypedConfig(socket.GetTypedConfig(), ctx) {
+		return
+	}
+	c.checkCommonTLS(resource.ClusterType, name, ctx.GetCommonTlsContext())
+}
+
+func (c *semanticChecker) checkCommonTLS(typeURL, name string, ctx *auth.CommonTlsContext) {
+	for _, sds := range ctx.GetTlsCertificateSdsSecretConfigs() {
+		c.checkSecretReference(typeURL, name, sds)
+	}
+	c.checkSecretReference(typeURL, name, ctx.GetValidationContextSdsSecretConfig())
+	c.checkSecretReference(typeURL, name, ctx.GetCombinedValidationContext().GetValidationContextSdsSecretConfig())
+}
+
+// unmarshalTypedConfig unpacks a typed config into out if it holds a message of the same type.
+func unmarshalTypedConfig(config *anypb.Any, out proto.Message) bool {
+	if config == nil || !config.MessageIs(out) {
+		return false
+	}
+	return anypb.UnmarshalTo(config, out, proto.UnmarshalOptions{}) == nil
+}
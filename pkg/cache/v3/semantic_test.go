@@ -0,0 +1,207 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package cache_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	listener "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	route "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	hcm "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	auth "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	rsrc "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/test/resource/v3"
+)
+
+func TestCheckSemanticsGeneratedSnapshot(t *testing.T) {
+	ts := resource.TestSnapshot{
+		Xds:                    resource.Ads,
+		Version:                "1",
+		UpstreamPort:           18080,
+		BasePort:               9000,
+		NumClusters:            4,
+		NumHTTPListeners:       2,
+		NumScopedHTTPListeners: 1,
+		NumVHDSHTTPListeners:   1,
+		NumTCPListeners:        2,
+		NumRuntimes:            1,
+		TLS:                    true,
+		NumExtension:           1,
+	}
+	report := ts.Generate().CheckSemantics()
+	assert.Empty(t, report.Issues)
+	assert.NoError(t, report.Err())
+}
+
+func TestCheckSemanticsMissingCluster(t *testing.T) {
+	snap, err := cache.NewSnapshot(fixture.version, map[rsrc.Type][]types.Resource{
+		rsrc.ClusterType:  {testCluster},
+		rsrc.RouteType:    {resource.MakeRouteConfig("route", "missing")},
+		rsrc.ListenerType: {resource.MakeTCPListener("tcp", 9000, "missing-tcp")},
+	})
+	require.NoError(t, err)
+
+	report := snap.CheckSemantics()
+	require.Error(t, report.Err())
+	assert.Equal(t, []cache.SemanticIssue{
+		{
+			Kind:      cache.MissingCluster,
+			TypeURL:   rsrc.ListenerType,
+			Name:      "tcp",
+			Reference: "missing-tcp",
+			Detail:    `cluster "missing-tcp" is not listed in CDS`,
+		},
+		{
+			Kind:      cache.MissingCluster,
+			TypeURL:   rsrc.RouteType,
+			Name:      "route",
+			Reference: "missing",
+			Detail:    `cluster "missing" is not listed in CDS`,
+		},
+	}, report.Issues)
+}
+
+func TestCheckSemanticsPartialSnapshot(t *testing.T) {
+	// Without CDS in the snapshot, clusters are expected to be served from elsewhere.
+	snap, err := cache.NewSnapshot(fixture.version, map[rsrc.Type][]types.Resource{
+		rsrc.RouteType: {resource.MakeRouteConfig("route", "missing")},
+	})
+	require.NoError(t, err)
+	assert.Empty(t, snap.CheckSemantics().Issues)
+}
+
+func TestCheckSemanticsWeightedClusters(t *testing.T) {
+	makeRoute := func(name string, total *wrapperspb.UInt32Value, weights ...uint32) *route.RouteConfiguration {
+		weighted := &route.WeightedCluster{TotalWeight: total}
+		for _, w := range weights {
+			weighted.Clusters = append(weighted.Clusters, &route.WeightedCluster_ClusterWeight{
+				Name:   clusterName,
+				Weight: wrapperspb.UInt32(w),
+			})
+		}
+		rc := resource.MakeRouteConfig(name, clusterName)
+		rc.VirtualHosts[0].Routes[0].GetRoute().ClusterSpecifier = &route.RouteAction_WeightedClusters{
+			WeightedClusters: weighted,
+		}
+		return rc
+	}
+
+	snap, err := cache.NewSnapshot(fixture.version, map[rsrc.Type][]types.Resource{
+		rsrc.ClusterType: {testCluster},
+		rsrc.RouteType: {
+			makeRoute("valid", nil, 30, 70),
+			makeRoute("valid-total", wrapperspb.UInt32(10), 3, 7),
+			makeRoute("invalid-total", wrapperspb.UInt32(100), 30, 30),
+			makeRoute("invalid-zero", nil, 0, 0),
+		},
+	})
+	require.NoError(t, err)
+
+	issues := snap.CheckSemantics().IssuesOfKind(cache.InvalidClusterWeights)
+	require.Len(t, issues, 2)
+	assert.Equal(t, "invalid-total", issues[0].Name)
+	assert.Equal(t, "invalid-zero", issues[1].Name)
+}
+
+func TestCheckSemanticsDuplicateListenerAddress(t *testing.T) {
+	snap, err := cache.NewSnapshot(fixture.version, map[rsrc.Type][]types.Resource{
+		rsrc.ClusterType: {testCluster},
+		rsrc.ListenerType: {
+			resource.MakeTCPListener("a", 9000, clusterName),
+			resource.MakeTCPListener("b", 9000, clusterName),
+			resource.MakeTCPListener("c", 9000, clusterName),
+			resource.MakeTCPListener("d", 9001, clusterName),
+		},
+	})
+	require.NoError(t, err)
+
+	issues := snap.CheckSemantics().IssuesOfKind(cache.DuplicateListenerAddress)
+	require.Len(t, issues, 2)
+	assert.Equal(t, "b", issues[0].Name)
+	assert.Equal(t, "a", issues[0].Reference)
+	assert.Equal(t, "c", issues[1].Name)
+	assert.Equal(t, "a", issues[1].Reference)
+}
+
+func TestCheckSemanticsMissingSecret(t *testing.T) {
+	tlsContext, err := anypb.New(&auth.DownstreamTlsContext{
+		CommonTlsContext: &auth.CommonTlsContext{
+			TlsCertificateSdsSecretConfigs: []*auth.SdsSecretConfig{{Name: testSecret[0].Name}},
+			ValidationContextType: &auth.CommonTlsContext_ValidationContextSdsSecretConfig{
+				ValidationContextSdsSecretConfig: &auth.SdsSecretConfig{Name: "missing-ca"},
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	l := resource.MakeRouteHTTPListener(resource.Ads, "tls", 443, routeName)
+	l.FilterChains[0].TransportSocket = &core.TransportSocket{
+		Name:       "envoy.transport_sockets.tls",
+		ConfigType: &core.TransportSocket_TypedConfig{TypedConfig: tlsContext},
+	}
+
+	snap, err := cache.NewSnapshot(fixture.version, map[rsrc.Type][]types.Resource{
+		rsrc.ListenerType: {l},
+		rsrc.SecretType:   {testSecret[0]},
+	})
+	require.NoError(t, err)
+
+	issues := snap.CheckSemantics().IssuesOfKind(cache.MissingSecret)
+	require.Len(t, issues, 1)
+	assert.Equal(t, "tls", issues[0].Name)
+	assert.Equal(t, "missing-ca", issues[0].Reference)
+}
+
+func TestCheckSemanticsMissingExtensionConfig(t *testing.T) {
+	l := resource.MakeRouteHTTPListener(resource.Ads, "ecds", 80, routeName)
+	manager := rsrc.GetHTTPConnectionManager(l.FilterChains[0].Filters[0])
+	require.NotNil(t, manager)
+	manager.HttpFilters = append([]*hcm.HttpFilter{
+		{
+			Name: "envoy.filters.http.present",
+			ConfigType: &hcm.HttpFilter_ConfigDiscovery{
+				ConfigDiscovery: &core.ExtensionConfigSource{ConfigSource: &core.ConfigSource{}},
+			},
+		},
+		{
+			Name: "envoy.filters.http.missing",
+			ConfigType: &hcm.HttpFilter_ConfigDiscovery{
+				ConfigDiscovery: &core.ExtensionConfigSource{ConfigSource: &core.ConfigSource{}},
+			},
+		},
+	}, manager.HttpFilters...)
+	typedConfig, err := anypb.New(manager)
+	require.NoError(t, err)
+	l.FilterChains[0].Filters[0].ConfigType = &listener.Filter_TypedConfig{TypedConfig: typedConfig}
+
+	snap, err := cache.NewSnapshot(fixture.version, map[rsrc.Type][]types.Resource{
+		rsrc.ListenerType:        {l},
+		rsrc.ExtensionConfigType: {&core.TypedExtensionConfig{Name: "envoy.filters.http.present"}},
+	})
+	require.NoError(t, err)
+
+	issues := snap.CheckSemantics().IssuesOfKind(cache.MissingExtensionConfig)
+	require.Len(t, issues, 1)
+	assert.Equal(t, "ecds", issues[0].Name)
+	assert.Equal(t, "envoy.filters.http.missing", issues[0].Reference)
+}
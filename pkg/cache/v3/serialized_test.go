@@ -0,0 +1,141 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package cache_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	endpoint "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	rsrc "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/server/stream/v3"
+)
+
+func serialize(t *testing.T, name, typeURL string, msg proto.Message) *types.SerializedResource {
+	bytes, err := proto.Marshal(msg)
+	require.NoError(t, err)
+	return &types.SerializedResource{Name: name, TypeURL: typeURL, Bytes: bytes}
+}
+
+func TestSerializedResourceSnapshot(t *testing.T) {
+	cluster := serialize(t, clusterName, rsrc.ClusterType, testCluster)
+	endpoints := serialize(t, clusterName, rsrc.EndpointType, testEndpoint)
+	endpoints.Version = "v1"
+
+	snap, err := cache.NewSnapshotWithTTLs(fixture.version, map[rsrc.Type][]types.ResourceWithTTL{
+		rsrc.ClusterType: {{Serialized: cluster}},
+	})
+	require.NoError(t, err)
+	assert.Contains(t, snap.GetResources(rsrc.ClusterType), clusterName)
+	assert.True(t, proto.Equal(testCluster, snap.GetResources(rsrc.ClusterType)[clusterName]))
+
+	// The references of the cluster are read from its decoded message.
+	assert.Error(t, snap.Consistent())
+	snap, err = cache.NewSnapshotWithTTLs(fixture.version, map[rsrc.Type][]types.ResourceWithTTL{
+		rsrc.ClusterType:  {{Serialized: cluster}},
+		rsrc.EndpointType: {{Serialized: endpoints}},
+	})
+	require.NoError(t, err)
+	assert.NoError(t, snap.Consistent())
+	assert.NoError(t, snap.Validate())
+	assert.Empty(t, snap.CheckSemantics().Issues)
+
+	c := cache.NewSnapshotCache(false, cache.IDHash{}, nil)
+	require.NoError(t, c.SetSnapshot(context.Background(), key, snap))
+
+	value := make(chan cache.Response, 1)
+	c.CreateWatch(&discovery.DiscoveryRequest{TypeUrl: rsrc.ClusterType, Node: &core.Node{Id: key}},
+		stream.NewStreamState(false, nil), value)
+	resp, err := (<-value).GetDiscoveryResponse()
+	require.NoError(t, err)
+	require.Len(t, resp.Resources, 1)
+	assert.Equal(t, rsrc.ClusterType, resp.Resources[0].TypeUrl)
+	assert.Equal(t, cluster.Bytes, resp.Resources[0].Value)
+
+	delta := make(chan cache.DeltaResponse, 1)
+	c.CreateDeltaWatch(&discovery.DeltaDiscoveryRequest{TypeUrl: rsrc.EndpointType, Node: &core.Node{Id: key}},
+		stream.NewStreamState(true, nil), delta)
+	deltaResp, err := (<-delta).GetDeltaDiscoveryResponse()
+	require.NoError(t, err)
+	require.Len(t, deltaResp.Resources, 1)
+	assert.Equal(t, clusterName, deltaResp.Resources[0].Name)
+	assert.Equal(t, "v1", deltaResp.Resources[0].Version)
+	assert.Equal(t, endpoints.Bytes, deltaResp.Resources[0].Resource.Value)
+}
+
+func TestSerializedResourceValidation(t *testing.T) {
+	invalid := serialize(t, "invalid", rsrc.EndpointType, &endpoint.ClusterLoadAssignment{})
+	var errs cache.ValidationErrors
+	require.True(t, errors.As(cache.ValidateSerializedResource(invalid), &errs))
+	assert.Equal(t, "invalid", errs[0].Name)
+
+	corrupt := &types.SerializedResource{Name: "corrupt", TypeURL: rsrc.EndpointType, Bytes: []byte{0xff}}
+	assert.Error(t, cache.ValidateSerializedResource(corrupt))
+	snap, err := cache.NewSnapshotWithTTLs(fixture.version, map[rsrc.Type][]types.ResourceWithTTL{
+		rsrc.EndpointType: {{Serialized: corrupt}},
+	})
+	require.NoError(t, err)
+	assert.Error(t, snap.Validate())
+
+	// Types unknown to the binary cannot be inspected and are skipped.
+	unknown := &types.SerializedResource{Name: "unknown", TypeURL: "type.googleapis.com/acme.v1.Unknown", Bytes: []byte{0xff}}
+	assert.NoError(t, cache.ValidateSerializedResource(unknown))
+}
+
+const stringType = "type.googleapis.com/google.protobuf.StringValue"
+
+func TestSerializedResourceLinearCache(t *testing.T) {
+	value := serialize(t, "a", stringType, wrapperspb.String("a"))
+	c := cache.NewLinearCache(stringType)
+	require.NoError(t, c.UpdateSerializedResources([]*types.SerializedResource{value}, nil))
+
+	w := make(chan cache.Response, 1)
+	c.CreateWatch(&discovery.DiscoveryRequest{TypeUrl: stringType, ResourceNames: []string{"a"}},
+		stream.NewStreamState(false, nil), w)
+	resp, err := (<-w).GetDiscoveryResponse()
+	require.NoError(t, err)
+	require.Len(t, resp.Resources, 1)
+	assert.Equal(t, value.Bytes, resp.Resources[0].Value)
+
+	delta := make(chan cache.DeltaResponse, 1)
+	c.CreateDeltaWatch(&discovery.DeltaDiscoveryRequest{TypeUrl: stringType},
+		stream.NewStreamState(true, nil), delta)
+	deltaResp, err := (<-delta).GetDeltaDiscoveryResponse()
+	require.NoError(t, err)
+	require.Len(t, deltaResp.Resources, 1)
+	assert.Equal(t, "a", deltaResp.Resources[0].Name)
+	assert.Equal(t, value.Bytes, deltaResp.Resources[0].Resource.Value)
+
+	decoded, err := value.Decode()
+	require.NoError(t, err)
+	assert.True(t, proto.Equal(wrapperspb.String("a"), decoded))
+	assert.True(t, proto.Equal(decoded, c.GetResources()["a"]))
+
+	invalid := cache.NewLinearCache(rsrc.EndpointType, cache.WithResourceValidation())
+	assert.Error(t, invalid.UpdateSerializedResources([]*types.SerializedResource{
+		serialize(t, "invalid", rsrc.EndpointType, &endpoint.ClusterLoadAssignment{}),
+	}, nil))
+	assert.Zero(t, invalid.NumResources())
+}
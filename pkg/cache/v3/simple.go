@@ -21,9 +21,15 @@ import (
 	"sync/atomic"
 	"time"
 
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
 	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
 	"github.com/envoyproxy/go-control-plane/pkg/log"
+	"github.com/envoyproxy/go-control-plane/pkg/metrics"
 	"github.com/envoyproxy/go-control-plane/pkg/server/stream/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/tracing"
 )
 
 // ResourceSnapshot is an abstract snapshot of a collection of resources that
@@ -81,6 +87,7 @@ type SnapshotCache interface {
 	GetSnapshot(node string) (ResourceSnapshot, error)
 
 	// ClearSnapshot removes all status and snapshot information associated with a node.
+	// The open watches of the node are closed according to the ClearPolicy of the cache.
 	ClearSnapshot(node string)
 
 	// GetStatusInfo retrieves status information for a node ID.
@@ -90,6 +97,44 @@ type SnapshotCache interface {
 	GetStatusKeys() []string
 }
 
+// SnapshotUpdater is implemented by the snapshot cache to change a single
+// type of the snapshot of a node:
+//
+//	c.(cache.SnapshotUpdater).UpdateResources(ctx, node, typeURL, upserts, deletes)
+type SnapshotUpdater interface {
+	// UpdateResources upserts and deletes resources of a type in the snapshot
+	// of a node, which must be a *Snapshot, without rebuilding it. Only the
+	// version of that type changes, so only its watches are responded to.
+	//
+	// Only snapshots set for the node itself with SetSnapshot can be updated:
+	// an error is returned for the nodes served a group snapshot, with or
+	// without an overlay, or a generated or fallback snapshot. Those are
+	// changed by setting the group snapshot or the overlay again.
+	UpdateResources(ctx context.Context, node, typeURL string, upserts map[string]types.Resource, deletes []string) error
+}
+
+var _ SnapshotUpdater = &snapshotCache{}
+
+// NodeSnapshotCache is implemented by the snapshot cache to manage the
+// snapshots set for the nodes themselves apart from those they are served,
+// e.g. that of their group:
+//
+//	c.(cache.NodeSnapshotCache).GetNodeSnapshot(node)
+type NodeSnapshotCache interface {
+	// GetNodeSnapshot gets the snapshot set for the node itself with
+	// SetSnapshot or UpdateResources, ignoring the snapshot of its group,
+	// its overlay and the fallback snapshot.
+	GetNodeSnapshot(node string) (ResourceSnapshot, error)
+
+	// DeleteSnapshot removes the snapshot set for the node itself, leaving
+	// its status, overlay and streams in place. The node is then served the
+	// snapshot of its group or the fallback snapshot, if any, to which its
+	// open watches respond.
+	DeleteSnapshot(ctx context.Context, node string) error
+}
+
+var _ NodeSnapshotCache = &snapshotCache{}
+
 type snapshotCache struct {
 	// watchCount and deltaWatchCount are atomic counters incremented for each watch respectively. They need to
 	// be the first fields in the struct to guarantee 64-bit alignment,
@@ -98,7 +143,7 @@ type snapshotCache struct {
 	watchCount      int64
 	deltaWatchCount int64
 
-	log log.Logger
+	log log.StructuredLogger
 
 	// ads flag to hold responses until all resources are named
 	ads bool
@@ -112,9 +157,152 @@ type snapshotCache struct {
 	// hash is the hashing function for Envoy nodes
 	hash NodeHash
 
+	// groups is the hashing function mapping Envoy nodes to their group, if enabled
+	groups NodeHash
+
+	// groupSnapshots are cached resources indexed by group IDs
+	groupSnapshots map[string]ResourceSnapshot
+
+	// overlays are the resources added to the group snapshots indexed by node IDs
+	overlays map[string]*nodeOverlay
+
+	// generator computes the snapshots of unknown nodes, if set
+	generator SnapshotGenerator
+
+	// generationTimeout bounds the calls to the generator
+	generationTimeout time.Duration
+
+	// generations are the snapshots being generated indexed by group or node IDs
+	generations map[string]*generation
+
+	// fallback is served to the nodes without a snapshot, if set
+	fallback ResourceSnapshot
+
+	// onFallback reports the nodes moving onto and off the fallback snapshot
+	onFallback FallbackCallback
+
+	// onEvict reports the nodes evicted by the stale node collection
+	onEvict EvictionCallback
+
+	// collectionStats are the statistics of the stale node collection
+	collectionStats NodeCollectionStats
+
+	// clearPolicy defines how the watches of cleared nodes are closed
+	clearPolicy ClearPolicy
+
+	// marshalCache memoizes the marshaled resources, if set
+	marshalCache *MarshalCache
+
+	// validate enables protoc-gen-validate checks on snapshots passed to SetSnapshot
+	validate bool
+
+	// suppressUnchanged skips responses for types whose resources did not change
+	suppressUnchanged bool
+
+	// metrics records the activity of the cache
+	metrics *metrics.CacheMetrics
+
+	// tracer records the spans of snapshot updates and watch responses
+	tracer trace.Tracer
+
+	// acks are the versions applied by the streams reported by the servers
+	acks ackTracker
+
 	mu sync.RWMutex
 }
 
+// SnapshotCacheOption modifies the behavior of the snapshot cache.
+type SnapshotCacheOption func(*snapshotCache)
+
+// WithSnapshotValidation makes SetSnapshot run the protoc-gen-validate rules
+// on all resources of the snapshot, including the messages packed in typed
+// configs. An invalid snapshot is rejected with a ValidationErrors and is not
+// stored, so it never reaches the proxies.
+func WithSnapshotValidation() SnapshotCacheOption {
+	return func(cache *snapshotCache) {
+		cache.validate = true
+	}
+}
+
+// ClearPolicy defines how ClearSnapshot closes the open watches of a node.
+type ClearPolicy int
+
+const (
+	// ClearLeaveStreams discards the watches of the node without responding
+	// to them. The streams stay open and are served again once the node
+	// requests resources. This is the default.
+	ClearLeaveStreams ClearPolicy = iota
+
+	// ClearTerminateStreams ends the streams of the node with an Unavailable
+	// status, so that the node reconnects and is served whatever snapshot it
+	// is then assigned.
+	ClearTerminateStreams
+
+	// ClearRemoveResources responds to the watches of the node with no
+	// resources: empty responses for SOTW watches, and the removal of all the
+	// resources the stream knows of for delta watches. The streams stay open.
+	ClearRemoveResources
+)
+
+// WithMarshalCache memoizes the serialized form and hash of the resources of
+// the snapshots with a marshal cache, see NewMarshalCache.
+func WithMarshalCache(m *MarshalCache) SnapshotCacheOption {
+	return func(cache *snapshotCache) {
+		cache.marshalCache = m
+	}
+}
+
+// WithClearPolicy sets how ClearSnapshot closes the open watches of a node.
+func WithClearPolicy(policy ClearPolicy) SnapshotCacheOption {
+	return func(cache *snapshotCache) {
+		cache.clearPolicy = policy
+	}
+}
+
+// WithUnchangedPushSuppression makes SetSnapshot compare the resources of the
+// new snapshot with the previous one, type by type, and leave the watches of
+// the types whose resources are identical open instead of responding with the
+// same resources under a new version. Clients keep the version they have,
+// which remains valid. The skipped responses are counted in
+// SuppressionStatusInfo.GetNumSuppressedPushes.
+//
+// The comparison uses the resource hashes of delta xDS, computed once per
+// snapshot. Resources that fail to be hashed are logged and pushed.
+func WithUnchangedPushSuppression() SnapshotCacheOption {
+	return func(cache *snapshotCache) {
+		cache.suppressUnchanged = true
+	}
+}
+
+// WithSnapshotCacheMetrics makes the cache report its open watches by type,
+// the duration of SetSnapshot, the pushes skipped by
+// WithUnchangedPushSuppression and the activity of WithStaleNodeCollection,
+// see metrics.CacheMetrics.
+func WithSnapshotCacheMetrics(m metrics.Metrics) SnapshotCacheOption {
+	return func(cache *snapshotCache) {
+		cache.metrics = metrics.NewCacheMetrics(m)
+	}
+}
+
+// WithSnapshotCacheTracing makes the cache record OpenTelemetry spans for
+// SetSnapshot, SetGroupSnapshot and UpdateResources, and for each watch they
+// respond to. The responses carry the context of their span, so that the
+// servers record the marshaling and sending of the response in the same trace.
+func WithSnapshotCacheTracing(provider trace.TracerProvider) SnapshotCacheOption {
+	return func(cache *snapshotCache) {
+		cache.tracer = tracing.Tracer(provider)
+	}
+}
+
+// WithSnapshotCacheLogger makes the cache report its activity to a structured
+// logger, with the node ID, type URL and version attached to the messages. It
+// takes precedence over the logger given to NewSnapshotCache.
+func WithSnapshotCacheLogger(logger log.StructuredLogger) SnapshotCacheOption {
+	return func(cache *snapshotCache) {
+		cache.log = logger
+	}
+}
+
 // NewSnapshotCache initializes a simple cache.
 //
 // ADS flag forces a delay in responding to streaming requests until all
@@ -126,21 +314,26 @@ type snapshotCache struct {
 // is OK.
 //
 // Logger is optional.
-func NewSnapshotCache(ads bool, hash NodeHash, logger log.Logger) SnapshotCache {
-	return newSnapshotCache(ads, hash, logger)
+func NewSnapshotCache(ads bool, hash NodeHash, logger log.Logger, opts ...SnapshotCacheOption) SnapshotCache {
+	return newSnapshotCache(ads, hash, logger, opts...)
 }
 
-func newSnapshotCache(ads bool, hash NodeHash, logger log.Logger) *snapshotCache {
-	if logger == nil {
-		logger = log.NewDefaultLogger()
-	}
-
+func newSnapshotCache(ads bool, hash NodeHash, logger log.Logger, opts ...SnapshotCacheOption) *snapshotCache {
 	cache := &snapshotCache{
-		log:       logger,
-		ads:       ads,
-		snapshots: make(map[string]ResourceSnapshot),
-		status:    make(map[string]*statusInfo),
-		hash:      hash,
+		log:               log.FromLogger(logger),
+		ads:               ads,
+		snapshots:         make(map[string]ResourceSnapshot),
+		status:            make(map[string]*statusInfo),
+		hash:              hash,
+		groupSnapshots:    make(map[string]ResourceSnapshot),
+		overlays:          make(map[string]*nodeOverlay),
+		generations:       make(map[string]*generation),
+		generationTimeout: DefaultGenerationTimeout,
+		metrics:           metrics.NewCacheMetrics(nil),
+		tracer:            tracing.Tracer(nil),
+	}
+	for _, opt := range opts {
+		opt(cache)
 	}
 
 	return cache
@@ -161,8 +354,8 @@ func newSnapshotCache(ads bool, hash NodeHash, logger log.Logger) *snapshotCache
 //
 // The context provides a way to cancel the heartbeating routine, while the heartbeatInterval
 // parameter controls how often heartbeating occurs.
-func NewSnapshotCacheWithHeartbeating(ctx context.Context, ads bool, hash NodeHash, logger log.Logger, heartbeatInterval time.Duration) SnapshotCache {
-	cache := newSnapshotCache(ads, hash, logger)
+func NewSnapshotCacheWithHeartbeating(ctx context.Context, ads bool, hash NodeHash, logger log.Logger, heartbeatInterval time.Duration, opts ...SnapshotCacheOption) SnapshotCache {
+	cache := newSnapshotCache(ads, hash, logger, opts...)
 	go func() {
 		t := time.NewTicker(heartbeatInterval)
 
@@ -184,12 +377,12 @@ func NewSnapshotCacheWithHeartbeating(ctx context.Context, ads bool, hash NodeHa
 }
 
 func (cache *snapshotCache) sendHeartbeats(ctx context.Context, node string) {
-	snapshot, ok := cache.snapshots[node]
-	if !ok {
-		return
-	}
-
 	if info, ok := cache.status[node]; ok {
+		snapshot := cache.servedSnapshot(node, info.node)
+		if snapshot == nil {
+			return
+		}
+
 		info.mu.Lock()
 		for id, watch := range info.watches {
 			// Respond with the current version regardless of whether the version has changed.
@@ -207,80 +400,278 @@ func (cache *snapshotCache) sendHeartbeats(ctx context.Context, node string) {
 			if len(resourcesWithTTL) == 0 {
 				continue
 			}
-			cache.log.Debugf("respond open watch %d%v with heartbeat for version %q", id, watch.Request.ResourceNames, version)
+			logger := cache.log.With(log.Node(node), log.TypeURL(watch.Request.TypeUrl), log.Version(version), log.WatchID(id))
+			logger.Debug("respond open watch with heartbeat", log.Resources(watch.Request.ResourceNames))
 			err := cache.respond(ctx, watch.Request, watch.Response, resourcesWithTTL, version, true)
 			if err != nil {
-				cache.log.Errorf("received error when attempting to respond to watches: %v", err)
+				logger.Error("failed to respond to watch with heartbeat", log.Err(err))
 			}
 
 			// The watch must be deleted and we must rely on the client to ack this response to create a new watch.
 			delete(info.watches, id)
+			cache.metrics.Watches.Add(-1, watch.Request.TypeUrl)
 		}
 		info.mu.Unlock()
 	}
 }
 
 // SetSnapshotCacheContext updates a snapshot for a node.
-func (cache *snapshotCache) SetSnapshot(ctx context.Context, node string, snapshot ResourceSnapshot) error {
+func (cache *snapshotCache) SetSnapshot(ctx context.Context, node string, snapshot ResourceSnapshot) (err error) {
+	start := time.Now()
+	ctx, span := cache.tracer.Start(ctx, "SetSnapshot", trace.WithAttributes(tracing.NodeKey.String(node)))
+	defer func() {
+		tracing.End(span, err)
+		cache.metrics.SetSnapshotDuration.Observe(time.Since(start).Seconds())
+	}()
+
+	if cache.validate {
+		if err := validateSnapshot(snapshot); err != nil {
+			return err
+		}
+	}
+
 	cache.mu.Lock()
 	defer cache.mu.Unlock()
 
+	return cache.setSnapshot(ctx, node, snapshot)
+}
+
+// setSnapshot updates the snapshot of a node. The cache mutex must be held.
+func (cache *snapshotCache) setSnapshot(ctx context.Context, node string, snapshot ResourceSnapshot) error {
 	// update the existing entry
+	info, ok := cache.status[node]
+	previous := cache.snapshots[node]
+	if ok {
+		previous = cache.servedSnapshot(node, info.node)
+	}
 	cache.snapshots[node] = snapshot
 
 	// trigger existing watches for which version changed
+	if ok {
+		return cache.respondOpenWatches(ctx, info, previous, snapshot, "")
+	}
+
+	return nil
+}
+
+// UpdateResources updates the resources of a type in the snapshot of a node.
+//
+// The new version of the type is derived from its content, as with
+// WithContentVersions, and the resource hashes used by delta xDS are only
+// computed for the upserted resources. The snapshot set for the node is not
+// modified: the update is applied to a copy sharing the other types.
+func (cache *snapshotCache) UpdateResources(ctx context.Context, node, typeURL string, upserts map[string]types.Resource, deletes []string) (err error) {
+	ctx, span := cache.tracer.Start(ctx, "UpdateResources", trace.WithAttributes(
+		tracing.NodeKey.String(node),
+		tracing.TypeURLKey.String(typeURL),
+	))
+	defer func() {
+		tracing.End(span, err)
+	}()
+
+	if cache.validate {
+		if err := ValidateResources(typeURL, upserts); err != nil {
+			return err
+		}
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	own, ok := cache.snapshots[node]
+	if !ok {
+		return fmt.Errorf("node %q has no snapshot of its own to update", node)
+	}
+	current, ok := own.(*Snapshot)
+	if !ok {
+		return fmt.Errorf("snapshot of node %q is a %T, not a *Snapshot", node, own)
+	}
+	snapshot, err := current.withUpdates(cache.marshalCache, typeURL, upserts, deletes)
+	if err != nil {
+		return err
+	}
+	cache.snapshots[node] = snapshot
+
 	if info, ok := cache.status[node]; ok {
-		info.mu.Lock()
-		defer info.mu.Unlock()
-		for id, watch := range info.watches {
-			version := snapshot.GetVersion(watch.Request.TypeUrl)
-			if version != watch.Request.VersionInfo {
-				cache.log.Debugf("respond open watch %d %s%v with new version %q", id, watch.Request.TypeUrl, watch.Request.ResourceNames, version)
+		return cache.respondOpenWatches(ctx, info, current, snapshot, typeURL)
+	}
+	return nil
+}
 
-				resources := snapshot.GetResourcesAndTTL(watch.Request.TypeUrl)
-				err := cache.respond(ctx, watch.Request, watch.Response, resources, version, false)
-				if err != nil {
-					return err
-				}
+// respondOpenWatches responds to the open watches of a node for which the
+// snapshot has changed, only those of the given type unless it is empty. The
+// previous snapshot of the node may be nil.
+func (cache *snapshotCache) respondOpenWatches(ctx context.Context, info *statusInfo, previous, snapshot ResourceSnapshot, typeURL string) error {
+	cache.markFallback(info, snapshot)
 
-				// discard the watch
-				delete(info.watches, id)
-			}
+	info.mu.Lock()
+	defer info.mu.Unlock()
+	info.lastUpdateTime = time.Now()
+	unchanged := map[string]bool{}
+	for id, watch := range info.watches {
+		if typeURL != "" && watch.Request.TypeUrl != typeURL {
+			continue
 		}
+		version := snapshot.GetVersion(watch.Request.TypeUrl)
+		if version != watch.Request.VersionInfo {
+			if cache.suppressUnchanged && previous != nil {
+				same, ok := unchanged[watch.Request.TypeUrl]
+				if !ok {
+					var err error
+					// The snapshot is already stored, the resources are
+					// pushed if they cannot be compared.
+					if same, err = sameResources(cache.marshalCache, previous, snapshot, watch.Request.TypeUrl); err != nil {
+						cache.log.Error("failed to compare resources with the previous snapshot", log.Node(watch.Request.GetNode().GetId()),
+							log.TypeURL(watch.Request.TypeUrl), log.Version(version), log.Err(err))
+					}
+					unchanged[watch.Request.TypeUrl] = same
+				}
+				if same {
+					cache.log.Debug("skip open watch: resources are unchanged", log.Node(watch.Request.GetNode().GetId()),
+						log.TypeURL(watch.Request.TypeUrl), log.Version(version), log.WatchID(id), log.Resources(watch.Request.ResourceNames))
+					info.suppressedPushes++
+					cache.metrics.SuppressedPushes.Add(1, watch.Request.TypeUrl)
+					continue
+				}
+			}
+
+			cache.log.Debug("respond open watch with new version", log.Node(watch.Request.GetNode().GetId()),
+				log.TypeURL(watch.Request.TypeUrl), log.Version(version), log.WatchID(id), log.Resources(watch.Request.ResourceNames))
 
-		// We only calculate version hashes when using delta. We don't
-		// want to do this when using SOTW so we can avoid unnecessary
-		// computational cost if not using delta.
-		if len(info.deltaWatches) > 0 {
-			err := snapshot.ConstructVersionMap()
+			resources := snapshot.GetResourcesAndTTL(watch.Request.TypeUrl)
+			err := cache.respond(ctx, watch.Request, watch.Response, resources, version, false)
 			if err != nil {
 				return err
 			}
+
+			// discard the watch
+			delete(info.watches, id)
+			cache.metrics.Watches.Add(-1, watch.Request.TypeUrl)
 		}
+	}
 
-		// process our delta watches
-		for id, watch := range info.deltaWatches {
-			res, err := cache.respondDelta(
-				ctx,
-				snapshot,
-				watch.Request,
-				watch.Response,
-				watch.StreamState,
-			)
-			if err != nil {
-				return err
-			}
-			// If we detect a nil response here, that means there has been no state change
-			// so we don't want to respond or remove any existing resource watches
-			if res != nil {
-				delete(info.deltaWatches, id)
-			}
+	// We only calculate version hashes when using delta. We don't
+	// want to do this when using SOTW so we can avoid unnecessary
+	// computational cost if not using delta.
+	if len(info.deltaWatches) > 0 {
+		err := constructVersionMap(snapshot, cache.marshalCache)
+		if err != nil {
+			return err
+		}
+	}
+
+	// process our delta watches
+	for id, watch := range info.deltaWatches {
+		if typeURL != "" && watch.Request.TypeUrl != typeURL {
+			continue
+		}
+		res, err := cache.respondDelta(
+			ctx,
+			snapshot,
+			watch.Request,
+			watch.Response,
+			watch.StreamState,
+		)
+		if err != nil {
+			return err
+		}
+		// If we detect a nil response here, that means there has been no state change
+		// so we don't want to respond or remove any existing resource watches
+		if res != nil {
+			delete(info.deltaWatches, id)
+			cache.metrics.Watches.Add(-1, watch.Request.TypeUrl)
 		}
 	}
 
+	// The streams which are not pushed the snapshot already hold it.
+	if previous != nil {
+		cache.acks.skipped(cache.hash.ID(info.node), previous, snapshot, func(s *streamAcks, typeURL string, names []string) bool {
+			if !s.stream.Delta {
+				return unchanged[typeURL]
+			}
+			same, err := sameNamedResources(cache.marshalCache, previous, snapshot, typeURL, names)
+			return err == nil && same
+		})
+	}
+
 	return nil
 }
 
+// sameResources reports whether two snapshots hold identical resources of a type.
+func sameResources(m *MarshalCache, previous, snapshot ResourceSnapshot, typeURL string) (bool, error) {
+	if err := constructVersionMap(previous, m); err != nil {
+		return false, err
+	}
+	if err := constructVersionMap(snapshot, m); err != nil {
+		return false, err
+	}
+
+	previousVersions := previous.GetVersionMap(typeURL)
+	versions := snapshot.GetVersionMap(typeURL)
+	if len(previousVersions) != len(versions) {
+		return false, nil
+	}
+	for name, version := range versions {
+		if previousVersion, ok := previousVersions[name]; !ok || previousVersion != version {
+			return false, nil
+		}
+	}
+
+	// TTLs are part of the response but not of the resource hashes.
+	previousResources := previous.GetResourcesAndTTL(typeURL)
+	for name, res := range snapshot.GetResourcesAndTTL(typeURL) {
+		if !sameTTL(previousResources[name].TTL, res.TTL) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// sameNamedResources reports whether two snapshots hold identical resources
+// of a type under the names subscribed by a delta stream, all of them for a
+// wildcard subscription.
+func sameNamedResources(m *MarshalCache, previous, snapshot ResourceSnapshot, typeURL string, names []string) (bool, error) {
+	if err := constructVersionMap(previous, m); err != nil {
+		return false, err
+	}
+	if err := constructVersionMap(snapshot, m); err != nil {
+		return false, err
+	}
+
+	previousVersions := previous.GetVersionMap(typeURL)
+	versions := snapshot.GetVersionMap(typeURL)
+	wildcard := len(names) == 0
+	for _, name := range names {
+		if name == "*" {
+			wildcard = true
+		}
+	}
+	if wildcard {
+		names = names[:0:0]
+		for name := range previousVersions {
+			names = append(names, name)
+		}
+		for name := range versions {
+			names = append(names, name)
+		}
+	}
+	for _, name := range names {
+		previousVersion, previousOK := previousVersions[name]
+		version, ok := versions[name]
+		if previousOK != ok || previousVersion != version {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func sameTTL(a, b *time.Duration) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
 // GetSnapshots gets the snapshot for a node, and returns an error if not found.
 func (cache *snapshotCache) GetSnapshot(node string) (ResourceSnapshot, error) {
 	cache.mu.RLock()
@@ -288,18 +679,119 @@ func (cache *snapshotCache) GetSnapshot(node string) (ResourceSnapshot, error) {
 
 	snap, ok := cache.snapshots[node]
 	if !ok {
+		if info, connected := cache.status[node]; connected {
+			snap = cache.nodeSnapshot(node, info.node)
+		}
+	}
+	if snap == nil {
 		return nil, fmt.Errorf("no snapshot found for node %s", node)
 	}
 	return snap, nil
 }
 
-// ClearSnapshot clears snapshot and info for a node.
-func (cache *snapshotCache) ClearSnapshot(node string) {
+// GetNodeSnapshot gets the snapshot set for a node itself, and returns an
+// error if there is none.
+func (cache *snapshotCache) GetNodeSnapshot(node string) (ResourceSnapshot, error) {
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+
+	snap, ok := cache.snapshots[node]
+	if !ok {
+		return nil, fmt.Errorf("no snapshot set for node %s", node)
+	}
+	return snap, nil
+}
+
+// DeleteSnapshot removes the snapshot set for a node itself, and responds to
+// its open watches with the snapshot it is served instead.
+func (cache *snapshotCache) DeleteSnapshot(ctx context.Context, node string) error {
 	cache.mu.Lock()
 	defer cache.mu.Unlock()
 
+	previous, ok := cache.snapshots[node]
+	if !ok {
+		return nil
+	}
 	delete(cache.snapshots, node)
+
+	info, connected := cache.status[node]
+	if !connected {
+		return nil
+	}
+	snapshot := cache.servedSnapshot(node, info.node)
+	if snapshot == nil {
+		return nil
+	}
+	return cache.respondOpenWatches(ctx, info, previous, snapshot, "")
+}
+
+// ClearSnapshot clears snapshot and info for a node.
+func (cache *snapshotCache) ClearSnapshot(node string) {
+	cache.mu.Lock()
+	info, ok := cache.status[node]
+	delete(cache.snapshots, node)
+	delete(cache.overlays, node)
 	delete(cache.status, node)
+	cache.mu.Unlock()
+
+	if ok {
+		cache.closeWatches(node, info)
+	}
+}
+
+// closeWatches discards all the open watches of a cleared node, and responds
+// to them according to the clear policy so that its streams do not wait for
+// responses that will never come. The status of the node must no longer be
+// in the cache, so that the responses are sent without holding its locks.
+func (cache *snapshotCache) closeWatches(node string, info *statusInfo) {
+	info.mu.Lock()
+	watches, deltaWatches := info.watches, info.deltaWatches
+	info.watches = make(map[int64]ResponseWatch)
+	info.deltaWatches = make(map[int64]DeltaResponseWatch)
+	info.mu.Unlock()
+
+	terminate := status.Newf(codes.Unavailable, "snapshot of node %q was cleared", node)
+	for id, watch := range watches {
+		cache.metrics.Watches.Add(-1, watch.Request.TypeUrl)
+
+		var resp Response
+		switch cache.clearPolicy {
+		case ClearTerminateStreams:
+			resp = &TerminateResponse{Request: watch.Request, Status: terminate, Ctx: context.Background()}
+		case ClearRemoveResources:
+			resp = cache.createResponse(context.Background(), watch.Request, nil, "", false)
+		default:
+			continue
+		}
+		cache.log.Debug("close open watch of cleared node", log.Node(node), log.TypeURL(watch.Request.TypeUrl),
+			log.WatchID(id), log.Resources(watch.Request.ResourceNames))
+		watch.Response <- resp
+	}
+
+	for id, watch := range deltaWatches {
+		cache.metrics.Watches.Add(-1, watch.Request.TypeUrl)
+
+		var resp DeltaResponse
+		switch cache.clearPolicy {
+		case ClearTerminateStreams:
+			resp = &TerminateResponse{DeltaRequest: watch.Request, Status: terminate, Ctx: context.Background()}
+		case ClearRemoveResources:
+			removed := make([]string, 0, len(watch.StreamState.GetResourceVersions()))
+			for name := range watch.StreamState.GetResourceVersions() {
+				removed = append(removed, name)
+			}
+			resp = &RawDeltaResponse{
+				DeltaRequest:     watch.Request,
+				RemovedResources: removed,
+				NextVersionMap:   map[string]string{},
+				Ctx:              context.Background(),
+			}
+		default:
+			continue
+		}
+		cache.log.Debug("close open delta watch of cleared node", log.Node(node), log.TypeURL(watch.Request.TypeUrl), log.WatchID(id))
+		watch.Response <- resp
+	}
 }
 
 // nameSet creates a map from a string slice to value true.
@@ -341,7 +833,16 @@ func (cache *snapshotCache) CreateWatch(request *Request, streamState stream.Str
 
 	var version string
 
-	snapshot, exists := cache.snapshots[nodeID]
+	snapshot := cache.nodeSnapshot(nodeID, request.Node)
+	if snapshot == nil {
+		if cache.generator != nil {
+			cache.generate(nodeID, request.Node)
+		}
+		snapshot = cache.fallback
+	}
+	cache.markFallback(info, snapshot)
+
+	exists := snapshot != nil
 	if exists {
 		version = snapshot.GetVersion(request.TypeUrl)
 	}
@@ -355,16 +856,16 @@ func (cache *snapshotCache) CreateWatch(request *Request, streamState stream.Str
 			}
 		}
 
-		cache.log.Debugf("nodeID %q requested %s%v and known %v. Diff %v", nodeID,
-			request.TypeUrl, request.ResourceNames, knownResourceNames, diff)
+		cache.log.Debug("compare requested resources with known resources", log.Node(nodeID), log.TypeURL(request.TypeUrl),
+			log.Resources(request.ResourceNames), log.Any("known", knownResourceNames), log.Any("diff", diff))
 
 		if len(diff) > 0 {
 			resources := snapshot.GetResourcesAndTTL(request.TypeUrl)
 			for _, name := range diff {
 				if _, exists := resources[name]; exists {
 					if err := cache.respond(context.Background(), request, value, resources, version, false); err != nil {
-						cache.log.Errorf("failed to send a response for %s%v to nodeID %q: %s", request.TypeUrl,
-							request.ResourceNames, nodeID, err)
+						cache.log.Error("failed to send a response", log.Node(nodeID), log.TypeURL(request.TypeUrl),
+							log.Version(version), log.Resources(request.ResourceNames), log.Err(err))
 					}
 					return nil
 				}
@@ -375,18 +876,20 @@ func (cache *snapshotCache) CreateWatch(request *Request, streamState stream.Str
 	// if the requested version is up-to-date or missing a response, leave an open watch
 	if !exists || request.VersionInfo == version {
 		watchID := cache.nextWatchID()
-		cache.log.Debugf("open watch %d for %s%v from nodeID %q, version %q", watchID, request.TypeUrl, request.ResourceNames, nodeID, request.VersionInfo)
+		cache.log.Debug("open watch", log.Node(nodeID), log.TypeURL(request.TypeUrl), log.Version(request.VersionInfo),
+			log.WatchID(watchID), log.Resources(request.ResourceNames))
 		info.mu.Lock()
 		info.watches[watchID] = ResponseWatch{Request: request, Response: value}
 		info.mu.Unlock()
+		cache.metrics.Watches.Add(1, request.TypeUrl)
 		return cache.cancelWatch(nodeID, watchID)
 	}
 
 	// otherwise, the watch may be responded immediately
 	resources := snapshot.GetResourcesAndTTL(request.TypeUrl)
 	if err := cache.respond(context.Background(), request, value, resources, version, false); err != nil {
-		cache.log.Errorf("failed to send a response for %s%v to nodeID %q: %s", request.TypeUrl,
-			request.ResourceNames, nodeID, err)
+		cache.log.Error("failed to send a response", log.Node(nodeID), log.TypeURL(request.TypeUrl),
+			log.Version(version), log.Resources(request.ResourceNames), log.Err(err))
 	}
 
 	return nil
@@ -404,7 +907,10 @@ func (cache *snapshotCache) cancelWatch(nodeID string, watchID int64) func() {
 		defer cache.mu.RUnlock()
 		if info, ok := cache.status[nodeID]; ok {
 			info.mu.Lock()
-			delete(info.watches, watchID)
+			if watch, ok := info.watches[watchID]; ok {
+				delete(info.watches, watchID)
+				cache.metrics.Watches.Add(-1, watch.Request.TypeUrl)
+			}
 			info.mu.Unlock()
 		}
 	}
@@ -417,22 +923,31 @@ func (cache *snapshotCache) respond(ctx context.Context, request *Request, value
 	// if they do not, then the watch is never responded, and it is expected that envoy makes another request
 	if len(request.ResourceNames) != 0 && cache.ads {
 		if err := superset(nameSet(request.ResourceNames), resources); err != nil {
-			cache.log.Warnf("ADS mode: not responding to request: %v", err)
+			cache.log.Warn("ADS mode: not responding to request", log.Node(request.GetNode().GetId()),
+				log.TypeURL(request.TypeUrl), log.Version(version), log.Err(err))
 			return nil
 		}
 	}
 
-	cache.log.Debugf("respond %s%v version %q with version %q", request.TypeUrl, request.ResourceNames, request.VersionInfo, version)
+	cache.log.Debug("respond", log.Node(request.GetNode().GetId()), log.TypeURL(request.TypeUrl), log.Version(version),
+		log.Any("request_version", request.VersionInfo), log.Resources(request.ResourceNames))
+
+	ctx, span := cache.tracer.Start(ctx, "RespondWatch", trace.WithAttributes(
+		tracing.NodeKey.String(request.GetNode().GetId()),
+		tracing.TypeURLKey.String(request.TypeUrl),
+		tracing.VersionKey.String(version),
+	))
+	defer span.End()
 
 	select {
-	case value <- createResponse(ctx, request, resources, version, heartbeat):
+	case value <- cache.createResponse(ctx, request, resources, version, heartbeat):
 		return nil
 	case <-ctx.Done():
 		return context.Canceled
 	}
 }
 
-func createResponse(ctx context.Context, request *Request, resources map[string]types.ResourceWithTTL, version string, heartbeat bool) Response {
+func (cache *snapshotCache) createResponse(ctx context.Context, request *Request, resources map[string]types.ResourceWithTTL, version string, heartbeat bool) Response {
 	filtered := make([]types.ResourceWithTTL, 0, len(resources))
 
 	// Reply only with the requested resources. Envoy may ask each resource
@@ -452,11 +967,12 @@ func createResponse(ctx context.Context, request *Request, resources map[string]
 	}
 
 	return &RawResponse{
-		Request:   request,
-		Version:   version,
-		Resources: filtered,
-		Heartbeat: heartbeat,
-		Ctx:       ctx,
+		Request:      request,
+		Version:      version,
+		Resources:    filtered,
+		Heartbeat:    heartbeat,
+		Ctx:          ctx,
+		marshalCache: cache.marshalCache,
 	}
 }
 
@@ -478,7 +994,15 @@ func (cache *snapshotCache) CreateDeltaWatch(request *DeltaRequest, state stream
 	info.setLastDeltaWatchRequestTime(time.Now())
 
 	// find the current cache snapshot for the provided node
-	snapshot, exists := cache.snapshots[nodeID]
+	snapshot := cache.nodeSnapshot(nodeID, request.Node)
+	if snapshot == nil {
+		if cache.generator != nil {
+			cache.generate(nodeID, request.Node)
+		}
+		snapshot = cache.fallback
+	}
+	cache.markFallback(info, snapshot)
+	exists := snapshot != nil
 
 	// There are three different cases that leads to a delayed watch trigger:
 	// - no snapshot exists for the requested nodeID
@@ -486,13 +1010,13 @@ func (cache *snapshotCache) CreateDeltaWatch(request *DeltaRequest, state stream
 	// - we attempted to issue a response, but the caller is already up to date
 	delayedResponse := !exists
 	if exists {
-		err := snapshot.ConstructVersionMap()
+		err := constructVersionMap(snapshot, cache.marshalCache)
 		if err != nil {
-			cache.log.Errorf("failed to compute version for snapshot resources inline: %s", err)
+			cache.log.Error("failed to compute version for snapshot resources inline", log.Node(nodeID), log.TypeURL(t), log.Err(err))
 		}
 		response, err := cache.respondDelta(context.Background(), snapshot, request, value, state)
 		if err != nil {
-			cache.log.Errorf("failed to respond with delta response: %s", err)
+			cache.log.Error("failed to respond with delta response", log.Node(nodeID), log.TypeURL(t), log.Err(err))
 		}
 
 		delayedResponse = response == nil
@@ -501,13 +1025,14 @@ func (cache *snapshotCache) CreateDeltaWatch(request *DeltaRequest, state stream
 	if delayedResponse {
 		watchID := cache.nextDeltaWatchID()
 
+		fields := []log.Field{log.Node(nodeID), log.TypeURL(t), log.WatchID(watchID), log.Any(log.ResourcesKey, state.GetSubscribedResourceNames())}
 		if exists {
-			cache.log.Infof("open delta watch ID:%d for %s Resources:%v from nodeID: %q,  version %q", watchID, t, state.GetSubscribedResourceNames(), nodeID, snapshot.GetVersion(t))
-		} else {
-			cache.log.Infof("open delta watch ID:%d for %s Resources:%v from nodeID: %q", watchID, t, state.GetSubscribedResourceNames(), nodeID)
+			fields = append(fields, log.Version(snapshot.GetVersion(t)))
 		}
+		cache.log.Info("open delta watch", fields...)
 
 		info.setDeltaResponseWatch(watchID, DeltaResponseWatch{Request: request, Response: value, StreamState: state})
+		cache.metrics.Watches.Add(1, t)
 		return cache.cancelDeltaWatch(nodeID, watchID)
 	}
 
@@ -517,19 +1042,28 @@ func (cache *snapshotCache) CreateDeltaWatch(request *DeltaRequest, state stream
 // Respond to a delta watch with the provided snapshot value. If the response is nil, there has been no state change.
 func (cache *snapshotCache) respondDelta(ctx context.Context, snapshot ResourceSnapshot, request *DeltaRequest, value chan DeltaResponse, state stream.StreamState) (*RawDeltaResponse, error) {
 	resp := createDeltaResponse(ctx, request, state, resourceContainer{
-		resourceMap:   snapshot.GetResources(request.TypeUrl),
+		resourceMap:   snapshot.GetResourcesAndTTL(request.TypeUrl),
 		versionMap:    snapshot.GetVersionMap(request.TypeUrl),
 		systemVersion: snapshot.GetVersion(request.TypeUrl),
 	})
+	resp.marshalCache = cache.marshalCache
 
 	// Only send a response if there were changes
 	// We want to respond immediately for the first wildcard request in a stream, even if the response is empty
 	// otherwise, envoy won't complete initialization
-	if len(resp.Resources) > 0 || len(resp.RemovedResources) > 0 || (state.IsWildcard() && state.IsFirst()) {
-		if cache.log != nil {
-			cache.log.Debugf("node: %s, sending delta response for typeURL %s with resources: %v removed resources: %v with wildcard: %t",
-				request.GetNode().GetId(), request.TypeUrl, GetResourceNames(resp.Resources), resp.RemovedResources, state.IsWildcard())
-		}
+	if resp.numResources() > 0 || len(resp.RemovedResources) > 0 || (state.IsWildcard() && state.IsFirst()) {
+		cache.log.Debug("send delta response", log.Node(request.GetNode().GetId()), log.TypeURL(request.TypeUrl),
+			log.Version(resp.SystemVersionInfo), log.Resources(resp.resourceNames()),
+			log.Any("removed", resp.RemovedResources), log.Any("wildcard", state.IsWildcard()))
+
+		var span trace.Span
+		resp.Ctx, span = cache.tracer.Start(ctx, "RespondDeltaWatch", trace.WithAttributes(
+			tracing.NodeKey.String(request.GetNode().GetId()),
+			tracing.TypeURLKey.String(request.TypeUrl),
+			tracing.VersionKey.String(resp.SystemVersionInfo),
+		))
+		defer span.End()
+
 		select {
 		case value <- resp:
 			return resp, nil
@@ -551,7 +1085,10 @@ func (cache *snapshotCache) cancelDeltaWatch(nodeID string, watchID int64) func(
 		defer cache.mu.RUnlock()
 		if info, ok := cache.status[nodeID]; ok {
 			info.mu.Lock()
-			delete(info.deltaWatches, watchID)
+			if watch, ok := info.deltaWatches[watchID]; ok {
+				delete(info.deltaWatches, watchID)
+				cache.metrics.Watches.Add(-1, watch.Request.TypeUrl)
+			}
 			info.mu.Unlock()
 		}
 	}
@@ -563,19 +1100,39 @@ func (cache *snapshotCache) Fetch(ctx context.Context, request *Request) (Respon
 	nodeID := cache.hash.ID(request.Node)
 
 	cache.mu.RLock()
-	defer cache.mu.RUnlock()
+	snapshot := cache.nodeSnapshot(nodeID, request.Node)
+	cache.mu.RUnlock()
+
+	if snapshot == nil && cache.generator != nil {
+		cache.mu.Lock()
+		done := cache.generate(nodeID, request.Node)
+		cache.mu.Unlock()
+
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		cache.mu.RLock()
+		snapshot = cache.nodeSnapshot(nodeID, request.Node)
+		cache.mu.RUnlock()
+	}
+	if snapshot == nil {
+		snapshot = cache.fallback
+	}
 
-	if snapshot, exists := cache.snapshots[nodeID]; exists {
+	if snapshot != nil {
 		// Respond only if the request version is distinct from the current snapshot state.
 		// It might be beneficial to hold the request since Envoy will re-attempt the refresh.
 		version := snapshot.GetVersion(request.TypeUrl)
 		if request.VersionInfo == version {
-			cache.log.Warnf("skip fetch: version up to date")
+			cache.log.Warn("skip fetch: version up to date", log.Node(nodeID), log.TypeURL(request.TypeUrl), log.Version(version))
 			return nil, &types.SkipFetchError{}
 		}
 
 		resources := snapshot.GetResourcesAndTTL(request.TypeUrl)
-		out := createResponse(ctx, request, resources, version, false)
+		out := cache.createResponse(ctx, request, resources, version, false)
 		return out, nil
 	}
 
@@ -589,7 +1146,7 @@ func (cache *snapshotCache) GetStatusInfo(node string) StatusInfo {
 
 	info, exists := cache.status[node]
 	if !exists {
-		cache.log.Warnf("node does not exist")
+		cache.log.Warn("node does not exist", log.Node(node))
 		return nil
 	}
 
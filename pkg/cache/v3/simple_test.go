@@ -25,6 +25,8 @@ import (
 	"github.com/google/go-cmp/cmp"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/testing/protocmp"
 	"google.golang.org/protobuf/types/known/anypb"
@@ -339,6 +341,88 @@ func TestSnapshotCacheWatch(t *testing.T) {
 	}
 }
 
+func TestSnapshotCacheUnchangedPushSuppression(t *testing.T) {
+	c := cache.NewSnapshotCache(false, group{}, logger{t: t}, cache.WithUnchangedPushSuppression())
+	require.NoError(t, c.SetSnapshot(context.Background(), key, fixture.snapshot()))
+
+	streamState := stream.NewStreamState(false, map[string]string{})
+	watches := make(map[string]chan cache.Response)
+	for _, typ := range []string{rsrc.EndpointType, rsrc.ClusterType} {
+		streamState.SetKnownResourceNamesAsList(typ, names[typ])
+		watches[typ] = make(chan cache.Response, 1)
+		c.CreateWatch(&discovery.DiscoveryRequest{TypeUrl: typ, ResourceNames: names[typ], VersionInfo: fixture.version},
+			streamState, watches[typ])
+	}
+
+	// Same resources under a new version, except for the endpoints.
+	snapshot2 := fixture.snapshot()
+	for typ, resources := range snapshot2.Resources {
+		resources.Version = fixture.version2
+		snapshot2.Resources[typ] = resources
+	}
+	snapshot2.Resources[types.Endpoint] = cache.NewResources(fixture.version2, []types.Resource{resource.MakeEndpoint(clusterName, 9090)})
+	require.NoError(t, c.SetSnapshot(context.Background(), key, snapshot2))
+
+	select {
+	case out := <-watches[rsrc.EndpointType]:
+		gotVersion, _ := out.GetVersion()
+		assert.Equal(t, fixture.version2, gotVersion)
+	case <-time.After(time.Second):
+		t.Fatal("failed to receive snapshot response")
+	}
+	select {
+	case <-watches[rsrc.ClusterType]:
+		t.Fatal("unexpected response for unchanged clusters")
+	default:
+	}
+	assert.Equal(t, 1, c.GetStatusInfo(key).GetNumWatches())
+	assert.Equal(t, int64(1), c.GetStatusInfo(key).(cache.SuppressionStatusInfo).GetNumSuppressedPushes())
+
+	// The open cluster watch is responded once the clusters change.
+	snapshot3, err := cache.NewSnapshot("3", map[rsrc.Type][]types.Resource{
+		rsrc.ClusterType: {resource.MakeCluster(resource.Ads, "other")},
+	})
+	require.NoError(t, err)
+	require.NoError(t, c.SetSnapshot(context.Background(), key, snapshot3))
+	select {
+	case out := <-watches[rsrc.ClusterType]:
+		gotVersion, _ := out.GetVersion()
+		assert.Equal(t, "3", gotVersion)
+	case <-time.After(time.Second):
+		t.Fatal("failed to receive snapshot response")
+	}
+	assert.Equal(t, int64(1), c.GetStatusInfo(key).(cache.SuppressionStatusInfo).GetNumSuppressedPushes())
+}
+
+func TestSnapshotCacheUnchangedPushSuppressionError(t *testing.T) {
+	c := cache.NewSnapshotCache(false, group{}, logger{t: t}, cache.WithUnchangedPushSuppression())
+	// Invalid UTF-8 in a string field fails to marshal, and to be compared.
+	snapshot, err := cache.NewSnapshot(fixture.version, map[rsrc.Type][]types.Resource{
+		rsrc.ClusterType: {resource.MakeCluster(resource.Ads, "\xff")},
+	})
+	require.NoError(t, err)
+	require.NoError(t, c.SetSnapshot(context.Background(), key, snapshot))
+
+	watch := make(chan cache.Response, 1)
+	c.CreateWatch(&discovery.DiscoveryRequest{TypeUrl: rsrc.ClusterType, VersionInfo: fixture.version},
+		stream.NewStreamState(false, map[string]string{}), watch)
+
+	snapshot2, err := cache.NewSnapshot(fixture.version2, map[rsrc.Type][]types.Resource{
+		rsrc.ClusterType: {resource.MakeCluster(resource.Ads, "\xff")},
+	})
+	require.NoError(t, err)
+	require.NoError(t, c.SetSnapshot(context.Background(), key, snapshot2))
+
+	select {
+	case out := <-watch:
+		gotVersion, _ := out.GetVersion()
+		assert.Equal(t, fixture.version2, gotVersion)
+	case <-time.After(time.Second):
+		t.Fatal("failed to receive snapshot response")
+	}
+	assert.Equal(t, int64(0), c.GetStatusInfo(key).(cache.SuppressionStatusInfo).GetNumSuppressedPushes())
+}
+
 func TestConcurrentSetWatch(t *testing.T) {
 	c := cache.NewSnapshotCache(false, group{}, logger{t: t})
 	for i := 0; i < 50; i++ {
@@ -512,6 +596,140 @@ func TestSnapshotClear(t *testing.T) {
 	}
 }
 
+func TestSnapshotCacheUpdateResources(t *testing.T) {
+	c := cache.NewSnapshotCache(false, group{}, logger{t: t})
+	updater := c.(cache.SnapshotUpdater)
+	snapshot := fixture.snapshot()
+	require.NoError(t, c.SetSnapshot(context.Background(), key, snapshot))
+
+	streamState := stream.NewStreamState(false, map[string]string{})
+	watches := make(map[string]chan cache.Response)
+	for _, typ := range []string{rsrc.EndpointType, rsrc.ClusterType} {
+		streamState.SetKnownResourceNamesAsList(typ, names[typ])
+		watches[typ] = make(chan cache.Response, 1)
+		c.CreateWatch(&discovery.DiscoveryRequest{TypeUrl: typ, ResourceNames: names[typ], VersionInfo: fixture.version},
+			streamState, watches[typ])
+	}
+
+	updated := resource.MakeEndpoint(clusterName, 9090)
+	require.NoError(t, updater.UpdateResources(context.Background(), key, rsrc.EndpointType,
+		map[string]types.Resource{clusterName: updated}, nil))
+
+	select {
+	case out := <-watches[rsrc.EndpointType]:
+		version, _ := out.GetVersion()
+		assert.NotEqual(t, fixture.version, version)
+		assert.True(t, proto.Equal(updated, out.(*cache.RawResponse).Resources[0].Resource))
+	case <-time.After(time.Second):
+		t.Fatal("failed to receive snapshot response")
+	}
+	assert.Equal(t, 1, c.GetStatusInfo(key).GetNumWatches())
+
+	current, err := c.GetSnapshot(key)
+	require.NoError(t, err)
+	assert.Equal(t, fixture.version, current.GetVersion(rsrc.ClusterType))
+	assert.Equal(t, snapshot.GetVersionMap(rsrc.ClusterType), current.GetVersionMap(rsrc.ClusterType))
+	// The snapshot that was set is left untouched.
+	assert.Equal(t, fixture.version, snapshot.GetVersion(rsrc.EndpointType))
+
+	require.NoError(t, updater.UpdateResources(context.Background(), key, rsrc.EndpointType, nil, []string{clusterName}))
+	current, err = c.GetSnapshot(key)
+	require.NoError(t, err)
+	assert.Empty(t, current.GetResources(rsrc.EndpointType))
+	assert.Empty(t, current.GetVersionMap(rsrc.EndpointType))
+
+	assert.Error(t, updater.UpdateResources(context.Background(), "unknown", rsrc.EndpointType, nil, nil))
+	assert.Error(t, updater.UpdateResources(context.Background(), key, "unknown", nil, nil))
+}
+
+// createUpToDateDeltaWatch opens a delta watch for a stream that received the
+// current resources of a type.
+func createUpToDateDeltaWatch(t *testing.T, c cache.Cache, typ string) chan cache.DeltaResponse {
+	request := &discovery.DeltaDiscoveryRequest{Node: &core.Node{Id: key}, TypeUrl: typ}
+	deltaWatch := make(chan cache.DeltaResponse, 1)
+	c.CreateDeltaWatch(request, stream.NewStreamState(true, nil), deltaWatch)
+	out := <-deltaWatch
+
+	state := stream.NewStreamState(true, nil)
+	state.SetResourceVersions(out.GetNextVersionMap())
+	c.CreateDeltaWatch(request, state, deltaWatch)
+	return deltaWatch
+}
+
+func TestSnapshotClearLeavesStreams(t *testing.T) {
+	c := cache.NewSnapshotCache(false, group{}, logger{t: t})
+	require.NoError(t, c.SetSnapshot(context.Background(), key, fixture.snapshot()))
+
+	watch := make(chan cache.Response, 1)
+	c.CreateWatch(&discovery.DiscoveryRequest{TypeUrl: rsrc.ClusterType, VersionInfo: fixture.version},
+		stream.NewStreamState(false, map[string]string{}), watch)
+	deltaWatch := createUpToDateDeltaWatch(t, c, rsrc.ClusterType)
+
+	c.ClearSnapshot(key)
+
+	assert.Empty(t, watch)
+	assert.Empty(t, deltaWatch)
+	assert.Empty(t, c.GetStatusKeys())
+}
+
+func TestSnapshotClearTerminatesStreams(t *testing.T) {
+	c := cache.NewSnapshotCache(false, group{}, logger{t: t}, cache.WithClearPolicy(cache.ClearTerminateStreams))
+	require.NoError(t, c.SetSnapshot(context.Background(), key, fixture.snapshot()))
+
+	watch := make(chan cache.Response, 1)
+	c.CreateWatch(&discovery.DiscoveryRequest{TypeUrl: rsrc.ClusterType, VersionInfo: fixture.version},
+		stream.NewStreamState(false, map[string]string{}), watch)
+	deltaWatch := createUpToDateDeltaWatch(t, c, rsrc.ClusterType)
+	require.Equal(t, 1, c.GetStatusInfo(key).GetNumDeltaWatches())
+
+	c.ClearSnapshot(key)
+
+	select {
+	case out := <-watch:
+		_, err := out.GetDiscoveryResponse()
+		assert.Equal(t, codes.Unavailable, status.Code(err))
+	case <-time.After(time.Second):
+		t.Fatal("watch was not closed")
+	}
+	select {
+	case out := <-deltaWatch:
+		_, err := out.GetDeltaDiscoveryResponse()
+		assert.Equal(t, codes.Unavailable, status.Code(err))
+	case <-time.After(time.Second):
+		t.Fatal("delta watch was not closed")
+	}
+}
+
+func TestSnapshotClearRemovesResources(t *testing.T) {
+	c := cache.NewSnapshotCache(false, group{}, logger{t: t}, cache.WithClearPolicy(cache.ClearRemoveResources))
+	require.NoError(t, c.SetSnapshot(context.Background(), key, fixture.snapshot()))
+
+	watch := make(chan cache.Response, 1)
+	c.CreateWatch(&discovery.DiscoveryRequest{TypeUrl: rsrc.ClusterType, VersionInfo: fixture.version},
+		stream.NewStreamState(false, map[string]string{}), watch)
+	deltaWatch := createUpToDateDeltaWatch(t, c, rsrc.ClusterType)
+
+	c.ClearSnapshot(key)
+
+	select {
+	case out := <-watch:
+		resp, err := out.GetDiscoveryResponse()
+		require.NoError(t, err)
+		assert.Empty(t, resp.Resources)
+	case <-time.After(time.Second):
+		t.Fatal("watch was not closed")
+	}
+	select {
+	case out := <-deltaWatch:
+		resp, err := out.GetDeltaDiscoveryResponse()
+		require.NoError(t, err)
+		assert.Empty(t, resp.Resources)
+		assert.Equal(t, []string{clusterName}, resp.RemovedResources)
+	case <-time.After(time.Second):
+		t.Fatal("delta watch was not closed")
+	}
+}
+
 type singleResourceSnapshot struct {
 	version  string
 	typeurl  string
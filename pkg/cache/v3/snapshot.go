@@ -17,6 +17,7 @@ package cache
 import (
 	"errors"
 	"fmt"
+	"sort"
 
 	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
 	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
@@ -33,13 +34,35 @@ type Snapshot struct {
 	// instantiated by calling ConstructVersionMap().
 	// VersionMap is only to be used with delta xDS.
 	VersionMap map[string]map[string]string
+
+	// registered holds the resources of the types added with
+	// RegisterResourceType, allocated on first use.
+	registered map[types.ResponseType]Resources
 }
 
 var _ ResourceSnapshot = &Snapshot{}
 
+// SnapshotOption configures a snapshot created by NewSnapshot or NewSnapshotWithTTLs.
+type SnapshotOption func(*snapshotOptions)
+
+type snapshotOptions struct {
+	contentVersions bool
+}
+
+// WithContentVersions replaces the version given to the constructor with a
+// version per type derived from the content of its resources, see
+// ContentVersion. Identical resources then yield identical versions across
+// control plane replicas and restarts, and clients reconnecting to another
+// replica are not sent the same resources again.
+func WithContentVersions() SnapshotOption {
+	return func(o *snapshotOptions) {
+		o.contentVersions = true
+	}
+}
+
 // NewSnapshot creates a snapshot from response types and a version.
 // The resources map is keyed off the type URL of a resource, followed by the slice of resource objects.
-func NewSnapshot(version string, resources map[resource.Type][]types.Resource) (*Snapshot, error) {
+func NewSnapshot(version string, resources map[resource.Type][]types.Resource, opts ...SnapshotOption) (*Snapshot, error) {
 	out := Snapshot{}
 
 	for typ, resource := range resources {
@@ -48,15 +71,16 @@ func NewSnapshot(version string, resources map[resource.Type][]types.Resource) (
 			return nil, errors.New("unknown resource type: " + typ)
 		}
 
-		out.Resources[index] = NewResources(version, resource)
+		out.setResources(index, NewResources(version, resource))
 	}
 
-	return &out, nil
+	return out.applyOptions(opts)
 }
 
-// NewSnapshotWithTTLs creates a snapshot of ResourceWithTTLs.
+// NewSnapshotWithTTLs creates a snapshot of ResourceWithTTLs, which may hold
+// serialized resources.
 // The resources map is keyed off the type URL of a resource, followed by the slice of resource objects.
-func NewSnapshotWithTTLs(version string, resources map[resource.Type][]types.ResourceWithTTL) (*Snapshot, error) {
+func NewSnapshotWithTTLs(version string, resources map[resource.Type][]types.ResourceWithTTL, opts ...SnapshotOption) (*Snapshot, error) {
 	out := Snapshot{}
 
 	for typ, resource := range resources {
@@ -65,10 +89,36 @@ func NewSnapshotWithTTLs(version string, resources map[resource.Type][]types.Res
 			return nil, errors.New("unknown resource type: " + typ)
 		}
 
-		out.Resources[index] = NewResourcesWithTTL(version, resource)
+		out.setResources(index, NewResourcesWithTTL(version, resource))
 	}
 
-	return &out, nil
+	return out.applyOptions(opts)
+}
+
+func (s *Snapshot) applyOptions(opts []SnapshotOption) (*Snapshot, error) {
+	var o snapshotOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if o.contentVersions {
+		// The resources are hashed anyway, keep the hashes for delta xDS.
+		s.VersionMap = make(map[string]map[string]string)
+		for _, t := range registry.all() {
+			resources := s.resources(t.responseType)
+			version, hashes, err := contentVersion(resources.Items)
+			if err != nil {
+				return nil, err
+			}
+			if resources.Items != nil {
+				resources.Version = version
+				s.setResources(t.responseType, resources)
+			}
+			s.VersionMap[t.TypeURL] = hashes
+		}
+	}
+
+	return s, nil
 }
 
 // Consistent check verifies that the dependent resources are exactly listed in the
@@ -86,35 +136,29 @@ func (s *Snapshot) Consistent() error {
 	}
 
 	referencedResources := GetAllResourceReferences(s.Resources)
-
-	// Loop through each referenced resource.
-	referencedResponseTypes := map[types.ResponseType]struct{}{
-		types.Endpoint: {},
-		types.Route:    {},
+	for _, resources := range s.registered {
+		getResourceReferences(resources.Items, referencedResources)
 	}
 
-	for idx, items := range s.Resources {
-
-		// We only want to check resource types that are expected to be referenced by another resource type.
-		// Basically, if the consistency relationship is modeled as a DAG, we only want
-		// to check nodes that are expected to have edges pointing to it.
-		responseType := types.ResponseType(idx)
-		if _, ok := referencedResponseTypes[responseType]; ok {
-			typeURL, err := GetResponseTypeURL(responseType)
-			if err != nil {
-				return err
-			}
-			referenceSet := referencedResources[typeURL]
+	// We only want to check resource types that are expected to be referenced by another resource type.
+	// Basically, if the consistency relationship is modeled as a DAG, we only want
+	// to check nodes that are expected to have edges pointing to it.
+	for _, t := range registry.all() {
+		if !t.RequestedByName {
+			continue
+		}
+		typeURL := t.TypeURL
+		items := s.resources(t.responseType)
+		referenceSet := referencedResources[typeURL]
 
-			if len(referenceSet) != len(items.Items) {
-				return fmt.Errorf("mismatched %q reference and resource lengths: len(%v) != %d",
-					typeURL, referenceSet, len(items.Items))
-			}
+		if len(referenceSet) != len(items.Items) {
+			return fmt.Errorf("mismatched %q reference and resource lengths: len(%v) != %d",
+				typeURL, referenceSet, len(items.Items))
+		}
 
-			// Check superset.
-			if err := superset(referenceSet, items.Items); err != nil {
-				return fmt.Errorf("inconsistent %q reference: %w", typeURL, err)
-			}
+		// Check superset.
+		if err := superset(referenceSet, items.Items); err != nil {
+			return fmt.Errorf("inconsistent %q reference: %w", typeURL, err)
 		}
 	}
 
@@ -122,6 +166,7 @@ func (s *Snapshot) Consistent() error {
 }
 
 // GetResources selects snapshot resources by type, returning the map of resources.
+// Serialized resources are decoded, and left out if they cannot be.
 func (s *Snapshot) GetResources(typeURL resource.Type) map[string]types.Resource {
 	resources := s.GetResourcesAndTTL(typeURL)
 	if resources == nil {
@@ -131,7 +176,9 @@ func (s *Snapshot) GetResources(typeURL resource.Type) map[string]types.Resource
 	withoutTTL := make(map[string]types.Resource, len(resources))
 
 	for k, v := range resources {
-		withoutTTL[k] = v.Resource
+		if msg := entryMessage(v); msg != nil {
+			withoutTTL[k] = msg
+		}
 	}
 
 	return withoutTTL
@@ -146,7 +193,7 @@ func (s *Snapshot) GetResourcesAndTTL(typeURL resource.Type) map[string]types.Re
 	if typ == types.UnknownType {
 		return nil
 	}
-	return s.Resources[typ].Items
+	return s.resources(typ).Items
 }
 
 // GetVersion returns the version for a resource type.
@@ -158,7 +205,27 @@ func (s *Snapshot) GetVersion(typeURL resource.Type) string {
 	if typ == types.UnknownType {
 		return ""
 	}
-	return s.Resources[typ].Version
+	return s.resources(typ).Version
+}
+
+// resources returns the resources of a type, built-in or registered.
+func (s *Snapshot) resources(typ types.ResponseType) Resources {
+	if typ < types.UnknownType {
+		return s.Resources[typ]
+	}
+	return s.registered[typ]
+}
+
+// setResources sets the resources of a type, built-in or registered.
+func (s *Snapshot) setResources(typ types.ResponseType, resources Resources) {
+	if typ < types.UnknownType {
+		s.Resources[typ] = resources
+		return
+	}
+	if s.registered == nil {
+		s.registered = make(map[types.ResponseType]Resources)
+	}
+	s.registered[typ] = resources
 }
 
 // GetVersionMap will return the internal version map of the currently applied snapshot.
@@ -166,8 +233,69 @@ func (s *Snapshot) GetVersionMap(typeURL string) map[string]string {
 	return s.VersionMap[typeURL]
 }
 
+// withUpdates returns a copy of the snapshot where the resources of a type are
+// upserted and deleted. The version of the type is derived from its content,
+// see ContentVersion, using the hashes of the version map for the resources
+// left unchanged. The other types are shared with the snapshot.
+func (s *Snapshot) withUpdates(m *MarshalCache, typeURL string, upserts map[string]types.Resource, deletes []string) (*Snapshot, error) {
+	typ := GetResponseType(typeURL)
+	if typ == types.UnknownType {
+		return nil, errors.New("unknown resource type: " + typeURL)
+	}
+	if err := s.constructVersionMap(m); err != nil {
+		return nil, err
+	}
+
+	current := s.resources(typ).Items
+	items := make(map[string]types.ResourceWithTTL, len(current)+len(upserts))
+	for name, r := range current {
+		items[name] = r
+	}
+	hashes := make(map[string]string, len(items)+len(upserts))
+	for name, hash := range s.VersionMap[typeURL] {
+		hashes[name] = hash
+	}
+
+	for name, r := range upserts {
+		_, hash, err := m.marshalAndHash(r)
+		if err != nil {
+			return nil, err
+		}
+		items[name] = types.ResourceWithTTL{Resource: r}
+		hashes[name] = hash
+	}
+	for _, name := range deletes {
+		delete(items, name)
+		delete(hashes, name)
+	}
+
+	names := make([]string, 0, len(items))
+	for name := range items {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	out := &Snapshot{
+		Resources:  s.Resources,
+		VersionMap: make(map[string]map[string]string, len(s.VersionMap)),
+	}
+	for t, resources := range s.registered {
+		out.setResources(t, resources)
+	}
+	for t, versions := range s.VersionMap {
+		out.VersionMap[t] = versions
+	}
+	out.setResources(typ, Resources{Version: versionFromHashes(names, items, hashes), Items: items})
+	out.VersionMap[typeURL] = hashes
+	return out, nil
+}
+
 // ConstructVersionMap will construct a version map based on the current state of a snapshot
 func (s *Snapshot) ConstructVersionMap() error {
+	return s.constructVersionMap(nil)
+}
+
+func (s *Snapshot) constructVersionMap(m *MarshalCache) error {
 	if s == nil {
 		return fmt.Errorf("missing snapshot")
 	}
@@ -179,27 +307,23 @@ func (s *Snapshot) ConstructVersionMap() error {
 
 	s.VersionMap = make(map[string]map[string]string)
 
-	for i, resources := range s.Resources {
-		typeURL, err := GetResponseTypeURL(types.ResponseType(i))
-		if err != nil {
-			return err
-		}
+	for _, t := range registry.all() {
+		typeURL := t.TypeURL
 		if _, ok := s.VersionMap[typeURL]; !ok {
 			s.VersionMap[typeURL] = make(map[string]string)
 		}
 
-		for _, r := range resources.Items {
+		for _, r := range s.resources(t.responseType).Items {
 			// Hash our version in here and build the version map.
-			marshaledResource, err := MarshalResource(r.Resource)
+			_, v, err := m.marshalAndHashEntry(r)
 			if err != nil {
 				return err
 			}
-			v := HashResource(marshaledResource)
 			if v == "" {
 				return fmt.Errorf("failed to build resource version: %w", err)
 			}
 
-			s.VersionMap[typeURL][GetResourceName(r.Resource)] = v
+			s.VersionMap[typeURL][entryName(r)] = v
 		}
 	}
 
@@ -16,6 +16,7 @@ package cache_test
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -191,3 +192,54 @@ func TestNewSnapshotBadType(t *testing.T) {
 	assert.Error(t, err)
 	assert.Nil(t, snap)
 }
+
+func TestNewSnapshotWithContentVersions(t *testing.T) {
+	build := func(version string, endpoints ...types.Resource) *cache.Snapshot {
+		snap, err := cache.NewSnapshot(version, map[rsrc.Type][]types.Resource{
+			rsrc.EndpointType: endpoints,
+			rsrc.ClusterType:  {testCluster},
+		}, cache.WithContentVersions())
+		require.NoError(t, err)
+		return snap
+	}
+
+	other := resource.MakeEndpoint("other", 8080)
+	a := build("a", testEndpoint, other)
+	b := build("b", other, testEndpoint)
+	assert.NotEmpty(t, a.GetVersion(rsrc.EndpointType))
+	assert.NotEqual(t, "a", a.GetVersion(rsrc.EndpointType))
+	assert.Equal(t, a.GetVersion(rsrc.EndpointType), b.GetVersion(rsrc.EndpointType))
+	assert.Equal(t, a.GetVersion(rsrc.ClusterType), b.GetVersion(rsrc.ClusterType))
+	assert.NotEqual(t, a.GetVersion(rsrc.EndpointType), a.GetVersion(rsrc.ClusterType))
+
+	// Only the modified type changes version.
+	c := build("a", testEndpoint, resource.MakeEndpoint("other", 9090))
+	assert.NotEqual(t, a.GetVersion(rsrc.EndpointType), c.GetVersion(rsrc.EndpointType))
+	assert.Equal(t, a.GetVersion(rsrc.ClusterType), c.GetVersion(rsrc.ClusterType))
+
+	// The resource hashes are kept for delta xDS.
+	require.NoError(t, a.ConstructVersionMap())
+	fresh, err := cache.NewSnapshot("a", map[rsrc.Type][]types.Resource{
+		rsrc.EndpointType: {testEndpoint, other},
+		rsrc.ClusterType:  {testCluster},
+	})
+	require.NoError(t, err)
+	require.NoError(t, fresh.ConstructVersionMap())
+	assert.Equal(t, fresh.VersionMap, a.VersionMap)
+}
+
+func TestNewSnapshotWithTTLsContentVersions(t *testing.T) {
+	ttl := time.Second
+	longer := time.Minute
+	build := func(ttl *time.Duration) string {
+		snap, err := cache.NewSnapshotWithTTLs("", map[rsrc.Type][]types.ResourceWithTTL{
+			rsrc.EndpointType: {{Resource: testEndpoint, TTL: ttl}},
+		}, cache.WithContentVersions())
+		require.NoError(t, err)
+		return snap.GetVersion(rsrc.EndpointType)
+	}
+
+	assert.Equal(t, build(&ttl), build(&ttl))
+	assert.NotEqual(t, build(&ttl), build(&longer))
+	assert.NotEqual(t, build(&ttl), build(nil))
+}
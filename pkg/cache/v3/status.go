@@ -59,6 +59,18 @@ type StatusInfo interface {
 	GetLastDeltaWatchRequestTime() time.Time
 }
 
+// SuppressionStatusInfo is implemented by the StatusInfo of the snapshot
+// cache, which reports the pushes it suppressed:
+//
+//	info.(cache.SuppressionStatusInfo).GetNumSuppressedPushes()
+type SuppressionStatusInfo interface {
+	// GetNumSuppressedPushes returns the number of responses that were not
+	// sent because the resources were unchanged, see WithUnchangedPushSuppression.
+	GetNumSuppressedPushes() int64
+}
+
+var _ SuppressionStatusInfo = &statusInfo{}
+
 // statusInfo tracks the server state for the remote Envoy node.
 type statusInfo struct {
 	// node is the constant Envoy node metadata.
@@ -76,6 +88,16 @@ type statusInfo struct {
 	// the timestamp of the last delta watch request
 	lastDeltaWatchRequestTime time.Time
 
+	// the timestamp of the last change of the snapshot of the node, which
+	// responds to its open watches until the client requests again
+	lastUpdateTime time.Time
+
+	// the number of responses skipped since the resources were unchanged
+	suppressedPushes int64
+
+	// whether the node is served the fallback snapshot
+	fallback bool
+
 	// mutex to protect the status fields.
 	// should not acquire mutex of the parent cache after acquiring this mutex.
 	mu sync.RWMutex
@@ -142,6 +164,23 @@ func (info *statusInfo) GetLastDeltaWatchRequestTime() time.Time {
 	return info.lastDeltaWatchRequestTime
 }
 
+func (info *statusInfo) GetNumSuppressedPushes() int64 {
+	info.mu.RLock()
+	defer info.mu.RUnlock()
+	return info.suppressedPushes
+}
+
+// stale reports whether the node has no open watch, and has neither requested
+// resources nor had its snapshot changed since the deadline. A node whose
+// watches were all just responded has none open until its client ACKs.
+func (info *statusInfo) stale(deadline time.Time) bool {
+	info.mu.RLock()
+	defer info.mu.RUnlock()
+	return len(info.watches) == 0 && len(info.deltaWatches) == 0 &&
+		info.lastWatchRequestTime.Before(deadline) && info.lastDeltaWatchRequestTime.Before(deadline) &&
+		info.lastUpdateTime.Before(deadline)
+}
+
 // setLastDeltaWatchRequestTime will set the current time of the last delta discovery watch request.
 func (info *statusInfo) setLastDeltaWatchRequestTime(t time.Time) {
 	info.mu.Lock()
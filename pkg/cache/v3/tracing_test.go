@@ -0,0 +1,50 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package cache_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/server/stream/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/tracing/tracingtest"
+)
+
+func TestLinearCacheTracing(t *testing.T) {
+	provider := tracingtest.New()
+	c := cache.NewLinearCache(stringType, cache.WithLinearCacheTracing(provider))
+
+	value := make(chan cache.Response, 1)
+	c.CreateWatch(&discovery.DiscoveryRequest{TypeUrl: stringType, VersionInfo: "0"},
+		stream.NewStreamState(false, nil), value)
+	require.NoError(t, c.UpdateResource("a", wrapperspb.String("a")))
+
+	spans := provider.Ended()
+	require.Len(t, spans, 2)
+	respond, update := spans[0], spans[1]
+	assert.Equal(t, "RespondWatch", respond.Name())
+	assert.Equal(t, "UpdateResource", update.Name())
+	assert.Equal(t, update.SpanContext().SpanID(), respond.Parent().SpanID())
+
+	// The response carries the context of its span to the servers.
+	resp := <-value
+	assert.Equal(t, respond.SpanContext(), trace.SpanContextFromContext(resp.GetContext()))
+}
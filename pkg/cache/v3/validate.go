@@ -0,0 +1,283 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/known/anypb"
+
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+)
+
+// ResourceValidationError is a single protoc-gen-validate rule violation
+// found in a resource.
+type ResourceValidationError struct {
+	// TypeURL of the resource that failed validation.
+	TypeURL string
+
+	// Name of the resource that failed validation.
+	Name string
+
+	// Path to the Any-packed message that failed validation, relative to the
+	// resource, e.g. "filter_chains[0].filters[0].typed_config". It is empty
+	// when the violation is in the resource itself.
+	Path string
+
+	// Err is the violation reported by the generated validator.
+	Err error
+}
+
+// Error satisfies the error interface.
+func (e ResourceValidationError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("%s %q: %v", e.TypeURL, e.Name, e.Err)
+	}
+	return fmt.Sprintf("%s %q at %s: %v", e.TypeURL, e.Name, e.Path, e.Err)
+}
+
+// Unwrap returns the underlying violation.
+func (e ResourceValidationError) Unwrap() error {
+	return e.Err
+}
+
+// ValidationErrors aggregates all the violations found while validating one
+// or more resources.
+type ValidationErrors []ResourceValidationError
+
+// Error satisfies the error interface.
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// ValidateResource runs the protoc-gen-validate rules on a resource.
+// Messages packed in Any fields (typed configs) are unpacked and validated
+// recursively, as long as their type is linked into the binary; unknown types
+// are skipped. The returned error is nil or a ValidationErrors.
+func ValidateResource(typeURL string, res types.Resource) error {
+	errs := validateResource(typeURL, res)
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// ValidateSerializedResource runs ValidateResource on the message of a
+// serialized resource, decoding it with the type registered for its type URL.
+// Types not linked into the binary are skipped.
+func ValidateSerializedResource(res *types.SerializedResource) error {
+	errs := validateSerialized(res.TypeURL, res)
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// ValidateResources runs ValidateResource on every resource of the given type.
+func ValidateResources(typeURL string, resources map[string]types.Resource) error {
+	var errs ValidationErrors
+	for _, name := range sortedNames(resources) {
+		errs = append(errs, validateResource(typeURL, resources[name])...)
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// Validate runs the protoc-gen-validate rules on all resources in the snapshot.
+// See ValidateResource for details.
+func (s *Snapshot) Validate() error {
+	if s == nil {
+		return errors.New("nil snapshot")
+	}
+	return validateSnapshot(s)
+}
+
+// validateSnapshot validates the resources of every known type in a snapshot.
+func validateSnapshot(snapshot ResourceSnapshot) error {
+	var errs ValidationErrors
+	for _, typeURL := range RegisteredTypeURLs() {
+		resources := snapshot.GetResourcesAndTTL(typeURL)
+		names := make([]string, 0, len(resources))
+		for name := range resources {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			errs = append(errs, validateEntry(typeURL, resources[name])...)
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+func validateResource(typeURL resource.Type, res types.Resource) ValidationErrors {
+	if res == nil {
+		return nil
+	}
+
+	return validateMessage(typeURL, GetResourceName(res), res)
+}
+
+func validateMessage(typeURL resource.Type, name string, msg proto.Message) ValidationErrors {
+	v := &validator{typeURL: typeURL, name: name}
+	v.validate("", msg)
+	v.walk("", msg.ProtoReflect())
+	return v.errs
+}
+
+// validateSerialized validates the message of a serialized resource. Types not
+// linked into the binary are skipped, like the Any-packed messages.
+func validateSerialized(typeURL resource.Type, res *types.SerializedResource) ValidationErrors {
+	msg, err := res.Decode()
+	if errors.Is(err, protoregistry.NotFound) {
+		return nil
+	}
+	if err != nil {
+		return ValidationErrors{{TypeURL: typeURL, Name: res.Name, Err: err}}
+	}
+	return validateMessage(typeURL, res.Name, msg)
+}
+
+// validateEntry validates a resource, or the serialized resource held in its
+// place.
+func validateEntry(typeURL resource.Type, res types.ResourceWithTTL) ValidationErrors {
+	if res.Serialized != nil {
+		return validateSerialized(typeURL, res.Serialized)
+	}
+	return validateResource(typeURL, res.Resource)
+}
+
+// validator accumulates violations for a single resource.
+type validator struct {
+	typeURL string
+	name    string
+	errs    ValidationErrors
+}
+
+// validate runs the generated validator of a message, if it has one. Multi
+// errors are flattened so that each violation is reported separately.
+func (v *validator) validate(path string, msg proto.Message) {
+	val, ok := msg.(interface{ ValidateAll() error })
+	if !ok {
+		return
+	}
+	err := val.ValidateAll()
+	if err == nil {
+		return
+	}
+
+	var all []error
+	if multi, ok := err.(interface{ AllErrors() []error }); ok {
+		all = multi.AllErrors()
+	} else {
+		all = []error{err}
+	}
+	for _, e := range all {
+		v.errs = append(v.errs, ResourceValidationError{
+			TypeURL: v.typeURL,
+			Name:    v.name,
+			Path:    path,
+			Err:     e,
+		})
+	}
+}
+
+// walk visits all populated message fields looking for Any-packed messages.
+// The generated validators already recurse into regular embedded messages,
+// but they cannot see through an Any.
+func (v *validator) walk(path string, msg protoreflect.Message) {
+	msg.Range(func(fd protoreflect.FieldDescriptor, val protoreflect.Value) bool {
+		if fd.Kind() != protoreflect.MessageKind && fd.Kind() != protoreflect.GroupKind {
+			return true
+		}
+
+		fieldPath := string(fd.Name())
+		if path != "" {
+			fieldPath = path + "." + fieldPath
+		}
+
+		switch {
+		case fd.IsList():
+			list := val.List()
+			for i := 0; i < list.Len(); i++ {
+				v.visit(fmt.Sprintf("%s[%d]", fieldPath, i), list.Get(i).Message())
+			}
+		case fd.IsMap():
+			if fd.MapValue().Kind() != protoreflect.MessageKind {
+				return true
+			}
+			val.Map().Range(func(k protoreflect.MapKey, mv protoreflect.Value) bool {
+				v.visit(fmt.Sprintf("%s[%v]", fieldPath, k.Interface()), mv.Message())
+				return true
+			})
+		default:
+			v.visit(fieldPath, val.Message())
+		}
+		return true
+	})
+}
+
+// sortedNames returns the names of resources sorted, for the errors to be
+// reported in a stable order.
+func sortedNames(resources map[string]types.Resource) []string {
+	names := make([]string, 0, len(resources))
+	for name := range resources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// visit descends into a message, unpacking it first if it is an Any.
+func (v *validator) visit(path string, msg protoreflect.Message) {
+	packed, ok := msg.Interface().(*anypb.Any)
+	if !ok {
+		v.walk(path, msg)
+		return
+	}
+
+	inner, err := packed.UnmarshalNew()
+	if err != nil {
+		if errors.Is(err, protoregistry.NotFound) {
+			// The type is not linked into this binary, so there is nothing to validate it with.
+			return
+		}
+		v.errs = append(v.errs, ResourceValidationError{
+			TypeURL: v.typeURL,
+			Name:    v.name,
+			Path:    path,
+			Err:     err,
+		})
+		return
+	}
+
+	v.validate(path, inner)
+	v.walk(path, inner.ProtoReflect())
+}
@@ -0,0 +1,151 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package cache_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/anypb"
+
+	endpoint "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	listener "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	hcm "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	rsrc "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/test/resource/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/wellknown"
+)
+
+// makeInvalidHTTPListener returns a listener whose packed HTTP connection manager has no stat prefix.
+func makeInvalidHTTPListener(t *testing.T) *listener.Listener {
+	manager, err := anypb.New(&hcm.HttpConnectionManager{
+		RouteSpecifier: &hcm.HttpConnectionManager_RouteConfig{},
+	})
+	require.NoError(t, err)
+
+	l := resource.MakeRouteHTTPListener(resource.Ads, "invalid", 80, routeName)
+	l.FilterChains[0].Filters[0] = &listener.Filter{
+		Name:       wellknown.HTTPConnectionManager,
+		ConfigType: &listener.Filter_TypedConfig{TypedConfig: manager},
+	}
+	return l
+}
+
+// validSnapshot returns a snapshot that passes validation. The scoped route
+// listener of the fixture embeds an unnamed scope and does not.
+func validSnapshot(t *testing.T, version string) *cache.Snapshot {
+	snap, err := cache.NewSnapshot(version, map[rsrc.Type][]types.Resource{
+		rsrc.EndpointType: {testEndpoint},
+		rsrc.ClusterType:  {testCluster},
+		rsrc.RouteType:    {testRoute},
+		rsrc.ListenerType: {testListener},
+		rsrc.RuntimeType:  {testRuntime},
+		rsrc.SecretType:   {testSecret[0]},
+	})
+	require.NoError(t, err)
+	return snap
+}
+
+func TestValidateResource(t *testing.T) {
+	assert.NoError(t, cache.ValidateResource(rsrc.ListenerType, testListener))
+	assert.NoError(t, cache.ValidateResource(rsrc.ClusterType, testCluster))
+
+	err := cache.ValidateResource(rsrc.EndpointType, &endpoint.ClusterLoadAssignment{})
+	var errs cache.ValidationErrors
+	require.True(t, errors.As(err, &errs))
+	require.Len(t, errs, 1)
+	assert.Equal(t, rsrc.EndpointType, errs[0].TypeURL)
+	assert.Empty(t, errs[0].Path)
+}
+
+func TestValidateResourceUnpacksTypedConfig(t *testing.T) {
+	err := cache.ValidateResource(rsrc.ListenerType, makeInvalidHTTPListener(t))
+
+	var errs cache.ValidationErrors
+	require.True(t, errors.As(err, &errs))
+	require.Len(t, errs, 1)
+	assert.Equal(t, "invalid", errs[0].Name)
+	assert.Equal(t, "filter_chains[0].filters[0].typed_config", errs[0].Path)
+	assert.Contains(t, errs[0].Error(), "StatPrefix")
+}
+
+func TestValidateResourcesOrder(t *testing.T) {
+	invalid := func(name string) *endpoint.ClusterLoadAssignment {
+		return &endpoint.ClusterLoadAssignment{
+			ClusterName: name,
+			Endpoints:   []*endpoint.LocalityLbEndpoints{{Priority: 200}},
+		}
+	}
+	err := cache.ValidateResources(rsrc.EndpointType, map[string]types.Resource{
+		"c": invalid("c"),
+		"a": invalid("a"),
+		"b": invalid("b"),
+	})
+
+	// The errors are reported in the order of the names, whatever the order of the map.
+	var errs cache.ValidationErrors
+	require.True(t, errors.As(err, &errs))
+	require.Len(t, errs, 3)
+	for i, name := range []string{"a", "b", "c"} {
+		assert.Equal(t, name, errs[i].Name)
+	}
+}
+
+func TestSnapshotValidate(t *testing.T) {
+	assert.NoError(t, validSnapshot(t, fixture.version).Validate())
+
+	snap, err := cache.NewSnapshot(fixture.version, map[rsrc.Type][]types.Resource{
+		rsrc.EndpointType: {&endpoint.ClusterLoadAssignment{}},
+		rsrc.ListenerType: {makeInvalidHTTPListener(t)},
+	})
+	require.NoError(t, err)
+
+	var errs cache.ValidationErrors
+	require.True(t, errors.As(snap.Validate(), &errs))
+	assert.Len(t, errs, 2)
+}
+
+func TestSnapshotCacheWithValidation(t *testing.T) {
+	c := cache.NewSnapshotCache(false, group{}, logger{t: t}, cache.WithSnapshotValidation())
+	require.NoError(t, c.SetSnapshot(context.Background(), key, validSnapshot(t, fixture.version)))
+
+	snap, err := cache.NewSnapshot(fixture.version2, map[rsrc.Type][]types.Resource{
+		rsrc.ListenerType: {makeInvalidHTTPListener(t)},
+	})
+	require.NoError(t, err)
+	assert.Error(t, c.SetSnapshot(context.Background(), key, snap))
+
+	// The previous snapshot must still be served.
+	current, err := c.GetSnapshot(key)
+	require.NoError(t, err)
+	assert.Equal(t, fixture.version, current.GetVersion(rsrc.ListenerType))
+}
+
+func TestLinearCacheWithValidation(t *testing.T) {
+	c := cache.NewLinearCache(rsrc.EndpointType, cache.WithResourceValidation())
+
+	assert.NoError(t, c.UpdateResource(clusterName, testEndpoint))
+	assert.Error(t, c.UpdateResource("invalid", &endpoint.ClusterLoadAssignment{}))
+	assert.Error(t, c.UpdateResources(map[string]types.Resource{
+		"valid":   resource.MakeEndpoint("valid", 8080),
+		"invalid": &endpoint.ClusterLoadAssignment{},
+	}, nil))
+	assert.Equal(t, 1, c.NumResources())
+}
@@ -0,0 +1,74 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+// Package logr adapts a logr logger to the structured logger interface of
+// this library.
+package logr
+
+import (
+	"github.com/go-logr/logr"
+
+	"github.com/envoyproxy/go-control-plane/pkg/log"
+)
+
+// DebugLevel is the verbosity debugging messages are logged at. logr has no
+// warning level: warnings are informational messages with a "warning" field.
+const DebugLevel = 1
+
+// New returns a structured logger writing to logger.
+func New(logger logr.Logger) log.StructuredLogger {
+	return adapter{logger: logger}
+}
+
+type adapter struct {
+	logger logr.Logger
+}
+
+func (a adapter) Debug(msg string, fields ...log.Field) {
+	a.logger.V(DebugLevel).Info(msg, keysAndValues(fields)...)
+}
+
+func (a adapter) Info(msg string, fields ...log.Field) {
+	a.logger.Info(msg, keysAndValues(fields)...)
+}
+
+func (a adapter) Warn(msg string, fields ...log.Field) {
+	a.logger.Info(msg, append(keysAndValues(fields), "warning", true)...)
+}
+
+func (a adapter) Error(msg string, fields ...log.Field) {
+	// The error of the message, if any, is passed as such rather than as a field.
+	var err error
+	kvs := make([]interface{}, 0, 2*len(fields))
+	for _, field := range fields {
+		if e, ok := field.Value.(error); ok && field.Key == log.ErrorKey && err == nil {
+			err = e
+			continue
+		}
+		kvs = append(kvs, field.Key, field.Value)
+	}
+	a.logger.Error(err, msg, kvs...)
+}
+
+func (a adapter) With(fields ...log.Field) log.StructuredLogger {
+	return adapter{logger: a.logger.WithValues(keysAndValues(fields)...)}
+}
+
+func keysAndValues(fields []log.Field) []interface{} {
+	out := make([]interface{}, 0, 2*len(fields))
+	for _, field := range fields {
+		out = append(out, field.Key, field.Value)
+	}
+	return out
+}
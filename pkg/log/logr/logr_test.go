@@ -0,0 +1,46 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package logr_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/go-logr/logr/funcr"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/envoyproxy/go-control-plane/pkg/log"
+	loglogr "github.com/envoyproxy/go-control-plane/pkg/log/logr"
+)
+
+func TestLogger(t *testing.T) {
+	var lines []string
+	sink := funcr.New(func(prefix, args string) {
+		lines = append(lines, args)
+	}, funcr.Options{Verbosity: loglogr.DebugLevel})
+	logger := loglogr.New(sink).With(log.StreamID(1))
+
+	logger.Debug("open watch", log.Node("node"), log.TypeURL("type"))
+	logger.Info("info")
+	logger.Warn("warn")
+	logger.Error("error", log.Node("node"), log.Err(errors.New("oops")))
+
+	assert.Equal(t, []string{
+		`"level"=1 "msg"="open watch" "stream_id"=1 "node_id"="node" "type_url"="type"`,
+		`"level"=0 "msg"="info" "stream_id"=1`,
+		`"level"=0 "msg"="warn" "stream_id"=1 "warning"=true`,
+		`"msg"="error" "error"="oops" "stream_id"=1 "node_id"="node"`,
+	}, lines)
+}
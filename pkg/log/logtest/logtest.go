@@ -0,0 +1,113 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+// Package logtest provides a structured logger recording its messages, for
+// use in tests.
+package logtest
+
+import (
+	"sync"
+
+	"github.com/envoyproxy/go-control-plane/pkg/log"
+)
+
+// Level is the severity of a recorded message.
+type Level int
+
+const (
+	DebugLevel Level = iota
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+)
+
+// Entry is a recorded message.
+type Entry struct {
+	Level   Level
+	Message string
+
+	// Fields are the fields of the message, including those attached with
+	// With, keyed by name.
+	Fields map[string]interface{}
+}
+
+// Recorder is a structured logger recording all its messages.
+type Recorder struct {
+	entries *entries
+	fields  []log.Field
+}
+
+type entries struct {
+	mu   sync.Mutex
+	list []Entry
+}
+
+var _ log.StructuredLogger = &Recorder{}
+
+// New returns an empty recorder.
+func New() *Recorder {
+	return &Recorder{entries: &entries{}}
+}
+
+// Debug records a debugging message.
+func (r *Recorder) Debug(msg string, fields ...log.Field) { r.record(DebugLevel, msg, fields) }
+
+// Info records an informational message.
+func (r *Recorder) Info(msg string, fields ...log.Field) { r.record(InfoLevel, msg, fields) }
+
+// Warn records a warning message.
+func (r *Recorder) Warn(msg string, fields ...log.Field) { r.record(WarnLevel, msg, fields) }
+
+// Error records an error message.
+func (r *Recorder) Error(msg string, fields ...log.Field) { r.record(ErrorLevel, msg, fields) }
+
+// With returns a logger attaching fields to all its messages, recording to
+// the same entries as r.
+func (r *Recorder) With(fields ...log.Field) log.StructuredLogger {
+	return &Recorder{
+		entries: r.entries,
+		fields:  append(append([]log.Field(nil), r.fields...), fields...),
+	}
+}
+
+// All returns the recorded messages in order.
+func (r *Recorder) All() []Entry {
+	r.entries.mu.Lock()
+	defer r.entries.mu.Unlock()
+	return append([]Entry(nil), r.entries.list...)
+}
+
+// FilterMessage returns the recorded messages equal to msg in order.
+func (r *Recorder) FilterMessage(msg string) []Entry {
+	var out []Entry
+	for _, entry := range r.All() {
+		if entry.Message == msg {
+			out = append(out, entry)
+		}
+	}
+	return out
+}
+
+func (r *Recorder) record(level Level, msg string, fields []log.Field) {
+	entry := Entry{Level: level, Message: msg, Fields: map[string]interface{}{}}
+	for _, list := range [][]log.Field{r.fields, fields} {
+		for _, field := range list {
+			entry.Fields[field.Key] = field.Value
+		}
+	}
+
+	r.entries.mu.Lock()
+	defer r.entries.mu.Unlock()
+	r.entries.list = append(r.entries.list, entry)
+}
@@ -0,0 +1,16 @@
+module github.com/envoyproxy/go-control-plane/pkg/log/slog
+
+go 1.21
+
+replace github.com/envoyproxy/go-control-plane => ../../..
+
+require (
+	github.com/envoyproxy/go-control-plane v0.9.10-0.20210907150352-cf90f659a021
+	github.com/stretchr/testify v1.7.1
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b // indirect
+)
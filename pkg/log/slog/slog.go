@@ -0,0 +1,72 @@
+//go:build go1.21
+// +build go1.21
+
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+// Package slog adapts a log/slog logger to the structured logger interface of
+// this library.
+package slog
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/envoyproxy/go-control-plane/pkg/log"
+)
+
+// New returns a structured logger writing to logger, or to the default slog
+// logger if it is nil.
+func New(logger *slog.Logger) log.StructuredLogger {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return adapter{logger: logger}
+}
+
+type adapter struct {
+	logger *slog.Logger
+}
+
+func (a adapter) Debug(msg string, fields ...log.Field) {
+	a.log(slog.LevelDebug, msg, fields)
+}
+
+func (a adapter) Info(msg string, fields ...log.Field) {
+	a.log(slog.LevelInfo, msg, fields)
+}
+
+func (a adapter) Warn(msg string, fields ...log.Field) {
+	a.log(slog.LevelWarn, msg, fields)
+}
+
+func (a adapter) Error(msg string, fields ...log.Field) {
+	a.log(slog.LevelError, msg, fields)
+}
+
+func (a adapter) With(fields ...log.Field) log.StructuredLogger {
+	return adapter{logger: a.logger.With(attrs(fields)...)}
+}
+
+func (a adapter) log(level slog.Level, msg string, fields []log.Field) {
+	a.logger.Log(context.Background(), level, msg, attrs(fields)...)
+}
+
+func attrs(fields []log.Field) []any {
+	out := make([]any, 0, len(fields))
+	for _, field := range fields {
+		out = append(out, slog.Any(field.Key, field.Value))
+	}
+	return out
+}
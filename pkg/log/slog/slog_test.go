@@ -0,0 +1,55 @@
+//go:build go1.21
+// +build go1.21
+
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package slog_test
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/envoyproxy/go-control-plane/pkg/log"
+	logslog "github.com/envoyproxy/go-control-plane/pkg/log/slog"
+)
+
+func TestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	handler := slog.NewTextHandler(&buf, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	})
+	logger := logslog.New(slog.New(handler)).With(log.StreamID(1))
+
+	logger.Debug("open watch", log.Node("node"), log.TypeURL("type"))
+	logger.Info("info")
+	logger.Warn("warn")
+	logger.Error("error", log.Err(errors.New("oops")))
+
+	assert.Equal(t, `level=DEBUG msg="open watch" stream_id=1 node_id=node type_url=type
+level=INFO msg=info stream_id=1
+level=WARN msg=warn stream_id=1
+level=ERROR msg=error stream_id=1 error=oops
+`, buf.String())
+}
@@ -0,0 +1,185 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package log
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Keys of the fields attached by the caches and servers.
+const (
+	NodeKey      = "node_id"
+	StreamIDKey  = "stream_id"
+	TypeURLKey   = "type_url"
+	VersionKey   = "version"
+	NonceKey     = "nonce"
+	WatchIDKey   = "watch_id"
+	ResourcesKey = "resources"
+	ErrorKey     = "error"
+)
+
+// Field is a key-value pair attached to a structured message.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// Any returns a field with an arbitrary value.
+func Any(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+// Node returns the field of a node ID.
+func Node(id string) Field {
+	return Field{Key: NodeKey, Value: id}
+}
+
+// StreamID returns the field of a stream ID.
+func StreamID(id int64) Field {
+	return Field{Key: StreamIDKey, Value: id}
+}
+
+// TypeURL returns the field of a resource type URL.
+func TypeURL(typeURL string) Field {
+	return Field{Key: TypeURLKey, Value: typeURL}
+}
+
+// Version returns the field of a resource version.
+func Version(version string) Field {
+	return Field{Key: VersionKey, Value: version}
+}
+
+// Nonce returns the field of a response nonce.
+func Nonce(nonce string) Field {
+	return Field{Key: NonceKey, Value: nonce}
+}
+
+// WatchID returns the field of a cache watch ID.
+func WatchID(id int64) Field {
+	return Field{Key: WatchIDKey, Value: id}
+}
+
+// Resources returns the field of resource names.
+func Resources(names []string) Field {
+	return Field{Key: ResourcesKey, Value: names}
+}
+
+// Err returns the field of an error.
+func Err(err error) Field {
+	return Field{Key: ErrorKey, Value: err}
+}
+
+// StructuredLogger reports messages with key-value fields, so that they can
+// be filtered by node, stream, type or version. Adapters are provided for
+// log/slog, zap and logr in the nested modules pkg/log/slog, pkg/log/zap and
+// pkg/log/logr.
+type StructuredLogger interface {
+	// Debug logs a debugging message.
+	Debug(msg string, fields ...Field)
+
+	// Info logs an informational message.
+	Info(msg string, fields ...Field)
+
+	// Warn logs a warning message.
+	Warn(msg string, fields ...Field)
+
+	// Error logs an error message.
+	Error(msg string, fields ...Field)
+
+	// With returns a logger attaching fields to all its messages.
+	With(fields ...Field) StructuredLogger
+}
+
+// Discard is a structured logger dropping all messages.
+var Discard StructuredLogger = discard{}
+
+type discard struct{}
+
+func (discard) Debug(string, ...Field)           {}
+func (discard) Info(string, ...Field)            {}
+func (discard) Warn(string, ...Field)            {}
+func (discard) Error(string, ...Field)           {}
+func (d discard) With(...Field) StructuredLogger { return d }
+
+// OrDiscard returns logger, or Discard if it is nil.
+func OrDiscard(logger StructuredLogger) StructuredLogger {
+	if logger == nil {
+		return Discard
+	}
+	return logger
+}
+
+// FromLogger returns a structured logger formatting its messages for logger,
+// followed by their fields as key=value pairs. It returns Discard if logger
+// is nil.
+func FromLogger(logger Logger) StructuredLogger {
+	if logger == nil {
+		return Discard
+	}
+	return formatted{logger: logger}
+}
+
+type formatted struct {
+	logger Logger
+	fields []Field
+}
+
+func (f formatted) Debug(msg string, fields ...Field) {
+	f.logger.Debugf("%s", lazy{f, msg, fields})
+}
+
+func (f formatted) Info(msg string, fields ...Field) {
+	f.logger.Infof("%s", lazy{f, msg, fields})
+}
+
+func (f formatted) Warn(msg string, fields ...Field) {
+	f.logger.Warnf("%s", lazy{f, msg, fields})
+}
+
+func (f formatted) Error(msg string, fields ...Field) {
+	f.logger.Errorf("%s", lazy{f, msg, fields})
+}
+
+func (f formatted) With(fields ...Field) StructuredLogger {
+	return formatted{
+		logger: f.logger,
+		fields: append(append([]Field(nil), f.fields...), fields...),
+	}
+}
+
+// lazy formats a message and its fields only when the logger prints it, so
+// that the messages a logger discards are not formatted.
+type lazy struct {
+	f      formatted
+	msg    string
+	fields []Field
+}
+
+func (l lazy) String() string {
+	var b strings.Builder
+	b.WriteString(l.msg)
+	for _, list := range [][]Field{l.f.fields, l.fields} {
+		for _, field := range list {
+			fmt.Fprintf(&b, " %s=", field.Key)
+			if s, ok := field.Value.(string); ok {
+				fmt.Fprintf(&b, "%q", s)
+			} else {
+				fmt.Fprintf(&b, "%v", field.Value)
+			}
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,76 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package log
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFromLogger(t *testing.T) {
+	var debug, info, warn, errs []string
+	record := func(out *[]string) func(string, ...interface{}) {
+		return func(format string, args ...interface{}) {
+			*out = append(*out, fmt.Sprintf(format, args...))
+		}
+	}
+	logger := FromLogger(LoggerFuncs{
+		DebugFunc: record(&debug),
+		InfoFunc:  record(&info),
+		WarnFunc:  record(&warn),
+		ErrorFunc: record(&errs),
+	})
+
+	streamLogger := logger.With(StreamID(1), Node("node 1"))
+	streamLogger.Debug("open watch", TypeURL("type"), Version("v%d"))
+	streamLogger.Info("open stream")
+	logger.Warn("received NACK", Any("num", 2))
+	logger.Error("failed", Err(errors.New("oops")))
+
+	assert.Equal(t, []string{`open watch stream_id=1 node_id="node 1" type_url="type" version="v%d"`}, debug)
+	assert.Equal(t, []string{`open stream stream_id=1 node_id="node 1"`}, info)
+	assert.Equal(t, []string{`received NACK num=2`}, warn)
+	assert.Equal(t, []string{`failed error=oops`}, errs)
+}
+
+func TestFromLoggerLazy(t *testing.T) {
+	logger := FromLogger(LoggerFuncs{
+		DebugFunc: func(string, ...interface{}) {},
+	})
+
+	// The fields of discarded messages are not formatted.
+	logger.Debug("open watch", Any("value", stringer(func() string {
+		t.Error("formatted a discarded message")
+		return ""
+	})))
+}
+
+type stringer func() string
+
+func (s stringer) String() string {
+	return s()
+}
+
+func TestFromNilLogger(t *testing.T) {
+	logger := FromLogger(nil)
+	assert.Equal(t, Discard, logger)
+
+	// Just verifying that nothing panics.
+	logger.With(Node("node")).Debug("debug", TypeURL("type"))
+	assert.Equal(t, Discard, OrDiscard(nil))
+}
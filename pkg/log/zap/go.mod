@@ -0,0 +1,14 @@
+module github.com/envoyproxy/go-control-plane/pkg/log/zap
+
+go 1.16
+
+replace github.com/envoyproxy/go-control-plane => ../../..
+
+require (
+	github.com/envoyproxy/go-control-plane v0.9.10-0.20210907150352-cf90f659a021
+	github.com/kr/text v0.2.0 // indirect
+	github.com/stretchr/testify v1.7.1
+	go.uber.org/goleak v1.1.12 // indirect
+	go.uber.org/multierr v1.10.0 // indirect
+	go.uber.org/zap v1.21.0
+)
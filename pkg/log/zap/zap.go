@@ -0,0 +1,64 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+// Package zap adapts a zap logger to the structured logger interface of this
+// library.
+package zap
+
+import (
+	"go.uber.org/zap"
+
+	"github.com/envoyproxy/go-control-plane/pkg/log"
+)
+
+// New returns a structured logger writing to logger, or to the global zap
+// logger if it is nil.
+func New(logger *zap.Logger) log.StructuredLogger {
+	if logger == nil {
+		logger = zap.L()
+	}
+	return adapter{logger: logger}
+}
+
+type adapter struct {
+	logger *zap.Logger
+}
+
+func (a adapter) Debug(msg string, fields ...log.Field) {
+	a.logger.Debug(msg, zapFields(fields)...)
+}
+
+func (a adapter) Info(msg string, fields ...log.Field) {
+	a.logger.Info(msg, zapFields(fields)...)
+}
+
+func (a adapter) Warn(msg string, fields ...log.Field) {
+	a.logger.Warn(msg, zapFields(fields)...)
+}
+
+func (a adapter) Error(msg string, fields ...log.Field) {
+	a.logger.Error(msg, zapFields(fields)...)
+}
+
+func (a adapter) With(fields ...log.Field) log.StructuredLogger {
+	return adapter{logger: a.logger.With(zapFields(fields)...)}
+}
+
+func zapFields(fields []log.Field) []zap.Field {
+	out := make([]zap.Field, 0, len(fields))
+	for _, field := range fields {
+		out = append(out, zap.Any(field.Key, field.Value))
+	}
+	return out
+}
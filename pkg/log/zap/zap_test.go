@@ -0,0 +1,51 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package zap_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/envoyproxy/go-control-plane/pkg/log"
+	logzap "github.com/envoyproxy/go-control-plane/pkg/log/zap"
+)
+
+func TestLogger(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	logger := logzap.New(zap.New(core)).With(log.StreamID(1))
+
+	logger.Debug("open watch", log.Node("node"), log.TypeURL("type"))
+	logger.Info("info")
+	logger.Warn("warn")
+	logger.Error("error")
+
+	entries := logs.AllUntimed()
+	require.Len(t, entries, 4)
+	assert.Equal(t, zapcore.DebugLevel, entries[0].Level)
+	assert.Equal(t, "open watch", entries[0].Message)
+	assert.Equal(t, map[string]interface{}{
+		log.StreamIDKey: int64(1),
+		log.NodeKey:     "node",
+		log.TypeURLKey:  "type",
+	}, entries[0].ContextMap())
+	assert.Equal(t, zapcore.InfoLevel, entries[1].Level)
+	assert.Equal(t, zapcore.WarnLevel, entries[2].Level)
+	assert.Equal(t, zapcore.ErrorLevel, entries[3].Level)
+}
@@ -0,0 +1,95 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+// Package metrics provides a metrics interface for use in this library,
+// free of any dependency on a metrics system. See the nested module
+// pkg/metrics/prometheus for an implementation.
+package metrics
+
+// Metrics creates the instruments reporting the activity of caches and
+// servers. Instruments are identified by their name: asking twice for the
+// same name, e.g. from two caches, must return instruments reporting to the
+// same metric, with the same label names.
+type Metrics interface {
+	// Counter returns a counter, a value that only increases.
+	Counter(opts Opts) Counter
+
+	// Gauge returns a gauge, a value that goes up and down.
+	Gauge(opts Opts) Gauge
+
+	// Histogram returns a histogram, a distribution of observations.
+	Histogram(opts HistogramOpts) Histogram
+}
+
+// Opts describes an instrument.
+type Opts struct {
+	// Name of the metric, e.g. "xds_server_streams".
+	Name string
+
+	// Help describes the metric.
+	Help string
+
+	// Labels are the names of the labels of the metric. Their values are
+	// passed in the same order when recording.
+	Labels []string
+}
+
+// HistogramOpts describes a histogram.
+type HistogramOpts struct {
+	Opts
+
+	// Buckets are the upper bounds of the buckets of the histogram.
+	Buckets []float64
+}
+
+// Counter is a value that only increases.
+type Counter interface {
+	// Add adds a non-negative value to the counter.
+	Add(value float64, labelValues ...string)
+}
+
+// Gauge is a value that goes up and down.
+type Gauge interface {
+	// Add adds a value, possibly negative, to the gauge.
+	Add(value float64, labelValues ...string)
+
+	// Set sets the gauge to a value.
+	Set(value float64, labelValues ...string)
+}
+
+// Histogram is a distribution of observations.
+type Histogram interface {
+	// Observe adds an observation to the histogram.
+	Observe(value float64, labelValues ...string)
+}
+
+// Discard is a Metrics whose instruments record nothing.
+var Discard Metrics = discard{}
+
+type discard struct{}
+
+func (discard) Counter(Opts) Counter              { return discard{} }
+func (discard) Gauge(Opts) Gauge                  { return discard{} }
+func (discard) Histogram(HistogramOpts) Histogram { return discard{} }
+func (discard) Add(float64, ...string)            {}
+func (discard) Set(float64, ...string)            {}
+func (discard) Observe(float64, ...string)        {}
+
+// OrDiscard returns the metrics, or Discard if they are nil.
+func OrDiscard(m Metrics) Metrics {
+	if m == nil {
+		return Discard
+	}
+	return m
+}
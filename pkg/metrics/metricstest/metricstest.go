@@ -0,0 +1,89 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+// Package metricstest provides metrics recording their values in memory, for
+// use in tests.
+package metricstest
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/envoyproxy/go-control-plane/pkg/metrics"
+)
+
+// Recorder is a Metrics whose instruments record their values in memory.
+type Recorder struct {
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+var _ metrics.Metrics = &Recorder{}
+
+// New returns an empty recorder.
+func New() *Recorder {
+	return &Recorder{values: map[string]float64{}}
+}
+
+// Counter returns a counter recording to r.
+func (r *Recorder) Counter(opts metrics.Opts) metrics.Counter {
+	return instrument{recorder: r, name: opts.Name}
+}
+
+// Gauge returns a gauge recording to r.
+func (r *Recorder) Gauge(opts metrics.Opts) metrics.Gauge {
+	return instrument{recorder: r, name: opts.Name}
+}
+
+// Histogram returns a histogram recording its number of observations to r.
+func (r *Recorder) Histogram(opts metrics.HistogramOpts) metrics.Histogram {
+	return instrument{recorder: r, name: opts.Name}
+}
+
+// Values returns the recorded values keyed by metric name and label values,
+// e.g. "xds_server_streams{sotw}". Histograms report their number of
+// observations.
+func (r *Recorder) Values() map[string]float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make(map[string]float64, len(r.values))
+	for key, value := range r.values {
+		out[key] = value
+	}
+	return out
+}
+
+type instrument struct {
+	recorder *Recorder
+	name     string
+}
+
+func (i instrument) Add(value float64, labelValues ...string) {
+	i.update(labelValues, func(v float64) float64 { return v + value })
+}
+
+func (i instrument) Set(value float64, labelValues ...string) {
+	i.update(labelValues, func(float64) float64 { return value })
+}
+
+func (i instrument) Observe(_ float64, labelValues ...string) {
+	i.update(labelValues, func(v float64) float64 { return v + 1 })
+}
+
+func (i instrument) update(labelValues []string, f func(float64) float64) {
+	key := i.name + "{" + strings.Join(labelValues, ",") + "}"
+	i.recorder.mu.Lock()
+	defer i.recorder.mu.Unlock()
+	i.recorder.values[key] = f(i.recorder.values[key])
+}
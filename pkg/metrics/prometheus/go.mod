@@ -0,0 +1,11 @@
+module github.com/envoyproxy/go-control-plane/pkg/metrics/prometheus
+
+go 1.16
+
+replace github.com/envoyproxy/go-control-plane => ../../..
+
+require (
+	github.com/envoyproxy/go-control-plane v0.9.10-0.20210907150352-cf90f659a021
+	github.com/prometheus/client_golang v1.12.2
+	github.com/stretchr/testify v1.7.1
+)
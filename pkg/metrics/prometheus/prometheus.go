@@ -0,0 +1,115 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+// Package prometheus implements the metrics interface of this library with
+// the Prometheus client.
+package prometheus
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/envoyproxy/go-control-plane/pkg/metrics"
+)
+
+// New returns metrics registering their collectors with the registerer, or
+// the default registerer if it is nil.
+func New(registerer prometheus.Registerer) metrics.Metrics {
+	if registerer == nil {
+		registerer = prometheus.DefaultRegisterer
+	}
+	return &adapter{
+		registerer: registerer,
+		collectors: map[string]prometheus.Collector{},
+	}
+}
+
+type adapter struct {
+	registerer prometheus.Registerer
+
+	// collectors are the registered collectors indexed by metric name
+	collectors map[string]prometheus.Collector
+	mu         sync.Mutex
+}
+
+func (a *adapter) Counter(opts metrics.Opts) metrics.Counter {
+	vec := a.register(opts.Name, func() prometheus.Collector {
+		return prometheus.NewCounterVec(prometheus.CounterOpts{Name: opts.Name, Help: opts.Help}, opts.Labels)
+	}).(*prometheus.CounterVec)
+	return counter{vec}
+}
+
+func (a *adapter) Gauge(opts metrics.Opts) metrics.Gauge {
+	vec := a.register(opts.Name, func() prometheus.Collector {
+		return prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: opts.Name, Help: opts.Help}, opts.Labels)
+	}).(*prometheus.GaugeVec)
+	return gauge{vec}
+}
+
+func (a *adapter) Histogram(opts metrics.HistogramOpts) metrics.Histogram {
+	vec := a.register(opts.Name, func() prometheus.Collector {
+		return prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    opts.Name,
+			Help:    opts.Help,
+			Buckets: opts.Buckets,
+		}, opts.Labels)
+	}).(*prometheus.HistogramVec)
+	return histogram{vec}
+}
+
+// register returns the collector of a metric, creating and registering it
+// on first use. A collector registered beforehand by someone else under the
+// same name is reused.
+func (a *adapter) register(name string, create func() prometheus.Collector) prometheus.Collector {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if c, ok := a.collectors[name]; ok {
+		return c
+	}
+	c := create()
+	if err := a.registerer.Register(c); err != nil {
+		var registered prometheus.AlreadyRegisteredError
+		if !errors.As(err, &registered) {
+			panic(err)
+		}
+		c = registered.ExistingCollector
+	}
+	a.collectors[name] = c
+	return c
+}
+
+type counter struct{ vec *prometheus.CounterVec }
+
+func (c counter) Add(value float64, labelValues ...string) {
+	c.vec.WithLabelValues(labelValues...).Add(value)
+}
+
+type gauge struct{ vec *prometheus.GaugeVec }
+
+func (g gauge) Add(value float64, labelValues ...string) {
+	g.vec.WithLabelValues(labelValues...).Add(value)
+}
+
+func (g gauge) Set(value float64, labelValues ...string) {
+	g.vec.WithLabelValues(labelValues...).Set(value)
+}
+
+type histogram struct{ vec *prometheus.HistogramVec }
+
+func (h histogram) Observe(value float64, labelValues ...string) {
+	h.vec.WithLabelValues(labelValues...).Observe(value)
+}
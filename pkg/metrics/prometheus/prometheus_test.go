@@ -0,0 +1,93 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package prometheus_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/envoyproxy/go-control-plane/pkg/metrics"
+	adapter "github.com/envoyproxy/go-control-plane/pkg/metrics/prometheus"
+)
+
+func TestAdapter(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m := adapter.New(reg)
+
+	opts := metrics.Opts{Name: "requests_total", Help: "Requests.", Labels: []string{"type_url"}}
+	m.Counter(opts).Add(1, "a")
+	// Instruments of the same name report to the same metric.
+	m.Counter(opts).Add(2, "a")
+
+	gauge := m.Gauge(metrics.Opts{Name: "streams", Help: "Streams."})
+	gauge.Add(3)
+	gauge.Add(-1)
+
+	hist := m.Histogram(metrics.HistogramOpts{
+		Opts:    metrics.Opts{Name: "latency_seconds", Help: "Latency.", Labels: []string{"type_url"}},
+		Buckets: metrics.LatencyBuckets,
+	})
+	hist.Observe(0.1, "b")
+	hist.Observe(0.2, "b")
+
+	assert.Equal(t, map[string]float64{
+		"requests_total{a}":  3,
+		"streams{}":          2,
+		"latency_seconds{b}": 2,
+	}, gather(t, reg))
+}
+
+func TestAdapterSharedRegistry(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	opts := metrics.Opts{Name: "requests_total", Help: "Requests."}
+
+	// A second adapter on the same registry reuses the registered collectors.
+	adapter.New(reg).Counter(opts).Add(1)
+	adapter.New(reg).Counter(opts).Add(1)
+
+	assert.Equal(t, map[string]float64{"requests_total{}": 2}, gather(t, reg))
+}
+
+// gather returns the values of the metrics of a registry keyed by name and
+// label values, e.g. "xds_server_streams{sotw}". Histograms report their
+// number of observations.
+func gather(t *testing.T, reg *prometheus.Registry) map[string]float64 {
+	families, err := reg.Gather()
+	require.NoError(t, err)
+
+	out := map[string]float64{}
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			values := make([]string, 0, len(m.GetLabel()))
+			for _, label := range m.GetLabel() {
+				values = append(values, label.GetValue())
+			}
+			key := family.GetName() + "{" + strings.Join(values, ",") + "}"
+			switch {
+			case m.Counter != nil:
+				out[key] = m.GetCounter().GetValue()
+			case m.Gauge != nil:
+				out[key] = m.GetGauge().GetValue()
+			case m.Histogram != nil:
+				out[key] = float64(m.GetHistogram().GetSampleCount())
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,226 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package metrics
+
+import "time"
+
+// Label names of the metrics of caches and servers.
+const (
+	// LabelProtocol is the protocol of a server: ProtocolSOTW, ProtocolDelta or ProtocolREST.
+	LabelProtocol = "protocol"
+
+	// LabelTypeURL is the type URL of the resources.
+	LabelTypeURL = "type_url"
+)
+
+// Protocols served by the xDS servers.
+const (
+	ProtocolSOTW  = "sotw"
+	ProtocolDelta = "delta"
+	ProtocolREST  = "rest"
+)
+
+var (
+	// LatencyBuckets are the buckets of the histograms measuring durations, in seconds.
+	LatencyBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5}
+
+	// SizeBuckets are the buckets of the histograms measuring sizes, in bytes.
+	SizeBuckets = []float64{256, 1 << 10, 4 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20, 4 << 20, 16 << 20}
+)
+
+// ServerMetrics are the instruments of the xDS servers.
+type ServerMetrics struct {
+	// Streams is the number of open streams, by protocol.
+	Streams Gauge
+
+	// Requests counts the discovery requests received, by protocol and type URL.
+	Requests Counter
+
+	// Nacks counts the requests rejecting a response, by protocol and type URL.
+	Nacks Counter
+
+	// NoncesInFlight is the number of responses sent on streams and not yet
+	// acknowledged or rejected, by protocol.
+	NoncesInFlight Gauge
+
+	// PushLatency is the time to marshal and send a response, by protocol and type URL.
+	PushLatency Histogram
+
+	// ResponseSize is the size of the responses sent, by protocol and type URL.
+	ResponseSize Histogram
+}
+
+// NewServerMetrics creates the instruments of the xDS servers. Nil metrics
+// are discarded.
+func NewServerMetrics(m Metrics) *ServerMetrics {
+	m = OrDiscard(m)
+	return &ServerMetrics{
+		Streams: m.Gauge(Opts{
+			Name:   "xds_server_streams",
+			Help:   "Number of open xDS streams.",
+			Labels: []string{LabelProtocol},
+		}),
+		Requests: m.Counter(Opts{
+			Name:   "xds_server_requests_total",
+			Help:   "Number of discovery requests received.",
+			Labels: []string{LabelProtocol, LabelTypeURL},
+		}),
+		Nacks: m.Counter(Opts{
+			Name:   "xds_server_nacks_total",
+			Help:   "Number of discovery requests rejecting a response.",
+			Labels: []string{LabelProtocol, LabelTypeURL},
+		}),
+		NoncesInFlight: m.Gauge(Opts{
+			Name:   "xds_server_nonces_in_flight",
+			Help:   "Number of responses sent and not yet acknowledged or rejected.",
+			Labels: []string{LabelProtocol},
+		}),
+		PushLatency: m.Histogram(HistogramOpts{
+			Opts: Opts{
+				Name:   "xds_server_push_latency_seconds",
+				Help:   "Time to marshal and send a discovery response.",
+				Labels: []string{LabelProtocol, LabelTypeURL},
+			},
+			Buckets: LatencyBuckets,
+		}),
+		ResponseSize: m.Histogram(HistogramOpts{
+			Opts: Opts{
+				Name:   "xds_server_response_size_bytes",
+				Help:   "Size of the discovery responses sent.",
+				Labels: []string{LabelProtocol, LabelTypeURL},
+			},
+			Buckets: SizeBuckets,
+		}),
+	}
+}
+
+// Request records a discovery request. A request carrying an error detail
+// is counted as a NACK.
+func (m *ServerMetrics) Request(protocol, typeURL string, nack bool) {
+	m.Requests.Add(1, protocol, typeURL)
+	if nack {
+		m.Nacks.Add(1, protocol, typeURL)
+	}
+}
+
+// Response records a discovery response of the given size, sent in the time since start.
+func (m *ServerMetrics) Response(protocol, typeURL string, size int, start time.Time) {
+	m.PushLatency.Observe(time.Since(start).Seconds(), protocol, typeURL)
+	m.ResponseSize.Observe(float64(size), protocol, typeURL)
+}
+
+// OpenStream records a new stream and returns its metrics.
+func (m *ServerMetrics) OpenStream(protocol string) *StreamMetrics {
+	m.Streams.Add(1, protocol)
+	return &StreamMetrics{server: m, protocol: protocol, inFlight: map[string]string{}}
+}
+
+// StreamMetrics records the metrics of a stream. It keeps track of the
+// last nonce sent for each type, and is not safe for concurrent use.
+type StreamMetrics struct {
+	server   *ServerMetrics
+	protocol string
+
+	// inFlight are the nonces sent and not yet acknowledged or rejected, by type URL
+	inFlight map[string]string
+}
+
+// Request records a request on the stream. The nonce of the last response
+// of the type is no longer in flight if the request carries it.
+func (s *StreamMetrics) Request(typeURL, nonce string, nack bool) {
+	s.server.Request(s.protocol, typeURL, nack)
+	if last, ok := s.inFlight[typeURL]; ok && nonce == last {
+		delete(s.inFlight, typeURL)
+		s.server.NoncesInFlight.Add(-1, s.protocol)
+	}
+}
+
+// Response records a response sent on the stream with the given nonce.
+func (s *StreamMetrics) Response(typeURL, nonce string, size int, start time.Time) {
+	s.server.Response(s.protocol, typeURL, size, start)
+	if _, ok := s.inFlight[typeURL]; !ok {
+		s.server.NoncesInFlight.Add(1, s.protocol)
+	}
+	s.inFlight[typeURL] = nonce
+}
+
+// Close records the end of the stream.
+func (s *StreamMetrics) Close() {
+	s.server.Streams.Add(-1, s.protocol)
+	if len(s.inFlight) > 0 {
+		s.server.NoncesInFlight.Add(-float64(len(s.inFlight)), s.protocol)
+	}
+}
+
+// CacheMetrics are the instruments of the caches.
+type CacheMetrics struct {
+	// Watches is the number of open watches, by type URL.
+	Watches Gauge
+
+	// Resources is the number of resources of linear caches, by type URL.
+	Resources Gauge
+
+	// SetSnapshotDuration is the time SetSnapshot takes, including the
+	// responses to the open watches.
+	SetSnapshotDuration Histogram
+
+	// SuppressedPushes counts the responses skipped because the resources
+	// of their type did not change, by type URL.
+	SuppressedPushes Counter
+
+	// NodeCollections counts the runs of the stale node collection.
+	NodeCollections Counter
+
+	// EvictedNodes counts the nodes evicted by the stale node collection.
+	EvictedNodes Counter
+}
+
+// NewCacheMetrics creates the instruments of the caches. Nil metrics are
+// discarded.
+func NewCacheMetrics(m Metrics) *CacheMetrics {
+	m = OrDiscard(m)
+	return &CacheMetrics{
+		Watches: m.Gauge(Opts{
+			Name:   "xds_cache_watches",
+			Help:   "Number of open watches.",
+			Labels: []string{LabelTypeURL},
+		}),
+		Resources: m.Gauge(Opts{
+			Name:   "xds_cache_resources",
+			Help:   "Number of resources of linear caches.",
+			Labels: []string{LabelTypeURL},
+		}),
+		SetSnapshotDuration: m.Histogram(HistogramOpts{
+			Opts: Opts{
+				Name: "xds_cache_set_snapshot_duration_seconds",
+				Help: "Time to set a snapshot and respond to the open watches.",
+			},
+			Buckets: LatencyBuckets,
+		}),
+		SuppressedPushes: m.Counter(Opts{
+			Name:   "xds_cache_suppressed_pushes_total",
+			Help:   "Number of responses skipped because the resources of their type did not change.",
+			Labels: []string{LabelTypeURL},
+		}),
+		NodeCollections: m.Counter(Opts{
+			Name: "xds_cache_node_collections_total",
+			Help: "Number of runs of the stale node collection.",
+		}),
+		EvictedNodes: m.Counter(Opts{
+			Name: "xds_cache_evicted_nodes_total",
+			Help: "Number of nodes evicted by the stale node collection.",
+		}),
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+// Package rollout stages a snapshot progressively across the nodes of a
+// SnapshotCache: a share of the nodes first, in waves, halting as soon as too
+// many of them reject it, until it is promoted to all of them or aborted.
+package rollout
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+)
+
+var (
+	// ErrInProgress is returned when starting a rollout while another one
+	// is running or halted.
+	ErrInProgress = errors.New("a rollout is in progress")
+
+	// ErrNoNodes is returned when starting a rollout that targets no node.
+	ErrNoNodes = errors.New("no node to roll out to")
+
+	// ErrUnsupportedCache is returned when starting a rollout on a cache
+	// that does not manage the snapshots set for the nodes themselves or
+	// track their convergence, see cache.NodeSnapshotCache and
+	// cache.ConvergenceTracker.
+	ErrUnsupportedCache = errors.New("the cache does not support rollouts")
+)
+
+// rolloutCache is the cache a rollout stages its snapshot with, as
+// implemented by the snapshot cache.
+type rolloutCache interface {
+	cache.SnapshotCache
+	cache.NodeSnapshotCache
+	cache.ConvergenceTracker
+}
+
+// Controller runs the rollouts of a SnapshotCache, one at a time. It learns
+// which nodes applied or rejected a snapshot from the Convergence of the
+// cache, which is told of the ACKs and NACKs by the servers serving it.
+type Controller struct {
+	cache cache.SnapshotCache
+
+	mu      sync.Mutex
+	current *Rollout
+}
+
+// NewController returns the rollout controller of a cache, which must
+// implement cache.NodeSnapshotCache and cache.ConvergenceTracker as the
+// snapshot cache does.
+func NewController(c cache.SnapshotCache) *Controller {
+	return &Controller{
+		cache: c,
+	}
+}
+
+// Start starts rolling a snapshot out and stages its first wave, with ctx.
+// The following waves are staged in the background as the nodes of the
+// previous one ACK the snapshot. Without options, the snapshot is staged to
+// all the nodes of the cache at once.
+func (c *Controller) Start(ctx context.Context, snapshot cache.ResourceSnapshot, opts ...Option) (*Rollout, error) {
+	rc, ok := c.cache.(rolloutCache)
+	if !ok {
+		return nil, ErrUnsupportedCache
+	}
+	o := options{percentage: 100, waveTimeout: DefaultWaveTimeout}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	c.mu.Lock()
+	if c.current != nil && c.current.active() {
+		c.mu.Unlock()
+		return nil, ErrInProgress
+	}
+	nodes := o.nodes
+	if nodes == nil {
+		nodes = c.cache.GetStatusKeys()
+	}
+	waves := plan(o.targets(nodes), o.waves)
+	if len(waves) == 0 {
+		c.mu.Unlock()
+		return nil, ErrNoNodes
+	}
+	r := newRollout(rc, snapshot, waves, o)
+	c.current = r
+	c.mu.Unlock()
+
+	if err := r.stage(ctx, 1); err != nil {
+		return r, err
+	}
+	return r, nil
+}
+
+// Current returns the last rollout started, or nil.
+func (c *Controller) Current() *Rollout {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.current
+}
@@ -0,0 +1,154 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package rollout
+
+import (
+	"hash/fnv"
+	"math"
+	"sort"
+	"time"
+)
+
+// DefaultWaveTimeout is how long the nodes of a wave have to ACK or NACK the
+// snapshot by default, see WithWaveTimeout.
+const DefaultWaveTimeout = 10 * time.Minute
+
+// Option configures a rollout.
+type Option func(*options)
+
+type options struct {
+	nodes         []string
+	selector      func(node string) bool
+	percentage    float64
+	waves         []float64
+	bakeTime      time.Duration
+	waveTimeout   time.Duration
+	nackThreshold float64
+}
+
+// WithNodes sets the nodes the rollout may target, instead of the nodes
+// known to the cache.
+func WithNodes(nodes ...string) Option {
+	return func(o *options) {
+		o.nodes = append([]string{}, nodes...)
+	}
+}
+
+// WithSelector restricts the rollout to the nodes for which selector returns
+// true.
+func WithSelector(selector func(node string) bool) Option {
+	return func(o *options) {
+		o.selector = selector
+	}
+}
+
+// WithPercentage restricts the rollout to a percentage of the selected
+// nodes, at least one. The nodes are chosen by a hash of their ID, so that a
+// larger percentage targets the nodes of a smaller one and more.
+func WithPercentage(percentage float64) Option {
+	return func(o *options) {
+		o.percentage = percentage
+	}
+}
+
+// WithWaves stages the snapshot in waves, given by the cumulative
+// percentage of the targeted nodes they reach, e.g. 5, 25, 100. A last wave
+// is added for the remaining nodes if the last percentage is below 100.
+func WithWaves(percentages ...float64) Option {
+	return func(o *options) {
+		o.waves = append([]float64{}, percentages...)
+	}
+}
+
+// WithBakeTime sets how long to wait once all the nodes of a wave have ACKed
+// the snapshot before staging the next one.
+func WithBakeTime(d time.Duration) Option {
+	return func(o *options) {
+		o.bakeTime = d
+	}
+}
+
+// WithWaveTimeout halts the rollout when the nodes of a wave have not all
+// ACKed or NACKed the snapshot within d of its staging, DefaultWaveTimeout by
+// default. A zero or negative d disables the timeout.
+func WithWaveTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.waveTimeout = d
+	}
+}
+
+// WithNackThreshold sets the ratio of the staged nodes that may NACK the
+// snapshot, between 0 and 1, above which the rollout halts. By default, the
+// rollout halts on the first NACK.
+func WithNackThreshold(ratio float64) Option {
+	return func(o *options) {
+		o.nackThreshold = ratio
+	}
+}
+
+// targets returns the nodes selected for the rollout, in the order of their
+// hash.
+func (o options) targets(nodes []string) []string {
+	selected := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		if o.selector == nil || o.selector(node) {
+			selected = append(selected, node)
+		}
+	}
+	sort.Slice(selected, func(i, j int) bool {
+		hi, hj := hash(selected[i]), hash(selected[j])
+		if hi != hj {
+			return hi < hj
+		}
+		return selected[i] < selected[j]
+	})
+	return selected[:share(len(selected), o.percentage)]
+}
+
+// plan splits the targets in waves reaching the cumulative percentages.
+// Waves adding no node are dropped.
+func plan(targets []string, percentages []float64) [][]string {
+	if len(percentages) == 0 || percentages[len(percentages)-1] < 100 {
+		percentages = append(percentages, 100)
+	}
+	var waves [][]string
+	staged := 0
+	for _, percentage := range percentages {
+		n := share(len(targets), percentage)
+		if n > staged {
+			waves = append(waves, targets[staged:n])
+			staged = n
+		}
+	}
+	return waves
+}
+
+// share returns the number of nodes making a percentage of n, at least one
+// for a positive percentage.
+func share(n int, percentage float64) int {
+	if percentage <= 0 || n == 0 {
+		return 0
+	}
+	if percentage >= 100 {
+		return n
+	}
+	return int(math.Max(1, math.Ceil(float64(n)*percentage/100)))
+}
+
+func hash(node string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(node))
+	return h.Sum64()
+}
@@ -0,0 +1,454 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package rollout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+)
+
+// ErrFinished is returned when promoting or aborting a rollout that is
+// completed or aborted.
+var ErrFinished = errors.New("the rollout is finished")
+
+// State is the state of a rollout.
+type State int
+
+const (
+	// Running rollouts stage their waves as the nodes ACK the snapshot.
+	Running State = iota
+	// Halted rollouts stage no more waves, until promoted or aborted.
+	Halted
+	// Completed rollouts were staged to all their nodes, which ACKed or
+	// NACKed the snapshot.
+	Completed
+	// Aborted rollouts restored the previous snapshots of their nodes.
+	Aborted
+)
+
+func (s State) String() string {
+	switch s {
+	case Running:
+		return "running"
+	case Halted:
+		return "halted"
+	case Completed:
+		return "completed"
+	case Aborted:
+		return "aborted"
+	default:
+		return fmt.Sprintf("State(%d)", int(s))
+	}
+}
+
+// NodeState is the state of a node in a rollout.
+type NodeState int
+
+const (
+	// NodePending nodes are not staged yet.
+	NodePending NodeState = iota
+	// NodeStaged nodes were set the snapshot, and have not applied it yet.
+	NodeStaged
+	// NodeAcked nodes applied the snapshot, see cache.Convergence, or have
+	// no stream left to apply it: they are served it when they reconnect.
+	NodeAcked
+	// NodeNacked nodes NACKed a type of the snapshot.
+	NodeNacked
+)
+
+func (s NodeState) String() string {
+	switch s {
+	case NodePending:
+		return "pending"
+	case NodeStaged:
+		return "staged"
+	case NodeAcked:
+		return "acked"
+	case NodeNacked:
+		return "nacked"
+	default:
+		return fmt.Sprintf("NodeState(%d)", int(s))
+	}
+}
+
+// NodeStatus is the status of a node in a rollout.
+type NodeStatus struct {
+	State NodeState
+
+	// Wave is the wave the node is staged in, from 1.
+	Wave int
+
+	// Error is the error the node reported when NACKing the snapshot.
+	Error string
+}
+
+// Status is the status of a rollout.
+type Status struct {
+	State State
+
+	// Reason is why the rollout halted.
+	Reason string
+
+	// Wave is the number of waves staged so far, out of Waves.
+	Wave  int
+	Waves int
+
+	// Nodes are the status of the nodes targeted by the rollout.
+	Nodes map[string]NodeStatus
+}
+
+// NodesIn returns the nodes in a state, sorted.
+func (s Status) NodesIn(state NodeState) []string {
+	var out []string
+	for node, status := range s.Nodes {
+		if status.State == state {
+			out = append(out, node)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Rollout is a snapshot being staged across nodes, see Controller.Start.
+//
+// A node has applied the snapshot once its streams have all ACKed it, as
+// reported by the Convergence of the cache, and NACKed it once they have all
+// ACKed or NACKed it with some NACKs. The next wave is staged once every node
+// of the staged waves has ACKed or NACKed the snapshot, and the rollout halts
+// when the NACK threshold is exceeded or a wave times out.
+type Rollout struct {
+	cache    rolloutCache
+	snapshot cache.ResourceSnapshot
+	waves    [][]string
+	opts     options
+
+	// ctx ends the waits for the convergence of the nodes once the rollout
+	// is finished.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// staging serializes the staging of the waves and Abort, so that the
+	// nodes are not set the snapshot after their previous one is restored.
+	staging sync.Mutex
+
+	mu       sync.Mutex
+	state    State
+	reason   string
+	wave     int
+	promoted bool
+	nodes    map[string]*node
+	timer    *time.Timer
+}
+
+// node is the state of a node in a rollout.
+type node struct {
+	status NodeStatus
+
+	// previous is the snapshot set for the node itself before it was
+	// staged, nil if it was served the snapshot of its group or none.
+	previous cache.ResourceSnapshot
+}
+
+func newRollout(c rolloutCache, snapshot cache.ResourceSnapshot, waves [][]string, opts options) *Rollout {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &Rollout{
+		cache:    c,
+		snapshot: snapshot,
+		waves:    waves,
+		opts:     opts,
+		ctx:      ctx,
+		cancel:   cancel,
+		nodes:    make(map[string]*node),
+	}
+	for i, wave := range waves {
+		for _, id := range wave {
+			r.nodes[id] = &node{status: NodeStatus{Wave: i + 1}}
+		}
+	}
+	return r
+}
+
+// Status returns the status of the rollout.
+func (r *Rollout) Status() Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	status := Status{
+		State:  r.state,
+		Reason: r.reason,
+		Wave:   r.wave,
+		Waves:  len(r.waves),
+		Nodes:  make(map[string]NodeStatus, len(r.nodes)),
+	}
+	for id, n := range r.nodes {
+		status.Nodes[id] = n.status
+	}
+	return status
+}
+
+// Promote stages the snapshot to all the remaining nodes at once, and
+// resumes a halted rollout. The NACKs of the nodes no longer halt it.
+func (r *Rollout) Promote(ctx context.Context) error {
+	r.mu.Lock()
+	if r.state == Completed || r.state == Aborted {
+		r.mu.Unlock()
+		return ErrFinished
+	}
+	r.state = Running
+	r.reason = ""
+	r.promoted = true
+	r.mu.Unlock()
+
+	err := r.stage(ctx, len(r.waves))
+
+	r.mu.Lock()
+	r.progress()
+	r.mu.Unlock()
+	return err
+}
+
+// Abort stops the rollout and restores the snapshots set for the staged nodes
+// before. The nodes which had none are served again the snapshot of their
+// group, if any: the snapshot set by the rollout is deleted, leaving their
+// streams open. A wave being staged is restored once it is.
+func (r *Rollout) Abort(ctx context.Context) error {
+	r.staging.Lock()
+	defer r.staging.Unlock()
+
+	r.mu.Lock()
+	if r.state == Completed || r.state == Aborted {
+		r.mu.Unlock()
+		return ErrFinished
+	}
+	r.state = Aborted
+	r.stopTimer()
+	r.cancel()
+	type restore struct {
+		id       string
+		previous cache.ResourceSnapshot
+	}
+	var restores []restore
+	for _, wave := range r.waves[:r.wave] {
+		for _, id := range wave {
+			restores = append(restores, restore{id: id, previous: r.nodes[id].previous})
+		}
+	}
+	r.mu.Unlock()
+
+	var err error
+	for _, restore := range restores {
+		var restoreErr error
+		if restore.previous == nil {
+			restoreErr = r.cache.DeleteSnapshot(ctx, restore.id)
+		} else {
+			restoreErr = r.cache.SetSnapshot(ctx, restore.id, restore.previous)
+		}
+		if restoreErr != nil && err == nil {
+			err = fmt.Errorf("failed to restore the snapshot of node %q: %w", restore.id, restoreErr)
+		}
+	}
+	return err
+}
+
+func (r *Rollout) active() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.state == Running || r.state == Halted
+}
+
+// stage sets the snapshot of the nodes of the waves up to the given one,
+// from 1, if the rollout is running.
+func (r *Rollout) stage(ctx context.Context, upTo int) error {
+	r.staging.Lock()
+	defer r.staging.Unlock()
+
+	r.mu.Lock()
+	if r.state != Running || upTo <= r.wave {
+		r.mu.Unlock()
+		return nil
+	}
+	var staged []string
+	for ; r.wave < upTo; r.wave++ {
+		for _, id := range r.waves[r.wave] {
+			n := r.nodes[id]
+			n.previous, _ = r.cache.GetNodeSnapshot(id)
+			n.status.State = NodeStaged
+			staged = append(staged, id)
+		}
+	}
+	r.stopTimer()
+	if r.opts.waveTimeout > 0 {
+		wave := r.wave
+		r.timer = time.AfterFunc(r.opts.waveTimeout, func() {
+			r.timeout(wave)
+		})
+	}
+	r.mu.Unlock()
+
+	for _, id := range staged {
+		if err := r.cache.SetSnapshot(ctx, id, r.snapshot); err != nil {
+			err = fmt.Errorf("failed to set the snapshot of node %q: %w", id, err)
+			r.mu.Lock()
+			r.halt(err.Error())
+			r.mu.Unlock()
+			return err
+		}
+		go r.wait(id, r.cache.Convergence(id))
+	}
+	return nil
+}
+
+// wait waits for a staged node to apply the snapshot, and records the
+// outcome.
+func (r *Rollout) wait(id string, convergence *cache.Convergence) {
+	err := convergence.Wait(r.ctx)
+	var convergenceErr *cache.ConvergenceError
+	switch {
+	case err == nil:
+		r.acked(id)
+	case !errors.As(err, &convergenceErr) || convergenceErr.Unwrap() != nil:
+		// The rollout is finished.
+	case len(convergenceErr.Report.Nackers) > 0:
+		r.nacked(id, nackMessage(convergenceErr.Report.Nackers))
+	default:
+		// The streams that did not converge were closed, and the node is
+		// served the snapshot when it reconnects.
+		r.acked(id)
+	}
+}
+
+// nackMessage returns the error reported by the first stream and type
+// NACKing the snapshot.
+func nackMessage(nackers []cache.StreamConvergence) string {
+	nacks := nackers[0].Nacks
+	typeURLs := make([]string, 0, len(nacks))
+	for typeURL := range nacks {
+		typeURLs = append(typeURLs, typeURL)
+	}
+	sort.Strings(typeURLs)
+	return nacks[typeURLs[0]].GetMessage()
+}
+
+// acked records that a node applied the snapshot.
+func (r *Rollout) acked(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := r.nodes[id]
+	if n == nil || n.status.State != NodeStaged {
+		return
+	}
+	n.status.State = NodeAcked
+	r.progress()
+}
+
+// nacked records a NACK of a node, and halts the rollout once the share of
+// the staged nodes that NACKed exceeds the threshold.
+func (r *Rollout) nacked(id, message string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := r.nodes[id]
+	if n == nil || n.status.State != NodeStaged {
+		return
+	}
+	n.status.State = NodeNacked
+	n.status.Error = message
+
+	var staged, nacked int
+	for _, n := range r.nodes {
+		switch n.status.State {
+		case NodePending:
+		case NodeNacked:
+			nacked++
+			staged++
+		default:
+			staged++
+		}
+	}
+	if !r.promoted && float64(nacked)/float64(staged) > r.opts.nackThreshold {
+		r.halt(fmt.Sprintf("%d of %d staged nodes NACKed the snapshot", nacked, staged))
+		return
+	}
+	r.progress()
+}
+
+// timeout halts the rollout if the nodes of a wave have not all ACKed or
+// NACKed the snapshot.
+func (r *Rollout) timeout(wave int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.state != Running || r.wave != wave {
+		return
+	}
+	if pending := r.staged(); pending > 0 {
+		r.halt(fmt.Sprintf("wave %d timed out with %d nodes yet to ACK the snapshot", wave, pending))
+	}
+}
+
+// progress completes the rollout, or schedules the staging of the next wave,
+// once the staged nodes have all ACKed or NACKed the snapshot. It must be
+// called with the lock held.
+func (r *Rollout) progress() {
+	if r.state != Running || r.staged() > 0 {
+		return
+	}
+	r.stopTimer()
+	if r.wave == len(r.waves) {
+		r.state = Completed
+		r.cancel()
+		return
+	}
+	next := r.wave + 1
+	r.timer = time.AfterFunc(r.opts.bakeTime, func() {
+		_ = r.stage(context.Background(), next)
+	})
+}
+
+// staged returns the number of nodes that have not ACKed or NACKed the
+// snapshot yet. It must be called with the lock held.
+func (r *Rollout) staged() int {
+	count := 0
+	for _, n := range r.nodes {
+		if n.status.State == NodeStaged {
+			count++
+		}
+	}
+	return count
+}
+
+// halt halts a running rollout. It must be called with the lock held.
+func (r *Rollout) halt(reason string) {
+	if r.state != Running {
+		return
+	}
+	r.state = Halted
+	r.reason = reason
+	r.stopTimer()
+}
+
+func (r *Rollout) stopTimer() {
+	if r.timer != nil {
+		r.timer.Stop()
+		r.timer = nil
+	}
+}
@@ -0,0 +1,377 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package rollout_test
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	statuspb "google.golang.org/genproto/googleapis/rpc/status"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	rsrc "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/rollout/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/server/events/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/server/stream/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/test/resource/v3"
+)
+
+func makeSnapshot(t *testing.T, version string) *cache.Snapshot {
+	snap, err := cache.NewSnapshot(version, map[rsrc.Type][]types.Resource{
+		rsrc.ClusterType: {resource.MakeCluster(resource.Ads, "cluster-"+version)},
+	})
+	require.NoError(t, err)
+	return snap
+}
+
+// setup returns a controller of a cache holding snapshot "1" for n nodes,
+// and the observer of a server to which each node has a stream that ACKed
+// it.
+func setup(t *testing.T, n int) (*rollout.Controller, cache.SnapshotCache, events.Observer, []string) {
+	c := cache.NewSnapshotCache(false, cache.IDHash{}, nil)
+	observer := c.(events.ObserverFactory).NewObserver()
+	var nodes []string
+	for i := 0; i < n; i++ {
+		node := fmt.Sprintf("node-%d", i)
+		require.NoError(t, c.SetSnapshot(context.Background(), node, makeSnapshot(t, "1")))
+		observer.StreamOpened(context.Background(), eventStream(node))
+		observer.Requested(context.Background(), eventStream(node), rsrc.ClusterType, nil)
+		observer.Acked(context.Background(), eventStream(node), rsrc.ClusterType, "1")
+		nodes = append(nodes, node)
+	}
+	return rollout.NewController(c), c, observer, nodes
+}
+
+func eventStream(node string) events.Stream {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(node))
+	return events.Stream{ID: int64(h.Sum32()), Node: &core.Node{Id: node}, TypeURL: rsrc.ClusterType}
+}
+
+// apply reports the ACK of the clusters of a version by nodes.
+func apply(observer events.Observer, version string, nodes ...string) {
+	for _, node := range nodes {
+		observer.Acked(context.Background(), eventStream(node), rsrc.ClusterType, version)
+	}
+}
+
+// reject reports the NACK of the clusters of a version by a node.
+func reject(observer events.Observer, version, node string) {
+	observer.Nacked(context.Background(), eventStream(node), rsrc.ClusterType, version, &statuspb.Status{Message: "invalid cluster"})
+}
+
+func versions(t *testing.T, c cache.SnapshotCache, nodes []string) map[string]string {
+	out := make(map[string]string)
+	for _, node := range nodes {
+		snap, err := c.GetSnapshot(node)
+		require.NoError(t, err)
+		out[node] = snap.GetVersion(rsrc.ClusterType)
+	}
+	return out
+}
+
+func TestRolloutWaves(t *testing.T) {
+	ctrl, c, observer, nodes := setup(t, 10)
+	r, err := ctrl.Start(context.Background(), makeSnapshot(t, "2"), rollout.WithNodes(nodes...), rollout.WithWaves(20, 50))
+	require.NoError(t, err)
+	assert.Same(t, r, ctrl.Current())
+
+	status := r.Status()
+	assert.Equal(t, rollout.Running, status.State)
+	assert.Equal(t, 1, status.Wave)
+	assert.Equal(t, 3, status.Waves)
+	first := status.NodesIn(rollout.NodeStaged)
+	require.Len(t, first, 2)
+	assert.Len(t, status.NodesIn(rollout.NodePending), 8)
+	for node, version := range versions(t, c, nodes) {
+		if status.Nodes[node].State == rollout.NodeStaged {
+			assert.Equal(t, "2", version)
+		} else {
+			assert.Equal(t, "1", version)
+		}
+	}
+
+	// Another rollout waits for this one to be over.
+	_, err = ctrl.Start(context.Background(), makeSnapshot(t, "3"))
+	assert.ErrorIs(t, err, rollout.ErrInProgress)
+
+	// ACKs of other versions or nodes do not count.
+	apply(observer, "1", first...)
+	apply(observer, "2", "other")
+	assert.Equal(t, first, r.Status().NodesIn(rollout.NodeStaged))
+
+	apply(observer, "2", first[0])
+	require.Eventually(t, func() bool {
+		return r.Status().Nodes[first[0]].State == rollout.NodeAcked
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, 1, r.Status().Wave)
+	apply(observer, "2", first[1])
+	require.Eventually(t, func() bool {
+		return r.Status().Wave == 2
+	}, time.Second, time.Millisecond)
+	status = r.Status()
+	assert.Equal(t, first, status.NodesIn(rollout.NodeAcked))
+	second := status.NodesIn(rollout.NodeStaged)
+	assert.Len(t, second, 3)
+	for _, node := range second {
+		assert.Equal(t, 2, status.Nodes[node].Wave)
+	}
+
+	apply(observer, "2", second...)
+	require.Eventually(t, func() bool {
+		return r.Status().Wave == 3
+	}, time.Second, time.Millisecond)
+	apply(observer, "2", r.Status().NodesIn(rollout.NodeStaged)...)
+
+	require.Eventually(t, func() bool {
+		return r.Status().State == rollout.Completed
+	}, time.Second, time.Millisecond)
+	status = r.Status()
+	assert.Len(t, status.NodesIn(rollout.NodeAcked), 10)
+	for _, version := range versions(t, c, nodes) {
+		assert.Equal(t, "2", version)
+	}
+	assert.ErrorIs(t, r.Abort(context.Background()), rollout.ErrFinished)
+}
+
+func TestRolloutHaltsOnNack(t *testing.T) {
+	ctrl, c, observer, nodes := setup(t, 4)
+	r, err := ctrl.Start(context.Background(), makeSnapshot(t, "2"),
+		rollout.WithNodes(nodes...), rollout.WithWaves(50), rollout.WithNackThreshold(0.4))
+	require.NoError(t, err)
+	first := r.Status().NodesIn(rollout.NodeStaged)
+	require.Len(t, first, 2)
+
+	apply(observer, "2", first[0])
+	reject(observer, "2", first[1])
+
+	require.Eventually(t, func() bool {
+		return r.Status().State == rollout.Halted
+	}, time.Second, time.Millisecond)
+	status := r.Status()
+	assert.Equal(t, "1 of 2 staged nodes NACKed the snapshot", status.Reason)
+	assert.Equal(t, 1, status.Wave)
+	assert.Equal(t, []string{first[1]}, status.NodesIn(rollout.NodeNacked))
+	assert.Equal(t, "invalid cluster", status.Nodes[first[1]].Error)
+	assert.Len(t, status.NodesIn(rollout.NodePending), 2)
+
+	// Aborting restores the previous snapshot of the staged nodes.
+	require.NoError(t, r.Abort(context.Background()))
+	assert.Equal(t, rollout.Aborted, r.Status().State)
+	for _, version := range versions(t, c, nodes) {
+		assert.Equal(t, "1", version)
+	}
+
+	_, err = ctrl.Start(context.Background(), makeSnapshot(t, "3"), rollout.WithNodes(nodes...))
+	assert.NoError(t, err)
+}
+
+func TestRolloutPromote(t *testing.T) {
+	ctrl, c, observer, nodes := setup(t, 4)
+	r, err := ctrl.Start(context.Background(), makeSnapshot(t, "2"),
+		rollout.WithNodes(nodes...), rollout.WithWaves(25, 50))
+	require.NoError(t, err)
+	first := r.Status().NodesIn(rollout.NodeStaged)
+	require.Len(t, first, 1)
+	reject(observer, "2", first[0])
+	require.Eventually(t, func() bool {
+		return r.Status().State == rollout.Halted
+	}, time.Second, time.Millisecond)
+
+	require.NoError(t, r.Promote(context.Background()))
+	status := r.Status()
+	assert.Equal(t, rollout.Running, status.State)
+	assert.Equal(t, 3, status.Wave)
+	for _, version := range versions(t, c, nodes) {
+		assert.Equal(t, "2", version)
+	}
+
+	// Once promoted, NACKs no longer halt the rollout.
+	rest := status.NodesIn(rollout.NodeStaged)
+	reject(observer, "2", rest[0])
+	apply(observer, "2", rest[1:]...)
+	require.Eventually(t, func() bool {
+		return r.Status().State == rollout.Completed
+	}, time.Second, time.Millisecond)
+	status = r.Status()
+	assert.Len(t, status.NodesIn(rollout.NodeNacked), 2)
+}
+
+func TestRolloutWaveTimeout(t *testing.T) {
+	ctrl, _, observer, nodes := setup(t, 2)
+	r, err := ctrl.Start(context.Background(), makeSnapshot(t, "2"),
+		rollout.WithNodes(nodes...), rollout.WithWaveTimeout(10*time.Millisecond))
+	require.NoError(t, err)
+	apply(observer, "2", nodes[0])
+
+	require.Eventually(t, func() bool {
+		return r.Status().State == rollout.Halted
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, "wave 1 timed out with 1 nodes yet to ACK the snapshot", r.Status().Reason)
+}
+
+func TestRolloutTargets(t *testing.T) {
+	ctrl, c, _, nodes := setup(t, 20)
+	canary := func(node string) bool {
+		return !strings.HasSuffix(node, "-0")
+	}
+
+	// The nodes known to the cache are targeted by default.
+	for _, node := range nodes {
+		c.CreateWatch(&cache.Request{Node: &core.Node{Id: node}, TypeUrl: rsrc.ClusterType},
+			stream.NewStreamState(false, nil), make(chan cache.Response, 1))
+	}
+	r, err := ctrl.Start(context.Background(), makeSnapshot(t, "2"), rollout.WithSelector(canary), rollout.WithPercentage(50))
+	require.NoError(t, err)
+	half := r.Status().Nodes
+	assert.Len(t, half, 10)
+	assert.NotContains(t, half, "node-0")
+	require.NoError(t, r.Abort(context.Background()))
+
+	// A smaller percentage targets a subset of the nodes of a larger one.
+	r, err = ctrl.Start(context.Background(), makeSnapshot(t, "2"),
+		rollout.WithNodes(nodes...), rollout.WithSelector(canary), rollout.WithPercentage(10))
+	require.NoError(t, err)
+	assert.Len(t, r.Status().Nodes, 2)
+	for node := range r.Status().Nodes {
+		assert.Contains(t, half, node)
+	}
+	require.NoError(t, r.Abort(context.Background()))
+
+	_, err = ctrl.Start(context.Background(), makeSnapshot(t, "2"), rollout.WithNodes())
+	assert.ErrorIs(t, err, rollout.ErrNoNodes)
+}
+
+func TestRolloutUnsupportedCache(t *testing.T) {
+	_, c, _, nodes := setup(t, 1)
+	ctrl := rollout.NewController(struct{ cache.SnapshotCache }{c})
+	_, err := ctrl.Start(context.Background(), makeSnapshot(t, "2"), rollout.WithNodes(nodes...))
+	assert.ErrorIs(t, err, rollout.ErrUnsupportedCache)
+}
+
+func TestRolloutDisconnectedNodes(t *testing.T) {
+	ctrl, _, observer, nodes := setup(t, 3)
+	observer.StreamClosed(context.Background(), eventStream(nodes[0]))
+	r, err := ctrl.Start(context.Background(), makeSnapshot(t, "2"), rollout.WithNodes(nodes...))
+	require.NoError(t, err)
+
+	// The nodes without streams are not waited for, nor those whose streams
+	// close before applying the snapshot.
+	require.Eventually(t, func() bool {
+		return r.Status().Nodes[nodes[0]].State == rollout.NodeAcked
+	}, time.Second, time.Millisecond)
+	observer.StreamClosed(context.Background(), eventStream(nodes[1]))
+	apply(observer, "2", nodes[2])
+	require.Eventually(t, func() bool {
+		return r.Status().State == rollout.Completed
+	}, time.Second, time.Millisecond)
+	assert.Len(t, r.Status().NodesIn(rollout.NodeAcked), 3)
+}
+
+func TestRolloutAbortGroupNode(t *testing.T) {
+	c := cache.NewSnapshotCache(false, cache.IDHash{}, nil, cache.WithNodeGroups(clusterGroup{}))
+	node := &core.Node{Id: "node", Cluster: "edge"}
+	require.NoError(t, c.(cache.GroupSnapshotCache).SetGroupSnapshot(context.Background(), "edge", makeSnapshot(t, "1")))
+	observer := c.(events.ObserverFactory).NewObserver()
+	observer.StreamOpened(context.Background(), events.Stream{ID: 1, Node: node, TypeURL: rsrc.ClusterType})
+	observer.Requested(context.Background(), events.Stream{ID: 1, Node: node, TypeURL: rsrc.ClusterType}, rsrc.ClusterType, nil)
+
+	ctrl := rollout.NewController(c)
+	r, err := ctrl.Start(context.Background(), makeSnapshot(t, "2"), rollout.WithNodes(node.GetId()))
+	require.NoError(t, err)
+	value := make(chan cache.Response, 1)
+	c.CreateWatch(&cache.Request{Node: node, TypeUrl: rsrc.ClusterType, VersionInfo: "2"},
+		stream.NewStreamState(false, nil), value)
+
+	// The node is served its group snapshot again, without its stream being
+	// terminated.
+	require.NoError(t, r.Abort(context.Background()))
+	_, err = c.(cache.NodeSnapshotCache).GetNodeSnapshot(node.GetId())
+	assert.Error(t, err)
+	resp := <-value
+	require.IsType(t, &cache.RawResponse{}, resp)
+	version, err := resp.GetVersion()
+	require.NoError(t, err)
+	assert.Equal(t, "1", version)
+}
+
+// clusterGroup groups the nodes by their cluster.
+type clusterGroup struct{}
+
+func (clusterGroup) ID(node *core.Node) string {
+	return node.GetCluster()
+}
+
+// stagingCache is the cache rollouts stage their snapshots with.
+type stagingCache interface {
+	cache.SnapshotCache
+	cache.NodeSnapshotCache
+	cache.ConvergenceTracker
+}
+
+// blockingCache blocks the first SetSnapshot once armed, until released.
+type blockingCache struct {
+	stagingCache
+	armed   int32
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (c *blockingCache) SetSnapshot(ctx context.Context, node string, snapshot cache.ResourceSnapshot) error {
+	if atomic.CompareAndSwapInt32(&c.armed, 1, 0) {
+		close(c.entered)
+		<-c.release
+	}
+	return c.stagingCache.SetSnapshot(ctx, node, snapshot)
+}
+
+func TestRolloutAbortWhileStaging(t *testing.T) {
+	_, c, observer, nodes := setup(t, 4)
+	blocking := &blockingCache{stagingCache: c.(stagingCache), entered: make(chan struct{}), release: make(chan struct{})}
+	ctrl := rollout.NewController(blocking)
+	r, err := ctrl.Start(context.Background(), makeSnapshot(t, "2"), rollout.WithNodes(nodes...), rollout.WithWaves(50))
+	require.NoError(t, err)
+
+	// The second wave is staged once the first one ACKed the snapshot, and
+	// the rollout is aborted while it is.
+	atomic.StoreInt32(&blocking.armed, 1)
+	apply(observer, "2", r.Status().NodesIn(rollout.NodeStaged)...)
+	<-blocking.entered
+	aborted := make(chan error, 1)
+	go func() {
+		aborted <- r.Abort(context.Background())
+	}()
+	select {
+	case err := <-aborted:
+		t.Fatalf("aborted while staging: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(blocking.release)
+	require.NoError(t, <-aborted)
+
+	assert.Equal(t, rollout.Aborted, r.Status().State)
+	for _, version := range versions(t, c, nodes) {
+		assert.Equal(t, "1", version)
+	}
+}
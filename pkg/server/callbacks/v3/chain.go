@@ -0,0 +1,124 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+// Package callbacks composes server callbacks and provides ready-made
+// middleware for logging requests and recovering from panics.
+package callbacks
+
+import (
+	"context"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/server/v3"
+)
+
+// Chain returns callbacks invoking each of cbs in order, skipping nil ones.
+//
+// The callbacks returning an error short-circuit: OnStreamOpen,
+// OnDeltaStreamOpen, OnStreamRequest, OnStreamDeltaRequest and OnFetchRequest
+// return the first error, and the callbacks after the failing one are not
+// invoked. The server then closes the stream, or fails the fetch. The closing
+// callbacks are invoked on all callbacks in reverse order, including those
+// after the one that rejected the opening of the stream: a callback may see
+// the closing of a stream it never saw open. The response callbacks are
+// invoked on all callbacks in order.
+func Chain(cbs ...server.Callbacks) server.Callbacks {
+	out := make(chain, 0, len(cbs))
+	for _, cb := range cbs {
+		if cb != nil {
+			out = append(out, cb)
+		}
+	}
+	return out
+}
+
+type chain []server.Callbacks
+
+var _ server.Callbacks = chain{}
+
+func (c chain) OnStreamOpen(ctx context.Context, streamID int64, typeURL string) error {
+	for _, cb := range c {
+		if err := cb.OnStreamOpen(ctx, streamID, typeURL); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c chain) OnStreamClosed(streamID int64, node *core.Node) {
+	for i := len(c) - 1; i >= 0; i-- {
+		c[i].OnStreamClosed(streamID, node)
+	}
+}
+
+func (c chain) OnDeltaStreamOpen(ctx context.Context, streamID int64, typeURL string) error {
+	for _, cb := range c {
+		if err := cb.OnDeltaStreamOpen(ctx, streamID, typeURL); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c chain) OnDeltaStreamClosed(streamID int64, node *core.Node) {
+	for i := len(c) - 1; i >= 0; i-- {
+		c[i].OnDeltaStreamClosed(streamID, node)
+	}
+}
+
+func (c chain) OnStreamRequest(streamID int64, req *discovery.DiscoveryRequest) error {
+	for _, cb := range c {
+		if err := cb.OnStreamRequest(streamID, req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c chain) OnStreamResponse(ctx context.Context, streamID int64, req *discovery.DiscoveryRequest, resp *discovery.DiscoveryResponse) {
+	for _, cb := range c {
+		cb.OnStreamResponse(ctx, streamID, req, resp)
+	}
+}
+
+func (c chain) OnStreamDeltaRequest(streamID int64, req *discovery.DeltaDiscoveryRequest) error {
+	for _, cb := range c {
+		if err := cb.OnStreamDeltaRequest(streamID, req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c chain) OnStreamDeltaResponse(streamID int64, req *discovery.DeltaDiscoveryRequest, resp *discovery.DeltaDiscoveryResponse) {
+	for _, cb := range c {
+		cb.OnStreamDeltaResponse(streamID, req, resp)
+	}
+}
+
+func (c chain) OnFetchRequest(ctx context.Context, req *discovery.DiscoveryRequest) error {
+	for _, cb := range c {
+		if err := cb.OnFetchRequest(ctx, req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c chain) OnFetchResponse(req *discovery.DiscoveryRequest, resp *discovery.DiscoveryResponse) {
+	for _, cb := range c {
+		cb.OnFetchResponse(req, resp)
+	}
+}
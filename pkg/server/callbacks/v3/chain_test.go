@@ -0,0 +1,100 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package callbacks_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/server/callbacks/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/server/v3"
+)
+
+// recorder returns callbacks appending their name and the callback invoked to
+// calls, and failing the request callbacks with err.
+func recorder(name string, calls *[]string, err error) server.Callbacks {
+	record := func(callback string) {
+		*calls = append(*calls, name+"."+callback)
+	}
+	return server.CallbackFuncs{
+		StreamOpenFunc: func(context.Context, int64, string) error {
+			record("OnStreamOpen")
+			return err
+		},
+		StreamClosedFunc: func(int64, *core.Node) {
+			record("OnStreamClosed")
+		},
+		StreamRequestFunc: func(int64, *discovery.DiscoveryRequest) error {
+			record("OnStreamRequest")
+			return err
+		},
+		StreamResponseFunc: func(context.Context, int64, *discovery.DiscoveryRequest, *discovery.DiscoveryResponse) {
+			record("OnStreamResponse")
+		},
+		StreamDeltaRequestFunc: func(int64, *discovery.DeltaDiscoveryRequest) error {
+			record("OnStreamDeltaRequest")
+			return err
+		},
+		FetchRequestFunc: func(context.Context, *discovery.DiscoveryRequest) error {
+			record("OnFetchRequest")
+			return err
+		},
+	}
+}
+
+func TestChain(t *testing.T) {
+	var calls []string
+	cb := callbacks.Chain(recorder("a", &calls, nil), nil, recorder("b", &calls, nil))
+
+	ctx := context.Background()
+	assert.NoError(t, cb.OnStreamOpen(ctx, 1, ""))
+	assert.NoError(t, cb.OnStreamRequest(1, &discovery.DiscoveryRequest{}))
+	cb.OnStreamResponse(ctx, 1, &discovery.DiscoveryRequest{}, &discovery.DiscoveryResponse{})
+	cb.OnStreamClosed(1, nil)
+
+	assert.Equal(t, []string{
+		"a.OnStreamOpen", "b.OnStreamOpen",
+		"a.OnStreamRequest", "b.OnStreamRequest",
+		"a.OnStreamResponse", "b.OnStreamResponse",
+		"b.OnStreamClosed", "a.OnStreamClosed",
+	}, calls)
+}
+
+func TestChainShortCircuit(t *testing.T) {
+	var calls []string
+	denied := errors.New("denied")
+	cb := callbacks.Chain(recorder("a", &calls, nil), recorder("b", &calls, denied), recorder("c", &calls, nil))
+
+	ctx := context.Background()
+	assert.Equal(t, denied, cb.OnStreamOpen(ctx, 1, ""))
+	assert.Equal(t, denied, cb.OnStreamRequest(1, &discovery.DiscoveryRequest{}))
+	assert.Equal(t, denied, cb.OnStreamDeltaRequest(1, &discovery.DeltaDiscoveryRequest{}))
+	assert.Equal(t, denied, cb.OnFetchRequest(ctx, &discovery.DiscoveryRequest{}))
+	cb.OnStreamClosed(1, nil)
+
+	// The closing of the rejected stream reaches c, which did not see it open.
+	assert.Equal(t, []string{
+		"a.OnStreamOpen", "b.OnStreamOpen",
+		"a.OnStreamRequest", "b.OnStreamRequest",
+		"a.OnStreamDeltaRequest", "b.OnStreamDeltaRequest",
+		"a.OnFetchRequest", "b.OnFetchRequest",
+		"c.OnStreamClosed", "b.OnStreamClosed", "a.OnStreamClosed",
+	}, calls)
+}
@@ -0,0 +1,140 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package callbacks
+
+import (
+	"context"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/log"
+	"github.com/envoyproxy/go-control-plane/pkg/metrics"
+	"github.com/envoyproxy/go-control-plane/pkg/server/v3"
+)
+
+// protocolKey is the field holding the protocol of a stream or fetch.
+const protocolKey = "protocol"
+
+// Logging returns callbacks logging the opening and closing of streams at
+// the info level, their requests and responses and the fetches at the debug
+// level, and NACKs as warnings. The messages carry the stream ID, node ID,
+// type URL, version and nonce.
+func Logging(logger log.StructuredLogger) server.Callbacks {
+	return logging{log: log.OrDiscard(logger)}
+}
+
+type logging struct {
+	log log.StructuredLogger
+}
+
+func (l logging) OnStreamOpen(_ context.Context, streamID int64, typeURL string) error {
+	l.log.Info("stream opened", log.Any(protocolKey, metrics.ProtocolSOTW), log.StreamID(streamID), log.TypeURL(typeURL))
+	return nil
+}
+
+func (l logging) OnStreamClosed(streamID int64, node *core.Node) {
+	l.log.Info("stream closed", log.Any(protocolKey, metrics.ProtocolSOTW), log.StreamID(streamID), log.Node(node.GetId()))
+}
+
+func (l logging) OnDeltaStreamOpen(_ context.Context, streamID int64, typeURL string) error {
+	l.log.Info("stream opened", log.Any(protocolKey, metrics.ProtocolDelta), log.StreamID(streamID), log.TypeURL(typeURL))
+	return nil
+}
+
+func (l logging) OnDeltaStreamClosed(streamID int64, node *core.Node) {
+	l.log.Info("stream closed", log.Any(protocolKey, metrics.ProtocolDelta), log.StreamID(streamID), log.Node(node.GetId()))
+}
+
+func (l logging) OnStreamRequest(streamID int64, req *discovery.DiscoveryRequest) error {
+	fields := []log.Field{
+		log.Any(protocolKey, metrics.ProtocolSOTW),
+		log.StreamID(streamID),
+		log.Node(req.GetNode().GetId()),
+		log.TypeURL(req.GetTypeUrl()),
+		log.Version(req.GetVersionInfo()),
+		log.Nonce(req.GetResponseNonce()),
+		log.Resources(req.GetResourceNames()),
+	}
+	if req.GetErrorDetail() != nil {
+		l.log.Warn("NACK", append(fields, log.Any("error_detail", req.GetErrorDetail().GetMessage()))...)
+		return nil
+	}
+	l.log.Debug("request", fields...)
+	return nil
+}
+
+func (l logging) OnStreamResponse(_ context.Context, streamID int64, req *discovery.DiscoveryRequest, resp *discovery.DiscoveryResponse) {
+	l.log.Debug("response",
+		log.Any(protocolKey, metrics.ProtocolSOTW),
+		log.StreamID(streamID),
+		log.Node(req.GetNode().GetId()),
+		log.TypeURL(resp.GetTypeUrl()),
+		log.Version(resp.GetVersionInfo()),
+		log.Nonce(resp.GetNonce()),
+		log.Any("num_resources", len(resp.GetResources())),
+	)
+}
+
+func (l logging) OnStreamDeltaRequest(streamID int64, req *discovery.DeltaDiscoveryRequest) error {
+	fields := []log.Field{
+		log.Any(protocolKey, metrics.ProtocolDelta),
+		log.StreamID(streamID),
+		log.Node(req.GetNode().GetId()),
+		log.TypeURL(req.GetTypeUrl()),
+		log.Nonce(req.GetResponseNonce()),
+		log.Any("subscribe", req.GetResourceNamesSubscribe()),
+		log.Any("unsubscribe", req.GetResourceNamesUnsubscribe()),
+	}
+	if req.GetErrorDetail() != nil {
+		l.log.Warn("NACK", append(fields, log.Any("error_detail", req.GetErrorDetail().GetMessage()))...)
+		return nil
+	}
+	l.log.Debug("request", fields...)
+	return nil
+}
+
+func (l logging) OnStreamDeltaResponse(streamID int64, req *discovery.DeltaDiscoveryRequest, resp *discovery.DeltaDiscoveryResponse) {
+	l.log.Debug("response",
+		log.Any(protocolKey, metrics.ProtocolDelta),
+		log.StreamID(streamID),
+		log.Node(req.GetNode().GetId()),
+		log.TypeURL(resp.GetTypeUrl()),
+		log.Version(resp.GetSystemVersionInfo()),
+		log.Nonce(resp.GetNonce()),
+		log.Any("num_resources", len(resp.GetResources())),
+		log.Any("removed", resp.GetRemovedResources()),
+	)
+}
+
+func (l logging) OnFetchRequest(_ context.Context, req *discovery.DiscoveryRequest) error {
+	l.log.Debug("request",
+		log.Any(protocolKey, metrics.ProtocolREST),
+		log.Node(req.GetNode().GetId()),
+		log.TypeURL(req.GetTypeUrl()),
+		log.Version(req.GetVersionInfo()),
+		log.Resources(req.GetResourceNames()),
+	)
+	return nil
+}
+
+func (l logging) OnFetchResponse(req *discovery.DiscoveryRequest, resp *discovery.DiscoveryResponse) {
+	l.log.Debug("response",
+		log.Any(protocolKey, metrics.ProtocolREST),
+		log.Node(req.GetNode().GetId()),
+		log.TypeURL(resp.GetTypeUrl()),
+		log.Version(resp.GetVersionInfo()),
+		log.Any("num_resources", len(resp.GetResources())),
+	)
+}
@@ -0,0 +1,71 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package callbacks_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	statuspb "google.golang.org/genproto/googleapis/rpc/status"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/log"
+	"github.com/envoyproxy/go-control-plane/pkg/log/logtest"
+	rsrc "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/server/callbacks/v3"
+)
+
+func TestLogging(t *testing.T) {
+	logs := logtest.New()
+	cb := callbacks.Logging(logs)
+	node := &core.Node{Id: "node"}
+
+	require.NoError(t, cb.OnStreamOpen(context.Background(), 1, rsrc.AnyType))
+	require.NoError(t, cb.OnStreamRequest(1, &discovery.DiscoveryRequest{
+		Node:          node,
+		TypeUrl:       rsrc.ClusterType,
+		VersionInfo:   "1",
+		ResponseNonce: "2",
+		ErrorDetail:   &statuspb.Status{Message: "rejected"},
+	}))
+	cb.OnStreamResponse(context.Background(), 1, &discovery.DiscoveryRequest{Node: node}, &discovery.DiscoveryResponse{
+		TypeUrl:     rsrc.ClusterType,
+		VersionInfo: "2",
+		Nonce:       "3",
+	})
+	cb.OnStreamClosed(1, node)
+
+	entries := logs.All()
+	require.Len(t, entries, 4)
+	assert.Equal(t, "stream opened", entries[0].Message)
+	assert.Equal(t, logtest.WarnLevel, entries[1].Level)
+	assert.Equal(t, map[string]interface{}{
+		"protocol":       "sotw",
+		log.StreamIDKey:  int64(1),
+		log.NodeKey:      "node",
+		log.TypeURLKey:   rsrc.ClusterType,
+		log.VersionKey:   "1",
+		log.NonceKey:     "2",
+		log.ResourcesKey: []string(nil),
+		"error_detail":   "rejected",
+	}, entries[1].Fields)
+	assert.Equal(t, "response", entries[2].Message)
+	assert.Equal(t, "3", entries[2].Fields[log.NonceKey])
+	assert.Equal(t, "stream closed", entries[3].Message)
+	assert.Equal(t, "node", entries[3].Fields[log.NodeKey])
+}
@@ -0,0 +1,114 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package callbacks
+
+import (
+	"context"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/server/v3"
+)
+
+// PanicHandler is called with the name of a callback that panicked, e.g.
+// "OnStreamRequest", and the value it panicked with.
+type PanicHandler func(callback string, recovered interface{})
+
+// Recover returns callbacks invoking cb and recovering from its panics, which
+// would otherwise crash the server. The panics are reported to handler, if
+// not nil. A panic in a callback returning an error is turned into an
+// Internal status error, which closes the stream or fails the fetch; a panic
+// in any other callback is only reported.
+//
+// Recover is meant to wrap a Chain, so that a faulty middleware does not
+// bring down the whole control plane:
+//
+//	cb := callbacks.Recover(callbacks.Chain(auth, audit), handler)
+func Recover(cb server.Callbacks, handler PanicHandler) server.Callbacks {
+	return recovery{next: cb, handler: handler}
+}
+
+type recovery struct {
+	next    server.Callbacks
+	handler PanicHandler
+}
+
+// recover must be deferred by the callbacks. If err is not nil, it is set to
+// an error reporting the panic.
+func (r recovery) recover(callback string, err *error) {
+	v := recover()
+	if v == nil {
+		return
+	}
+	if r.handler != nil {
+		r.handler(callback, v)
+	}
+	if err != nil {
+		*err = status.Errorf(codes.Internal, "panic in %s: %v", callback, v)
+	}
+}
+
+func (r recovery) OnStreamOpen(ctx context.Context, streamID int64, typeURL string) (err error) {
+	defer r.recover("OnStreamOpen", &err)
+	return r.next.OnStreamOpen(ctx, streamID, typeURL)
+}
+
+func (r recovery) OnStreamClosed(streamID int64, node *core.Node) {
+	defer r.recover("OnStreamClosed", nil)
+	r.next.OnStreamClosed(streamID, node)
+}
+
+func (r recovery) OnDeltaStreamOpen(ctx context.Context, streamID int64, typeURL string) (err error) {
+	defer r.recover("OnDeltaStreamOpen", &err)
+	return r.next.OnDeltaStreamOpen(ctx, streamID, typeURL)
+}
+
+func (r recovery) OnDeltaStreamClosed(streamID int64, node *core.Node) {
+	defer r.recover("OnDeltaStreamClosed", nil)
+	r.next.OnDeltaStreamClosed(streamID, node)
+}
+
+func (r recovery) OnStreamRequest(streamID int64, req *discovery.DiscoveryRequest) (err error) {
+	defer r.recover("OnStreamRequest", &err)
+	return r.next.OnStreamRequest(streamID, req)
+}
+
+func (r recovery) OnStreamResponse(ctx context.Context, streamID int64, req *discovery.DiscoveryRequest, resp *discovery.DiscoveryResponse) {
+	defer r.recover("OnStreamResponse", nil)
+	r.next.OnStreamResponse(ctx, streamID, req, resp)
+}
+
+func (r recovery) OnStreamDeltaRequest(streamID int64, req *discovery.DeltaDiscoveryRequest) (err error) {
+	defer r.recover("OnStreamDeltaRequest", &err)
+	return r.next.OnStreamDeltaRequest(streamID, req)
+}
+
+func (r recovery) OnStreamDeltaResponse(streamID int64, req *discovery.DeltaDiscoveryRequest, resp *discovery.DeltaDiscoveryResponse) {
+	defer r.recover("OnStreamDeltaResponse", nil)
+	r.next.OnStreamDeltaResponse(streamID, req, resp)
+}
+
+func (r recovery) OnFetchRequest(ctx context.Context, req *discovery.DiscoveryRequest) (err error) {
+	defer r.recover("OnFetchRequest", &err)
+	return r.next.OnFetchRequest(ctx, req)
+}
+
+func (r recovery) OnFetchResponse(req *discovery.DiscoveryRequest, resp *discovery.DiscoveryResponse) {
+	defer r.recover("OnFetchResponse", nil)
+	r.next.OnFetchResponse(req, resp)
+}
@@ -0,0 +1,62 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package callbacks_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/server/callbacks/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/server/v3"
+)
+
+func TestRecover(t *testing.T) {
+	var panics []string
+	cb := callbacks.Recover(server.CallbackFuncs{
+		StreamRequestFunc: func(int64, *discovery.DiscoveryRequest) error {
+			panic("boom")
+		},
+		StreamClosedFunc: func(int64, *core.Node) {
+			panic("closed")
+		},
+	}, func(callback string, recovered interface{}) {
+		panics = append(panics, callback+": "+recovered.(string))
+	})
+
+	err := cb.OnStreamRequest(1, &discovery.DiscoveryRequest{})
+	assert.Equal(t, codes.Internal, status.Code(err))
+	assert.Contains(t, err.Error(), "boom")
+
+	assert.NotPanics(t, func() { cb.OnStreamClosed(1, nil) })
+	assert.NoError(t, cb.OnStreamOpen(context.Background(), 1, ""))
+	assert.Equal(t, []string{"OnStreamRequest: boom", "OnStreamClosed: closed"}, panics)
+}
+
+func TestRecoverWithoutHandler(t *testing.T) {
+	cb := callbacks.Recover(server.CallbackFuncs{
+		FetchRequestFunc: func(context.Context, *discovery.DiscoveryRequest) error {
+			panic("boom")
+		},
+	}, nil)
+
+	err := cb.OnFetchRequest(context.Background(), &discovery.DiscoveryRequest{})
+	assert.Equal(t, codes.Internal, status.Code(err))
+}
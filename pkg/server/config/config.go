@@ -0,0 +1,89 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+// Package config holds the options shared by the xDS servers.
+package config
+
+import (
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/envoyproxy/go-control-plane/pkg/log"
+	"github.com/envoyproxy/go-control-plane/pkg/metrics"
+	"github.com/envoyproxy/go-control-plane/pkg/server/events/v3"
+)
+
+// Opts are the options of the xDS servers.
+type Opts struct {
+	// Metrics records the activity of the servers, discarded if nil.
+	Metrics metrics.Metrics
+
+	// TracerProvider provides the tracer of the servers, tracing is disabled if nil.
+	TracerProvider trace.TracerProvider
+
+	// Logger reports the activity of the streams, discarded if nil.
+	Logger log.StructuredLogger
+
+	// Observers are notified of the events of the streams.
+	Observers []events.Observer
+}
+
+// XDSOption modifies the behavior of the xDS servers.
+type XDSOption func(*Opts)
+
+// NewOpts returns the options resulting from applying opts.
+func NewOpts(opts ...XDSOption) Opts {
+	var o Opts
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithMetrics makes the servers report their open streams, requests, NACKs,
+// nonces in flight, push latency and response sizes, see metrics.ServerMetrics.
+func WithMetrics(m metrics.Metrics) XDSOption {
+	return func(o *Opts) {
+		o.Metrics = m
+	}
+}
+
+// WithTracerProvider makes the servers record OpenTelemetry spans for the
+// marshaling and sending of each response. The spans are children of the
+// context of the responses, which the caches link to the update that
+// triggered them, see cache.WithSnapshotCacheTracing.
+func WithTracerProvider(provider trace.TracerProvider) XDSOption {
+	return func(o *Opts) {
+		o.TracerProvider = provider
+	}
+}
+
+// WithLogger makes the servers report the opening and closing of streams, the
+// requests, NACKs and responses to a structured logger, with the stream ID,
+// node ID, type URL, version and nonce attached to the messages. A
+// printf-style log.Logger is adapted with log.FromLogger.
+func WithLogger(logger log.StructuredLogger) XDSOption {
+	return func(o *Opts) {
+		o.Logger = logger
+	}
+}
+
+// WithObserver makes the SOTW and delta servers report the events of their
+// streams to an observer: the streams opening and closing, the responses
+// sent, and the ACKs, NACKs and unsubscriptions of the clients. It can be
+// given several times, and the observers are notified in order.
+func WithObserver(observer events.Observer) XDSOption {
+	return func(o *Opts) {
+		o.Observers = append(o.Observers, observer)
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package delta
+
+import (
+	"context"
+	"sort"
+
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/server/events/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/server/stream/v3"
+)
+
+// streamObserver reports the events of a stream to the observer of the
+// server. A nil streamObserver reports nothing.
+type streamObserver struct {
+	observer events.Observer
+	ctx      context.Context
+	stream   events.Stream
+	nonces   events.Nonces
+}
+
+func newStreamObserver(ctx context.Context, observer events.Observer, streamID int64, typeURL string) *streamObserver {
+	if observer == nil {
+		return nil
+	}
+	return &streamObserver{
+		observer: observer,
+		ctx:      ctx,
+		stream:   events.Stream{ID: streamID, TypeURL: typeURL, Delta: true},
+	}
+}
+
+// request reports the events of a request, whose node and type are set,
+// once its subscriptions are applied to the state of the type.
+func (o *streamObserver) request(req *discovery.DeltaDiscoveryRequest, state stream.StreamState) {
+	if o == nil {
+		return
+	}
+	if o.stream.Node == nil {
+		o.stream.Node = req.Node
+		o.observer.StreamOpened(o.ctx, o.stream)
+	}
+
+	typeURL := req.TypeUrl
+	if version, ok := o.nonces.Match(typeURL, req.ResponseNonce); ok {
+		if req.ErrorDetail != nil {
+			o.observer.Nacked(o.ctx, o.stream, typeURL, version, req.ErrorDetail)
+		} else {
+			o.observer.Acked(o.ctx, o.stream, typeURL, version)
+		}
+	}
+	if len(req.ResourceNamesUnsubscribe) > 0 {
+		o.observer.Unsubscribed(o.ctx, o.stream, typeURL, req.ResourceNamesUnsubscribe)
+	}
+
+	subscribed := state.GetSubscribedResourceNames()
+	names := make([]string, 0, len(subscribed)+1)
+	if state.IsWildcard() {
+		names = append(names, "*")
+	}
+	for name := range subscribed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	o.observer.Requested(o.ctx, o.stream, typeURL, names)
+}
+
+// pushed reports a response sent on the stream.
+func (o *streamObserver) pushed(out *discovery.DeltaDiscoveryResponse) {
+	if o == nil {
+		return
+	}
+	o.nonces.Sent(out.TypeUrl, out.Nonce, out.SystemVersionInfo)
+	o.observer.ResourcesPushed(o.ctx, o.stream, events.Push{
+		TypeURL:      out.TypeUrl,
+		Version:      out.SystemVersionInfo,
+		Nonce:        out.Nonce,
+		NumResources: len(out.Resources),
+		Removed:      out.RemovedResources,
+	})
+}
+
+// close reports the closing of the stream, if it was reported opened.
+func (o *streamObserver) close() {
+	if o == nil || o.stream.Node == nil {
+		return
+	}
+	o.observer.StreamClosed(o.ctx, o.stream)
+}
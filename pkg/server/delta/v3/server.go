@@ -5,15 +5,23 @@ import (
 	"errors"
 	"strconv"
 	"sync/atomic"
+	"time"
 
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 
 	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
 	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
 	"github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/log"
+	"github.com/envoyproxy/go-control-plane/pkg/metrics"
 	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/server/config"
+	"github.com/envoyproxy/go-control-plane/pkg/server/events/v3"
 	"github.com/envoyproxy/go-control-plane/pkg/server/stream/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/tracing"
 )
 
 // Server is a wrapper interface which is meant to hold the proper stream handler for each xDS protocol.
@@ -39,6 +47,13 @@ var deltaErrorResponse = &cache.RawDeltaResponse{}
 type server struct {
 	cache     cache.ConfigWatcher
 	callbacks Callbacks
+	metrics   *metrics.ServerMetrics
+	tracer    trace.Tracer
+	log       log.StructuredLogger
+	observer  events.Observer
+
+	// controls of the streams by node, see TerminateNode and RepushNode
+	controls stream.Controls
 
 	// total stream count for counting bi-di streams
 	streamCount int64
@@ -46,10 +61,23 @@ type server struct {
 }
 
 // NewServer creates a delta xDS specific server which utilizes a ConfigWatcher and delta Callbacks.
-func NewServer(ctx context.Context, config cache.ConfigWatcher, callbacks Callbacks) Server {
+func NewServer(ctx context.Context, cw cache.ConfigWatcher, callbacks Callbacks, opts ...config.XDSOption) Server {
+	o := config.NewOpts(opts...)
+
+	// A cache keeping track of the versions applied by the clients is told
+	// of their ACKs and NACKs.
+	observers := o.Observers
+	if factory, ok := cw.(events.ObserverFactory); ok {
+		observers = append(observers[:len(observers):len(observers)], factory.NewObserver())
+	}
+
 	return &server{
-		cache:     config,
+		cache:     cw,
 		callbacks: callbacks,
+		metrics:   metrics.NewServerMetrics(o.Metrics),
+		tracer:    tracing.Tracer(o.TracerProvider),
+		log:       log.OrDiscard(o.Logger),
+		observer:  events.Multi(observers...),
 		ctx:       ctx,
 	}
 }
@@ -65,31 +93,72 @@ func (s *server) processDelta(str stream.DeltaStream, reqCh <-chan *discovery.De
 
 	var node = &core.Node{}
 
+	// control is registered for the node of the stream once known
+	control := stream.NewControl(s.ctx)
+	registered := false
+
+	streamMetrics := s.metrics.OpenStream(metrics.ProtocolDelta)
+	logger := s.log.With(log.StreamID(streamID))
+	observer := newStreamObserver(str.Context(), s.observer, streamID, defaultTypeURL)
+
 	defer func() {
 		watches.Cancel()
+		streamMetrics.Close()
+		observer.close()
+		if registered {
+			s.controls.Remove(node.GetId(), streamID)
+		}
+		control.Cancel()
+		logger.Debug("close delta stream", log.Node(node.GetId()))
 		if s.callbacks != nil {
 			s.callbacks.OnDeltaStreamClosed(streamID, node)
 		}
 	}()
 
 	// Sends a response, returns the new stream nonce
-	send := func(resp cache.DeltaResponse) (string, error) {
+	send := func(resp cache.DeltaResponse) (nonce string, err error) {
 		if resp == nil {
 			return "", errors.New("missing response")
 		}
 
+		ctx := resp.GetContext()
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		ctx, span := s.tracer.Start(ctx, "Send", trace.WithAttributes(
+			tracing.ProtocolKey.String(metrics.ProtocolDelta),
+			tracing.StreamIDKey.Int64(streamID),
+			tracing.NodeKey.String(node.GetId()),
+			tracing.TypeURLKey.String(resp.GetDeltaRequest().GetTypeUrl()),
+		))
+		defer func() {
+			tracing.End(span, err)
+		}()
+
+		start := time.Now()
+		_, marshal := s.tracer.Start(ctx, "Marshal")
 		response, err := resp.GetDeltaDiscoveryResponse()
+		tracing.End(marshal, err)
 		if err != nil {
 			return "", err
 		}
 
 		streamNonce = streamNonce + 1
 		response.Nonce = strconv.FormatInt(streamNonce, 10)
+		span.SetAttributes(tracing.VersionKey.String(response.SystemVersionInfo), tracing.NonceKey.String(response.Nonce))
 		if s.callbacks != nil {
 			s.callbacks.OnStreamDeltaResponse(streamID, resp.GetDeltaRequest(), response)
 		}
 
-		return response.Nonce, str.Send(response)
+		if err := str.Send(response); err != nil {
+			return "", err
+		}
+		streamMetrics.Response(response.TypeUrl, response.Nonce, proto.Size(response), start)
+		observer.pushed(response)
+		logger.Debug("send delta response", log.Node(node.GetId()), log.TypeURL(response.TypeUrl),
+			log.Version(response.SystemVersionInfo), log.Nonce(response.Nonce),
+			log.Any("num_resources", len(response.Resources)), log.Any("removed", response.RemovedResources))
+		return response.Nonce, nil
 	}
 
 	if s.callbacks != nil {
@@ -97,11 +166,38 @@ func (s *server) processDelta(str stream.DeltaStream, reqCh <-chan *discovery.De
 			return err
 		}
 	}
+	logger.Debug("open delta stream", log.TypeURL(defaultTypeURL))
+
+	// openWatch opens the watch of a type in the cache, and forwards its response
+	openWatch := func(typeURL string, w watch) {
+		w.responses = make(chan cache.DeltaResponse, 1)
+		w.cancel = s.cache.CreateDeltaWatch(w.request, w.state, w.responses)
+		watches.deltaWatches[typeURL] = w
+
+		go func() {
+			resp, more := <-w.responses
+			if more {
+				watches.deltaMuxedResponses <- resp
+			}
+		}()
+	}
 
 	for {
 		select {
-		case <-s.ctx.Done():
-			return nil
+		case <-control.Context().Done():
+			// the stream ends with the status it was terminated with, if any
+			return control.Err()
+		case <-control.Repushes():
+			// Open the watches again as if the client had none of the resources, so that the current
+			// ones are pushed and the removed ones reported.
+			for typeURL, w := range watches.deltaWatches {
+				w.Cancel()
+				versions := w.state.GetResourceVersions()
+				for name := range versions {
+					versions[name] = ""
+				}
+				openWatch(typeURL, w)
+			}
 		case resp, more := <-watches.deltaMuxedResponses:
 			if !more {
 				break
@@ -144,6 +240,10 @@ func (s *server) processDelta(str stream.DeltaStream, reqCh <-chan *discovery.De
 			} else {
 				req.Node = node
 			}
+			if !registered {
+				s.controls.Add(node.GetId(), streamID, control)
+				registered = true
+			}
 
 			// type URL is required for ADS but is implicit for any other xDS stream
 			if defaultTypeURL == resource.AnyType {
@@ -155,9 +255,18 @@ func (s *server) processDelta(str stream.DeltaStream, reqCh <-chan *discovery.De
 			}
 
 			typeURL := req.GetTypeUrl()
+			streamMetrics.Request(typeURL, req.GetResponseNonce(), req.ErrorDetail != nil)
+			if req.ErrorDetail != nil {
+				logger.Warn("received NACK", log.Node(node.GetId()), log.TypeURL(typeURL),
+					log.Nonce(req.GetResponseNonce()), log.Any("error_detail", req.ErrorDetail.GetMessage()))
+			} else {
+				logger.Debug("received delta request", log.Node(node.GetId()), log.TypeURL(typeURL),
+					log.Nonce(req.GetResponseNonce()), log.Any("subscribe", req.GetResourceNamesSubscribe()),
+					log.Any("unsubscribe", req.GetResourceNamesUnsubscribe()))
+			}
 
 			// cancel existing watch to (re-)request a newer version
-			watch, ok := watches.deltaWatches[typeURL]
+			w, ok := watches.deltaWatches[typeURL]
 			if !ok {
 				// Initialize the state of the stream.
 				// Since there was no previous state, we know we're handling the first request of this type
@@ -165,28 +274,42 @@ func (s *server) processDelta(str stream.DeltaStream, reqCh <-chan *discovery.De
 				// We also set the stream as wildcard based on its legacy meaning (no resource name sent in resource_names_subscribe).
 				// If the state starts with this legacy mode, adding new resources will not unsubscribe from wildcard.
 				// It can still be done by explicitly unsubscribing from "*"
-				watch.state = stream.NewStreamState(len(req.GetResourceNamesSubscribe()) == 0, req.GetInitialResourceVersions())
+				w.state = stream.NewStreamState(len(req.GetResourceNamesSubscribe()) == 0, req.GetInitialResourceVersions())
 			} else {
-				watch.Cancel()
+				w.Cancel()
 			}
 
-			s.subscribe(req.GetResourceNamesSubscribe(), &watch.state)
-			s.unsubscribe(req.GetResourceNamesUnsubscribe(), &watch.state)
-
-			watch.responses = make(chan cache.DeltaResponse, 1)
-			watch.cancel = s.cache.CreateDeltaWatch(req, watch.state, watch.responses)
-			watches.deltaWatches[typeURL] = watch
+			s.subscribe(req.GetResourceNamesSubscribe(), &w.state)
+			s.unsubscribe(req.GetResourceNamesUnsubscribe(), &w.state)
+			observer.request(req, w.state)
 
-			go func() {
-				resp, more := <-watch.responses
-				if more {
-					watches.deltaMuxedResponses <- resp
-				}
-			}()
+			w.request = req
+			openWatch(typeURL, w)
 		}
 	}
 }
 
+// TerminateNode ends the streams of a node with a status. It returns the
+// number of streams ended.
+func (s *server) TerminateNode(node string, st *status.Status) int {
+	controls := s.controls.Node(node)
+	for _, control := range controls {
+		control.Terminate(st)
+	}
+	return len(controls)
+}
+
+// RepushNode makes the streams of a node push the current resources of all
+// the types they watch, whatever the node has. It returns the number of
+// streams.
+func (s *server) RepushNode(node string) int {
+	controls := s.controls.Node(node)
+	for _, control := range controls {
+		control.Repush()
+	}
+	return len(controls)
+}
+
 func (s *server) DeltaStreamHandler(str stream.DeltaStream, typeURL string) error {
 	// a channel for receiving incoming delta requests
 	reqCh := make(chan *discovery.DeltaDiscoveryRequest)
@@ -1,6 +1,7 @@
 package delta
 
 import (
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
 	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
 	"github.com/envoyproxy/go-control-plane/pkg/cache/v3"
 	"github.com/envoyproxy/go-control-plane/pkg/server/stream/v3"
@@ -36,6 +37,9 @@ type watch struct {
 	cancel    func()
 	nonce     string
 
+	// request is the last request of the type, to open the watch again on a re-push
+	request *discovery.DeltaDiscoveryRequest
+
 	state stream.StreamState
 }
 
@@ -0,0 +1,235 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+// Package events reports the lifecycle of xDS streams as typed events: the
+// streams opening and closing, the resources pushed to them, and the ACKs,
+// NACKs and unsubscriptions of the clients. Unlike the server callbacks, the
+// events are classified by the servers, which match the nonces of the
+// requests with the responses they sent.
+package events
+
+import (
+	"context"
+
+	statuspb "google.golang.org/genproto/googleapis/rpc/status"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+)
+
+// Stream identifies the stream of an event.
+type Stream struct {
+	// ID is the ID of the stream, as given to the server callbacks.
+	ID int64
+
+	// Node is the node of the stream, as sent in its first request.
+	Node *core.Node
+
+	// TypeURL is the type served by the stream, or resource.AnyType for ADS.
+	TypeURL string
+
+	// Delta is set for incremental xDS streams.
+	Delta bool
+}
+
+// ADS returns whether the stream is an aggregated stream serving all types.
+func (s Stream) ADS() bool {
+	return s.TypeURL == resource.AnyType
+}
+
+// Push describes a response sent on a stream.
+type Push struct {
+	TypeURL string
+
+	// Version is the version of the response, or its system version for
+	// delta xDS.
+	Version string
+
+	Nonce string
+
+	// NumResources is the number of resources in the response.
+	NumResources int
+
+	// Removed are the names of the resources removed by a delta response.
+	Removed []string
+}
+
+// Observer is notified of the events of the streams of a server. The context
+// of the events is the context of the stream, which holds e.g. its peer and
+// metadata. The events of a stream are reported sequentially, from the
+// goroutine serving the stream, and the observer must not block.
+type Observer interface {
+	// StreamOpened is called when the first request of a stream is received,
+	// which holds the node of the stream.
+	StreamOpened(ctx context.Context, stream Stream)
+
+	// StreamClosed is called when a stream that was reported opened is closed.
+	StreamClosed(ctx context.Context, stream Stream)
+
+	// Requested is called for each request of a stream with the resource
+	// names it is subscribed to for the type, none meaning all of them for
+	// SOTW. For delta xDS, the names are those subscribed so far and a
+	// wildcard subscription is reported as "*".
+	Requested(ctx context.Context, stream Stream, typeURL string, names []string)
+
+	// ResourcesPushed is called once a response is sent on a stream.
+	ResourcesPushed(ctx context.Context, stream Stream, push Push)
+
+	// Acked is called when the client accepts a response, with the version
+	// of the response.
+	Acked(ctx context.Context, stream Stream, typeURL, version string)
+
+	// Nacked is called when the client rejects a response, with the version
+	// of the rejected response and the error reported by the client.
+	Nacked(ctx context.Context, stream Stream, typeURL, version string, errorDetail *statuspb.Status)
+
+	// Unsubscribed is called when the client stops requesting resources: the
+	// names removed from the request of a type for SOTW, or explicitly
+	// unsubscribed for delta xDS.
+	Unsubscribed(ctx context.Context, stream Stream, typeURL string, names []string)
+}
+
+// ObserverFactory is implemented by the config watchers observing the streams
+// of the servers they are given to, such as the snapshot cache. The SOTW and
+// delta servers each ask for an observer of their own when created, as the
+// IDs of the streams are only unique within a server.
+type ObserverFactory interface {
+	// NewObserver returns an observer for the streams of a new server.
+	NewObserver() Observer
+}
+
+// Funcs is a convenience type for implementing the Observer interface, with
+// only the events of interest.
+type Funcs struct {
+	StreamOpenedFunc    func(context.Context, Stream)
+	StreamClosedFunc    func(context.Context, Stream)
+	RequestedFunc       func(context.Context, Stream, string, []string)
+	ResourcesPushedFunc func(context.Context, Stream, Push)
+	AckedFunc           func(context.Context, Stream, string, string)
+	NackedFunc          func(context.Context, Stream, string, string, *statuspb.Status)
+	UnsubscribedFunc    func(context.Context, Stream, string, []string)
+}
+
+var _ Observer = Funcs{}
+
+// StreamOpened invokes StreamOpenedFunc.
+func (f Funcs) StreamOpened(ctx context.Context, stream Stream) {
+	if f.StreamOpenedFunc != nil {
+		f.StreamOpenedFunc(ctx, stream)
+	}
+}
+
+// StreamClosed invokes StreamClosedFunc.
+func (f Funcs) StreamClosed(ctx context.Context, stream Stream) {
+	if f.StreamClosedFunc != nil {
+		f.StreamClosedFunc(ctx, stream)
+	}
+}
+
+// Requested invokes RequestedFunc.
+func (f Funcs) Requested(ctx context.Context, stream Stream, typeURL string, names []string) {
+	if f.RequestedFunc != nil {
+		f.RequestedFunc(ctx, stream, typeURL, names)
+	}
+}
+
+// ResourcesPushed invokes ResourcesPushedFunc.
+func (f Funcs) ResourcesPushed(ctx context.Context, stream Stream, push Push) {
+	if f.ResourcesPushedFunc != nil {
+		f.ResourcesPushedFunc(ctx, stream, push)
+	}
+}
+
+// Acked invokes AckedFunc.
+func (f Funcs) Acked(ctx context.Context, stream Stream, typeURL, version string) {
+	if f.AckedFunc != nil {
+		f.AckedFunc(ctx, stream, typeURL, version)
+	}
+}
+
+// Nacked invokes NackedFunc.
+func (f Funcs) Nacked(ctx context.Context, stream Stream, typeURL, version string, errorDetail *statuspb.Status) {
+	if f.NackedFunc != nil {
+		f.NackedFunc(ctx, stream, typeURL, version, errorDetail)
+	}
+}
+
+// Unsubscribed invokes UnsubscribedFunc.
+func (f Funcs) Unsubscribed(ctx context.Context, stream Stream, typeURL string, names []string) {
+	if f.UnsubscribedFunc != nil {
+		f.UnsubscribedFunc(ctx, stream, typeURL, names)
+	}
+}
+
+// Multi returns an observer notifying each of observers in order, skipping
+// nil ones. It returns nil if there are none.
+func Multi(observers ...Observer) Observer {
+	out := make(multi, 0, len(observers))
+	for _, o := range observers {
+		if o != nil {
+			out = append(out, o)
+		}
+	}
+	switch len(out) {
+	case 0:
+		return nil
+	case 1:
+		return out[0]
+	}
+	return out
+}
+
+type multi []Observer
+
+func (m multi) StreamOpened(ctx context.Context, stream Stream) {
+	for _, o := range m {
+		o.StreamOpened(ctx, stream)
+	}
+}
+
+func (m multi) StreamClosed(ctx context.Context, stream Stream) {
+	for _, o := range m {
+		o.StreamClosed(ctx, stream)
+	}
+}
+
+func (m multi) Requested(ctx context.Context, stream Stream, typeURL string, names []string) {
+	for _, o := range m {
+		o.Requested(ctx, stream, typeURL, names)
+	}
+}
+
+func (m multi) ResourcesPushed(ctx context.Context, stream Stream, push Push) {
+	for _, o := range m {
+		o.ResourcesPushed(ctx, stream, push)
+	}
+}
+
+func (m multi) Acked(ctx context.Context, stream Stream, typeURL, version string) {
+	for _, o := range m {
+		o.Acked(ctx, stream, typeURL, version)
+	}
+}
+
+func (m multi) Nacked(ctx context.Context, stream Stream, typeURL, version string, errorDetail *statuspb.Status) {
+	for _, o := range m {
+		o.Nacked(ctx, stream, typeURL, version, errorDetail)
+	}
+}
+
+func (m multi) Unsubscribed(ctx context.Context, stream Stream, typeURL string, names []string) {
+	for _, o := range m {
+		o.Unsubscribed(ctx, stream, typeURL, names)
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package events_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/envoyproxy/go-control-plane/pkg/server/events/v3"
+)
+
+func TestNonces(t *testing.T) {
+	var n events.Nonces
+	_, ok := n.Match("type", "")
+	assert.False(t, ok)
+
+	n.Sent("type", "1", "v1")
+	n.Sent("other", "2", "v2")
+	n.Sent("type", "3", "v3")
+	n.Sent("type", "4", "v4")
+	assert.Equal(t, 3, n.Pending("type"))
+
+	// The response of another type does not match.
+	_, ok = n.Match("type", "2")
+	assert.False(t, ok)
+
+	// Answering a response forgets those sent before it.
+	version, ok := n.Match("type", "3")
+	assert.True(t, ok)
+	assert.Equal(t, "v3", version)
+	assert.Equal(t, 1, n.Pending("type"))
+	_, ok = n.Match("type", "1")
+	assert.False(t, ok)
+
+	version, ok = n.Match("type", "4")
+	assert.True(t, ok)
+	assert.Equal(t, "v4", version)
+	assert.Equal(t, 0, n.Pending("type"))
+	assert.Equal(t, 1, n.Pending("other"))
+}
+
+func TestMulti(t *testing.T) {
+	assert.Nil(t, events.Multi(nil, nil))
+
+	var got []string
+	record := func(name string) events.Observer {
+		return events.Funcs{
+			AckedFunc: func(_ context.Context, _ events.Stream, _, version string) {
+				got = append(got, name+" "+version)
+			},
+		}
+	}
+	events.Multi(record("a"), nil, record("b")).Acked(context.Background(), events.Stream{}, "type", "v1")
+	assert.Equal(t, []string{"a v1", "b v1"}, got)
+}
@@ -0,0 +1,64 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package events
+
+// Nonces matches the nonces of the requests of a stream with the responses
+// sent on it, to tell which response a request acknowledges or rejects.
+// The zero value is ready to use. Nonces is not safe for concurrent use.
+type Nonces struct {
+	// sent holds the responses of each type not acknowledged yet, in the
+	// order they were sent.
+	sent map[string][]sent
+}
+
+type sent struct {
+	nonce   string
+	version string
+}
+
+// Sent records a response sent for a type.
+func (n *Nonces) Sent(typeURL, nonce, version string) {
+	if n.sent == nil {
+		n.sent = make(map[string][]sent)
+	}
+	n.sent[typeURL] = append(n.sent[typeURL], sent{nonce: nonce, version: version})
+}
+
+// Match returns the version of the response of a type with the nonce of a
+// request, and whether there is one. The request answers the response: the
+// response and those sent before it, which the client has processed, are
+// forgotten.
+func (n *Nonces) Match(typeURL, nonce string) (string, bool) {
+	if nonce == "" {
+		return "", false
+	}
+	pending := n.sent[typeURL]
+	for i, s := range pending {
+		if s.nonce == nonce {
+			if i == len(pending)-1 {
+				delete(n.sent, typeURL)
+			} else {
+				n.sent[typeURL] = pending[i+1:]
+			}
+			return s.version, true
+		}
+	}
+	return "", false
+}
+
+// Pending returns the number of responses of a type not answered yet.
+func (n *Nonces) Pending(typeURL string) int {
+	return len(n.sent[typeURL])
+}
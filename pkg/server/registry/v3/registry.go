@@ -0,0 +1,212 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+// Package registry keeps track of the streams connected to the xDS servers,
+// to tell which nodes are connected and what they have.
+package registry
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	statuspb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/peer"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/metrics"
+	"github.com/envoyproxy/go-control-plane/pkg/server/events/v3"
+)
+
+// StreamInfo describes a stream connected to a server.
+type StreamInfo struct {
+	// ID is the ID of the stream, as given to the server callbacks.
+	ID int64
+
+	// Node is the node of the stream.
+	Node *core.Node
+
+	// Peer is the address of the client, if known.
+	Peer string
+
+	// Protocol is metrics.ProtocolSOTW or metrics.ProtocolDelta.
+	Protocol string
+
+	// ADS is set for aggregated streams serving all types.
+	ADS bool
+
+	// ConnectedAt is the time the first request of the stream was received.
+	ConnectedAt time.Time
+
+	// Subscriptions are the resource names requested for each type, none
+	// meaning all of them for SOTW and "*" a wildcard subscription for delta.
+	Subscriptions map[string][]string
+
+	// AckedVersions are the versions of the last response the client
+	// accepted for each type.
+	AckedVersions map[string]string
+}
+
+// Registry records the streams connected to a server. It is an
+// events.Observer, and is fed by the server it is given to with
+// config.WithObserver:
+//
+//	reg := registry.New()
+//	srv := server.NewServer(ctx, snapshotCache, callbacks, config.WithObserver(reg))
+//
+// A registry must be given to a single server, whose SOTW and delta streams
+// are told apart.
+type Registry struct {
+	mu      sync.RWMutex
+	streams map[streamKey]*StreamInfo
+}
+
+type streamKey struct {
+	id    int64
+	delta bool
+}
+
+var _ events.Observer = &Registry{}
+
+// New returns an empty registry.
+func New() *Registry {
+	return &Registry{
+		streams: make(map[streamKey]*StreamInfo),
+	}
+}
+
+// Nodes returns the IDs of the connected nodes, sorted.
+func (r *Registry) Nodes() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	seen := make(map[string]struct{})
+	out := []string{}
+	for _, info := range r.streams {
+		id := info.Node.GetId()
+		if _, ok := seen[id]; !ok {
+			seen[id] = struct{}{}
+			out = append(out, id)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Streams returns the connected streams of all nodes, sorted by protocol and
+// stream ID.
+func (r *Registry) Streams() []StreamInfo {
+	return r.filter(func(*StreamInfo) bool { return true })
+}
+
+// NodeStreams returns the connected streams of a node, sorted by protocol and
+// stream ID.
+func (r *Registry) NodeStreams(node string) []StreamInfo {
+	return r.filter(func(info *StreamInfo) bool { return info.Node.GetId() == node })
+}
+
+func (r *Registry) filter(keep func(*StreamInfo) bool) []StreamInfo {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	out := []StreamInfo{}
+	for _, info := range r.streams {
+		if keep(info) {
+			out = append(out, info.clone())
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Protocol != out[j].Protocol {
+			return out[i].Protocol > out[j].Protocol
+		}
+		return out[i].ID < out[j].ID
+	})
+	return out
+}
+
+func (info *StreamInfo) clone() StreamInfo {
+	out := *info
+	out.Subscriptions = make(map[string][]string, len(info.Subscriptions))
+	for typeURL, names := range info.Subscriptions {
+		out.Subscriptions[typeURL] = append([]string(nil), names...)
+	}
+	out.AckedVersions = make(map[string]string, len(info.AckedVersions))
+	for typeURL, version := range info.AckedVersions {
+		out.AckedVersions[typeURL] = version
+	}
+	return out
+}
+
+// update applies f to the info of a registered stream.
+func (r *Registry) update(stream events.Stream, f func(*StreamInfo)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if info, ok := r.streams[streamKey{id: stream.ID, delta: stream.Delta}]; ok {
+		f(info)
+	}
+}
+
+// StreamOpened registers a stream.
+func (r *Registry) StreamOpened(ctx context.Context, stream events.Stream) {
+	info := &StreamInfo{
+		ID:            stream.ID,
+		Node:          stream.Node,
+		Protocol:      metrics.ProtocolSOTW,
+		ADS:           stream.ADS(),
+		ConnectedAt:   time.Now(),
+		Subscriptions: make(map[string][]string),
+		AckedVersions: make(map[string]string),
+	}
+	if stream.Delta {
+		info.Protocol = metrics.ProtocolDelta
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		info.Peer = p.Addr.String()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.streams[streamKey{id: stream.ID, delta: stream.Delta}] = info
+}
+
+// StreamClosed unregisters a stream.
+func (r *Registry) StreamClosed(_ context.Context, stream events.Stream) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.streams, streamKey{id: stream.ID, delta: stream.Delta})
+}
+
+// Requested records the subscriptions of a stream.
+func (r *Registry) Requested(_ context.Context, stream events.Stream, typeURL string, names []string) {
+	r.update(stream, func(info *StreamInfo) {
+		info.Subscriptions[typeURL] = append([]string(nil), names...)
+	})
+}
+
+// ResourcesPushed does nothing, the registry records what the clients accept.
+func (r *Registry) ResourcesPushed(context.Context, events.Stream, events.Push) {}
+
+// Acked records the version accepted by a stream.
+func (r *Registry) Acked(_ context.Context, stream events.Stream, typeURL, version string) {
+	r.update(stream, func(info *StreamInfo) {
+		info.AckedVersions[typeURL] = version
+	})
+}
+
+// Nacked does nothing, the stream keeps the version it accepted last.
+func (r *Registry) Nacked(context.Context, events.Stream, string, string, *statuspb.Status) {}
+
+// Unsubscribed does nothing, the subscriptions are recorded by Requested.
+func (r *Registry) Unsubscribed(context.Context, events.Stream, string, []string) {}
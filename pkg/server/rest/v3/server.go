@@ -18,9 +18,17 @@ package rest
 import (
 	"context"
 	"errors"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/protobuf/proto"
 
 	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
 	"github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/log"
+	"github.com/envoyproxy/go-control-plane/pkg/metrics"
+	"github.com/envoyproxy/go-control-plane/pkg/server/config"
+	"github.com/envoyproxy/go-control-plane/pkg/tracing"
 )
 
 type Server interface {
@@ -35,16 +43,38 @@ type Callbacks interface {
 	OnFetchResponse(*discovery.DiscoveryRequest, *discovery.DiscoveryResponse)
 }
 
-func NewServer(config cache.ConfigFetcher, callbacks Callbacks) Server {
-	return &server{cache: config, callbacks: callbacks}
+func NewServer(cf cache.ConfigFetcher, callbacks Callbacks, opts ...config.XDSOption) Server {
+	o := config.NewOpts(opts...)
+	return &server{
+		cache:     cf,
+		callbacks: callbacks,
+		metrics:   metrics.NewServerMetrics(o.Metrics),
+		tracer:    tracing.Tracer(o.TracerProvider),
+		log:       log.OrDiscard(o.Logger),
+	}
 }
 
 type server struct {
 	cache     cache.ConfigFetcher
 	callbacks Callbacks
+	metrics   *metrics.ServerMetrics
+	tracer    trace.Tracer
+	log       log.StructuredLogger
 }
 
-func (s *server) Fetch(ctx context.Context, req *discovery.DiscoveryRequest) (*discovery.DiscoveryResponse, error) {
+func (s *server) Fetch(ctx context.Context, req *discovery.DiscoveryRequest) (out *discovery.DiscoveryResponse, err error) {
+	ctx, span := s.tracer.Start(ctx, "Fetch", trace.WithAttributes(
+		tracing.ProtocolKey.String(metrics.ProtocolREST),
+		tracing.NodeKey.String(req.GetNode().GetId()),
+		tracing.TypeURLKey.String(req.GetTypeUrl()),
+	))
+	defer func() {
+		tracing.End(span, err)
+	}()
+
+	s.metrics.Request(metrics.ProtocolREST, req.GetTypeUrl(), req.ErrorDetail != nil)
+	s.log.Debug("received fetch request", log.Node(req.GetNode().GetId()), log.TypeURL(req.GetTypeUrl()),
+		log.Version(req.GetVersionInfo()), log.Resources(req.GetResourceNames()))
 	if s.callbacks != nil {
 		if err := s.callbacks.OnFetchRequest(ctx, req); err != nil {
 			return nil, err
@@ -57,9 +87,17 @@ func (s *server) Fetch(ctx context.Context, req *discovery.DiscoveryRequest) (*d
 	if resp == nil {
 		return nil, errors.New("missing response")
 	}
-	out, err := resp.GetDiscoveryResponse()
+	start := time.Now()
+	_, marshal := s.tracer.Start(ctx, "Marshal")
+	out, err = resp.GetDiscoveryResponse()
+	tracing.End(marshal, err)
 	if s.callbacks != nil {
 		s.callbacks.OnFetchResponse(req, out)
 	}
+	if err == nil {
+		s.metrics.Response(metrics.ProtocolREST, req.GetTypeUrl(), proto.Size(out), start)
+		s.log.Debug("send fetch response", log.Node(req.GetNode().GetId()), log.TypeURL(out.TypeUrl),
+			log.Version(out.VersionInfo), log.Any("num_resources", len(out.Resources)))
+	}
 	return out, err
 }
@@ -0,0 +1,108 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sotw
+
+import (
+	"context"
+
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/server/events/v3"
+)
+
+// streamObserver reports the events of a stream to the observer of the
+// server. A nil streamObserver reports nothing.
+type streamObserver struct {
+	observer events.Observer
+	ctx      context.Context
+	stream   events.Stream
+	nonces   events.Nonces
+
+	// names are the resource names last requested for each type.
+	names map[string][]string
+}
+
+func newStreamObserver(ctx context.Context, observer events.Observer, streamID int64, typeURL string) *streamObserver {
+	if observer == nil {
+		return nil
+	}
+	return &streamObserver{
+		observer: observer,
+		ctx:      ctx,
+		stream:   events.Stream{ID: streamID, TypeURL: typeURL},
+		names:    make(map[string][]string),
+	}
+}
+
+// request reports the events of a request, whose node and type are set.
+func (o *streamObserver) request(req *discovery.DiscoveryRequest) {
+	if o == nil {
+		return
+	}
+	if o.stream.Node == nil {
+		o.stream.Node = req.Node
+		o.observer.StreamOpened(o.ctx, o.stream)
+	}
+
+	typeURL := req.TypeUrl
+	if version, ok := o.nonces.Match(typeURL, req.ResponseNonce); ok {
+		if req.ErrorDetail != nil {
+			o.observer.Nacked(o.ctx, o.stream, typeURL, version, req.ErrorDetail)
+		} else {
+			o.observer.Acked(o.ctx, o.stream, typeURL, version)
+		}
+	}
+
+	// An empty list of names may be a wildcard request, which is not
+	// reported as an unsubscription.
+	if len(req.ResourceNames) > 0 {
+		requested := make(map[string]struct{}, len(req.ResourceNames))
+		for _, name := range req.ResourceNames {
+			requested[name] = struct{}{}
+		}
+		var removed []string
+		for _, name := range o.names[typeURL] {
+			if _, ok := requested[name]; !ok {
+				removed = append(removed, name)
+			}
+		}
+		if len(removed) > 0 {
+			o.observer.Unsubscribed(o.ctx, o.stream, typeURL, removed)
+		}
+	}
+	o.names[typeURL] = req.ResourceNames
+	o.observer.Requested(o.ctx, o.stream, typeURL, req.ResourceNames)
+}
+
+// pushed reports a response sent on the stream.
+func (o *streamObserver) pushed(out *discovery.DiscoveryResponse) {
+	if o == nil {
+		return
+	}
+	o.nonces.Sent(out.TypeUrl, out.Nonce, out.VersionInfo)
+	o.observer.ResourcesPushed(o.ctx, o.stream, events.Push{
+		TypeURL:      out.TypeUrl,
+		Version:      out.VersionInfo,
+		Nonce:        out.Nonce,
+		NumResources: len(out.Resources),
+	})
+}
+
+// close reports the closing of the stream, if it was reported opened.
+func (o *streamObserver) close() {
+	if o == nil || o.stream.Node == nil {
+		return
+	}
+	o.observer.StreamClosed(o.ctx, o.stream)
+}
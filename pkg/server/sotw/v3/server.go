@@ -21,15 +21,23 @@ import (
 	"reflect"
 	"strconv"
 	"sync/atomic"
+	"time"
 
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 
 	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
 	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
 	"github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/log"
+	"github.com/envoyproxy/go-control-plane/pkg/metrics"
 	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/server/config"
+	"github.com/envoyproxy/go-control-plane/pkg/server/events/v3"
 	"github.com/envoyproxy/go-control-plane/pkg/server/stream/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/tracing"
 )
 
 type Server interface {
@@ -50,14 +58,38 @@ type Callbacks interface {
 }
 
 // NewServer creates handlers from a config watcher and callbacks.
-func NewServer(ctx context.Context, config cache.ConfigWatcher, callbacks Callbacks) Server {
-	return &server{cache: config, callbacks: callbacks, ctx: ctx}
+func NewServer(ctx context.Context, cw cache.ConfigWatcher, callbacks Callbacks, opts ...config.XDSOption) Server {
+	o := config.NewOpts(opts...)
+
+	// A cache keeping track of the versions applied by the clients is told
+	// of their ACKs and NACKs.
+	observers := o.Observers
+	if factory, ok := cw.(events.ObserverFactory); ok {
+		observers = append(observers[:len(observers):len(observers)], factory.NewObserver())
+	}
+
+	return &server{
+		cache:     cw,
+		callbacks: callbacks,
+		ctx:       ctx,
+		metrics:   metrics.NewServerMetrics(o.Metrics),
+		tracer:    tracing.Tracer(o.TracerProvider),
+		log:       log.OrDiscard(o.Logger),
+		observer:  events.Multi(observers...),
+	}
 }
 
 type server struct {
 	cache     cache.ConfigWatcher
 	callbacks Callbacks
 	ctx       context.Context
+	metrics   *metrics.ServerMetrics
+	tracer    trace.Tracer
+	log       log.StructuredLogger
+	observer  events.Observer
+
+	// controls of the streams by node, see TerminateNode and RepushNode
+	controls stream.Controls
 
 	// streamCount for counting bi-di streams
 	streamCount int64
@@ -84,26 +116,63 @@ func (s *server) process(str stream.Stream, reqCh <-chan *discovery.DiscoveryReq
 	streamState := stream.NewStreamState(false, map[string]string{})
 	lastDiscoveryResponses := map[string]lastDiscoveryResponse{}
 
+	// the last request of each type, to open the watches again on a re-push,
+	// and the types re-pushed but not sent yet
+	lastRequests := map[string]*discovery.DiscoveryRequest{}
+	repushing := map[string]bool{}
+
+	// control is registered for the node of the stream once known
+	control := stream.NewControl(s.ctx)
+	registered := false
+
 	// a collection of stack allocated watches per request type
 	watches := newWatches()
 
 	// node may only be set on the first discovery request
 	var node = &core.Node{}
 
+	streamMetrics := s.metrics.OpenStream(metrics.ProtocolSOTW)
+	logger := s.log.With(log.StreamID(streamID))
+	observer := newStreamObserver(str.Context(), s.observer, streamID, defaultTypeURL)
+
 	defer func() {
 		watches.close()
+		streamMetrics.Close()
+		observer.close()
+		if registered {
+			s.controls.Remove(node.GetId(), streamID)
+		}
+		control.Cancel()
+		logger.Debug("close stream", log.Node(node.GetId()))
 		if s.callbacks != nil {
 			s.callbacks.OnStreamClosed(streamID, node)
 		}
 	}()
 
 	// sends a response by serializing to protobuf Any
-	send := func(resp cache.Response) (string, error) {
+	send := func(resp cache.Response) (nonce string, err error) {
 		if resp == nil {
 			return "", errors.New("missing response")
 		}
 
+		ctx := resp.GetContext()
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		ctx, span := s.tracer.Start(ctx, "Send", trace.WithAttributes(
+			tracing.ProtocolKey.String(metrics.ProtocolSOTW),
+			tracing.StreamIDKey.Int64(streamID),
+			tracing.NodeKey.String(node.GetId()),
+			tracing.TypeURLKey.String(resp.GetRequest().GetTypeUrl()),
+		))
+		defer func() {
+			tracing.End(span, err)
+		}()
+
+		start := time.Now()
+		_, marshal := s.tracer.Start(ctx, "Marshal")
 		out, err := resp.GetDiscoveryResponse()
+		tracing.End(marshal, err)
 		if err != nil {
 			return "", err
 		}
@@ -111,6 +180,7 @@ func (s *server) process(str stream.Stream, reqCh <-chan *discovery.DiscoveryReq
 		// increment nonce
 		streamNonce = streamNonce + 1
 		out.Nonce = strconv.FormatInt(streamNonce, 10)
+		span.SetAttributes(tracing.VersionKey.String(out.VersionInfo), tracing.NonceKey.String(out.Nonce))
 
 		lastResponse := lastDiscoveryResponse{
 			nonce:     out.Nonce,
@@ -124,7 +194,14 @@ func (s *server) process(str stream.Stream, reqCh <-chan *discovery.DiscoveryReq
 		if s.callbacks != nil {
 			s.callbacks.OnStreamResponse(resp.GetContext(), streamID, resp.GetRequest(), out)
 		}
-		return out.Nonce, str.Send(out)
+		if err := str.Send(out); err != nil {
+			return "", err
+		}
+		streamMetrics.Response(out.TypeUrl, out.Nonce, proto.Size(out), start)
+		observer.pushed(out)
+		logger.Debug("send response", log.Node(node.GetId()), log.TypeURL(out.TypeUrl),
+			log.Version(out.VersionInfo), log.Nonce(out.Nonce), log.Any("num_resources", len(out.Resources)))
+		return out.Nonce, nil
 	}
 
 	if s.callbacks != nil {
@@ -132,20 +209,23 @@ func (s *server) process(str stream.Stream, reqCh <-chan *discovery.DiscoveryReq
 			return err
 		}
 	}
+	logger.Debug("open stream", log.TypeURL(defaultTypeURL))
 
 	// recompute dynamic channels for this stream
-	watches.recompute(s.ctx, reqCh)
+	watches.recompute(control.Context(), reqCh, control.Repushes())
 
 	for {
 		// The list of select cases looks like this:
 		// 0: <- ctx.Done
 		// 1: <- reqCh
-		// 2...: per type watches
+		// 2: <- repush
+		// 3...: per type watches
 		index, value, ok := reflect.Select(watches.cases)
 		switch index {
-		// ctx.Done() -> if we receive a value here we return as no further computation is needed
+		// ctx.Done() -> if we receive a value here we return as no further computation is needed,
+		// with the status the stream was terminated with, if any
 		case 0:
-			return nil
+			return control.Err()
 		// Case 1 handles any request inbound on the stream and handles all initialization as needed
 		case 1:
 			// input stream ended or errored out
@@ -164,6 +244,10 @@ func (s *server) process(str stream.Stream, reqCh <-chan *discovery.DiscoveryReq
 			} else {
 				req.Node = node
 			}
+			if !registered {
+				s.controls.Add(node.GetId(), streamID, control)
+				registered = true
+			}
 
 			// nonces can be reused across streams; we verify nonce only if nonce is not initialized
 			nonce := req.GetResponseNonce()
@@ -177,11 +261,21 @@ func (s *server) process(str stream.Stream, reqCh <-chan *discovery.DiscoveryReq
 				req.TypeUrl = defaultTypeURL
 			}
 
+			streamMetrics.Request(req.TypeUrl, nonce, req.ErrorDetail != nil)
+			if req.ErrorDetail != nil {
+				logger.Warn("received NACK", log.Node(node.GetId()), log.TypeURL(req.TypeUrl),
+					log.Version(req.VersionInfo), log.Nonce(nonce), log.Any("error_detail", req.ErrorDetail.GetMessage()))
+			} else {
+				logger.Debug("received request", log.Node(node.GetId()), log.TypeURL(req.TypeUrl),
+					log.Version(req.VersionInfo), log.Nonce(nonce), log.Resources(req.ResourceNames))
+			}
+
 			if s.callbacks != nil {
 				if err := s.callbacks.OnStreamRequest(streamID, req); err != nil {
 					return err
 				}
 			}
+			observer.request(req)
 
 			if lastResponse, ok := lastDiscoveryResponses[req.TypeUrl]; ok {
 				if lastResponse.nonce == "" || lastResponse.nonce == nonce {
@@ -191,6 +285,12 @@ func (s *server) process(str stream.Stream, reqCh <-chan *discovery.DiscoveryReq
 			}
 
 			typeURL := req.GetTypeUrl()
+			lastRequests[typeURL] = req
+			watchReq := req
+			if repushing[typeURL] {
+				// the re-push must not be lost to a request acknowledging the previous version
+				watchReq = repushRequest(req)
+			}
 			responder := make(chan cache.Response, 1)
 			if w, ok := watches.responders[typeURL]; ok {
 				// We've found a pre-existing watch, lets check and update if needed.
@@ -199,7 +299,7 @@ func (s *server) process(str stream.Stream, reqCh <-chan *discovery.DiscoveryReq
 					w.close()
 
 					watches.addWatch(typeURL, &watch{
-						cancel:   s.cache.CreateWatch(req, streamState, responder),
+						cancel:   s.cache.CreateWatch(watchReq, streamState, responder),
 						response: responder,
 					})
 				}
@@ -207,13 +307,27 @@ func (s *server) process(str stream.Stream, reqCh <-chan *discovery.DiscoveryReq
 				// No pre-existing watch exists, let's create one.
 				// We need to precompute the watches first then open a watch in the cache.
 				watches.addWatch(typeURL, &watch{
-					cancel:   s.cache.CreateWatch(req, streamState, responder),
+					cancel:   s.cache.CreateWatch(watchReq, streamState, responder),
 					response: responder,
 				})
 			}
 
 			// Recompute the dynamic select cases for this stream.
-			watches.recompute(s.ctx, reqCh)
+			watches.recompute(control.Context(), reqCh, control.Repushes())
+		// Case 2 opens the watches of all types again, for the current resources whatever the client has
+		case 2:
+			for typeURL, req := range lastRequests {
+				if w, ok := watches.responders[typeURL]; ok {
+					w.close()
+				}
+				repushing[typeURL] = true
+				responder := make(chan cache.Response, 1)
+				watches.addWatch(typeURL, &watch{
+					cancel:   s.cache.CreateWatch(repushRequest(req), streamState, responder),
+					response: responder,
+				})
+			}
+			watches.recompute(control.Context(), reqCh, control.Repushes())
 		default:
 			// Channel n -> these are the dynamic list of responders that correspond to the stream request typeURL
 			if !ok {
@@ -228,10 +342,40 @@ func (s *server) process(str stream.Stream, reqCh <-chan *discovery.DiscoveryReq
 			}
 
 			watches.responders[res.GetRequest().TypeUrl].nonce = nonce
+			delete(repushing, res.GetRequest().TypeUrl)
 		}
 	}
 }
 
+// repushRequest returns a copy of a request for the current resources, whatever
+// the version the client has.
+func repushRequest(req *discovery.DiscoveryRequest) *discovery.DiscoveryRequest {
+	out := proto.Clone(req).(*discovery.DiscoveryRequest)
+	out.VersionInfo = ""
+	return out
+}
+
+// TerminateNode ends the streams of a node with a status. It returns the
+// number of streams ended.
+func (s *server) TerminateNode(node string, st *status.Status) int {
+	controls := s.controls.Node(node)
+	for _, control := range controls {
+		control.Terminate(st)
+	}
+	return len(controls)
+}
+
+// RepushNode makes the streams of a node push the current resources of all
+// the types they watch, whatever the node has. It returns the number of
+// streams.
+func (s *server) RepushNode(node string) int {
+	controls := s.controls.Node(node)
+	for _, control := range controls {
+		control.Repush()
+	}
+	return len(controls)
+}
+
 // StreamHandler converts a blocking read call to channels and initiates stream processing
 func (s *server) StreamHandler(stream stream.Stream, typeURL string) error {
 	// a channel for receiving incoming requests
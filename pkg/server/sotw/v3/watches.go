@@ -39,7 +39,7 @@ func (w *watches) close() {
 }
 
 // recomputeWatches rebuilds the known list of dynamic channels if needed
-func (w *watches) recompute(ctx context.Context, req <-chan *discovery.DiscoveryRequest) {
+func (w *watches) recompute(ctx context.Context, req <-chan *discovery.DiscoveryRequest, repush <-chan struct{}) {
 	w.cases = w.cases[:0] // Clear the existing cases while retaining capacity.
 
 	w.cases = append(w.cases,
@@ -49,6 +49,9 @@ func (w *watches) recompute(ctx context.Context, req <-chan *discovery.Discovery
 		}, reflect.SelectCase{
 			Dir:  reflect.SelectRecv,
 			Chan: reflect.ValueOf(req),
+		}, reflect.SelectCase{
+			Dir:  reflect.SelectRecv,
+			Chan: reflect.ValueOf(repush),
 		},
 	)
 
@@ -0,0 +1,129 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package stream
+
+import (
+	"context"
+	"sync"
+
+	"google.golang.org/grpc/status"
+)
+
+// Control lets the operations of a server on the nodes act on a stream
+// being served: ending it with a status, or making it push the current
+// resources again.
+type Control struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	repush chan struct{}
+
+	mu     sync.Mutex
+	status *status.Status
+}
+
+// NewControl returns the control of a stream served until parent is done.
+// Cancel must be called once the stream ends.
+func NewControl(parent context.Context) *Control {
+	ctx, cancel := context.WithCancel(parent)
+	return &Control{
+		ctx:    ctx,
+		cancel: cancel,
+		repush: make(chan struct{}, 1),
+	}
+}
+
+// Context is done when the stream must end, see Err.
+func (c *Control) Context() context.Context {
+	return c.ctx
+}
+
+// Terminate ends the stream with a status.
+func (c *Control) Terminate(st *status.Status) {
+	c.mu.Lock()
+	if c.status == nil {
+		c.status = st
+	}
+	c.mu.Unlock()
+	c.cancel()
+}
+
+// Err returns the error the stream must end with once its context is done:
+// the status given to Terminate, or nil if the server is shutting down.
+func (c *Control) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.status.Err()
+}
+
+// Repush asks the stream to push the current resources of all the types it
+// watches, whatever the client has. Requests made before the stream handles
+// the first one are merged.
+func (c *Control) Repush() {
+	select {
+	case c.repush <- struct{}{}:
+	default:
+	}
+}
+
+// Repushes receives the requests made with Repush.
+func (c *Control) Repushes() <-chan struct{} {
+	return c.repush
+}
+
+// Cancel releases the resources of the control.
+func (c *Control) Cancel() {
+	c.cancel()
+}
+
+// Controls holds the controls of the streams of a server by node ID. It is
+// safe for concurrent use.
+type Controls struct {
+	mu    sync.Mutex
+	nodes map[string]map[int64]*Control
+}
+
+// Add registers the control of a stream of a node.
+func (c *Controls) Add(node string, streamID int64, control *Control) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.nodes == nil {
+		c.nodes = make(map[string]map[int64]*Control)
+	}
+	if c.nodes[node] == nil {
+		c.nodes[node] = make(map[int64]*Control)
+	}
+	c.nodes[node][streamID] = control
+}
+
+// Remove unregisters the control of a stream of a node.
+func (c *Controls) Remove(node string, streamID int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.nodes[node], streamID)
+	if len(c.nodes[node]) == 0 {
+		delete(c.nodes, node)
+	}
+}
+
+// Node returns the controls of the streams of a node.
+func (c *Controls) Node(node string) []*Control {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make([]*Control, 0, len(c.nodes[node]))
+	for _, control := range c.nodes[node] {
+		out = append(out, control)
+	}
+	return out
+}
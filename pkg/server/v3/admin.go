@@ -0,0 +1,106 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// AdminHandler returns an HTTP handler for the operations of a node
+// controller, e.g. a Server returned by NewServer:
+//
+//	POST /terminate?node=<id>[&code=<gRPC code>][&message=<message>]
+//	POST /repush?node=<id>
+//
+// /terminate ends the streams of the node with a status, UNAVAILABLE by
+// default; the code is given by name, e.g. PERMISSION_DENIED, or number, and
+// must be an error code.
+// /repush pushes the current resources to the node. Both respond with the
+// number of streams of the node, as {"streams": n}.
+//
+// The handler performs no authentication or authorization: anyone reaching it
+// can disconnect any node. It is meant for an admin listener that only
+// operators can reach, or must be wrapped by a handler checking the
+// requests, and is mounted under a prefix with http.StripPrefix.
+func AdminHandler(c NodeController) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/terminate", func(w http.ResponseWriter, req *http.Request) {
+		node, ok := adminNode(w, req)
+		if !ok {
+			return
+		}
+		code := codes.Unavailable
+		if s := req.URL.Query().Get("code"); s != "" {
+			var ok bool
+			if code, ok = parseCode(s); !ok {
+				http.Error(w, fmt.Sprintf("invalid code %q", s), http.StatusBadRequest)
+				return
+			}
+		}
+		message := req.URL.Query().Get("message")
+		if message == "" {
+			message = "terminated by the control plane"
+		}
+		writeStreams(w, c.TerminateNode(node, status.New(code, message)))
+	})
+	mux.HandleFunc("/repush", func(w http.ResponseWriter, req *http.Request) {
+		node, ok := adminNode(w, req)
+		if !ok {
+			return
+		}
+		writeStreams(w, c.RepushNode(node))
+	})
+	return mux
+}
+
+// adminNode returns the node of an admin request, or responds with an error.
+func adminNode(w http.ResponseWriter, req *http.Request) (string, bool) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return "", false
+	}
+	node := req.URL.Query().Get("node")
+	if node == "" {
+		http.Error(w, "missing node", http.StatusBadRequest)
+		return "", false
+	}
+	return node, true
+}
+
+func writeStreams(w http.ResponseWriter, n int) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(struct {
+		Streams int `json:"streams"`
+	}{Streams: n})
+}
+
+// parseCode parses a gRPC error code given by name or number. OK, which would
+// end the streams without an error, and unknown codes are rejected.
+func parseCode(s string) (codes.Code, bool) {
+	var code codes.Code
+	if n, err := strconv.ParseUint(s, 10, 32); err == nil {
+		code = codes.Code(n)
+	} else if err := code.UnmarshalJSON([]byte(strconv.Quote(s))); err != nil {
+		return 0, false
+	}
+	return code, code != codes.OK && code <= codes.Unauthenticated
+}
@@ -0,0 +1,133 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package server_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	rsrc "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/server/v3"
+)
+
+// admin makes a request to the admin handler of s, and returns the status
+// code and body of the response.
+func admin(s server.Server, method, target string) (int, string) {
+	rec := httptest.NewRecorder()
+	server.AdminHandler(s.(server.NodeController)).ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+	return rec.Code, rec.Body.String()
+}
+
+func TestAdminTerminate(t *testing.T) {
+	cw := makeMockConfigWatcher()
+	cw.responses = makeResponses()
+	cw.deltaResources = makeDeltaResources()
+	s := server.NewServer(context.Background(), cw, nil)
+
+	resp := makeMockStream(t)
+	resp.recv <- &discovery.DiscoveryRequest{Node: node, TypeUrl: rsrc.ClusterType}
+	done := make(chan error, 2)
+	go func() {
+		done <- s.StreamAggregatedResources(resp)
+	}()
+	deltaResp := makeMockDeltaStream(t)
+	deltaResp.recv <- &discovery.DeltaDiscoveryRequest{Node: node, TypeUrl: rsrc.EndpointType}
+	go func() {
+		done <- s.DeltaAggregatedResources(deltaResp)
+	}()
+	<-resp.sent
+	<-deltaResp.sent
+
+	code, body := admin(s, http.MethodPost, "/terminate?node=other")
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"streams": 0}`, body)
+
+	code, body = admin(s, http.MethodPost, "/terminate?node="+node.GetId()+"&code=PERMISSION_DENIED&message=wedged")
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"streams": 2}`, body)
+	for i := 0; i < 2; i++ {
+		err := <-done
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+		assert.Equal(t, "wedged", status.Convert(err).Message())
+	}
+}
+
+func TestAdminRepush(t *testing.T) {
+	c := cache.NewSnapshotCache(false, cache.IDHash{}, nil)
+	snap, err := cache.NewSnapshot("1", map[rsrc.Type][]types.Resource{rsrc.ClusterType: {cluster}})
+	require.NoError(t, err)
+	require.NoError(t, c.SetSnapshot(context.Background(), node.GetId(), snap))
+	s := server.NewServer(context.Background(), c, nil)
+
+	resp := makeMockStream(t)
+	resp.recv <- &discovery.DiscoveryRequest{Node: node, TypeUrl: rsrc.ClusterType}
+	done := make(chan error, 2)
+	go func() {
+		done <- s.StreamClusters(resp)
+	}()
+	deltaResp := makeMockDeltaStream(t)
+	deltaResp.recv <- &discovery.DeltaDiscoveryRequest{Node: node, TypeUrl: rsrc.ClusterType}
+	go func() {
+		done <- s.DeltaClusters(deltaResp)
+	}()
+
+	// Once the responses are acknowledged, the streams are up to date.
+	sent := <-resp.sent
+	resp.recv <- &discovery.DiscoveryRequest{TypeUrl: rsrc.ClusterType, VersionInfo: sent.VersionInfo, ResponseNonce: sent.Nonce}
+	deltaSent := <-deltaResp.sent
+	deltaResp.recv <- &discovery.DeltaDiscoveryRequest{TypeUrl: rsrc.ClusterType, ResponseNonce: deltaSent.Nonce}
+
+	code, body := admin(s, http.MethodPost, "/repush?node="+node.GetId())
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"streams": 2}`, body)
+
+	sent = <-resp.sent
+	assert.Equal(t, "1", sent.VersionInfo)
+	assert.Equal(t, "2", sent.Nonce)
+	deltaSent = <-deltaResp.sent
+	assert.Equal(t, "2", deltaSent.Nonce)
+	require.Len(t, deltaSent.Resources, 1)
+	assert.Equal(t, cluster.Name, deltaSent.Resources[0].Name)
+
+	close(resp.recv)
+	close(deltaResp.recv)
+	require.NoError(t, <-done)
+	require.NoError(t, <-done)
+}
+
+func TestAdminErrors(t *testing.T) {
+	s := server.NewServer(context.Background(), makeMockConfigWatcher(), nil)
+
+	code, _ := admin(s, http.MethodGet, "/repush?node=node")
+	assert.Equal(t, http.StatusMethodNotAllowed, code)
+	code, _ = admin(s, http.MethodPost, "/repush")
+	assert.Equal(t, http.StatusBadRequest, code)
+	for _, invalid := range []string{"NOT_A_CODE", "OK", "0", "17"} {
+		code, _ = admin(s, http.MethodPost, "/terminate?node=node&code="+invalid)
+		assert.Equal(t, http.StatusBadRequest, code, invalid)
+	}
+	code, _ = admin(s, http.MethodPost, "/terminate?node=node&code=7")
+	assert.Equal(t, http.StatusOK, code)
+}
@@ -0,0 +1,219 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package server_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	statuspb "google.golang.org/genproto/googleapis/rpc/status"
+
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	rsrc "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/server/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/test/resource/v3"
+)
+
+func TestConvergence(t *testing.T) {
+	c := cache.NewSnapshotCache(false, cache.IDHash{}, nil)
+	setSnapshot := func(version string) {
+		// the cluster changes with the version for delta xDS to push it
+		cl := resource.MakeCluster(resource.Ads, clusterName)
+		cl.AltStatName = version
+		snap, err := cache.NewSnapshot(version, map[rsrc.Type][]types.Resource{rsrc.ClusterType: {cl}})
+		require.NoError(t, err)
+		require.NoError(t, c.SetSnapshot(context.Background(), node.GetId(), snap))
+	}
+	setSnapshot("1")
+	s := server.NewServer(context.Background(), c, nil)
+
+	resp := makeMockStream(t)
+	resp.recv <- &discovery.DiscoveryRequest{Node: node, TypeUrl: rsrc.ClusterType}
+	done := make(chan error, 1)
+	go func() {
+		done <- s.StreamClusters(resp)
+	}()
+	deltaResp := makeMockDeltaStream(t)
+	deltaResp.recv <- &discovery.DeltaDiscoveryRequest{Node: node, TypeUrl: rsrc.ClusterType}
+	go func() {
+		_ = s.DeltaClusters(deltaResp)
+	}()
+	sent := <-resp.sent
+	deltaSent := <-deltaResp.sent
+
+	// Without a server, a cache knows of no stream.
+	assert.NoError(t, cache.NewSnapshotCache(false, cache.IDHash{}, nil).(cache.ConvergenceTracker).Convergence(node.GetId()).Wait(context.Background()))
+	assert.Empty(t, c.(cache.ConvergenceTracker).Convergence("other").Report())
+
+	setSnapshot("2")
+	convergence := c.(cache.ConvergenceTracker).Convergence(node.GetId())
+	report := convergence.Report()
+	require.Len(t, report.Laggards, 2)
+	assert.Equal(t, node.GetId(), report.Laggards[0].Node)
+	assert.False(t, report.Laggards[0].Delta)
+	assert.True(t, report.Laggards[1].Delta)
+	assert.Equal(t, []string{rsrc.ClusterType}, report.Laggards[0].Pending)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := convergence.Wait(ctx)
+	var convergenceErr *cache.ConvergenceError
+	require.True(t, errors.As(err, &convergenceErr))
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Len(t, convergenceErr.Report.Laggards, 2)
+
+	// The SOTW stream applies the new version, the delta stream rejects it.
+	wait := make(chan error, 1)
+	go func() {
+		wait <- convergence.Wait(context.Background())
+	}()
+	resp.recv <- &discovery.DiscoveryRequest{TypeUrl: rsrc.ClusterType, VersionInfo: sent.VersionInfo, ResponseNonce: sent.Nonce}
+	deltaResp.recv <- &discovery.DeltaDiscoveryRequest{TypeUrl: rsrc.ClusterType, ResponseNonce: deltaSent.Nonce}
+	sent = <-resp.sent
+	assert.Equal(t, "2", sent.VersionInfo)
+	deltaSent = <-deltaResp.sent
+	resp.recv <- &discovery.DiscoveryRequest{TypeUrl: rsrc.ClusterType, VersionInfo: sent.VersionInfo, ResponseNonce: sent.Nonce}
+	deltaResp.recv <- &discovery.DeltaDiscoveryRequest{
+		TypeUrl:       rsrc.ClusterType,
+		ResponseNonce: deltaSent.Nonce,
+		ErrorDetail:   &statuspb.Status{Message: "invalid cluster"},
+	}
+
+	select {
+	case err = <-wait:
+	case <-time.After(5 * time.Second):
+		t.Fatal("convergence was not reached")
+	}
+	require.True(t, errors.As(err, &convergenceErr))
+	assert.False(t, errors.Is(err, context.Canceled))
+	report = convergenceErr.Report
+	require.Len(t, report.Converged, 1)
+	assert.False(t, report.Converged[0].Delta)
+	require.Len(t, report.Nackers, 1)
+	assert.True(t, report.Nackers[0].Delta)
+	assert.Equal(t, "invalid cluster", report.Nackers[0].Nacks[rsrc.ClusterType].GetMessage())
+	assert.Empty(t, report.Laggards)
+
+	// Closed streams are not waited for, but reported.
+	setSnapshot("3")
+	convergence = c.(cache.ConvergenceTracker).Convergence(node.GetId())
+	close(resp.recv)
+	require.NoError(t, <-done)
+	require.Eventually(t, func() bool {
+		return len(convergence.Report().Disconnected) == 1
+	}, time.Second, time.Millisecond)
+	assert.Len(t, convergence.Report().Laggards, 1)
+	close(deltaResp.recv)
+	err = convergence.Wait(context.Background())
+	require.True(t, errors.As(err, &convergenceErr))
+	assert.Len(t, convergenceErr.Report.Disconnected, 2)
+	assert.Empty(t, convergenceErr.Report.Laggards)
+}
+
+func TestConvergenceServers(t *testing.T) {
+	c := cache.NewSnapshotCache(false, cache.IDHash{}, nil)
+	snap, err := cache.NewSnapshot("1", map[rsrc.Type][]types.Resource{rsrc.ClusterType: {cluster}})
+	require.NoError(t, err)
+	require.NoError(t, c.SetSnapshot(context.Background(), node.GetId(), snap))
+
+	// The streams of two servers have the same ID, and are tracked apart.
+	var streams []*mockStream
+	done := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		s := server.NewServer(context.Background(), c, nil)
+		resp := makeMockStream(t)
+		resp.recv <- &discovery.DiscoveryRequest{Node: node, TypeUrl: rsrc.ClusterType}
+		go func() {
+			done <- s.StreamClusters(resp)
+		}()
+		<-resp.sent
+		streams = append(streams, resp)
+	}
+	report := c.(cache.ConvergenceTracker).Convergence(node.GetId()).Report()
+	require.Len(t, report.Laggards, 2)
+	assert.Equal(t, report.Laggards[0].StreamID, report.Laggards[1].StreamID)
+
+	for _, resp := range streams {
+		resp.recv <- &discovery.DiscoveryRequest{TypeUrl: rsrc.ClusterType, VersionInfo: "1", ResponseNonce: "1"}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, c.(cache.ConvergenceTracker).Convergence(node.GetId()).Wait(ctx))
+
+	for _, resp := range streams {
+		close(resp.recv)
+		require.NoError(t, <-done)
+	}
+}
+
+func TestConvergenceUnchangedResources(t *testing.T) {
+	c := cache.NewSnapshotCache(false, cache.IDHash{}, nil, cache.WithUnchangedPushSuppression())
+	setSnapshot := func(version string, names ...string) {
+		var clusters []types.Resource
+		for _, name := range names {
+			clusters = append(clusters, resource.MakeCluster(resource.Ads, name))
+		}
+		snap, err := cache.NewSnapshot(version, map[rsrc.Type][]types.Resource{rsrc.ClusterType: clusters})
+		require.NoError(t, err)
+		require.NoError(t, c.SetSnapshot(context.Background(), node.GetId(), snap))
+	}
+	wait := func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return c.(cache.ConvergenceTracker).Convergence(node.GetId()).Wait(ctx)
+	}
+	setSnapshot("1", clusterName)
+	s := server.NewServer(context.Background(), c, nil)
+
+	resp := makeMockStream(t)
+	resp.recv <- &discovery.DiscoveryRequest{Node: node, TypeUrl: rsrc.ClusterType}
+	done := make(chan error, 2)
+	go func() {
+		done <- s.StreamClusters(resp)
+	}()
+	deltaResp := makeMockDeltaStream(t)
+	deltaResp.recv <- &discovery.DeltaDiscoveryRequest{Node: node, TypeUrl: rsrc.ClusterType, ResourceNamesSubscribe: []string{clusterName}}
+	go func() {
+		done <- s.DeltaClusters(deltaResp)
+	}()
+	sent := <-resp.sent
+	resp.recv <- &discovery.DiscoveryRequest{TypeUrl: rsrc.ClusterType, VersionInfo: sent.VersionInfo, ResponseNonce: sent.Nonce}
+	deltaSent := <-deltaResp.sent
+	deltaResp.recv <- &discovery.DeltaDiscoveryRequest{TypeUrl: rsrc.ClusterType, ResponseNonce: deltaSent.Nonce}
+	require.NoError(t, wait())
+
+	// Neither stream is pushed the same resources under a new version.
+	setSnapshot("2", clusterName)
+	require.NoError(t, wait())
+
+	// The delta stream is not pushed a cluster it did not subscribe to.
+	setSnapshot("3", clusterName, "other")
+	sent = <-resp.sent
+	assert.Equal(t, "3", sent.VersionInfo)
+	resp.recv <- &discovery.DiscoveryRequest{TypeUrl: rsrc.ClusterType, VersionInfo: sent.VersionInfo, ResponseNonce: sent.Nonce}
+	require.NoError(t, wait())
+	assert.Empty(t, deltaResp.sent)
+
+	close(resp.recv)
+	close(deltaResp.recv)
+	require.NoError(t, <-done)
+	require.NoError(t, <-done)
+}
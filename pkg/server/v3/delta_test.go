@@ -7,9 +7,11 @@ import (
 	"testing"
 	"time"
 
-	"google.golang.org/grpc"
-
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
 	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
 	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
@@ -614,3 +616,38 @@ func TestDeltaWildcardSubscriptions(t *testing.T) {
 	})
 
 }
+
+func TestDeltaTerminateResponse(t *testing.T) {
+	config := cache.NewSnapshotCache(false, cache.IDHash{}, nil, cache.WithClearPolicy(cache.ClearTerminateStreams))
+	snapshot, err := cache.NewSnapshot("1", map[rsrc.Type][]types.Resource{rsrc.ClusterType: {cluster}})
+	require.NoError(t, err)
+	require.NoError(t, config.SetSnapshot(context.Background(), node.Id, snapshot))
+	s := server.NewServer(context.Background(), config, server.CallbackFuncs{})
+
+	resp := makeMockDeltaStream(t)
+	resp.recv <- &discovery.DeltaDiscoveryRequest{Node: node, TypeUrl: rsrc.ClusterType}
+	done := make(chan error, 1)
+	go func() {
+		done <- s.DeltaAggregatedResources(resp)
+	}()
+
+	select {
+	case <-resp.sent:
+	case <-time.After(time.Second):
+		t.Fatal("failed to receive the clusters")
+	}
+	resp.recv <- &discovery.DeltaDiscoveryRequest{Node: node, TypeUrl: rsrc.ClusterType, ResponseNonce: "1"}
+	require.Eventually(t, func() bool {
+		return config.GetStatusInfo(node.Id).GetNumDeltaWatches() == 1
+	}, time.Second, 10*time.Millisecond)
+
+	config.ClearSnapshot(node.Id)
+	select {
+	case err := <-done:
+		assert.Equal(t, codes.Unavailable, status.Code(err))
+	case <-time.After(time.Second):
+		t.Fatal("stream was not terminated")
+	}
+	assert.Empty(t, resp.sent)
+	close(resp.recv)
+}
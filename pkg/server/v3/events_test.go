@@ -0,0 +1,141 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package server_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	statuspb "google.golang.org/genproto/googleapis/rpc/status"
+
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	rsrc "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/server/config"
+	"github.com/envoyproxy/go-control-plane/pkg/server/events/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/server/v3"
+)
+
+type contextKey struct{}
+
+// eventRecorder returns an observer appending a description of the events to
+// out, and checking that they carry the context of the stream.
+func eventRecorder(t *testing.T, out *[]string) events.Observer {
+	record := func(ctx context.Context, stream events.Stream, format string, args ...interface{}) {
+		assert.Equal(t, "stream", ctx.Value(contextKey{}))
+		assert.Equal(t, node.GetId(), stream.Node.GetId())
+		*out = append(*out, fmt.Sprintf(format, args...))
+	}
+	return events.Funcs{
+		StreamOpenedFunc: func(ctx context.Context, stream events.Stream) {
+			record(ctx, stream, "opened %d ads=%t delta=%t", stream.ID, stream.ADS(), stream.Delta)
+		},
+		StreamClosedFunc: func(ctx context.Context, stream events.Stream) {
+			record(ctx, stream, "closed %d", stream.ID)
+		},
+		ResourcesPushedFunc: func(ctx context.Context, stream events.Stream, push events.Push) {
+			record(ctx, stream, "pushed %s nonce=%s resources=%d", push.Version, push.Nonce, push.NumResources)
+		},
+		AckedFunc: func(ctx context.Context, stream events.Stream, typeURL, version string) {
+			record(ctx, stream, "acked %s", version)
+		},
+		NackedFunc: func(ctx context.Context, stream events.Stream, typeURL, version string, errorDetail *statuspb.Status) {
+			record(ctx, stream, "nacked %s: %s", version, errorDetail.GetMessage())
+		},
+		UnsubscribedFunc: func(ctx context.Context, stream events.Stream, typeURL string, names []string) {
+			record(ctx, stream, "unsubscribed %s", strings.Join(names, ","))
+		},
+	}
+}
+
+func TestStreamEvents(t *testing.T) {
+	var got []string
+	cw := makeMockConfigWatcher()
+	cw.responses = makeResponses()
+	cw.responses[rsrc.ClusterType] = append(cw.responses[rsrc.ClusterType], &cache.RawResponse{
+		Version:   "4",
+		Resources: []types.ResourceWithTTL{{Resource: cluster}},
+		Request:   &discovery.DiscoveryRequest{TypeUrl: rsrc.ClusterType},
+	})
+	s := server.NewServer(context.Background(), cw, nil, config.WithObserver(eventRecorder(t, &got)))
+
+	resp := makeMockStream(t)
+	resp.ctx = context.WithValue(context.Background(), contextKey{}, "stream")
+	resp.recv <- &discovery.DiscoveryRequest{Node: node, TypeUrl: rsrc.ClusterType, ResourceNames: []string{"a", "b"}}
+	done := make(chan error)
+	go func() {
+		done <- s.StreamClusters(resp)
+	}()
+	<-resp.sent
+
+	// The ACK of the first response drops a name, and gets the second response.
+	resp.recv <- &discovery.DiscoveryRequest{TypeUrl: rsrc.ClusterType, ResourceNames: []string{"a"}, VersionInfo: "2", ResponseNonce: "1"}
+	<-resp.sent
+	resp.recv <- &discovery.DiscoveryRequest{
+		TypeUrl:       rsrc.ClusterType,
+		ResourceNames: []string{"a"},
+		VersionInfo:   "2",
+		ResponseNonce: "2",
+		ErrorDetail:   &statuspb.Status{Message: "rejected"},
+	}
+	close(resp.recv)
+	require.NoError(t, <-done)
+
+	assert.Equal(t, []string{
+		"opened 1 ads=false delta=false",
+		"pushed 2 nonce=1 resources=1",
+		"acked 2",
+		"unsubscribed b",
+		"pushed 4 nonce=2 resources=1",
+		"nacked 4: rejected",
+		"closed 1",
+	}, got)
+}
+
+func TestDeltaStreamEvents(t *testing.T) {
+	var got []string
+	cw := makeMockConfigWatcher()
+	cw.deltaResources = makeDeltaResources()
+	s := server.NewServer(context.Background(), cw, nil, config.WithObserver(eventRecorder(t, &got)))
+
+	resp := makeMockDeltaStream(t)
+	resp.ctx = context.WithValue(context.Background(), contextKey{}, "stream")
+	resp.recv <- &discovery.DeltaDiscoveryRequest{Node: node, TypeUrl: rsrc.EndpointType}
+	done := make(chan error)
+	go func() {
+		done <- s.DeltaAggregatedResources(resp)
+	}()
+	sent := <-resp.sent
+	resp.recv <- &discovery.DeltaDiscoveryRequest{
+		TypeUrl:                  rsrc.EndpointType,
+		ResponseNonce:            sent.Nonce,
+		ResourceNamesUnsubscribe: []string{clusterName},
+	}
+	close(resp.recv)
+	require.NoError(t, <-done)
+
+	assert.Equal(t, []string{
+		"opened 1 ads=true delta=true",
+		fmt.Sprintf("pushed %s nonce=1 resources=1", sent.SystemVersionInfo),
+		"acked " + sent.SystemVersionInfo,
+		"unsubscribed " + clusterName,
+		"closed 1",
+	}, got)
+}
@@ -0,0 +1,81 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package server_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	statuspb "google.golang.org/genproto/googleapis/rpc/status"
+
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/log"
+	"github.com/envoyproxy/go-control-plane/pkg/log/logtest"
+	rsrc "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/server/config"
+	"github.com/envoyproxy/go-control-plane/pkg/server/v3"
+)
+
+func TestServerLogging(t *testing.T) {
+	logs := logtest.New()
+	cw := makeMockConfigWatcher()
+	cw.responses = makeResponses()
+	s := server.NewServer(context.Background(), cw, server.CallbackFuncs{}, config.WithLogger(logs))
+
+	resp := makeMockStream(t)
+	resp.recv <- &discovery.DiscoveryRequest{Node: node, TypeUrl: rsrc.ClusterType}
+	done := make(chan error)
+	go func() {
+		done <- s.StreamAggregatedResources(resp)
+	}()
+	<-resp.sent
+	resp.recv <- &discovery.DiscoveryRequest{
+		Node:          node,
+		TypeUrl:       rsrc.ClusterType,
+		VersionInfo:   "0",
+		ResponseNonce: "1",
+		ErrorDetail:   &statuspb.Status{Message: "rejected"},
+	}
+	close(resp.recv)
+	require.NoError(t, <-done)
+
+	sent := logs.FilterMessage("send response")
+	require.Len(t, sent, 1)
+	assert.Equal(t, map[string]interface{}{
+		log.StreamIDKey: int64(1),
+		log.NodeKey:     node.GetId(),
+		log.TypeURLKey:  rsrc.ClusterType,
+		log.VersionKey:  "2",
+		log.NonceKey:    "1",
+		"num_resources": 1,
+	}, sent[0].Fields)
+
+	nacks := logs.FilterMessage("received NACK")
+	require.Len(t, nacks, 1)
+	assert.Equal(t, logtest.WarnLevel, nacks[0].Level)
+	assert.Equal(t, map[string]interface{}{
+		log.StreamIDKey: int64(1),
+		log.NodeKey:     node.GetId(),
+		log.TypeURLKey:  rsrc.ClusterType,
+		log.VersionKey:  "0",
+		log.NonceKey:    "1",
+		"error_detail":  "rejected",
+	}, nacks[0].Fields)
+
+	assert.Equal(t, 1, len(logs.FilterMessage("open stream")))
+	assert.Equal(t, 1, len(logs.FilterMessage("close stream")))
+}
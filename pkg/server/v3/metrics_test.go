@@ -0,0 +1,83 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package server_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	statuspb "google.golang.org/genproto/googleapis/rpc/status"
+
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/metrics/metricstest"
+	rsrc "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/server/config"
+	"github.com/envoyproxy/go-control-plane/pkg/server/v3"
+)
+
+func TestServerMetrics(t *testing.T) {
+	reg := metricstest.New()
+	cw := makeMockConfigWatcher()
+	cw.responses = makeResponses()
+	cw.deltaResources = makeDeltaResources()
+	s := server.NewServer(context.Background(), cw, server.CallbackFuncs{}, config.WithMetrics(reg))
+	nack := &statuspb.Status{Message: "rejected"}
+
+	// The response is rejected, so no nonce is left in flight.
+	resp := makeMockStream(t)
+	resp.recv <- &discovery.DiscoveryRequest{Node: node, TypeUrl: rsrc.ClusterType}
+	done := make(chan error)
+	go func() {
+		done <- s.StreamAggregatedResources(resp)
+	}()
+	<-resp.sent
+	resp.recv <- &discovery.DiscoveryRequest{Node: node, TypeUrl: rsrc.ClusterType, ResponseNonce: "1", ErrorDetail: nack}
+	close(resp.recv)
+	require.NoError(t, <-done)
+
+	deltaResp := makeMockDeltaStream(t)
+	deltaResp.recv <- &discovery.DeltaDiscoveryRequest{Node: node, TypeUrl: rsrc.EndpointType}
+	go func() {
+		done <- s.DeltaAggregatedResources(deltaResp)
+	}()
+	<-deltaResp.sent
+	close(deltaResp.recv)
+	require.NoError(t, <-done)
+
+	_, err := s.FetchRoutes(context.Background(), &discovery.DiscoveryRequest{Node: node})
+	require.NoError(t, err)
+
+	values := reg.Values()
+	for key, want := range map[string]float64{
+		"xds_server_streams{sotw}":                                   0,
+		"xds_server_streams{delta}":                                  0,
+		"xds_server_requests_total{sotw," + rsrc.ClusterType + "}":   2,
+		"xds_server_nacks_total{sotw," + rsrc.ClusterType + "}":      1,
+		"xds_server_nonces_in_flight{sotw}":                          0,
+		"xds_server_requests_total{delta," + rsrc.EndpointType + "}": 1,
+		"xds_server_requests_total{rest," + rsrc.RouteType + "}":     1,
+		// The delta response was not acknowledged before the stream ended.
+		"xds_server_nonces_in_flight{delta}":                              0,
+		"xds_server_push_latency_seconds{sotw," + rsrc.ClusterType + "}":  1,
+		"xds_server_response_size_bytes{delta," + rsrc.EndpointType + "}": 1,
+		"xds_server_response_size_bytes{rest," + rsrc.RouteType + "}":     1,
+	} {
+		got, ok := values[key]
+		assert.True(t, ok, key)
+		assert.Equal(t, want, got, key)
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package server_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/peer"
+
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/metrics"
+	rsrc "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/server/config"
+	"github.com/envoyproxy/go-control-plane/pkg/server/registry/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/server/v3"
+)
+
+func TestRegistry(t *testing.T) {
+	cw := makeMockConfigWatcher()
+	cw.responses = makeResponses()
+	cw.deltaResources = makeDeltaResources()
+	reg := registry.New()
+	s := server.NewServer(context.Background(), cw, nil, config.WithObserver(reg))
+
+	addr := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 4242}
+	resp := makeMockStream(t)
+	resp.ctx = peer.NewContext(context.Background(), &peer.Peer{Addr: addr})
+	resp.recv <- &discovery.DiscoveryRequest{Node: node, TypeUrl: rsrc.ClusterType}
+	done := make(chan error)
+	go func() {
+		done <- s.StreamAggregatedResources(resp)
+	}()
+	<-resp.sent
+	resp.recv <- &discovery.DiscoveryRequest{TypeUrl: rsrc.ClusterType, VersionInfo: "2", ResponseNonce: "1"}
+	resp.recv <- &discovery.DiscoveryRequest{TypeUrl: rsrc.EndpointType, ResourceNames: []string{clusterName}}
+	<-resp.sent
+
+	deltaResp := makeMockDeltaStream(t)
+	deltaResp.recv <- &discovery.DeltaDiscoveryRequest{Node: node, TypeUrl: rsrc.ListenerType, ResourceNamesSubscribe: []string{listenerName}}
+	go func() {
+		done <- s.DeltaListeners(deltaResp)
+	}()
+	<-deltaResp.sent
+
+	require.Eventually(t, func() bool {
+		return len(reg.NodeStreams(node.GetId())) == 2
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, []string{node.GetId()}, reg.Nodes())
+
+	streams := reg.NodeStreams(node.GetId())
+	sotw, delta := streams[0], streams[1]
+	assert.Equal(t, int64(1), sotw.ID)
+	assert.Equal(t, metrics.ProtocolSOTW, sotw.Protocol)
+	assert.True(t, sotw.ADS)
+	assert.Equal(t, addr.String(), sotw.Peer)
+	assert.False(t, sotw.ConnectedAt.IsZero())
+	assert.Equal(t, map[string][]string{
+		rsrc.ClusterType:  nil,
+		rsrc.EndpointType: {clusterName},
+	}, sotw.Subscriptions)
+	assert.Equal(t, map[string]string{rsrc.ClusterType: "2"}, sotw.AckedVersions)
+
+	assert.Equal(t, int64(1), delta.ID)
+	assert.Equal(t, metrics.ProtocolDelta, delta.Protocol)
+	assert.False(t, delta.ADS)
+	assert.Empty(t, delta.Peer)
+	assert.Equal(t, map[string][]string{rsrc.ListenerType: {listenerName}}, delta.Subscriptions)
+	assert.Empty(t, delta.AckedVersions)
+
+	close(resp.recv)
+	close(deltaResp.recv)
+	require.NoError(t, <-done)
+	require.NoError(t, <-done)
+	assert.Empty(t, reg.Streams())
+	assert.Empty(t, reg.Nodes())
+}
@@ -21,6 +21,7 @@ import (
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 
+	"github.com/envoyproxy/go-control-plane/pkg/server/config"
 	"github.com/envoyproxy/go-control-plane/pkg/server/delta/v3"
 	"github.com/envoyproxy/go-control-plane/pkg/server/rest/v3"
 	"github.com/envoyproxy/go-control-plane/pkg/server/sotw/v3"
@@ -59,6 +60,22 @@ type Server interface {
 	delta.Server
 }
 
+// NodeController acts on the streams of the nodes connected to a server. The
+// servers returned by NewServer implement it:
+//
+//	c := srv.(server.NodeController)
+type NodeController interface {
+	// TerminateNode ends all the SOTW and delta streams of a node with a gRPC
+	// status, e.g. to make a wedged proxy reconnect. It returns the number of
+	// streams ended.
+	TerminateNode(node string, st *status.Status) int
+
+	// RepushNode makes all the SOTW and delta streams of a node push the
+	// current resources of the types they watch, whatever the node has, e.g.
+	// after rotating secrets. It returns the number of streams.
+	RepushNode(node string) int
+}
+
 // Callbacks is a collection of callbacks inserted into the server operation.
 // The callbacks are invoked synchronously.
 type Callbacks interface {
@@ -164,10 +181,11 @@ func (c CallbackFuncs) OnFetchResponse(req *discovery.DiscoveryRequest, resp *di
 }
 
 // NewServer creates handlers from a config watcher and callbacks.
-func NewServer(ctx context.Context, config cache.Cache, callbacks Callbacks) Server {
-	return NewServerAdvanced(rest.NewServer(config, callbacks),
-		sotw.NewServer(ctx, config, callbacks),
-		delta.NewServer(ctx, config, callbacks),
+// The options are passed to the REST, SOTW and delta servers.
+func NewServer(ctx context.Context, c cache.Cache, callbacks Callbacks, opts ...config.XDSOption) Server {
+	return NewServerAdvanced(rest.NewServer(c, callbacks, opts...),
+		sotw.NewServer(ctx, c, callbacks, opts...),
+		delta.NewServer(ctx, c, callbacks, opts...),
 	)
 }
 
@@ -175,12 +193,45 @@ func NewServerAdvanced(restServer rest.Server, sotwServer sotw.Server, deltaServ
 	return &server{rest: restServer, sotw: sotwServer, delta: deltaServer}
 }
 
+var _ NodeController = &server{}
+
 type server struct {
 	rest  rest.Server
 	sotw  sotw.Server
 	delta delta.Server
 }
 
+// TerminateNode ends the streams of a node on the SOTW and delta servers that
+// are node controllers.
+func (s *server) TerminateNode(node string, st *status.Status) int {
+	n := 0
+	for _, c := range s.nodeControllers() {
+		n += c.TerminateNode(node, st)
+	}
+	return n
+}
+
+// RepushNode re-pushes the resources of the streams of a node on the SOTW
+// and delta servers that are node controllers.
+func (s *server) RepushNode(node string) int {
+	n := 0
+	for _, c := range s.nodeControllers() {
+		n += c.RepushNode(node)
+	}
+	return n
+}
+
+func (s *server) nodeControllers() []NodeController {
+	var out []NodeController
+	if c, ok := s.sotw.(NodeController); ok {
+		out = append(out, c)
+	}
+	if c, ok := s.delta.(NodeController); ok {
+		out = append(out, c)
+	}
+	return out
+}
+
 func (s *server) StreamHandler(stream stream.Stream, typeURL string) error {
 	return s.sotw.StreamHandler(stream, typeURL)
 }
@@ -24,6 +24,8 @@ import (
 	"time"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
 	"github.com/stretchr/testify/assert"
 
@@ -501,6 +503,25 @@ func TestSendError(t *testing.T) {
 	}
 }
 
+func TestTerminateResponse(t *testing.T) {
+	config := makeMockConfigWatcher()
+	config.responses = map[string][]cache.Response{
+		rsrc.ClusterType: {&cache.TerminateResponse{
+			Request: &discovery.DiscoveryRequest{TypeUrl: rsrc.ClusterType},
+			Status:  status.New(codes.Unavailable, "cleared"),
+		}},
+	}
+	s := server.NewServer(context.Background(), config, server.CallbackFuncs{})
+
+	resp := makeMockStream(t)
+	resp.recv <- &discovery.DiscoveryRequest{Node: node, TypeUrl: rsrc.ClusterType}
+
+	err := s.StreamAggregatedResources(resp)
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Empty(t, resp.sent)
+	close(resp.recv)
+}
+
 func TestStaleNonce(t *testing.T) {
 	for _, typ := range testTypes {
 		t.Run(typ, func(t *testing.T) {
@@ -0,0 +1,85 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package server_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	rsrc "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/server/config"
+	"github.com/envoyproxy/go-control-plane/pkg/server/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/tracing"
+	"github.com/envoyproxy/go-control-plane/pkg/tracing/tracingtest"
+)
+
+func TestTracing(t *testing.T) {
+	provider := tracingtest.New()
+
+	c := cache.NewSnapshotCache(false, cache.IDHash{}, nil, cache.WithSnapshotCacheTracing(provider))
+	s := server.NewServer(context.Background(), c, server.CallbackFuncs{}, config.WithTracerProvider(provider))
+
+	resp := makeMockStream(t)
+	resp.recv <- &discovery.DiscoveryRequest{Node: node, TypeUrl: rsrc.ClusterType}
+	done := make(chan error)
+	go func() {
+		done <- s.StreamAggregatedResources(resp)
+	}()
+
+	// Wait for the watch to be open, so that it is responded by SetSnapshot.
+	require.Eventually(t, func() bool {
+		info := c.GetStatusInfo(node.Id)
+		return info != nil && info.GetNumWatches() == 1
+	}, time.Second, time.Millisecond)
+
+	snap, err := cache.NewSnapshot("1", map[rsrc.Type][]types.Resource{rsrc.ClusterType: {cluster}})
+	require.NoError(t, err)
+	require.NoError(t, c.SetSnapshot(context.Background(), node.Id, snap))
+	<-resp.sent
+	close(resp.recv)
+	require.NoError(t, <-done)
+
+	spans := map[string]*tracingtest.Span{}
+	for _, span := range provider.Ended() {
+		spans[span.Name()] = span
+	}
+	require.Contains(t, spans, "SetSnapshot")
+	require.Contains(t, spans, "RespondWatch")
+	require.Contains(t, spans, "Send")
+	require.Contains(t, spans, "Marshal")
+
+	// The spans form a single trace, from the update of the snapshot to the
+	// marshaling of the response.
+	assert.Equal(t, spans["SetSnapshot"].SpanContext().SpanID(), spans["RespondWatch"].Parent().SpanID())
+	assert.Equal(t, spans["RespondWatch"].SpanContext().SpanID(), spans["Send"].Parent().SpanID())
+	assert.Equal(t, spans["Send"].SpanContext().SpanID(), spans["Marshal"].Parent().SpanID())
+
+	attributes := map[attribute.Key]attribute.Value{}
+	for _, kv := range spans["Send"].Attributes() {
+		attributes[kv.Key] = kv.Value
+	}
+	assert.Equal(t, node.Id, attributes[tracing.NodeKey].AsString())
+	assert.Equal(t, rsrc.ClusterType, attributes[tracing.TypeURLKey].AsString())
+	assert.Equal(t, "1", attributes[tracing.VersionKey].AsString())
+	assert.Equal(t, "1", attributes[tracing.NonceKey].AsString())
+}
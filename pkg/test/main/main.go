@@ -28,6 +28,7 @@ import (
 	"runtime/pprof"
 	"time"
 
+	"github.com/envoyproxy/go-control-plane/pkg/builder/v3"
 	"github.com/envoyproxy/go-control-plane/pkg/cache/v3"
 	"github.com/envoyproxy/go-control-plane/pkg/server/v3"
 	"github.com/envoyproxy/go-control-plane/pkg/test"
@@ -44,6 +45,7 @@ var (
 	upstreamMessage string
 	basePort        uint
 	alsPort         uint
+	adminPort       uint
 
 	delay    time.Duration
 	requests int
@@ -62,6 +64,8 @@ var (
 
 	nodeID string
 
+	bootstrapPath string
+
 	pprofEnabled bool
 )
 
@@ -92,6 +96,9 @@ func init() {
 	// The control plane accesslog server port
 	flag.UintVar(&alsPort, "als", 18090, "Control plane accesslog server port")
 
+	// The port of the Envoy admin interface
+	flag.UintVar(&adminPort, "admin", 19000, "Envoy admin port")
+
 	//
 	// These parameters control Envoy configuration
 	//
@@ -154,6 +161,9 @@ func init() {
 	// Enable use of the pprof profiler
 	flag.BoolVar(&pprofEnabled, "pprof", false, "Enable use of the pprof profiler")
 
+	// Write the Envoy bootstrap matching the parameters above instead of
+	// running the tests
+	flag.StringVar(&bootstrapPath, "bootstrap", "", "Write the Envoy bootstrap to this file and exit")
 }
 
 // main returns code 1 if any of the batches failed to pass all requests
@@ -161,6 +171,13 @@ func main() {
 	flag.Parse()
 	ctx := context.Background()
 
+	if bootstrapPath != "" {
+		if err := writeBootstrap(bootstrapPath); err != nil {
+			log.Fatalf("failed to write bootstrap: %v", err)
+		}
+		return
+	}
+
 	if pprofEnabled {
 		runtime.SetBlockProfileRate(1)
 		for _, prof := range []string{"block", "goroutine", "mutex"} {
@@ -303,6 +320,43 @@ func main() {
 	log.Printf("Test for %s passed!\n", mode)
 }
 
+// writeBootstrap writes the bootstrap of an Envoy talking to this control
+// plane with the selected mode.
+func writeBootstrap(path string) error {
+	xdsPort := port
+	if mode == resource.Rest {
+		xdsPort = gatewayPort
+	}
+
+	// The delta bootstraps have no RTDS layers, as the sample files they
+	// replace, so that the integration tests cover the same configurations.
+	var layers []string
+	if mode != resource.Delta && mode != resource.DeltaAds {
+		for i := 0; i < runtimes; i++ {
+			layers = append(layers, fmt.Sprintf("runtime-%d", i))
+		}
+	}
+
+	b, err := builder.NewBootstrap(nodeID, "test-cluster", builder.Mode(mode),
+		builder.Address{Host: "127.0.0.1", Port: uint32(xdsPort)},
+		builder.WithAdmin(builder.Address{Host: "127.0.0.1", Port: uint32(adminPort)}),
+		builder.WithRuntimeLayers(layers...),
+		builder.WithStaticClusters(builder.NewCluster(resource.AlsCluster,
+			builder.WithStatic(builder.Address{Host: "127.0.0.1", Port: uint32(alsPort)}),
+			builder.WithConnectTimeout(time.Second),
+			builder.WithHTTP2(),
+		)),
+	)
+	if err != nil {
+		return err
+	}
+	out, err := builder.MarshalYAML(b)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, out, 0o600)
+}
+
 // callEcho calls upstream echo service on all listener ports and returns an error
 // if any of the listeners returned an error.
 func callEcho() (int, int) {
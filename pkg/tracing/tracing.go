@@ -0,0 +1,70 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+// Package tracing provides the OpenTelemetry instrumentation shared by the
+// caches and servers of this library. Tracing is disabled unless a tracer
+// provider is passed to their options.
+package tracing
+
+import (
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracerName is the name of the tracer of this library.
+const TracerName = "github.com/envoyproxy/go-control-plane"
+
+// Attributes of the spans, which link the spans of a node and type URL
+// from the cache update to the responses sent on each stream.
+const (
+	// NodeKey is the ID of the node.
+	NodeKey = attribute.Key("xds.node")
+
+	// GroupKey is the group of nodes, see cache.WithNodeGroups.
+	GroupKey = attribute.Key("xds.group")
+
+	// TypeURLKey is the type URL of the resources.
+	TypeURLKey = attribute.Key("xds.type_url")
+
+	// VersionKey is the version of the resources.
+	VersionKey = attribute.Key("xds.version")
+
+	// ProtocolKey is the protocol of a server: sotw, delta or rest.
+	ProtocolKey = attribute.Key("xds.protocol")
+
+	// StreamIDKey is the ID of a stream within its server.
+	StreamIDKey = attribute.Key("xds.stream_id")
+
+	// NonceKey is the nonce of a response.
+	NonceKey = attribute.Key("xds.nonce")
+)
+
+// Tracer returns the tracer of this library from a provider, or a tracer
+// recording nothing if the provider is nil.
+func Tracer(provider trace.TracerProvider) trace.Tracer {
+	if provider == nil {
+		provider = trace.NewNoopTracerProvider()
+	}
+	return provider.Tracer(TracerName)
+}
+
+// End ends a span, recording the error if it is not nil.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
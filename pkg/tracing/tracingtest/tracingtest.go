@@ -0,0 +1,209 @@
+// Copyright 2022 Envoyproxy Authors
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+// Package tracingtest provides a tracer provider recording its spans in
+// memory, for use in tests.
+package tracingtest
+
+import (
+	"context"
+	"encoding/binary"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Recorder is a tracer provider recording the spans of its tracers.
+type Recorder struct {
+	mu     sync.Mutex
+	lastID uint64
+	ended  []*Span
+}
+
+var _ trace.TracerProvider = &Recorder{}
+
+// New returns an empty recorder.
+func New() *Recorder {
+	return &Recorder{}
+}
+
+// Tracer returns a tracer recording its spans to r.
+func (r *Recorder) Tracer(string, ...trace.TracerOption) trace.Tracer {
+	return tracer{recorder: r}
+}
+
+// Ended returns the ended spans in the order they ended.
+func (r *Recorder) Ended() []*Span {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*Span(nil), r.ended...)
+}
+
+func (r *Recorder) nextID() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lastID++
+	return r.lastID
+}
+
+type tracer struct {
+	recorder *Recorder
+}
+
+func (t tracer) Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	config := trace.NewSpanStartConfig(opts...)
+	parent := trace.SpanContextFromContext(ctx)
+	if config.NewRoot() {
+		parent = trace.SpanContext{}
+	}
+
+	traceID := parent.TraceID()
+	if !parent.HasTraceID() {
+		binary.BigEndian.PutUint64(traceID[8:], t.recorder.nextID())
+	}
+	var spanID trace.SpanID
+	binary.BigEndian.PutUint64(spanID[:], t.recorder.nextID())
+
+	span := &Span{
+		recorder: t.recorder,
+		name:     name,
+		context: trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID:    traceID,
+			SpanID:     spanID,
+			TraceFlags: trace.FlagsSampled,
+		}),
+		parent:     parent,
+		attributes: config.Attributes(),
+	}
+	return trace.ContextWithSpan(ctx, span), span
+}
+
+// Span is a span recorded by a Recorder.
+type Span struct {
+	recorder *Recorder
+	context  trace.SpanContext
+	parent   trace.SpanContext
+
+	mu          sync.Mutex
+	name        string
+	attributes  []attribute.KeyValue
+	code        codes.Code
+	description string
+	errors      []error
+	ended       bool
+}
+
+var _ trace.Span = &Span{}
+
+// Name returns the name of the span.
+func (s *Span) Name() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.name
+}
+
+// Parent returns the context of the parent of the span, which is invalid
+// for a root span.
+func (s *Span) Parent() trace.SpanContext {
+	return s.parent
+}
+
+// Attributes returns the attributes of the span in the order they were set.
+func (s *Span) Attributes() []attribute.KeyValue {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]attribute.KeyValue(nil), s.attributes...)
+}
+
+// Status returns the status of the span.
+func (s *Span) Status() (codes.Code, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.code, s.description
+}
+
+// Errors returns the errors recorded on the span.
+func (s *Span) Errors() []error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]error(nil), s.errors...)
+}
+
+// End ends the span. Only the first call has an effect.
+func (s *Span) End(...trace.SpanEndOption) {
+	s.mu.Lock()
+	ended := s.ended
+	s.ended = true
+	s.mu.Unlock()
+	if ended {
+		return
+	}
+
+	s.recorder.mu.Lock()
+	defer s.recorder.mu.Unlock()
+	s.recorder.ended = append(s.recorder.ended, s)
+}
+
+// AddEvent does nothing: events are not recorded.
+func (s *Span) AddEvent(string, ...trace.EventOption) {}
+
+// IsRecording returns whether the span has not ended yet.
+func (s *Span) IsRecording() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return !s.ended
+}
+
+// RecordError records an error on the span.
+func (s *Span) RecordError(err error, _ ...trace.EventOption) {
+	if err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errors = append(s.errors, err)
+}
+
+// SpanContext returns the context of the span.
+func (s *Span) SpanContext() trace.SpanContext {
+	return s.context
+}
+
+// SetStatus sets the status of the span.
+func (s *Span) SetStatus(code codes.Code, description string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.code, s.description = code, description
+}
+
+// SetName renames the span.
+func (s *Span) SetName(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.name = name
+}
+
+// SetAttributes adds attributes to the span.
+func (s *Span) SetAttributes(kv ...attribute.KeyValue) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attributes = append(s.attributes, kv...)
+}
+
+// TracerProvider returns the recorder of the span.
+func (s *Span) TracerProvider() trace.TracerProvider {
+	return s.recorder
+}